@@ -3,10 +3,14 @@ package aigateway
 import (
 	_ "embed"
 	"fmt"
+	"strconv"
 	"strings"
 
+	"bitbucket.org/atlassian-developers/proximity/internal/catalog"
 	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/limits"
 	"bitbucket.org/atlassian-developers/proximity/internal/server"
+	"bitbucket.org/atlassian-developers/proximity/internal/usage"
 
 	"github.com/urfave/cli/v2"
 )
@@ -36,26 +40,81 @@ through AI-Gateway.`,
 			},
 			&cli.StringSliceFlag{
 				Name:  "profile",
-				Usage: "Profile definition: name=W;useCaseId=X;adGroup=Y;atlassianCloudId=Z (adGroup and atlassianCloudId are optional)",
+				Usage: "Profile definition: name=W;useCaseId=X;adGroup=Y;atlassianCloudId=Z (adGroup and atlassianCloudId are optional). Limit the profile with requestsPerMinute, concurrentStreams and tokensPerDay",
 			},
 			&cli.StringFlag{
 				Name:  "default-profile",
 				Usage: "Name of the profile to use by default (if not defined then uses the first profile)",
 			},
+			&cli.IntFlag{
+				Name:  "admin-port",
+				Usage: "Port to serve metrics on at /metrics and token usage on /usage (disabled when not set)",
+			},
+			&cli.StringFlag{
+				Name:    "otlp-endpoint",
+				Usage:   "OTLP/HTTP url to send traces to, such as http://localhost:4318/v1/traces",
+				EnvVars: []string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"},
+			},
+			&cli.StringFlag{
+				Name:  "trace-file",
+				Usage: "Append traces to this file as OTLP JSON, one batch per line",
+			},
+			&cli.StringFlag{
+				Name:  "record",
+				Usage: "Record every upstream exchange to files in this directory",
+			},
+			&cli.StringFlag{
+				Name:  "replay",
+				Usage: "Serve upstream exchanges recorded with --record from this directory instead of contacting upstreams",
+			},
+			&cli.BoolFlag{
+				Name:  "mock-upstream",
+				Usage: "Answer upstream requests with the built-in mock of AI-Gateway instead of contacting upstreams",
+			},
+			&cli.StringFlag{
+				Name:  "usage-file",
+				Value: usage.DefaultPath(),
+				Usage: "Aggregate the tokens used per profile, use case, model and day in this file, set it to empty to not record usage",
+			},
+			&cli.StringFlag{
+				Name:  "limits-file",
+				Value: limits.DefaultPath(),
+				Usage: "Keep the requests and tokens counted against limits in this file so that they survive restarts",
+			},
+			&cli.StringFlag{
+				Name:  "models-file",
+				Value: catalog.DefaultPath(),
+				Usage: "Add the model aliases in this file to the built-in catalog, see the README for its format",
+			},
+			&cli.StringFlag{
+				Name:  "har",
+				Usage: "Write the last 200 exchanges, as sent by the client and to the upstream, to this HAR file on shutdown",
+			},
+			&cli.StringFlag{
+				Name:  "log-format",
+				Value: "text",
+				Usage: "Format of the logs, text or json",
+			},
+			&cli.StringFlag{
+				Name:  "log-level",
+				Value: "info",
+				Usage: "Minimum level of the logs, debug, info, warn or error",
+			},
 		},
 		Action: run,
 	}
 }
 
-func parseProfile(s string) (map[string]string, error) {
+func parseProfile(s string) (map[string]string, config.Limit, error) {
 	profile := make(map[string]string)
+	limit := config.Limit{}
 	parts := strings.Split(s, ";")
 
 	for _, part := range parts {
 		kv := strings.SplitN(part, "=", 2)
 
 		if len(kv) != 2 {
-			return nil, fmt.Errorf("invalid profile part: %s", part)
+			return nil, limit, fmt.Errorf("invalid profile part: %s", part)
 		}
 
 		key := strings.TrimSpace(kv[0])
@@ -64,12 +123,26 @@ func parseProfile(s string) (map[string]string, error) {
 		switch key {
 		case "name", "useCaseId", "adGroup", "atlassianCloudId":
 			profile[key] = value
+		case "requestsPerMinute", "concurrentStreams", "tokensPerDay":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return nil, limit, fmt.Errorf("invalid %s: %s", key, value)
+			}
+
+			switch key {
+			case "requestsPerMinute":
+				limit.RequestsPerMinute = int(n)
+			case "concurrentStreams":
+				limit.ConcurrentStreams = int(n)
+			default:
+				limit.TokensPerDay = n
+			}
 		default:
-			return nil, fmt.Errorf("unknown profile key: %s", key)
+			return nil, limit, fmt.Errorf("unknown profile key: %s", key)
 		}
 	}
 
-	return profile, nil
+	return profile, limit, nil
 }
 
 func run(c *cli.Context) error {
@@ -83,20 +156,28 @@ func run(c *cli.Context) error {
 		return fmt.Errorf("at least one --profile must be defined")
 	}
 
+	cfg, err := config.LoadFromBytes(proxyConfig)
+	if err != nil {
+		return fmt.Errorf("failed to parse embedded config: %w", err)
+	}
+
 	profiles := make([]any, 0, len(profileStrings))
 
 	for i, s := range profileStrings {
-		profile, err := parseProfile(s)
+		profile, limit, err := parseProfile(s)
 		if err != nil {
 			return fmt.Errorf("failed to parse profile %d: %w", i, err)
 		}
 
 		profiles = append(profiles, profile)
-	}
 
-	cfg, err := config.LoadFromBytes(proxyConfig)
-	if err != nil {
-		return fmt.Errorf("failed to parse embedded config: %w", err)
+		if limit != (config.Limit{}) {
+			cfg.Limits = append(cfg.Limits, config.LimitRule{
+				Name:     "profile:" + profile["name"],
+				Profiles: []string{profile["name"]},
+				Limit:    limit,
+			})
+		}
 	}
 
 	// Prepare global variables for the proxy
@@ -109,5 +190,25 @@ func run(c *cli.Context) error {
 		vars["defaultProfile"] = c.String("default-profile")
 	}
 
-	return server.RunServer(cfg, port, vars)
+	return server.RunServer(cfg, server.Options{
+		Port:      port,
+		AdminPort: c.Int("admin-port"),
+		Vars:      vars,
+		RecordDir: c.String("record"),
+		ReplayDir: c.String("replay"),
+
+		MockUpstream: c.Bool("mock-upstream"),
+
+		OtlpEndpoint: c.String("otlp-endpoint"),
+		TraceFile:    c.String("trace-file"),
+
+		LogFormat: c.String("log-format"),
+		LogLevel:  c.String("log-level"),
+
+		UsageFile:  c.String("usage-file"),
+		LimitsFile: c.String("limits-file"),
+		ModelsFile: c.String("models-file"),
+		HarFile:    c.String("har"),
+		Version:    c.App.Version,
+	})
 }
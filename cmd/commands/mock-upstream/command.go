@@ -0,0 +1,59 @@
+package mockupstream
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	mock "bitbucket.org/atlassian-developers/proximity/internal/mockupstream"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Command returns the mock-upstream subcommand
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:  "mock-upstream",
+		Usage: "Run a mock of the AI-Gateway endpoints for developing configs offline",
+		Description: `Serves the AI-Gateway chat endpoints for OpenAI, Bedrock and Vertex AI along with the model list
+endpoints, answering every completion with the same text either whole or streamed a word at a time.
+Requests aren't authenticated so no network or slauth is needed.
+
+To run the proxy against the mock without a separate process use --mock-upstream instead.`,
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:    "port",
+				Aliases: []string{"p"},
+				Value:   29575,
+				Usage:   "Port to run the mock on",
+			},
+			&cli.StringFlag{
+				Name:  "completion",
+				Value: mock.DefaultCompletion,
+				Usage: "Text every completion responds with",
+			},
+			&cli.DurationFlag{
+				Name:  "delay",
+				Usage: "How long to wait between the chunks of a streamed completion",
+			},
+		},
+		Action: run,
+	}
+}
+
+func run(c *cli.Context) error {
+	handler := mock.New(mock.Options{
+		Completion: c.String("completion"),
+		Delay:      c.Duration("delay"),
+	})
+
+	slog.Info("starting mock upstream", "port", c.Int("port"))
+
+	err := http.ListenAndServe(fmt.Sprint(":", c.Int("port")), handler)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"text/tabwriter"
 
 	aigateway "bitbucket.org/atlassian-developers/proximity/cmd/commands/ai-gateway"
+	mockupstream "bitbucket.org/atlassian-developers/proximity/cmd/commands/mock-upstream"
 	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/limits"
 	"bitbucket.org/atlassian-developers/proximity/internal/server"
+	"bitbucket.org/atlassian-developers/proximity/internal/template"
+	"bitbucket.org/atlassian-developers/proximity/internal/testrunner"
+	"bitbucket.org/atlassian-developers/proximity/internal/usage"
 
 	"github.com/urfave/cli/v2"
 )
@@ -32,10 +41,120 @@ based on a YAML configuration file.`,
 				Value:   29574,
 				Usage:   "Port to run the server on",
 			},
+			&cli.IntFlag{
+				Name:  "admin-port",
+				Usage: "Port to serve metrics on at /metrics and token usage on /usage (disabled when not set)",
+			},
+			&cli.StringFlag{
+				Name:    "otlp-endpoint",
+				Usage:   "OTLP/HTTP url to send traces to, such as http://localhost:4318/v1/traces",
+				EnvVars: []string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"},
+			},
+			&cli.StringFlag{
+				Name:  "trace-file",
+				Usage: "Append traces to this file as OTLP JSON, one batch per line",
+			},
+			&cli.StringFlag{
+				Name:  "record",
+				Usage: "Record every upstream exchange to files in this directory",
+			},
+			&cli.StringFlag{
+				Name:  "replay",
+				Usage: "Serve upstream exchanges recorded with --record from this directory instead of contacting upstreams",
+			},
+			&cli.BoolFlag{
+				Name:  "mock-upstream",
+				Usage: "Answer upstream requests with the built-in mock of AI-Gateway instead of contacting upstreams",
+			},
+			&cli.StringFlag{
+				Name:  "usage-file",
+				Value: usage.DefaultPath(),
+				Usage: "Aggregate the tokens used per profile, use case, model and day in this file, set it to empty to not record usage",
+			},
+			&cli.StringFlag{
+				Name:  "limits-file",
+				Value: limits.DefaultPath(),
+				Usage: "Keep the requests and tokens counted against limits in this file so that they survive restarts",
+			},
+			&cli.StringFlag{
+				Name:  "har",
+				Usage: "Write the last 200 exchanges, as sent by the client and to the upstream, to this HAR file on shutdown",
+			},
+			&cli.StringFlag{
+				Name:  "log-format",
+				Value: "text",
+				Usage: "Format of the logs, text or json",
+			},
+			&cli.StringFlag{
+				Name:  "log-level",
+				Value: "info",
+				Usage: "Minimum level of the logs, debug, info, warn or error",
+			},
 		},
 		Action: runWithConfig,
 		Commands: []*cli.Command{
 			aigateway.Command(),
+			mockupstream.Command(),
+			{
+				Name:      "validate",
+				Usage:     "Validate a config file without running the proxy",
+				ArgsUsage: "[config]",
+				Description: `Reports unknown keys, overrides which don't match a supported uri, invalid header and patch
+definitions, and compiles every expr and template. Defaults to the file given by --config.`,
+				Action: validateConfig,
+			},
+			{
+				Name:      "test",
+				Usage:     "Run golden-file test cases through the config given by --config",
+				ArgsUsage: "<file or directory>...",
+				Description: `Sends the request of each test case through the proxy with its upstream requests answered by
+stubbed responses, then compares the requests the upstream received and the response the client
+received with the expected ones. Directories are searched for yaml test files recursively.`,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "verbose",
+						Aliases: []string{"v"},
+						Usage:   "Print the proxy logs while running the test cases",
+					},
+				},
+				Action: testConfig,
+			},
+			{
+				Name:  "usage",
+				Usage: "Show the tokens used through the proxy per profile, use case, model and day",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "file",
+						Value: usage.DefaultPath(),
+						Usage: "Usage file the proxy recorded to with --usage-file",
+					},
+					&cli.StringFlag{
+						Name:  "from",
+						Usage: "First day to include, as YYYY-MM-DD",
+					},
+					&cli.StringFlag{
+						Name:  "to",
+						Usage: "Last day to include, as YYYY-MM-DD",
+					},
+					&cli.StringFlag{
+						Name:  "profile",
+						Usage: "Only include this profile",
+					},
+					&cli.StringFlag{
+						Name:  "use-case-id",
+						Usage: "Only include this use case",
+					},
+					&cli.StringFlag{
+						Name:  "model",
+						Usage: "Only include this model",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the usage as json",
+					},
+				},
+				Action: showUsage,
+			},
 		},
 	}
 
@@ -59,5 +178,131 @@ func runWithConfig(c *cli.Context) error {
 	}
 
 	// TODO: allow caller to provide a path to a file which provides vars
-	return server.RunServer(cfg, port, make(map[string]any))
+	return server.RunServer(cfg, server.Options{
+		Port:       port,
+		AdminPort:  c.Int("admin-port"),
+		Vars:       make(map[string]any),
+		ConfigPath: configPath,
+		RecordDir:  c.String("record"),
+		ReplayDir:  c.String("replay"),
+
+		MockUpstream: c.Bool("mock-upstream"),
+
+		OtlpEndpoint: c.String("otlp-endpoint"),
+		TraceFile:    c.String("trace-file"),
+
+		LogFormat: c.String("log-format"),
+		LogLevel:  c.String("log-level"),
+
+		UsageFile:  c.String("usage-file"),
+		LimitsFile: c.String("limits-file"),
+		HarFile:    c.String("har"),
+		Version:    c.App.Version,
+	})
+}
+
+func validateConfig(c *cli.Context) error {
+	configPath := c.Args().First()
+	if configPath == "" {
+		configPath = c.String("config")
+	}
+
+	if configPath == "" {
+		return fmt.Errorf("a config file must be provided either as an argument or with --config")
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	err = config.Validate(data, template.NewRenderer(slog.Default()))
+
+	var validationErrs config.ValidationErrors
+
+	if errors.As(err, &validationErrs) {
+		for _, validationErr := range validationErrs {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", configPath, validationErr.Error())
+		}
+
+		return fmt.Errorf("%s: found %d problem(s)", configPath, len(validationErrs))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: ok\n", configPath)
+	return nil
+}
+
+func testConfig(c *cli.Context) error {
+	configPath := c.String("config")
+	if configPath == "" {
+		return fmt.Errorf("--config flag is required to run tests")
+	}
+
+	if c.NArg() == 0 {
+		return fmt.Errorf("at least one test file or directory must be provided")
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	suites, err := testrunner.Load(c.Args().Slice()...)
+	if err != nil {
+		return fmt.Errorf("failed to load tests: %w", err)
+	}
+
+	runner := &testrunner.Runner{
+		Config: cfg,
+		Out:    os.Stdout,
+	}
+
+	if c.Bool("verbose") {
+		runner.Logger = slog.Default()
+	}
+
+	failed, total := runner.Run(suites)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d test case(s) failed", failed, total)
+	}
+
+	fmt.Printf("all %d test case(s) passed\n", total)
+	return nil
+}
+
+func showUsage(c *cli.Context) error {
+	report, err := usage.NewStore(c.String("file")).Report(usage.Filter{
+		From:      c.String("from"),
+		To:        c.String("to"),
+		Profile:   c.String("profile"),
+		UseCaseId: c.String("use-case-id"),
+		Model:     c.String("model"),
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("json") {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(report)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "DAY\tPROFILE\tUSE CASE\tMODEL\tREQUESTS\tINPUT TOKENS\tOUTPUT TOKENS")
+
+	for _, record := range report.Records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%d\n", record.Day, record.Profile, record.UseCaseId, record.Model,
+			record.Requests, record.InputTokens, record.OutputTokens)
+	}
+
+	fmt.Fprintf(w, "TOTAL\t\t\t\t%d\t%d\t%d\n", report.Total.Requests, report.Total.InputTokens, report.Total.OutputTokens)
+
+	return w.Flush()
 }
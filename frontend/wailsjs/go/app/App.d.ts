@@ -1,15 +1,33 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 import {app} from '../models';
+import {exchange} from '../models';
+import {usage} from '../models';
+
+export function ClearExchanges():Promise<void>;
 
 export function ClearLogs():Promise<void>;
 
+export function ExportHar():Promise<string>;
+
+export function GetChangelog():Promise<Record<string, string>>;
+
 export function GetEndpoints():Promise<app.EndpointsResponse>;
 
+export function GetExchange(arg1:string):Promise<exchange.Exchange>;
+
+export function GetLogEntries(arg1:app.LogFilter):Promise<Array<app.LogEntry>>;
+
 export function GetLogs():Promise<string>;
 
+export function GetPort():Promise<number>;
+
+export function GetUsage(arg1:usage.Filter):Promise<usage.Report>;
+
 export function IsRunning():Promise<boolean>;
 
+export function ListExchanges():Promise<Array<exchange.Summary>>;
+
 export function StartProxy():Promise<void>;
 
 export function StopProxy():Promise<void>;
@@ -2,22 +2,54 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function ClearExchanges() {
+  return window['go']['app']['App']['ClearExchanges']();
+}
+
 export function ClearLogs() {
   return window['go']['app']['App']['ClearLogs']();
 }
 
+export function ExportHar() {
+  return window['go']['app']['App']['ExportHar']();
+}
+
+export function GetChangelog() {
+  return window['go']['app']['App']['GetChangelog']();
+}
+
 export function GetEndpoints() {
   return window['go']['app']['App']['GetEndpoints']();
 }
 
+export function GetExchange(arg1) {
+  return window['go']['app']['App']['GetExchange'](arg1);
+}
+
+export function GetLogEntries(arg1) {
+  return window['go']['app']['App']['GetLogEntries'](arg1);
+}
+
 export function GetLogs() {
   return window['go']['app']['App']['GetLogs']();
 }
 
+export function GetPort() {
+  return window['go']['app']['App']['GetPort']();
+}
+
+export function GetUsage(arg1) {
+  return window['go']['app']['App']['GetUsage'](arg1);
+}
+
 export function IsRunning() {
   return window['go']['app']['App']['IsRunning']();
 }
 
+export function ListExchanges() {
+  return window['go']['app']['App']['ListExchanges']();
+}
+
 export function StartProxy() {
   return window['go']['app']['App']['StartProxy']();
 }
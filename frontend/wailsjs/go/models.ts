@@ -1,31 +1,522 @@
 export namespace app {
 	
-	export class Endpoint {
+	export class EndpointsResponse {
+	    baseEndpoint: string;
+	    uriGroups: config.UriGroup[];
+	
+	    static createFrom(source: any = {}) {
+	        return new EndpointsResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.baseEndpoint = source["baseEndpoint"];
+	        this.uriGroups = this.convertValues(source["uriGroups"], config.UriGroup);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class LogEntry {
+	    time: string;
+	    level: string;
+	    message: string;
+	    requestId?: string;
+	    attrs?: Record<string, any>;
+	    text: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new LogEntry(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.time = source["time"];
+	        this.level = source["level"];
+	        this.message = source["message"];
+	        this.requestId = source["requestId"];
+	        this.attrs = source["attrs"];
+	        this.text = source["text"];
+	    }
+	}
+	export class LogFilter {
+	    level: string;
+	    requestId: string;
+	    query: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new LogFilter(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.level = source["level"];
+	        this.requestId = source["requestId"];
+	        this.query = source["query"];
+	    }
+	}
+
+}
+
+export namespace config {
+	
+	export class ReqResponse {
+	    ResultPath: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ReqResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.ResultPath = source["ResultPath"];
+	    }
+	}
+	export class Request {
+	    Method: string;
+	    Url: string;
+	    // Go type: ReqResponse
+	    Response: any;
+	    JsonBody: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Request(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.Method = source["Method"];
+	        this.Url = source["Url"];
+	        this.Response = this.convertValues(source["Response"], null);
+	        this.JsonBody = source["JsonBody"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class OutMethod {
+	    method: string;
+	    Text: string;
+	    Template: string;
+	    Expr: string;
+	    File: string;
+	    // Go type: Request
+	    Request: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new OutMethod(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.method = source["method"];
+	        this.Text = source["Text"];
+	        this.Template = source["Template"];
+	        this.Expr = source["Expr"];
+	        this.File = source["File"];
+	        this.Request = this.convertValues(source["Request"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Upstream {
+	    url?: string;
+	    expr?: string;
+	    weight?: number;
+	    priority?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new Upstream(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.url = source["url"];
+	        this.expr = source["expr"];
+	        this.weight = source["weight"];
+	        this.priority = source["priority"];
+	    }
+	}
+	export class UriMap {
 	    in: string;
-	    out: string;
+	    description?: string;
+	    out?: OutMethod[];
+	    baseEndpoint?: Upstream[];
+	    errorFormat?: string;
+	    modelFamily?: string;
 	
 	    static createFrom(source: any = {}) {
-	        return new Endpoint(source);
+	        return new UriMap(source);
 	    }
 	
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
 	        this.in = source["in"];
-	        this.out = source["out"];
+	        this.description = source["description"];
+	        this.out = this.convertValues(source["out"], OutMethod);
+	        this.baseEndpoint = this.convertValues(source["baseEndpoint"], Upstream);
+	        this.errorFormat = source["errorFormat"];
+	        this.modelFamily = source["modelFamily"];
 	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
 	}
-	export class EndpointsResponse {
-	    baseEndpoint: string;
-	    endpoints: Endpoint[];
+	export class UriGroup {
+	    name: string;
+	    hidden?: boolean;
+	    supportedUris: UriMap[];
+	    errorFormat?: string;
+	    modelFamily?: string;
 	
 	    static createFrom(source: any = {}) {
-	        return new EndpointsResponse(source);
+	        return new UriGroup(source);
 	    }
 	
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
-	        this.baseEndpoint = source["baseEndpoint"];
-	        this.endpoints = this.convertValues(source["endpoints"], Endpoint);
+	        this.name = source["name"];
+	        this.hidden = source["hidden"];
+	        this.supportedUris = this.convertValues(source["supportedUris"], UriMap);
+	        this.errorFormat = source["errorFormat"];
+	        this.modelFamily = source["modelFamily"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
+export namespace exchange {
+	
+	export class Timings {
+	    upstreamMs: number;
+	    totalMs: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new Timings(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.upstreamMs = source["upstreamMs"];
+	        this.totalMs = source["totalMs"];
+	    }
+	}
+	export class Fetch {
+	    name: string;
+	    method: string;
+	    url: string;
+	    status?: number;
+	    error?: string;
+	    durationMs: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new Fetch(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.method = source["method"];
+	        this.url = source["url"];
+	        this.status = source["status"];
+	        this.error = source["error"];
+	        this.durationMs = source["durationMs"];
+	    }
+	}
+	export class Message {
+	    method?: string;
+	    url?: string;
+	    status?: number;
+	    headers: Record<string, Array<string>>;
+	    body: string;
+	    bodyTruncated?: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new Message(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.method = source["method"];
+	        this.url = source["url"];
+	        this.status = source["status"];
+	        this.headers = source["headers"];
+	        this.body = source["body"];
+	        this.bodyTruncated = source["bodyTruncated"];
+	    }
+	}
+	export class Exchange {
+	    id: string;
+	    requestId: string;
+	    // Go type: time
+	    time: any;
+	    method: string;
+	    path: string;
+	    route: string;
+	    request: Message;
+	    upstreamRequest?: Message;
+	    upstream?: string;
+	    upstreamStatus?: number;
+	    upstreamAttempts?: number;
+	    upstreamResponse?: Message;
+	    response: Message;
+	    fetches?: Fetch[];
+	    sseEvents: number;
+	    timings: Timings;
+	    error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Exchange(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.requestId = source["requestId"];
+	        this.time = this.convertValues(source["time"], null);
+	        this.method = source["method"];
+	        this.path = source["path"];
+	        this.route = source["route"];
+	        this.request = this.convertValues(source["request"], Message);
+	        this.upstreamRequest = this.convertValues(source["upstreamRequest"], Message);
+	        this.upstream = source["upstream"];
+	        this.upstreamStatus = source["upstreamStatus"];
+	        this.upstreamAttempts = source["upstreamAttempts"];
+	        this.upstreamResponse = this.convertValues(source["upstreamResponse"], Message);
+	        this.response = this.convertValues(source["response"], Message);
+	        this.fetches = this.convertValues(source["fetches"], Fetch);
+	        this.sseEvents = source["sseEvents"];
+	        this.timings = this.convertValues(source["timings"], Timings);
+	        this.error = source["error"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	
+	
+	export class Summary {
+	    id: string;
+	    requestId: string;
+	    // Go type: time
+	    time: any;
+	    method: string;
+	    path: string;
+	    status: number;
+	    upstream?: string;
+	    upstreamStatus?: number;
+	    sseEvents: number;
+	    totalMs: number;
+	    error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Summary(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.requestId = source["requestId"];
+	        this.time = this.convertValues(source["time"], null);
+	        this.method = source["method"];
+	        this.path = source["path"];
+	        this.status = source["status"];
+	        this.upstream = source["upstream"];
+	        this.upstreamStatus = source["upstreamStatus"];
+	        this.sseEvents = source["sseEvents"];
+	        this.totalMs = source["totalMs"];
+	        this.error = source["error"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
+export namespace usage {
+	
+	export class Filter {
+	    from: string;
+	    to: string;
+	    profile: string;
+	    useCaseId: string;
+	    model: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Filter(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.from = source["from"];
+	        this.to = source["to"];
+	        this.profile = source["profile"];
+	        this.useCaseId = source["useCaseId"];
+	        this.model = source["model"];
+	    }
+	}
+	export class Record {
+	    day: string;
+	    profile: string;
+	    useCaseId: string;
+	    model: string;
+	    requests: number;
+	    inputTokens: number;
+	    outputTokens: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new Record(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.day = source["day"];
+	        this.profile = source["profile"];
+	        this.useCaseId = source["useCaseId"];
+	        this.model = source["model"];
+	        this.requests = source["requests"];
+	        this.inputTokens = source["inputTokens"];
+	        this.outputTokens = source["outputTokens"];
+	    }
+	}
+	export class Totals {
+	    requests: number;
+	    inputTokens: number;
+	    outputTokens: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new Totals(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.requests = source["requests"];
+	        this.inputTokens = source["inputTokens"];
+	        this.outputTokens = source["outputTokens"];
+	    }
+	}
+	export class Report {
+	    records: Record[];
+	    total: Totals;
+	
+	    static createFrom(source: any = {}) {
+	        return new Report(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.records = this.convertValues(source["records"], Record);
+	        this.total = this.convertValues(source["total"], Totals);
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
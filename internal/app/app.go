@@ -8,13 +8,22 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/exchange"
+	"bitbucket.org/atlassian-developers/proximity/internal/limits"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
 	"bitbucket.org/atlassian-developers/proximity/internal/proxy"
+	"bitbucket.org/atlassian-developers/proximity/internal/redact"
 	"bitbucket.org/atlassian-developers/proximity/internal/settings"
+	"bitbucket.org/atlassian-developers/proximity/internal/tracing"
 	"bitbucket.org/atlassian-developers/proximity/internal/update"
+	"bitbucket.org/atlassian-developers/proximity/internal/usage"
 	wruntime "github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
@@ -24,8 +33,19 @@ type App struct {
 	mu      sync.Mutex
 	running bool
 	logs    bytes.Buffer
+	entries []LogEntry
+
+	// exchanges keeps the most recent requests handled by the proxy for the inspector
+	exchanges *exchange.Store
+
+	// usage aggregates the tokens used through the proxy, it's shared with the command line proxy
+	usage *usage.Store
+
+	// limiter keeps the state of the config's limits across proxy restarts
+	limiter *limits.Limiter
 
 	proxy      proxy.Interface
+	tracer     *tracing.Tracer
 	pipeWriter io.WriteCloser
 	port       int
 
@@ -47,6 +67,9 @@ func NewApp(configPath string, port int, settingsPath, version, changelog string
 		settingsPath: settingsPath,
 		version:      version,
 		changelog:    changelog,
+		exchanges:    exchange.NewStore(exchange.DefaultCapacity),
+		usage:        usage.NewStore(usage.DefaultPath()),
+		limiter:      limits.New(limits.DefaultPath()),
 	}
 }
 
@@ -55,6 +78,10 @@ func NewApp(configPath string, port int, settingsPath, version, changelog string
 func (a *App) Startup(ctx context.Context) {
 	a.ctx = ctx
 
+	a.exchanges.Subscribe(func(e *exchange.Exchange) {
+		wruntime.EventsEmit(a.ctx, "proxy:exchange", e.Summary())
+	})
+
 	var err error
 
 	// Check if auto-start is enabled
@@ -80,6 +107,22 @@ func (a *App) Startup(ctx context.Context) {
 	}
 }
 
+// Shutdown is called when the app quits, the usage and limits batched up in memory are written out
+func (a *App) Shutdown(ctx context.Context) {
+	if err := a.flush(); err != nil {
+		log.Printf("Failed to write usage and limits: %v", err)
+	}
+}
+
+// flush writes the usage and limits batched up in memory to their files
+func (a *App) flush() error {
+	if err := a.usage.Flush(); err != nil {
+		return err
+	}
+
+	return a.limiter.Flush()
+}
+
 func (a *App) StartProxy() error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
@@ -89,19 +132,47 @@ func (a *App) StartProxy() error {
 	}
 
 	a.logs.Reset()
+	a.entries = nil
 
-	// Create a pipe and a logger that writes to it so we can stream proxy logs to the UI
+	// Create a pipe and a logger that writes to it so we can stream proxy logs to the UI. The logs are written as json
+	// so that they can be parsed into entries the UI can filter.
 	pr, pw := io.Pipe()
+
+	logger, err := logging.New(pw, logging.Options{Format: logging.FormatJson, Level: a.settings.LogLevel})
+	if err != nil {
+		pw.Close()
+		return err
+	}
+
 	a.pipeWriter = pw
-	logger := log.New(pw, "", log.LstdFlags)
+
+	tracer, err := tracing.New(tracing.Options{
+		OtlpEndpoint:   a.settings.Tracing.OtlpEndpoint,
+		File:           a.settings.Tracing.File,
+		ServiceVersion: a.version,
+		Logger:         logger,
+	})
+	if err != nil {
+		pw.Close()
+		a.pipeWriter = nil
+		return err
+	}
+
+	a.tracer = tracer
 
 	a.proxy = proxy.New(proxy.Options{
-		Port:     a.port,
-		TestMode: false,
-		Logger:   logger,
-		Config:   a.config,
-		Vars:     a.settings.Vars,
-		Version:  a.version,
+		Port:       a.port,
+		AdminPort:  a.settings.AdminPort,
+		TestMode:   false,
+		Logger:     logger,
+		Config:     a.config,
+		Vars:       a.settings.Vars,
+		SecretVars: a.settings.SecretVars,
+		Version:    a.version,
+		Exchanges:  a.exchanges,
+		Usage:      a.usage,
+		Limiter:    a.limiter,
+		Tracer:     tracer,
 	})
 
 	a.running = true
@@ -128,6 +199,16 @@ func (a *App) StopProxy() error {
 		return err
 	}
 
+	if err := a.tracer.Shutdown(a.ctx); err != nil {
+		return err
+	}
+
+	a.tracer = nil
+
+	if err := a.flush(); err != nil {
+		return err
+	}
+
 	// Close the pipe writer to unblock the pipeLogs goroutine
 	if a.pipeWriter != nil {
 		a.pipeWriter.Close()
@@ -158,9 +239,77 @@ func (a *App) ClearLogs() {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 	a.logs.Reset()
+	a.entries = nil
 	wruntime.EventsEmit(a.ctx, "proxy:log:cleared")
 }
 
+// GetLogEntries returns the accumulated log entries which match the filter
+func (a *App) GetLogEntries(filter LogFilter) []LogEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entries := []LogEntry{}
+
+	for _, entry := range a.entries {
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// ListExchanges returns the summaries of the most recent exchanges, oldest first
+func (a *App) ListExchanges() []exchange.Summary {
+	return a.exchanges.List()
+}
+
+// GetExchange returns an exchange with its requests and response
+func (a *App) GetExchange(id string) (*exchange.Exchange, error) {
+	e, ok := a.exchanges.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("exchange %s not found", id)
+	}
+
+	return e, nil
+}
+
+// ExportHar asks where to save the stored exchanges and writes them there as a HAR file. It returns the path written
+// to, which is empty when the dialog was cancelled.
+func (a *App) ExportHar() (string, error) {
+	path, err := wruntime.SaveFileDialog(a.ctx, wruntime.SaveDialogOptions{
+		Title:           "Export exchanges",
+		DefaultFilename: fmt.Sprintf("proximity-%s.har", time.Now().Format("20060102-150405")),
+		Filters:         []wruntime.FileFilter{{DisplayName: "HAR files (*.har)", Pattern: "*.har"}},
+	})
+	if err != nil || path == "" {
+		return "", err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := exchange.WriteHar(f, a.exchanges.All(), a.version); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// GetUsage returns the tokens used through the proxy per profile, use case, model and day, along with their total
+func (a *App) GetUsage(filter usage.Filter) (*usage.Report, error) {
+	return a.usage.Report(filter)
+}
+
+// ClearExchanges clears the stored exchanges
+func (a *App) ClearExchanges() {
+	a.exchanges.Clear()
+	wruntime.EventsEmit(a.ctx, "proxy:exchange:cleared")
+}
+
 // EndpointsResponse is the structure returned to the frontend
 type EndpointsResponse struct {
 	BaseEndpoint string            `json:"baseEndpoint"`
@@ -179,7 +328,7 @@ func (a *App) GetEndpoints() (*EndpointsResponse, error) {
 	}
 
 	return &EndpointsResponse{
-		BaseEndpoint: a.config.BaseEndpoint,
+		BaseEndpoint: a.config.BaseEndpoint.String(),
 		UriGroups:    visibleGroups,
 	}, nil
 }
@@ -203,20 +352,29 @@ func (a *App) pipeLogs(r io.Reader) {
 	scanner.Buffer(buf, 1024*1024)
 
 	for scanner.Scan() {
-		line := scanner.Text()
+		entry := parseLogEntry(scanner.Text())
+
 		a.mu.Lock()
-		a.logs.WriteString(line + "\n")
+		a.logs.WriteString(entry.Text + "\n")
+		a.entries = append(a.entries, entry)
 		a.mu.Unlock()
-		wruntime.EventsEmit(a.ctx, "proxy:log", line)
+
+		// The formatted line is kept for the plain log view, the entry carries the fields to filter by
+		wruntime.EventsEmit(a.ctx, "proxy:log", entry.Text)
+		wruntime.EventsEmit(a.ctx, "proxy:log:entry", entry)
 	}
 }
 
-func (a *App) logSettings(logger *log.Logger) {
+func (a *App) logSettings(logger *slog.Logger) {
 	logLineParts := []string{}
 
 	for key, value := range a.settings.Vars {
+		if a.settings.IsSecretVar(key) {
+			value = redact.Mask
+		}
+
 		logLineParts = append(logLineParts, fmt.Sprintf("%s=%s", key, value))
 	}
 
-	logger.Printf("loading variables %v", strings.Join(logLineParts, " "))
+	logger.Info("loading variables", "vars", strings.Join(logLineParts, " "))
 }
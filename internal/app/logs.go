@@ -0,0 +1,137 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+)
+
+// LogEntry is a structured log line from the proxy
+type LogEntry struct {
+	Time      string         `json:"time"`
+	Level     string         `json:"level"`
+	Message   string         `json:"message"`
+	RequestID string         `json:"requestId,omitempty"`
+	Attrs     map[string]any `json:"attrs,omitempty"`
+
+	// Text is the entry formatted as a single line for display
+	Text string `json:"text"`
+}
+
+// LogFilter selects log entries, empty fields match every entry
+type LogFilter struct {
+	// Level is the minimum level of the entries, such as warn
+	Level string `json:"level"`
+
+	RequestID string `json:"requestId"`
+
+	// Query is matched against the text of the entries ignoring case
+	Query string `json:"query"`
+}
+
+// parseLogEntry parses a line written by the proxy's json logger. Lines which aren't json are kept as info messages
+// so that nothing written to the pipe is lost.
+func parseLogEntry(line string) LogEntry {
+	var record map[string]any
+
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		entry := LogEntry{
+			Time:    time.Now().Format(time.RFC3339Nano),
+			Level:   slog.LevelInfo.String(),
+			Message: line,
+		}
+
+		entry.Text = formatLogEntry(entry)
+		return entry
+	}
+
+	entry := LogEntry{Attrs: make(map[string]any)}
+
+	for key, value := range record {
+		switch key {
+		case slog.TimeKey:
+			entry.Time = fmt.Sprint(value)
+		case slog.LevelKey:
+			entry.Level = fmt.Sprint(value)
+		case slog.MessageKey:
+			entry.Message = fmt.Sprint(value)
+		case logging.RequestIDKey:
+			entry.RequestID = fmt.Sprint(value)
+		default:
+			entry.Attrs[key] = value
+		}
+	}
+
+	entry.Text = formatLogEntry(entry)
+	return entry
+}
+
+// formatLogEntry formats an entry the same way the logs were shown before they were structured, with the level,
+// request id and attributes added.
+func formatLogEntry(entry LogEntry) string {
+	var b strings.Builder
+
+	if t, err := time.Parse(time.RFC3339Nano, entry.Time); err == nil {
+		b.WriteString(t.Local().Format("2006/01/02 15:04:05 "))
+	}
+
+	b.WriteString(entry.Level)
+
+	if entry.RequestID != "" {
+		fmt.Fprintf(&b, " [%s]", entry.RequestID)
+	}
+
+	b.WriteString(" ")
+	b.WriteString(entry.Message)
+
+	keys := make([]string, 0, len(entry.Attrs))
+	for key := range entry.Attrs {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, err := json.Marshal(entry.Attrs[key])
+		if err != nil {
+			value = []byte(fmt.Sprint(entry.Attrs[key]))
+		}
+
+		// Plain strings are shown without quotes when they don't need them
+		if s, ok := entry.Attrs[key].(string); ok && s != "" && !strings.ContainsAny(s, " \"=\n") {
+			value = []byte(s)
+		}
+
+		fmt.Fprintf(&b, " %s=%s", key, value)
+	}
+
+	return b.String()
+}
+
+// matches reports whether the entry is selected by the filter
+func (f LogFilter) matches(entry LogEntry) bool {
+	if f.Level != "" {
+		minLevel, err := logging.ParseLevel(f.Level)
+		if err == nil {
+			level, err := logging.ParseLevel(entry.Level)
+			if err == nil && level < minLevel {
+				return false
+			}
+		}
+	}
+
+	if f.RequestID != "" && entry.RequestID != f.RequestID {
+		return false
+	}
+
+	if f.Query != "" && !strings.Contains(strings.ToLower(entry.Text), strings.ToLower(f.Query)) {
+		return false
+	}
+
+	return true
+}
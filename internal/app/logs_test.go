@@ -0,0 +1,59 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLogEntry(t *testing.T) {
+	entry := parseLogEntry(`{"time":"2025-01-02T03:04:05Z","level":"WARN","msg":"upstream failed, failing over","upstream":"example.com","status":503,"request_id":"abc"}`)
+
+	if entry.Level != "WARN" || entry.Message != "upstream failed, failing over" || entry.RequestID != "abc" {
+		t.Errorf("Expected the fields to be parsed, got: %+v", entry)
+	}
+
+	if !strings.HasSuffix(entry.Text, "WARN [abc] upstream failed, failing over status=503 upstream=example.com") {
+		t.Errorf("Expected the entry to be formatted, got: %s", entry.Text)
+	}
+
+	plain := parseLogEntry("not json")
+
+	if plain.Level != "INFO" || plain.Message != "not json" {
+		t.Errorf("Expected lines which aren't json to be kept, got: %+v", plain)
+	}
+}
+
+func TestLogFilter(t *testing.T) {
+	entries := []LogEntry{
+		parseLogEntry(`{"level":"DEBUG","msg":"using cached slauth token","request_id":"a"}`),
+		parseLogEntry(`{"level":"INFO","msg":"request received","path":"/chat","request_id":"a"}`),
+		parseLogEntry(`{"level":"ERROR","msg":"failed to render request","request_id":"b"}`),
+	}
+
+	tests := []struct {
+		name     string
+		filter   LogFilter
+		expected []string
+	}{
+		{name: "everything", filter: LogFilter{}, expected: []string{"using cached slauth token", "request received", "failed to render request"}},
+		{name: "level", filter: LogFilter{Level: "info"}, expected: []string{"request received", "failed to render request"}},
+		{name: "request id", filter: LogFilter{RequestID: "a", Level: "info"}, expected: []string{"request received"}},
+		{name: "query", filter: LogFilter{Query: "/CHAT"}, expected: []string{"request received"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var matched []string
+
+			for _, entry := range entries {
+				if tt.filter.matches(entry) {
+					matched = append(matched, entry.Message)
+				}
+			}
+
+			if strings.Join(matched, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got: %v", tt.expected, matched)
+			}
+		})
+	}
+}
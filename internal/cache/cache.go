@@ -0,0 +1,244 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxSize is how many responses a store keeps by default
+const DefaultMaxSize = 1000
+
+// Entry is a cached response.
+type Entry struct {
+	Status  int         `json:"status"`
+	Header  http.Header `json:"header"`
+	Body    []byte      `json:"body"`
+	Created time.Time   `json:"created"`
+
+	// Expires is when the entry stops being served, it never does when zero
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// Expired is true once the entry shouldn't be served any more.
+func (e *Entry) Expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+// Store keeps responses by key, dropping the least recently used once it's full.
+type Store interface {
+	// Get returns the entry for a key, expired entries aren't returned
+	Get(key string) (*Entry, bool)
+	Put(key string, entry *Entry) error
+}
+
+// New returns a store which keeps up to maxSize responses in dir, or in memory when dir is empty.
+func New(dir string, maxSize int) Store {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	if dir == "" {
+		return &memoryStore{
+			maxSize: maxSize,
+			entries: make(map[string]*list.Element),
+			order:   list.New(),
+		}
+	}
+
+	return &diskStore{dir: dir, maxSize: maxSize}
+}
+
+type memoryEntry struct {
+	key   string
+	entry *Entry
+}
+
+type memoryStore struct {
+	maxSize int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+func (s *memoryStore) Get(key string) (*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*memoryEntry).entry
+
+	if entry.Expired(time.Now()) {
+		s.order.Remove(element)
+		delete(s.entries, key)
+
+		return nil, false
+	}
+
+	s.order.MoveToFront(element)
+	return entry, true
+}
+
+func (s *memoryStore) Put(key string, entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[key]; ok {
+		element.Value.(*memoryEntry).entry = entry
+		s.order.MoveToFront(element)
+
+		return nil
+	}
+
+	s.entries[key] = s.order.PushFront(&memoryEntry{key: key, entry: entry})
+
+	for s.order.Len() > s.maxSize {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryEntry).key)
+	}
+
+	return nil
+}
+
+// diskStore keeps each entry in its own file named by the hash of its key. The modification time of the files is
+// updated when they're read so that it orders them by when they were last used.
+type diskStore struct {
+	dir     string
+	maxSize int
+
+	mu sync.Mutex
+}
+
+func (s *diskStore) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(hash[:])+".json")
+}
+
+func (s *diskStore) Get(key string) (*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.path(key)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	var entry Entry
+
+	if err := json.Unmarshal(data, &entry); err != nil {
+		os.Remove(path)
+		return nil, false
+	}
+
+	now := time.Now()
+
+	if entry.Expired(now) {
+		os.Remove(path)
+		return nil, false
+	}
+
+	os.Chtimes(path, now, now)
+	return &entry, true
+}
+
+func (s *diskStore) Put(key string, entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+
+	// The entry is written to a temporary file and moved into place so that it's never read half written
+	tmp, err := os.CreateTemp(s.dir, "entry-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path(key)); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+
+	return s.evict()
+}
+
+// evict removes the least recently used entries over the max size.
+func (s *diskStore) evict() error {
+	dirEntries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to evict cache entries: %w", err)
+	}
+
+	type file struct {
+		name    string
+		modTime time.Time
+	}
+
+	var files []file
+
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), ".json") {
+			continue
+		}
+
+		info, err := dirEntry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to evict cache entries: %w", err)
+		}
+
+		files = append(files, file{name: dirEntry.Name(), modTime: info.ModTime()})
+	}
+
+	if len(files) <= s.maxSize {
+		return nil
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	for _, f := range files[:len(files)-s.maxSize] {
+		if err := os.Remove(filepath.Join(s.dir, f.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to evict cache entries: %w", err)
+		}
+	}
+
+	return nil
+}
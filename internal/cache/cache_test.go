@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return New("", 2) },
+		"disk":   func(t *testing.T) Store { return New(filepath.Join(t.TempDir(), "cache"), 2) },
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+
+			put := func(key string, expires time.Time) {
+				if err := store.Put(key, &Entry{Status: 200, Body: []byte(key), Created: time.Now(), Expires: expires}); err != nil {
+					t.Fatal(err)
+				}
+
+				// Disk stores order entries by their modification time
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			put("a", time.Time{})
+			put("b", time.Time{})
+
+			entry, ok := store.Get("a")
+			if !ok || string(entry.Body) != "a" {
+				t.Fatalf("Expected the entry for a, got: %v", entry)
+			}
+
+			time.Sleep(10 * time.Millisecond)
+
+			// b is the least recently used so it's dropped
+			put("c", time.Time{})
+
+			if _, ok := store.Get("b"); ok {
+				t.Error("Expected b to have been evicted")
+			}
+
+			for _, key := range []string{"a", "c"} {
+				if _, ok := store.Get(key); !ok {
+					t.Errorf("Expected %s to be kept", key)
+				}
+			}
+
+			put("expired", time.Now().Add(-time.Second))
+
+			if _, ok := store.Get("expired"); ok {
+				t.Error("Expected an expired entry not to be returned")
+			}
+		})
+	}
+}
+
+func TestDiskStoreSurvivesRestarts(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := New(dir, 0).Put("a", &Entry{Status: 200, Body: []byte("data: 1\n\n")}); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, ok := New(dir, 0).Get("a")
+	if !ok || string(entry.Body) != "data: 1\n\n" {
+		t.Errorf("Expected the entry to be read back, got: %v", entry)
+	}
+
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("Expected a single file for the entry, got: %d", len(files))
+	}
+}
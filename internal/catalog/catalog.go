@@ -0,0 +1,159 @@
+package catalog
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed builtin.yaml
+var builtin []byte
+
+// DefaultPath is where the user's models are read from unless another file is given.
+func DefaultPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".config", "proximity", "models.yaml")
+}
+
+// Model is an alias of a model along with the id its provider knows it by.
+type Model struct {
+	Alias string `json:"alias"`
+	ID    string `json:"id"`
+}
+
+// Catalog maps the aliases of models to their provider's ids. Aliases belong to a family, the routes which take the
+// same ids, e.g. Claude has different ids on Bedrock and Vertex AI.
+type Catalog struct {
+	families map[string]map[string]string
+}
+
+// Builtin returns the catalog of models which ship with the proxy.
+func Builtin() *Catalog {
+	c, err := parse(builtin)
+	if err != nil {
+		panic(fmt.Sprintf("invalid built-in model catalog: %v", err))
+	}
+
+	return c
+}
+
+// Load returns the built-in catalog with the models of a user file added. The file's aliases replace built-in ones
+// of the same name, and a file which doesn't exist adds nothing.
+func Load(path string) (*Catalog, error) {
+	c := Builtin()
+
+	if path == "" {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model catalog: %w", err)
+	}
+
+	user, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse model catalog %s: %w", path, err)
+	}
+
+	for family, models := range user.families {
+		if c.families[family] == nil {
+			c.families[family] = make(map[string]string)
+		}
+
+		for alias, id := range models {
+			c.families[family][alias] = id
+		}
+	}
+
+	return c, nil
+}
+
+func parse(data []byte) (*Catalog, error) {
+	families := make(map[string]map[string]string)
+
+	if err := yaml.Unmarshal(data, &families); err != nil {
+		return nil, err
+	}
+
+	for family, models := range families {
+		for alias, id := range models {
+			if id == "" {
+				return nil, fmt.Errorf("alias %s of %s doesn't have a model id", alias, family)
+			}
+		}
+	}
+
+	return &Catalog{families: families}, nil
+}
+
+// HasFamily reports whether the catalog has models for a family.
+func (c *Catalog) HasFamily(family string) bool {
+	_, ok := c.families[family]
+	return ok
+}
+
+// Resolve returns the id of a model given either its alias or its id. It's false for models the family doesn't have.
+func (c *Catalog) Resolve(family, model string) (string, bool) {
+	models := c.families[family]
+
+	if id, ok := models[model]; ok {
+		return id, true
+	}
+
+	for _, id := range models {
+		if id == model {
+			return id, true
+		}
+	}
+
+	return "", false
+}
+
+// Models returns the aliases of a family with their ids, in order of alias.
+func (c *Catalog) Models(family string) []Model {
+	models := make([]Model, 0, len(c.families[family]))
+
+	for alias, id := range c.families[family] {
+		models = append(models, Model{Alias: alias, ID: id})
+	}
+
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].Alias < models[j].Alias
+	})
+
+	return models
+}
+
+// AliasesOf returns the aliases of a family which stand for a model id, in order.
+func (c *Catalog) AliasesOf(family, id string) []string {
+	aliases := []string{}
+
+	for _, model := range c.Models(family) {
+		if model.ID == id {
+			aliases = append(aliases, model.Alias)
+		}
+	}
+
+	return aliases
+}
+
+// Aliases returns the aliases of a family in order.
+func (c *Catalog) Aliases(family string) []string {
+	models := c.Models(family)
+	aliases := make([]string, len(models))
+
+	for i, model := range models {
+		aliases[i] = model.Alias
+	}
+
+	return aliases
+}
@@ -0,0 +1,98 @@
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	c := Builtin()
+
+	tests := []struct {
+		name     string
+		family   string
+		model    string
+		expected string
+		ok       bool
+	}{
+		{name: "alias", family: "bedrock", model: "claude-sonnet-4-20250514", expected: "anthropic.claude-sonnet-4-20250514-v1:0", ok: true},
+		{name: "latest", family: "vertex", model: "claude-sonnet-latest", expected: "claude-sonnet-4-5@20250929", ok: true},
+		{name: "provider id", family: "bedrock", model: "anthropic.claude-sonnet-4-20250514-v1:0", expected: "anthropic.claude-sonnet-4-20250514-v1:0", ok: true},
+		{name: "older model", family: "bedrock", model: "claude-3-haiku-20240307", expected: "anthropic.claude-3-haiku-20240307-v1:0", ok: true},
+		{name: "versioned dated name", family: "vertex", model: "claude-3-5-sonnet-20241022-v2", expected: "claude-3-5-sonnet-v2@20241022", ok: true},
+		{name: "other family", family: "bedrock", model: "claude-sonnet-4@20250514"},
+		{name: "unknown", family: "openai", model: "gpt-2"},
+		{name: "unknown family", family: "unknown", model: "gpt-4o"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, ok := c.Resolve(tt.family, tt.model)
+
+			if id != tt.expected || ok != tt.ok {
+				t.Errorf("Expected %q %t, got: %q %t", tt.expected, tt.ok, id, ok)
+			}
+		})
+	}
+}
+
+func TestAliasesOf(t *testing.T) {
+	c := Builtin()
+
+	aliases := c.AliasesOf("bedrock", "anthropic.claude-sonnet-4-5-20250929-v1:0")
+	expected := []string{"claude-sonnet-4-5", "claude-sonnet-4-5-20250929", "claude-sonnet-latest"}
+
+	if !reflect.DeepEqual(aliases, expected) {
+		t.Errorf("Expected %v, got: %v", expected, aliases)
+	}
+
+	if aliases := c.AliasesOf("vertex", "anthropic.claude-sonnet-4-5-20250929-v1:0"); len(aliases) != 0 {
+		t.Errorf("Expected no aliases for another family's id, got: %v", aliases)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.yaml")
+
+	user := `
+openai:
+  gpt-latest: gpt-4o
+local:
+  llama: llama-3.3-70b
+`
+
+	if err := os.WriteFile(path, []byte(user), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, _ := c.Resolve("openai", "gpt-latest"); id != "gpt-4o" {
+		t.Errorf("Expected the user's alias to replace the built-in one, got: %s", id)
+	}
+
+	if id, _ := c.Resolve("openai", "gpt-5"); id != "gpt-5-2025-08-07" {
+		t.Errorf("Expected the built-in aliases to be kept, got: %s", id)
+	}
+
+	if expected := []Model{{Alias: "llama", ID: "llama-3.3-70b"}}; !reflect.DeepEqual(c.Models("local"), expected) {
+		t.Errorf("Expected %v, got: %v", expected, c.Models("local"))
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err != nil {
+		t.Errorf("Expected a missing file to be the same as an empty one, got: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("openai:\n  gpt-latest:\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Error("Expected an alias without an id to be refused")
+	}
+}
@@ -16,23 +16,173 @@ const (
 	RemoveOperation Operation = "remove"
 )
 
+// SseMode controls how a response body override is applied to a text/event-stream response
+type SseMode string
+
+const (
+	// SseLines renders every raw line of the stream with the line as a string in "event". This is the default.
+	SseLines SseMode = "lines"
+
+	// SseEvents parses the stream and renders every event with "event" set to an object with the name, id, data,
+	// retry and the data parsed as json. The output can be nil, an event object or a list of event objects.
+	SseEvents SseMode = "events"
+)
+
 type Config struct {
-	BaseEndpoint string     `yaml:"baseEndpoint"`
-	UriGroups    []UriGroup `yaml:"uriGroups"`
-	Overrides    Overrides  `yaml:"overrides"`
+	BaseEndpoint ExprUpstreams `yaml:"baseEndpoint"`
+	Failover     Failover      `yaml:"failover"`
+	Redact       Redact        `yaml:"redact"`
+	Usage        Usage         `yaml:"usage"`
+	Limits       []LimitRule   `yaml:"limits"`
+	UriGroups    []UriGroup    `yaml:"uriGroups"`
+	Overrides    Overrides     `yaml:"overrides"`
+}
+
+// Redact lists what is masked in logs and recorded exchanges on top of the credential headers and JWTs which always
+// are.
+type Redact struct {
+	Headers []string `yaml:"headers"`
+
+	// JsonPaths are JSON pointers into request and response bodies, * matches any key or index
+	JsonPaths []string `yaml:"jsonPaths"`
+}
+
+// Usage picks out who the tokens used by a request are accounted to, each is rendered with the request. The model
+// defaults to the one the response says it came from, then the model in the request body or path.
+type Usage struct {
+	Profile   Input `yaml:"profile"`
+	UseCaseId Input `yaml:"useCaseId"`
+	Model     Input `yaml:"model"`
+}
+
+// LimitRule limits each of its profiles separately on the routes it covers, a request has to be within every rule
+// which applies to it. The profile of a request is the one its usage is accounted to.
+type LimitRule struct {
+	// Name identifies the rule's state so that it's kept when rules are added or reordered, it defaults to the rule's
+	// position
+	Name string `yaml:"name"`
+
+	// Profiles are the profiles limited, every profile is when empty
+	Profiles []string `yaml:"profiles"`
+
+	// Routes are the supported uris limited, their requests count towards the same limit. Every route is when empty.
+	Routes []string `yaml:"routes"`
+
+	Limit `yaml:",inline"`
+}
+
+// Limit caps what a profile can use, zero is unlimited.
+type Limit struct {
+	RequestsPerMinute int `yaml:"requestsPerMinute"`
+
+	// ConcurrentStreams caps the requests in flight, streamed responses count until they've been sent
+	ConcurrentStreams int `yaml:"concurrentStreams"`
+
+	// TokensPerDay stops requests once the tokens used today reach it
+	TokensPerDay int64 `yaml:"tokensPerDay"`
+}
+
+// ErrorFormat is the shape of the errors the proxy responds with itself, such as when a limit is reached
+type ErrorFormat string
+
+const (
+	OpenAIErrorFormat    ErrorFormat = "openai"
+	AnthropicErrorFormat ErrorFormat = "anthropic"
+	GeminiErrorFormat    ErrorFormat = "gemini"
+)
+
+// Failover controls when a request is retried against the next upstream. Connection errors always fail over.
+type Failover struct {
+	StatusCodes []int `yaml:"statusCodes"`
+}
+
+// Upstream is a single target requests can be proxied to. Upstreams with the lowest priority are tried first and
+// requests are spread between upstreams of the same priority by their weight.
+type Upstream struct {
+	Url      string `yaml:"url" json:"url,omitempty"`
+	Expr     string `yaml:"expr" json:"expr,omitempty"`
+	Weight   int    `yaml:"weight" json:"weight,omitempty"`
+	Priority int    `yaml:"priority" json:"priority,omitempty"`
+}
+
+// Upstreams is a list of upstreams which can also be written as a single url.
+type Upstreams []Upstream
+
+func (u *Upstreams) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalUpstreams(node, (*[]Upstream)(u), func(value string) Upstream {
+		return Upstream{Url: value}
+	})
+}
+
+// ExprUpstreams is a list of upstreams which can also be written as a single expr.
+type ExprUpstreams []Upstream
+
+func (u *ExprUpstreams) UnmarshalYAML(node *yaml.Node) error {
+	return unmarshalUpstreams(node, (*[]Upstream)(u), func(value string) Upstream {
+		return Upstream{Expr: value}
+	})
+}
+
+// String returns the url or expr of each upstream for display.
+func (u ExprUpstreams) String() string {
+	values := make([]string, 0, len(u))
+
+	for _, upstream := range u {
+		if upstream.Url != "" {
+			values = append(values, upstream.Url)
+		} else {
+			values = append(values, strings.TrimSpace(upstream.Expr))
+		}
+	}
+
+	return strings.Join(values, ", ")
+}
+
+func unmarshalUpstreams(node *yaml.Node, upstreams *[]Upstream, fromScalar func(string) Upstream) error {
+	if node.ShortTag() == "!!null" {
+		*upstreams = nil
+		return nil
+	}
+
+	if node.Kind == yaml.ScalarNode {
+		*upstreams = []Upstream{fromScalar(node.Value)}
+		return nil
+	}
+
+	var list []Upstream
+
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+
+	*upstreams = list
+	return nil
 }
 
 type UriGroup struct {
 	Name          string   `yaml:"name" json:"name"`
 	Hidden        bool     `yaml:"hidden" json:"hidden,omitempty"`
 	SupportedUris []UriMap `yaml:"supportedUris" json:"supportedUris"`
+
+	// ErrorFormat is the default error format of the group's uris
+	ErrorFormat ErrorFormat `yaml:"errorFormat" json:"errorFormat,omitempty"`
+
+	// ModelFamily is the default model family of the group's uris
+	ModelFamily string `yaml:"modelFamily" json:"modelFamily,omitempty"`
 }
 
 type UriMap struct {
 	In           string      `yaml:"in" json:"in"`
 	Description  string      `yaml:"description" json:"description,omitempty"`
 	Out          []OutMethod `yaml:"out" json:"out,omitempty"`
-	BaseEndpoint string      `yaml:"baseEndpoint" json:"baseEndpoint,omitempty"`
+	BaseEndpoint Upstreams   `yaml:"baseEndpoint" json:"baseEndpoint,omitempty"`
+
+	// ErrorFormat shapes the errors the proxy responds with like the provider's, plain text is used when it's empty
+	ErrorFormat ErrorFormat `yaml:"errorFormat" json:"errorFormat,omitempty"`
+
+	// ModelFamily names the models of the catalog the uri takes. Aliases in the request's model are replaced with their
+	// ids before the request is rendered and unknown models are refused. Models aren't checked when it's empty.
+	ModelFamily string `yaml:"modelFamily" json:"modelFamily,omitempty"`
 }
 
 type Forward struct {
@@ -47,6 +197,55 @@ type FetchRequest struct {
 	Headers []Header `yaml:"headers"`
 	Body    Input    `yaml:"body"`
 	Timeout string   `yaml:"timeout"`
+	Retry   *Retry   `yaml:"retry"`
+}
+
+// Retry controls retrying requests which fail with a connection error or a retryable response. The delay between
+// attempts backs off exponentially with jitter unless the response has a Retry-After header.
+type Retry struct {
+	// MaxAttempts includes the first attempt, so 1 disables retrying
+	MaxAttempts int   `yaml:"maxAttempts"`
+	StatusCodes []int `yaml:"statusCodes"`
+
+	// When is an expr evaluated against each response with the status, headers and attempt number, the attempt
+	// is retried if it returns true. Connection errors are retried regardless
+	When string `yaml:"when"`
+
+	InitialDelay string `yaml:"initialDelay"`
+	MaxDelay     string `yaml:"maxDelay"`
+}
+
+// Fallback sends a request on to other routes when its upstream fails, before anything has been sent to the client.
+// Each route renders the request as the client sent it with its own overrides, so a conversation for Claude on Bedrock
+// can carry on with Claude on Vertex AI. The routes a request falls back to don't fall back themselves.
+type Fallback struct {
+	// Routes are the supported uris tried in order, each has to have an out method for the request's method
+	Routes []string `yaml:"routes"`
+
+	// When is an expr evaluated against the upstream's response with the status, headers, error and, for error
+	// statuses, the body. The request falls back if it returns true. Connection errors, 429s and 5xxs fall back when
+	// it's empty.
+	When string `yaml:"when"`
+}
+
+// Cache answers repeated requests with the response given to the first one, without contacting the upstream or
+// making any fetches. Streamed responses are cached once they've been read to the end and are replayed as a stream.
+type Cache struct {
+	// Key is an expr evaluated against the request, requests with the same key get the same response. It defaults to
+	// the method, path and a hash of the body with its keys sorted
+	Key string `yaml:"key"`
+
+	// When is an expr evaluated against the request, the response is only cached if it returns true
+	When string `yaml:"when"`
+
+	// Ttl is how long a response is served for, it's kept until it's evicted when empty
+	Ttl string `yaml:"ttl"`
+
+	// MaxSize is how many responses are kept before the least recently used are evicted
+	MaxSize int `yaml:"maxSize"`
+
+	// Dir keeps the responses on disk so they survive restarts, they're kept in memory when empty
+	Dir string `yaml:"dir"`
 }
 
 type Fetch struct {
@@ -68,12 +267,47 @@ type Overrides struct {
 
 	// First layer is route
 	// Second layer is http method
-	Uris map[string]map[string]RequestResponse `yaml:"uris"`
+	Uris map[string]map[string]Variants `yaml:"uris"`
+}
+
+// Variants are the overrides for a single route and method. They're either a single RequestResponse or a list of them
+// which are checked in order, the first whose when expr matches the request is merged on top of global.
+type Variants []RequestResponse
+
+func (v *Variants) UnmarshalYAML(node *yaml.Node) error {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	if node.Kind == yaml.SequenceNode {
+		var variants []RequestResponse
+
+		if err := node.Decode(&variants); err != nil {
+			return err
+		}
+
+		*v = variants
+		return nil
+	}
+
+	var variant RequestResponse
+
+	if err := node.Decode(&variant); err != nil {
+		return err
+	}
+
+	*v = Variants{variant}
+	return nil
 }
 
 type RequestResponse struct {
+	// When is an expr evaluated against the request, the overrides are only used if it returns true
+	When     string         `yaml:"when,omitempty"`
 	Forward  *Forward       `yaml:"forward,omitempty"`
 	Fetch    *Fetch         `yaml:"fetch,omitempty"`
+	Retry    *Retry         `yaml:"retry,omitempty"`
+	Fallback *Fallback      `yaml:"fallback,omitempty"`
+	Cache    *Cache         `yaml:"cache,omitempty"`
 	Request  OverrideConfig `yaml:"request,omitempty"`
 	Response OverrideConfig `yaml:"response,omitempty"`
 }
@@ -101,6 +335,7 @@ func (i Input) IsEmpty() bool {
 type Header struct {
 	Operation Operation `yaml:"op"`
 	Name      string    `yaml:"name"`
+	When      string    `yaml:"when"`
 	Input     `yaml:",inline"`
 }
 
@@ -120,12 +355,18 @@ type Body struct {
 	Text     string  `yaml:"text"`
 	Template string  `yaml:"template"`
 	Expr     string  `yaml:"expr"`
+	Sse      SseMode `yaml:"sse"`
+
+	// Transform is the name of a built-in translator between APIs, e.g. openai-chat-to-anthropic. It translates the
+	// request, the response and every event of a streamed response before any other override is applied
+	Transform string `yaml:"transform"`
 }
 
 type Patch struct {
 	Operation string `json:"op" yaml:"op"`
 	Path      string `json:"path" yaml:"path"`
 	Value     string `json:"value" yaml:"value"`
+	When      string `json:"-" yaml:"when"`
 }
 
 func ReadConfig(configData string) (*Config, error) {
@@ -0,0 +1,628 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/translate"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Compiler compiles expr and template sources so that errors in them can be reported before the config is used to
+// serve requests.
+type Compiler interface {
+	CompileExpr(exprStr string) error
+	CompileTemplate(templateStr string) error
+}
+
+// ValidationError is a single problem found in a config file along with its position in the file.
+type ValidationError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+// ValidationErrors holds every problem found in a config file, ordered by position.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	lines := make([]string, 0, len(e))
+
+	for _, validationErr := range e {
+		lines = append(lines, validationErr.Error())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+var patchOperations = []string{"add", "remove", "replace", "move", "copy", "test"}
+
+var errorFormats = []string{string(OpenAIErrorFormat), string(AnthropicErrorFormat), string(GeminiErrorFormat)}
+
+// Validate checks a raw config for problems which a plain yaml.Unmarshal silently ignores: unknown keys, overrides for
+// routes or methods that no supported uri defines, headers with conflicting inputs, invalid patch operations and
+// unparsable timeouts. Every expr and template is compiled with the compiler if one is provided.
+//
+// A ValidationErrors is returned if any problems are found.
+func Validate(data []byte, compiler Compiler) error {
+	var root yaml.Node
+
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	var cfg Config
+
+	if err := root.Decode(&cfg); err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	v := &validator{
+		compiler: compiler,
+		routes:   make(map[string]bool),
+	}
+
+	for _, uriGroup := range cfg.UriGroups {
+		for _, supportedUri := range uriGroup.SupportedUris {
+			v.routes[supportedUri.In] = true
+		}
+	}
+
+	v.walk(&root, reflect.TypeOf(cfg))
+	v.checkOverrideRoutes(&root, &cfg)
+	v.checkLimitRoutes(&root)
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		if v.errs[i].Line != v.errs[j].Line {
+			return v.errs[i].Line < v.errs[j].Line
+		}
+
+		return v.errs[i].Column < v.errs[j].Column
+	})
+
+	return v.errs
+}
+
+type validator struct {
+	compiler Compiler
+	errs     ValidationErrors
+
+	// routes are the supported uris of the config
+	routes map[string]bool
+}
+
+func (v *validator) addError(node *yaml.Node, format string, args ...any) {
+	v.errs = append(v.errs, ValidationError{
+		Line:    node.Line,
+		Column:  node.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// walk follows the yaml node tree alongside the Go type it decodes into.
+func (v *validator) walk(node *yaml.Node, t reflect.Type) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			v.walk(child, t)
+		}
+
+		return
+	case yaml.AliasNode:
+		// Anchors are validated where they are defined, validating them again at every alias only duplicates errors
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind == yaml.MappingNode {
+			v.walkStruct(node, t)
+		}
+	case reflect.Slice:
+		if node.Kind == yaml.SequenceNode {
+			for _, item := range node.Content {
+				v.walk(item, t.Elem())
+			}
+		}
+
+		if t == reflect.TypeOf(Variants{}) {
+			v.checkVariants(node)
+		}
+	case reflect.Map:
+		if node.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				v.walk(node.Content[i+1], t.Elem())
+			}
+		}
+	}
+}
+
+func (v *validator) walkStruct(node *yaml.Node, t reflect.Type) {
+	fields := make(map[string]reflect.Type)
+	checkedTypes := collectFields(t, fields)
+
+	for _, checkedType := range checkedTypes {
+		v.check(node, checkedType)
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		// Merge keys pull in an anchor which is validated where it is defined
+		if key.Tag == "!!merge" {
+			continue
+		}
+
+		fieldType, ok := fields[key.Value]
+		if !ok {
+			v.addError(key, "unknown key %q%s", key.Value, suggestKey(key.Value, fields))
+			continue
+		}
+
+		v.walk(value, fieldType)
+	}
+}
+
+// collectFields gathers the yaml keys of a struct including those of inlined structs. The struct type and every
+// inlined type are returned so that they can all be checked against the same node.
+func collectFields(t reflect.Type, fields map[string]reflect.Type) []reflect.Type {
+	types := []reflect.Type{t}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		if !field.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+
+		if name == "-" {
+			continue
+		}
+
+		if strings.Contains(opts, "inline") {
+			types = append(types, collectFields(field.Type, fields)...)
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		fields[name] = field.Type
+	}
+
+	return types
+}
+
+// check runs the semantic checks for a single config type.
+func (v *validator) check(node *yaml.Node, t reflect.Type) {
+	switch t {
+	case reflect.TypeOf(Config{}):
+		// A list of upstreams is checked item by item, only the single expr shorthand is compiled here
+		if baseEndpoint := lookupNode(node, "baseEndpoint"); baseEndpoint != nil && baseEndpoint.Kind == yaml.ScalarNode {
+			v.compileExpr(node, "baseEndpoint", baseEndpoint.Value)
+		}
+	case reflect.TypeOf(Upstream{}):
+		var upstream Upstream
+
+		if err := node.Decode(&upstream); err == nil {
+			v.checkUpstream(node, upstream)
+		}
+	case reflect.TypeOf(RequestResponse{}):
+		var reqResp RequestResponse
+
+		if err := node.Decode(&reqResp); err == nil {
+			v.compileExpr(node, "when", reqResp.When)
+		}
+	case reflect.TypeOf(Input{}):
+		var input Input
+
+		if err := node.Decode(&input); err == nil {
+			v.compileExpr(node, "expr", input.Expr)
+			v.compileTemplate(node, "template", input.Template)
+		}
+	case reflect.TypeOf(Body{}):
+		var body Body
+
+		if err := node.Decode(&body); err == nil {
+			v.compileExpr(node, "expr", body.Expr)
+			v.compileTemplate(node, "template", body.Template)
+
+			if body.Sse != "" && body.Sse != SseLines && body.Sse != SseEvents {
+				v.addError(valueNode(node, "sse"), "invalid sse mode %q, expected %s or %s", body.Sse, SseLines, SseEvents)
+			}
+
+			if _, ok := translate.Get(body.Transform); body.Transform != "" && !ok {
+				v.addError(valueNode(node, "transform"), "unknown transform %q, expected one of %s", body.Transform, strings.Join(translate.Names(), ", "))
+			}
+		}
+	case reflect.TypeOf(StatusCodeInput{}):
+		var statusCode StatusCodeInput
+
+		if err := node.Decode(&statusCode); err == nil {
+			v.compileExpr(node, "expr", statusCode.Expr)
+		}
+	case reflect.TypeOf(Header{}):
+		var header Header
+
+		if err := node.Decode(&header); err == nil {
+			v.checkHeader(node, header)
+		}
+	case reflect.TypeOf(Patch{}):
+		var patch Patch
+
+		if err := node.Decode(&patch); err == nil {
+			if !contains(patchOperations, patch.Operation) {
+				v.addError(valueNode(node, "op"), "invalid patch op %q, expected one of %s", patch.Operation, strings.Join(patchOperations, ", "))
+			}
+
+			v.compileExpr(node, "when", patch.When)
+		}
+	case reflect.TypeOf(FetchRequest{}):
+		var fetchRequest FetchRequest
+
+		if err := node.Decode(&fetchRequest); err == nil {
+			v.checkDuration(node, "timeout", fetchRequest.Timeout)
+		}
+	case reflect.TypeOf(Redact{}):
+		var redact Redact
+
+		if err := node.Decode(&redact); err == nil {
+			v.checkJsonPaths(valueNode(node, "jsonPaths"), redact.JsonPaths)
+		}
+	case reflect.TypeOf(UriGroup{}), reflect.TypeOf(UriMap{}):
+		if errorFormat := lookupNode(node, "errorFormat"); errorFormat != nil && !contains(errorFormats, errorFormat.Value) {
+			v.addError(errorFormat, "invalid error format %q, expected one of %s", errorFormat.Value, strings.Join(errorFormats, ", "))
+		}
+	case reflect.TypeOf(Limit{}):
+		var limit Limit
+
+		if err := node.Decode(&limit); err == nil {
+			for key, value := range map[string]int64{
+				"requestsPerMinute": int64(limit.RequestsPerMinute),
+				"concurrentStreams": int64(limit.ConcurrentStreams),
+				"tokensPerDay":      limit.TokensPerDay,
+			} {
+				if value < 0 {
+					v.addError(valueNode(node, key), "limit %s must not be negative", key)
+				}
+			}
+		}
+	case reflect.TypeOf(Cache{}):
+		var cache Cache
+
+		if err := node.Decode(&cache); err == nil {
+			if cache.MaxSize < 0 {
+				v.addError(valueNode(node, "maxSize"), "cache maxSize must not be negative")
+			}
+
+			v.checkDuration(node, "ttl", cache.Ttl)
+			v.compileExpr(node, "key", cache.Key)
+			v.compileExpr(node, "when", cache.When)
+		}
+	case reflect.TypeOf(Fallback{}):
+		var fallback Fallback
+
+		if err := node.Decode(&fallback); err == nil {
+			v.compileExpr(node, "when", fallback.When)
+		}
+
+		if routesNode := lookupNode(node, "routes"); routesNode != nil && routesNode.Kind == yaml.SequenceNode {
+			for _, routeNode := range routesNode.Content {
+				if !v.routes[routeNode.Value] {
+					v.addError(routeNode, "fallback route %q does not match any supported uri", routeNode.Value)
+				}
+			}
+		}
+	case reflect.TypeOf(Retry{}):
+		var retry Retry
+
+		if err := node.Decode(&retry); err == nil {
+			if retry.MaxAttempts < 0 {
+				v.addError(valueNode(node, "maxAttempts"), "retry maxAttempts must not be negative")
+			}
+
+			v.checkDuration(node, "initialDelay", retry.InitialDelay)
+			v.checkDuration(node, "maxDelay", retry.MaxDelay)
+			v.compileExpr(node, "when", retry.When)
+		}
+	}
+}
+
+func (v *validator) checkHeader(node *yaml.Node, header Header) {
+	if header.Operation != AddOperation && header.Operation != RemoveOperation {
+		v.addError(valueNode(node, "op"), "invalid header op %q, expected %s or %s", header.Operation, AddOperation, RemoveOperation)
+	}
+
+	if header.Operation == AddOperation && header.Name == "" {
+		v.addError(node, "header with op %s must have a name", AddOperation)
+	}
+
+	v.compileExpr(node, "when", header.When)
+
+	inputs := []string{}
+
+	for key, value := range map[string]string{"text": header.Text, "template": header.Template, "expr": header.Expr, "file": header.File} {
+		if value != "" {
+			inputs = append(inputs, key)
+		}
+	}
+
+	if len(inputs) > 1 {
+		sort.Strings(inputs)
+		v.addError(node, "header %q sets more than one of %s", header.Name, strings.Join(inputs, ", "))
+	}
+}
+
+func (v *validator) checkDuration(node *yaml.Node, key, value string) {
+	if value == "" {
+		return
+	}
+
+	if _, err := time.ParseDuration(value); err != nil {
+		v.addError(valueNode(node, key), "invalid %s %q: %v", key, value, err)
+	}
+}
+
+// checkJsonPaths reports the paths which aren't JSON pointers against their item in the list.
+func (v *validator) checkJsonPaths(node *yaml.Node, paths []string) {
+	for i, path := range paths {
+		if strings.HasPrefix(path, "/") {
+			continue
+		}
+
+		itemNode := node
+		if node != nil && i < len(node.Content) {
+			itemNode = node.Content[i]
+		}
+
+		v.addError(itemNode, "invalid json path %q, expected a JSON pointer such as /messages/*/content", path)
+	}
+}
+
+func (v *validator) checkUpstream(node *yaml.Node, upstream Upstream) {
+	if (upstream.Url == "") == (upstream.Expr == "") {
+		v.addError(node, "upstream must set exactly one of url, expr")
+	}
+
+	if upstream.Url != "" {
+		if _, err := url.Parse(upstream.Url); err != nil {
+			v.addError(valueNode(node, "url"), "invalid upstream url: %v", err)
+		}
+	}
+
+	if upstream.Weight < 0 {
+		v.addError(valueNode(node, "weight"), "upstream weight must not be negative")
+	}
+
+	v.compileExpr(node, "expr", upstream.Expr)
+}
+
+// checkVariants walks the variants of a route and method. A single variant is written as a mapping rather than a list
+// of one. In a list every variant after one without a when can never be used.
+func (v *validator) checkVariants(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		v.walk(node, reflect.TypeOf(RequestResponse{}))
+		return
+	}
+
+	if node.Kind != yaml.SequenceNode {
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i++ {
+		variant := resolveAlias(node.Content[i])
+
+		if variant.Kind == yaml.MappingNode && lookupNode(variant, "when") == nil {
+			v.addError(node.Content[i+1], "variant can never be used as the variant before it has no when")
+			break
+		}
+	}
+}
+
+func (v *validator) compileExpr(node *yaml.Node, key, exprStr string) {
+	if v.compiler == nil || strings.TrimSpace(exprStr) == "" {
+		return
+	}
+
+	if err := v.compiler.CompileExpr(exprStr); err != nil {
+		v.addError(valueNode(node, key), "invalid expr: %v", err)
+	}
+}
+
+func (v *validator) compileTemplate(node *yaml.Node, key, templateStr string) {
+	if v.compiler == nil || strings.TrimSpace(templateStr) == "" {
+		return
+	}
+
+	if err := v.compiler.CompileTemplate(templateStr); err != nil {
+		v.addError(valueNode(node, key), "invalid template: %v", err)
+	}
+}
+
+// checkOverrideRoutes reports uri overrides which can never be used because there is no supported uri, or no out
+// method on it, to attach them to.
+func (v *validator) checkOverrideRoutes(root *yaml.Node, cfg *Config) {
+	routeMethods := make(map[string][]string)
+
+	for _, uriGroup := range cfg.UriGroups {
+		for _, supportedUri := range uriGroup.SupportedUris {
+			for _, outMethod := range supportedUri.Out {
+				routeMethods[supportedUri.In] = append(routeMethods[supportedUri.In], outMethod.Method)
+			}
+		}
+	}
+
+	urisNode := lookupNode(root, "overrides", "uris")
+	if urisNode == nil || urisNode.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(urisNode.Content); i += 2 {
+		routeNode, methodsNode := urisNode.Content[i], resolveAlias(urisNode.Content[i+1])
+
+		methods, ok := routeMethods[routeNode.Value]
+		if !ok {
+			v.addError(routeNode, "override route %q does not match any supported uri", routeNode.Value)
+			continue
+		}
+
+		if methodsNode.Kind != yaml.MappingNode {
+			continue
+		}
+
+		for j := 0; j+1 < len(methodsNode.Content); j += 2 {
+			methodNode := methodsNode.Content[j]
+
+			if !contains(methods, methodNode.Value) {
+				v.addError(methodNode, "override %s %s does not match any out method of the supported uri", methodNode.Value, routeNode.Value)
+			}
+		}
+	}
+}
+
+// checkLimitRoutes reports limit routes which don't match any supported uri.
+func (v *validator) checkLimitRoutes(root *yaml.Node) {
+	limitsNode := lookupNode(root, "limits")
+	if limitsNode == nil || limitsNode.Kind != yaml.SequenceNode {
+		return
+	}
+
+	for _, ruleNode := range limitsNode.Content {
+		routesNode := lookupNode(ruleNode, "routes")
+		if routesNode == nil || routesNode.Kind != yaml.SequenceNode {
+			continue
+		}
+
+		for _, routeNode := range routesNode.Content {
+			if !v.routes[routeNode.Value] {
+				v.addError(routeNode, "limit route %q does not match any supported uri", routeNode.Value)
+			}
+		}
+	}
+}
+
+// lookupNode follows a path of mapping keys from the root node.
+func lookupNode(node *yaml.Node, path ...string) *yaml.Node {
+	node = resolveAlias(node)
+
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = resolveAlias(node.Content[0])
+	}
+
+	for _, key := range path {
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+
+		var next *yaml.Node
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				next = resolveAlias(node.Content[i+1])
+				break
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+
+		node = next
+	}
+
+	return node
+}
+
+// valueNode returns the value node of a key in a mapping so errors point at the offending value. The mapping itself
+// is returned if the key isn't set.
+func valueNode(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return node
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	return node
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// suggestKey returns a hint pointing at the closest known key for likely typos.
+func suggestKey(key string, fields map[string]reflect.Type) string {
+	best, bestDistance := "", 3
+
+	for field := range fields {
+		if distance := levenshtein(strings.ToLower(key), strings.ToLower(field)); distance < bestDistance {
+			best, bestDistance = field, distance
+		}
+	}
+
+	if best == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
@@ -0,0 +1,313 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+type fakeCompiler struct{}
+
+func (fakeCompiler) CompileExpr(exprStr string) error {
+	if strings.Contains(exprStr, "broken") {
+		return errors.New("broken expr")
+	}
+
+	return nil
+}
+
+func (fakeCompiler) CompileTemplate(templateStr string) error {
+	if strings.Contains(templateStr, "broken") {
+		return errors.New("broken template")
+	}
+
+	return nil
+}
+
+func TestValidateShippedConfigs(t *testing.T) {
+	for _, path := range []string{"../../config.yaml", "../../cmd/commands/ai-gateway/config.yaml"} {
+		t.Run(path, func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := Validate(data, fakeCompiler{}); err != nil {
+				t.Errorf("Expected no validation errors, got:\n%v", err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		expected []string
+	}{
+		{
+			name: "unknown key",
+			config: `
+uriGroups:
+  - name: A
+    supportedUris:
+      - in: /a
+        out:
+          - method: POST
+            tempalte: /b
+`,
+			expected: []string{`8:13: unknown key "tempalte", did you mean "template"?`},
+		},
+		{
+			name: "orphaned override route and method",
+			config: `
+uriGroups:
+  - name: A
+    supportedUris:
+      - in: /a
+        out:
+          - method: POST
+overrides:
+  uris:
+    /b:
+      POST: {}
+    /a:
+      GET: {}
+`,
+			expected: []string{
+				`10:5: override route "/b" does not match any supported uri`,
+				`13:7: override GET /a does not match any out method of the supported uri`,
+			},
+		},
+		{
+			name: "header with text and expr",
+			config: `
+overrides:
+  global:
+    request:
+      headers:
+        - op: add
+          name: X-Test
+          text: a
+          expr: '"b"'
+`,
+			expected: []string{`6:11: header "X-Test" sets more than one of expr, text`},
+		},
+		{
+			name: "invalid patch op and timeout",
+			config: `
+overrides:
+  global:
+    fetch:
+      requests:
+        models:
+          url:
+            text: http://localhost
+          timeout: soon
+    request:
+      body:
+        patches:
+          - op: upsert
+            path: /a
+`,
+			expected: []string{
+				`9:20: invalid timeout "soon"`,
+				`13:17: invalid patch op "upsert"`,
+			},
+		},
+		{
+			name: "expr and template compile errors",
+			config: `
+baseEndpoint: broken
+overrides:
+  global:
+    response:
+      statusCode:
+        expr: broken
+      body:
+        template: broken
+`,
+			expected: []string{
+				`2:15: invalid expr: broken expr`,
+				`7:15: invalid expr: broken expr`,
+				`9:19: invalid template: broken template`,
+			},
+		},
+		{
+			name: "anchors are only validated once",
+			config: `
+uriGroups:
+  - name: A
+    supportedUris:
+      - in: /a
+        out:
+          - method: GET
+          - method: POST
+overrides:
+  uris:
+    /a:
+      GET: &shared
+        request:
+          bdy: {}
+      POST: *shared
+`,
+			expected: []string{`14:11: unknown key "bdy", did you mean "body"?`},
+		},
+		{
+			name: "variants",
+			config: `
+uriGroups:
+  - name: A
+    supportedUris:
+      - in: /a
+        out:
+          - method: POST
+overrides:
+  uris:
+    /a:
+      POST:
+        - when: broken
+          request:
+            headers:
+              - op: add
+                name: X-Test
+                text: a
+                wen: body.stream
+        - request: {}
+        - when: body.stream
+`,
+			expected: []string{
+				`12:17: invalid expr: broken expr`,
+				`18:17: unknown key "wen", did you mean "when"?`,
+				`20:11: variant can never be used`,
+			},
+		},
+		{
+			name: "upstreams",
+			config: `
+baseEndpoint:
+  - expr: broken
+  - url: https://a.example.com
+    expr: '"https://b.example.com"'
+  - url: https://c.example.com
+    weight: -1
+    prioirty: 1
+`,
+			expected: []string{
+				`3:11: invalid expr: broken expr`,
+				`4:5: upstream must set exactly one of url, expr`,
+				`7:13: upstream weight must not be negative`,
+				`8:5: unknown key "prioirty", did you mean "priority"?`,
+			},
+		},
+		{
+			name: "redact",
+			config: `
+redact:
+  headers: [X-Secret]
+  jsonPaths:
+    - /messages/*/content
+    - system
+  jsonPath: /prompt
+`,
+			expected: []string{
+				`6:7: invalid json path "system"`,
+				`7:3: unknown key "jsonPath", did you mean "jsonPaths"?`,
+			},
+		},
+		{
+			name: "limits",
+			config: `
+uriGroups:
+  - name: A
+    errorFormat: openai
+    supportedUris:
+      - in: /a
+        errorFormat: azure
+        out:
+          - method: POST
+limits:
+  - routes: [/a, /b]
+    requestsPerMinute: -1
+    tokensPerDay: 100
+`,
+			expected: []string{
+				`7:22: invalid error format "azure"`,
+				`11:18: limit route "/b" does not match any supported uri`,
+				`12:24: limit requestsPerMinute must not be negative`,
+			},
+		},
+		{
+			name: "unknown transform",
+			config: `
+overrides:
+  global:
+    request:
+      body:
+        transform: openai-to-klingon
+`,
+			expected: []string{`6:20: unknown transform "openai-to-klingon"`},
+		},
+		{
+			name: "cache",
+			config: `
+overrides:
+  global:
+    cache:
+      key: broken
+      ttl: forever
+      maxSize: -1
+`,
+			expected: []string{
+				`5:12: invalid expr: broken expr`,
+				`6:12: invalid ttl "forever"`,
+				`7:16: cache maxSize must not be negative`,
+			},
+		},
+		{
+			name: "fallback",
+			config: `
+uriGroups:
+  - name: A
+    supportedUris:
+      - in: /a
+        out:
+          - method: POST
+overrides:
+  uris:
+    /a:
+      POST:
+        fallback:
+          routes: [/b]
+          when: broken
+`,
+			expected: []string{
+				`13:20: fallback route "/b" does not match any supported uri`,
+				`14:17: invalid expr: broken expr`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate([]byte(tt.config), fakeCompiler{})
+
+			var validationErrs ValidationErrors
+
+			if !errors.As(err, &validationErrs) {
+				t.Fatalf("Expected validation errors, got: %v", err)
+			}
+
+			if len(validationErrs) != len(tt.expected) {
+				t.Fatalf("Expected %d errors, got %d:\n%v", len(tt.expected), len(validationErrs), validationErrs)
+			}
+
+			for i, expected := range tt.expected {
+				if !strings.HasPrefix(validationErrs[i].Error(), expected) {
+					t.Errorf("Expected error starting with %q, got %q", expected, validationErrs[i].Error())
+				}
+			}
+		})
+	}
+}
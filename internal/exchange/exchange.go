@@ -0,0 +1,246 @@
+package exchange
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultCapacity is how many exchanges a store keeps by default
+	DefaultCapacity = 200
+
+	// MaxBodySize is how much of a body is kept, the rest is dropped and the body marked as truncated
+	MaxBodySize = 64 * 1024
+)
+
+// Exchange is a single request handled by the proxy, from the request the client sent to the response it received.
+type Exchange struct {
+	ID        string    `json:"id"`
+	RequestID string    `json:"requestId"`
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Route     string    `json:"route"`
+
+	// Request is the request as the client sent it
+	Request Message `json:"request"`
+
+	// UpstreamRequest is the rendered request as it was sent to the upstream, it's nil when no upstream was contacted
+	UpstreamRequest *Message `json:"upstreamRequest,omitempty"`
+
+	Upstream         string `json:"upstream,omitempty"`
+	UpstreamStatus   int    `json:"upstreamStatus,omitempty"`
+	UpstreamAttempts int    `json:"upstreamAttempts,omitempty"`
+
+	// UpstreamResponse is the response as the upstream sent it, before it was rendered
+	UpstreamResponse *Message `json:"upstreamResponse,omitempty"`
+
+	// Response is the rendered response as it was sent to the client
+	Response Message `json:"response"`
+
+	Fetches   []Fetch `json:"fetches,omitempty"`
+	SseEvents int     `json:"sseEvents"`
+	Timings   Timings `json:"timings"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Message is a request or response with its body.
+type Message struct {
+	Method        string      `json:"method,omitempty"`
+	Url           string      `json:"url,omitempty"`
+	Status        int         `json:"status,omitempty"`
+	Headers       http.Header `json:"headers"`
+	Body          string      `json:"body"`
+	BodyTruncated bool        `json:"bodyTruncated,omitempty"`
+}
+
+// SetBody sets the body of the message, keeping up to MaxBodySize of it. Binary bodies are replaced by their size.
+func (m *Message) SetBody(body []byte, truncated bool) {
+	if len(body) > MaxBodySize {
+		body = body[:MaxBodySize]
+		truncated = true
+	}
+
+	// Drop a character which was cut in half by the truncation
+	if truncated {
+		body = body[:len(body)-incompleteRuneLength(body)]
+	}
+
+	m.BodyTruncated = truncated
+
+	if !utf8.Valid(body) {
+		m.Body = fmt.Sprintf("<%d bytes of binary data>", len(body))
+		return
+	}
+
+	m.Body = string(body)
+}
+
+// incompleteRuneLength returns the length of the incomplete character at the end of b, if there is one.
+func incompleteRuneLength(b []byte) int {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		if !utf8.RuneStart(b[len(b)-i]) {
+			continue
+		}
+
+		if utf8.FullRune(b[len(b)-i:]) {
+			return 0
+		}
+
+		return i
+	}
+
+	return 0
+}
+
+// Fetch is a fetch request made while handling the exchange.
+type Fetch struct {
+	Name       string  `json:"name"`
+	Method     string  `json:"method"`
+	Url        string  `json:"url"`
+	Status     int     `json:"status,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+type Timings struct {
+	// UpstreamMs is how long the upstream took to respond with headers
+	UpstreamMs float64 `json:"upstreamMs"`
+
+	// TotalMs is how long the request took to handle, including the whole of a stream
+	TotalMs float64 `json:"totalMs"`
+}
+
+// Summary is the part of an exchange shown in a list of them.
+type Summary struct {
+	ID             string    `json:"id"`
+	RequestID      string    `json:"requestId"`
+	Time           time.Time `json:"time"`
+	Method         string    `json:"method"`
+	Path           string    `json:"path"`
+	Status         int       `json:"status"`
+	Upstream       string    `json:"upstream,omitempty"`
+	UpstreamStatus int       `json:"upstreamStatus,omitempty"`
+	SseEvents      int       `json:"sseEvents"`
+	TotalMs        float64   `json:"totalMs"`
+	Error          string    `json:"error,omitempty"`
+}
+
+func (e *Exchange) Summary() Summary {
+	return Summary{
+		ID:             e.ID,
+		RequestID:      e.RequestID,
+		Time:           e.Time,
+		Method:         e.Method,
+		Path:           e.Path,
+		Status:         e.Response.Status,
+		Upstream:       e.Upstream,
+		UpstreamStatus: e.UpstreamStatus,
+		SseEvents:      e.SseEvents,
+		TotalMs:        e.Timings.TotalMs,
+		Error:          e.Error,
+	}
+}
+
+// Store keeps the most recent exchanges in a ring buffer, the oldest one is dropped when it's full.
+type Store struct {
+	mu        sync.Mutex
+	exchanges []*Exchange
+	next      int
+	full      bool
+
+	listeners []func(*Exchange)
+}
+
+func NewStore(capacity int) *Store {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+
+	return &Store{exchanges: make([]*Exchange, capacity)}
+}
+
+// Subscribe calls fn with every exchange added from now on.
+func (s *Store) Subscribe(fn func(*Exchange)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.listeners = append(s.listeners, fn)
+}
+
+// Add stores a finished exchange. It mustn't be modified afterwards.
+func (s *Store) Add(e *Exchange) {
+	s.mu.Lock()
+
+	s.exchanges[s.next] = e
+	s.next = (s.next + 1) % len(s.exchanges)
+
+	if s.next == 0 {
+		s.full = true
+	}
+
+	listeners := s.listeners
+	s.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(e)
+	}
+}
+
+// List returns the summaries of the stored exchanges, oldest first.
+func (s *Store) List() []Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	summaries := []Summary{}
+
+	for _, e := range s.ordered() {
+		summaries = append(summaries, e.Summary())
+	}
+
+	return summaries
+}
+
+// Get returns the exchange with the id if it's still stored.
+func (s *Store) Get(id string) (*Exchange, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.exchanges {
+		if e != nil && e.ID == id {
+			return e, true
+		}
+	}
+
+	return nil, false
+}
+
+// All returns the stored exchanges, oldest first.
+func (s *Store) All() []*Exchange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ordered()
+}
+
+func (s *Store) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.exchanges = make([]*Exchange, len(s.exchanges))
+	s.next = 0
+	s.full = false
+}
+
+func (s *Store) ordered() []*Exchange {
+	ordered := []*Exchange{}
+
+	if s.full {
+		ordered = append(ordered, s.exchanges[s.next:]...)
+	}
+
+	return append(ordered, s.exchanges[:s.next]...)
+}
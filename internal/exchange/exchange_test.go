@@ -0,0 +1,97 @@
+package exchange
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		added    int
+		expected []string
+	}{
+		{name: "not full", capacity: 3, added: 2, expected: []string{"0", "1"}},
+		{name: "full", capacity: 3, added: 3, expected: []string{"0", "1", "2"}},
+		{name: "wrapped", capacity: 3, added: 5, expected: []string{"2", "3", "4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStore(tt.capacity)
+
+			published := 0
+			s.Subscribe(func(*Exchange) { published++ })
+
+			for i := 0; i < tt.added; i++ {
+				s.Add(&Exchange{ID: fmt.Sprint(i)})
+			}
+
+			if published != tt.added {
+				t.Errorf("Expected %d exchanges to be published, got: %d", tt.added, published)
+			}
+
+			ids := []string{}
+			for _, summary := range s.List() {
+				ids = append(ids, summary.ID)
+			}
+
+			if strings.Join(ids, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected exchanges %v, got: %v", tt.expected, ids)
+			}
+
+			if _, ok := s.Get("0"); ok != (tt.expected[0] == "0") {
+				t.Errorf("Expected the first exchange to be stored: %v, got: %v", tt.expected[0] == "0", ok)
+			}
+
+			s.Clear()
+
+			if len(s.List()) != 0 {
+				t.Errorf("Expected no exchanges after clearing, got: %v", s.List())
+			}
+		})
+	}
+}
+
+func TestSetBody(t *testing.T) {
+	tests := []struct {
+		name              string
+		body              []byte
+		truncated         bool
+		expected          string
+		expectedTruncated bool
+	}{
+		{name: "text", body: []byte(`{"a":1}`), expected: `{"a":1}`},
+		{name: "binary", body: []byte{0xff, 0xfe, 0x00}, expected: "<3 bytes of binary data>"},
+		{
+			name:              "too long",
+			body:              []byte(strings.Repeat("a", MaxBodySize+1)),
+			expected:          strings.Repeat("a", MaxBodySize),
+			expectedTruncated: true,
+		},
+		{
+			name:              "truncated mid character",
+			body:              []byte("ab\xc3"),
+			truncated:         true,
+			expected:          "ab",
+			expectedTruncated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Message
+			m.SetBody(tt.body, tt.truncated)
+
+			if m.Body != tt.expected {
+				t.Errorf("Expected body %.20q, got: %.20q", tt.expected, m.Body)
+			}
+
+			if m.BodyTruncated != tt.expectedTruncated {
+				t.Errorf("Expected truncated %v, got: %v", tt.expectedTruncated, m.BodyTruncated)
+			}
+		})
+	}
+}
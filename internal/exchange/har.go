@@ -0,0 +1,246 @@
+package exchange
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// harVersion is the version of the HAR format written, see http://www.softwareishard.com/blog/har-12-spec/
+const harVersion = "1.2"
+
+type har struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	Url         string         `json:"url"`
+	HttpVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HttpVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+	Comment     string         `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// WriteHar writes the exchanges to w as a HAR file. Each exchange has an entry for the request the client sent and,
+// when an upstream was contacted, an entry for the rendered request sent to it. Streamed bodies are written whole.
+func WriteHar(w io.Writer, exchanges []*Exchange, version string) error {
+	doc := har{
+		Log: harLog{
+			Version: harVersion,
+			Creator: harCreator{Name: "proximity", Version: version},
+			Entries: []harEntry{},
+		},
+	}
+
+	for _, e := range exchanges {
+		doc.Log.Entries = append(doc.Log.Entries, clientEntry(e))
+
+		if e.UpstreamRequest != nil {
+			doc.Log.Entries = append(doc.Log.Entries, upstreamEntry(e))
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("failed to write har: %w", err)
+	}
+
+	return nil
+}
+
+func clientEntry(e *Exchange) harEntry {
+	response := newHarResponse(&e.Response)
+
+	if e.SseEvents > 0 {
+		comment := fmt.Sprintf("%d sse events", e.SseEvents)
+		if response.Content.Comment != "" {
+			comment += ", " + response.Content.Comment
+		}
+
+		response.Content.Comment = comment
+	}
+
+	if e.Error != "" {
+		response.Comment = e.Error
+	}
+
+	return harEntry{
+		StartedDateTime: e.Time.Format(time.RFC3339Nano),
+		Time:            e.Timings.TotalMs,
+		Request:         newHarRequest(&e.Request),
+		Response:        response,
+		Timings:         harTimings{Wait: e.Timings.TotalMs},
+		Comment:         fmt.Sprintf("client %s %s", e.ID, e.RequestID),
+	}
+}
+
+func upstreamEntry(e *Exchange) harEntry {
+	response := harResponse{
+		Cookies:     []harNameValue{},
+		Headers:     []harNameValue{},
+		HttpVersion: "HTTP/1.1",
+		HeadersSize: -1,
+		BodySize:    -1,
+		Comment:     e.Error,
+	}
+
+	if e.UpstreamResponse != nil {
+		response = newHarResponse(e.UpstreamResponse)
+	}
+
+	comment := fmt.Sprintf("upstream %s %s", e.ID, e.RequestID)
+	if e.UpstreamAttempts > 1 {
+		comment += fmt.Sprintf(", last of %d attempts", e.UpstreamAttempts)
+	}
+
+	return harEntry{
+		StartedDateTime: e.Time.Format(time.RFC3339Nano),
+		Time:            e.Timings.UpstreamMs,
+		Request:         newHarRequest(e.UpstreamRequest),
+		Response:        response,
+		Timings:         harTimings{Wait: e.Timings.UpstreamMs},
+		Comment:         comment,
+	}
+}
+
+func newHarRequest(m *Message) harRequest {
+	request := harRequest{
+		Method:      m.Method,
+		Url:         m.Url,
+		HttpVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(m.Headers),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    len(m.Body),
+	}
+
+	if u, err := url.Parse(m.Url); err == nil {
+		for name, values := range u.Query() {
+			for _, value := range values {
+				request.QueryString = append(request.QueryString, harNameValue{Name: name, Value: value})
+			}
+		}
+
+		sort.SliceStable(request.QueryString, func(i, j int) bool {
+			return request.QueryString[i].Name < request.QueryString[j].Name
+		})
+	}
+
+	if m.Body != "" {
+		request.PostData = &harPostData{
+			MimeType: m.Headers.Get("Content-Type"),
+			Text:     m.Body,
+		}
+	}
+
+	return request
+}
+
+func newHarResponse(m *Message) harResponse {
+	response := harResponse{
+		Status:      m.Status,
+		StatusText:  http.StatusText(m.Status),
+		HttpVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(m.Headers),
+		Content: harContent{
+			Size:     len(m.Body),
+			MimeType: m.Headers.Get("Content-Type"),
+			Text:     m.Body,
+		},
+		HeadersSize: -1,
+		BodySize:    len(m.Body),
+	}
+
+	if m.BodyTruncated {
+		response.Content.Comment = "truncated"
+	}
+
+	return response
+}
+
+// harHeaders lists the headers sorted by name so that exports are stable.
+func harHeaders(header http.Header) []harNameValue {
+	headers := []harNameValue{}
+
+	for name, values := range header {
+		for _, value := range values {
+			headers = append(headers, harNameValue{Name: name, Value: value})
+		}
+	}
+
+	sort.SliceStable(headers, func(i, j int) bool {
+		return strings.ToLower(headers[i].Name) < strings.ToLower(headers[j].Name)
+	})
+
+	return headers
+}
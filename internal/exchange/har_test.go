@@ -0,0 +1,96 @@
+package exchange
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWriteHar(t *testing.T) {
+	exchanges := []*Exchange{
+		{
+			ID:        "1",
+			RequestID: "req-1",
+			Time:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			Request: Message{
+				Method:  http.MethodPost,
+				Url:     "http://localhost:29574/chat?stream=true",
+				Headers: http.Header{"Content-Type": {"application/json"}},
+				Body:    `{"model":"a"}`,
+			},
+			UpstreamRequest: &Message{
+				Method:  http.MethodPost,
+				Url:     "https://upstream/chat",
+				Headers: http.Header{"Authorization": {"[REDACTED]"}},
+				Body:    `{"model":"b"}`,
+			},
+			UpstreamResponse: &Message{
+				Status:  http.StatusOK,
+				Headers: http.Header{"Content-Type": {"text/event-stream"}},
+				Body:    "data: 1\n\ndata: 2\n\n",
+			},
+			UpstreamAttempts: 1,
+			Response: Message{
+				Status:  http.StatusOK,
+				Headers: http.Header{"Content-Type": {"text/event-stream"}},
+				Body:    "data: 1\n\ndata: 2\n\n",
+			},
+			SseEvents: 2,
+			Timings:   Timings{UpstreamMs: 10, TotalMs: 25},
+		},
+		{
+			ID:       "2",
+			Time:     time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC),
+			Request:  Message{Method: http.MethodGet, Url: "http://localhost:29574/models"},
+			Response: Message{Status: http.StatusOK},
+		},
+	}
+
+	var buf bytes.Buffer
+
+	if err := WriteHar(&buf, exchanges, "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc har
+
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.Log.Version != "1.2" || doc.Log.Creator.Version != "1.0.0" {
+		t.Errorf("Expected a HAR 1.2 log created by version 1.0.0, got: %+v", doc.Log)
+	}
+
+	if len(doc.Log.Entries) != 3 {
+		t.Fatalf("Expected 3 entries, got: %d", len(doc.Log.Entries))
+	}
+
+	client, upstream := doc.Log.Entries[0], doc.Log.Entries[1]
+
+	if client.Request.Url != "http://localhost:29574/chat?stream=true" || client.Request.PostData.Text != `{"model":"a"}` {
+		t.Errorf("Expected the client request, got: %+v", client.Request)
+	}
+
+	if len(client.Request.QueryString) != 1 || client.Request.QueryString[0].Value != "true" {
+		t.Errorf("Expected the query string to be listed, got: %+v", client.Request.QueryString)
+	}
+
+	if client.Response.Content.Text != "data: 1\n\ndata: 2\n\n" || client.Response.Content.Comment != "2 sse events" {
+		t.Errorf("Expected the whole stream in the response, got: %+v", client.Response.Content)
+	}
+
+	if upstream.Request.Url != "https://upstream/chat" || upstream.Request.Headers[0].Value != "[REDACTED]" {
+		t.Errorf("Expected the rendered upstream request, got: %+v", upstream.Request)
+	}
+
+	if upstream.Response.Status != http.StatusOK || upstream.Time != 10 {
+		t.Errorf("Expected the upstream response, got: %+v", upstream)
+	}
+
+	if doc.Log.Entries[2].Request.PostData != nil {
+		t.Errorf("Expected no post data for a request without a body, got: %+v", doc.Log.Entries[2].Request.PostData)
+	}
+}
@@ -0,0 +1,32 @@
+// Package filelock takes exclusive locks on files which are shared between processes, such as the usage and limits
+// files which the app and the command line can both write to.
+package filelock
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Lock takes an exclusive lock for path, blocking until no other process or Lock call holds it. The lock is held on a
+// separate path.lock file so that path itself can be replaced while it's held. unlock releases it.
+func Lock(path string) (unlock func() error, err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	return func() error {
+		defer f.Close()
+		return unlockFile(f)
+	}, nil
+}
@@ -0,0 +1,46 @@
+package filelock
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dir", "state.json")
+
+	unlock, err := Lock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locked := make(chan func() error)
+
+	go func() {
+		unlockSecond, err := Lock(path)
+		if err != nil {
+			t.Error(err)
+		}
+
+		locked <- unlockSecond
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("Expected the second lock to wait for the first to be released")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case unlockSecond := <-locked:
+		if err := unlockSecond(); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the second lock to be taken once the first was released")
+	}
+}
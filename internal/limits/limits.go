@@ -0,0 +1,361 @@
+package limits
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/filelock"
+)
+
+// DefaultPath is where the state of limits is kept unless another file is given.
+func DefaultPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".config", "proximity", "limits.json")
+}
+
+// Limit caps what can be used, zero is unlimited.
+type Limit struct {
+	RequestsPerMinute int
+	ConcurrentStreams int
+	TokensPerDay      int64
+}
+
+// Bucket is a limit along with the key its usage is counted under.
+type Bucket struct {
+	Key string
+	Limit
+}
+
+// ExceededError is returned when a request would go over a limit.
+type ExceededError struct {
+	Key   string
+	Limit string
+
+	// RetryAfter is how long until the limit has room for the request again
+	RetryAfter time.Duration
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s limit reached for %s, retry after %s", e.Limit, e.Key, e.RetryAfter)
+}
+
+// state is what's been used under a key. Streams are only counted in memory as they end with the proxy.
+type state struct {
+	Requests []time.Time `json:"requests,omitempty"`
+	Day      string      `json:"day,omitempty"`
+	Tokens   int64       `json:"tokens,omitempty"`
+}
+
+// flushInterval is how long tokens are counted in memory before they're written to the file
+const flushInterval = 2 * time.Second
+
+// Limiter counts requests, streams and tokens against limits. The state is kept in a json file so that it survives
+// restarts, and it's changed under a lock so that a proxy in the app and one on the command line can share it.
+// Requests are written as they're counted, tokens are batched up. It's only kept in memory when no path is given.
+type Limiter struct {
+	path string
+	now  func() time.Time
+
+	mu      sync.Mutex
+	states  map[string]*state
+	streams map[string]int
+
+	// pendingTokens are the tokens which haven't been written to the file yet
+	pendingTokens map[string]int64
+	timer         *time.Timer
+
+	// flushErr is why the last flush in the background failed, it's returned by the next AddTokens
+	flushErr error
+}
+
+func New(path string) *Limiter {
+	return &Limiter{
+		path:          path,
+		now:           time.Now,
+		states:        make(map[string]*state),
+		streams:       make(map[string]int),
+		pendingTokens: make(map[string]int64),
+	}
+}
+
+// Acquire counts a request against every bucket if all of them have room for it, otherwise an *ExceededError is
+// returned and nothing is counted. release must be called once the response has been sent.
+func (l *Limiter) Acquire(buckets []Bucket) (release func(), err error) {
+	if len(buckets) == 0 {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	counted := false
+
+	for _, bucket := range buckets {
+		counted = counted || bucket.RequestsPerMinute > 0
+	}
+
+	unlock, err := l.lockFile()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	now := l.now()
+
+	for _, bucket := range buckets {
+		if err := l.check(bucket, now); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, bucket := range buckets {
+		s := l.state(bucket.Key, now)
+
+		if bucket.RequestsPerMinute > 0 {
+			s.Requests = append(s.Requests, now)
+		}
+
+		l.streams[bucket.Key]++
+	}
+
+	var once sync.Once
+
+	release = func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			for _, bucket := range buckets {
+				if l.streams[bucket.Key]--; l.streams[bucket.Key] <= 0 {
+					delete(l.streams, bucket.Key)
+				}
+			}
+		})
+	}
+
+	// Streams are only counted in memory so the file only changes when requests are
+	if !counted {
+		return release, nil
+	}
+
+	if err := l.save(now); err != nil {
+		release()
+		return nil, err
+	}
+
+	return release, nil
+}
+
+// AddTokens counts the tokens used by a request under each key. They're written to the file shortly after, or when
+// the limiter is flushed.
+func (l *Limiter) AddTokens(keys []string, tokens int64) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.path == "" {
+		now := l.now()
+
+		for _, key := range keys {
+			l.state(key, now).Tokens += tokens
+		}
+
+		return nil
+	}
+
+	for _, key := range keys {
+		l.pendingTokens[key] += tokens
+	}
+
+	if l.timer == nil {
+		l.timer = time.AfterFunc(flushInterval, func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			l.flushErr = l.flush()
+		})
+	}
+
+	err := l.flushErr
+	l.flushErr = nil
+
+	return err
+}
+
+// Flush writes the tokens which haven't been written to the file yet.
+func (l *Limiter) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.flush()
+}
+
+func (l *Limiter) flush() error {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+
+	if len(l.pendingTokens) == 0 {
+		return nil
+	}
+
+	unlock, err := l.lockFile()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return l.save(l.now())
+}
+
+// lockFile locks the limits file and reads it with the pending tokens added, so that the state is up to date until
+// it's unlocked. The pending tokens are written by the next save.
+func (l *Limiter) lockFile() (unlock func() error, err error) {
+	if l.path == "" {
+		return func() error { return nil }, nil
+	}
+
+	unlock, err = filelock.Lock(l.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.load(); err != nil {
+		unlock()
+		return nil, err
+	}
+
+	now := l.now()
+
+	for key, tokens := range l.pendingTokens {
+		l.state(key, now).Tokens += tokens
+	}
+
+	return unlock, nil
+}
+
+func (l *Limiter) check(bucket Bucket, now time.Time) error {
+	s := l.state(bucket.Key, now)
+
+	if bucket.TokensPerDay > 0 && s.Tokens >= bucket.TokensPerDay {
+		year, month, day := now.Date()
+		tomorrow := time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+
+		return &ExceededError{Key: bucket.Key, Limit: "tokensPerDay", RetryAfter: tomorrow.Sub(now)}
+	}
+
+	if bucket.RequestsPerMinute > 0 && len(s.Requests) >= bucket.RequestsPerMinute {
+		// The request which has to fall out of the window for there to be room
+		oldest := s.Requests[len(s.Requests)-bucket.RequestsPerMinute]
+
+		return &ExceededError{Key: bucket.Key, Limit: "requestsPerMinute", RetryAfter: oldest.Add(time.Minute).Sub(now)}
+	}
+
+	if bucket.ConcurrentStreams > 0 && l.streams[bucket.Key] >= bucket.ConcurrentStreams {
+		return &ExceededError{Key: bucket.Key, Limit: "concurrentStreams", RetryAfter: time.Second}
+	}
+
+	return nil
+}
+
+// state returns the state of a key with requests from over a minute ago and tokens from previous days dropped.
+func (l *Limiter) state(key string, now time.Time) *state {
+	s, ok := l.states[key]
+	if !ok {
+		s = &state{}
+		l.states[key] = s
+	}
+
+	today := now.Format(time.DateOnly)
+
+	if s.Day != today {
+		s.Day = today
+		s.Tokens = 0
+	}
+
+	i := 0
+	for i < len(s.Requests) && !s.Requests[i].After(now.Add(-time.Minute)) {
+		i++
+	}
+
+	s.Requests = s.Requests[i:]
+
+	return s
+}
+
+func (l *Limiter) load() error {
+	if l.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to read limits: %w", err)
+	}
+
+	states := make(map[string]*state)
+
+	if err := json.Unmarshal(data, &states); err != nil {
+		return fmt.Errorf("failed to parse limits %s: %w", l.path, err)
+	}
+
+	l.states = states
+	return nil
+}
+
+// save writes the state which is still in use to a temporary file which replaces the limits file so that it's never
+// left half written.
+func (l *Limiter) save(now time.Time) error {
+	for key := range l.states {
+		if s := l.state(key, now); len(s.Requests) == 0 && s.Tokens == 0 {
+			delete(l.states, key)
+		}
+	}
+
+	if l.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(l.states, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return fmt.Errorf("failed to write limits: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write limits: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write limits: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write limits: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return fmt.Errorf("failed to write limits: %w", err)
+	}
+
+	l.pendingTokens = make(map[string]int64)
+	return nil
+}
@@ -0,0 +1,167 @@
+package limits
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestsPerMinute(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	l := New("")
+	l.now = func() time.Time { return now }
+
+	buckets := []Bucket{{Key: "a", Limit: Limit{RequestsPerMinute: 2}}}
+
+	for i := 0; i < 2; i++ {
+		release, err := l.Acquire(buckets)
+		if err != nil {
+			t.Fatalf("Expected request %d to be allowed, got: %v", i+1, err)
+		}
+
+		release()
+		now = now.Add(10 * time.Second)
+	}
+
+	_, err := l.Acquire(buckets)
+
+	var exceeded *ExceededError
+
+	if !errors.As(err, &exceeded) || exceeded.Limit != "requestsPerMinute" {
+		t.Fatalf("Expected the requests per minute limit to be reached, got: %v", err)
+	}
+
+	if exceeded.RetryAfter != 40*time.Second {
+		t.Errorf("Expected to retry once the first request is a minute old, got: %s", exceeded.RetryAfter)
+	}
+
+	now = now.Add(40 * time.Second)
+
+	if _, err := l.Acquire(buckets); err != nil {
+		t.Errorf("Expected a request to be allowed once the first is a minute old, got: %v", err)
+	}
+}
+
+func TestConcurrentStreams(t *testing.T) {
+	l := New("")
+	buckets := []Bucket{{Key: "a", Limit: Limit{ConcurrentStreams: 1}}}
+
+	release, err := l.Acquire(buckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.Acquire(buckets); err == nil {
+		t.Fatal("Expected a second stream to be refused")
+	}
+
+	// Releasing twice only frees the one stream
+	release()
+	release()
+
+	if _, err := l.Acquire(buckets); err != nil {
+		t.Errorf("Expected a stream to be allowed once the first ended, got: %v", err)
+	}
+}
+
+func TestTokensPerDay(t *testing.T) {
+	now := time.Date(2026, 1, 1, 18, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "limits.json")
+
+	l := New(path)
+	l.now = func() time.Time { return now }
+
+	buckets := []Bucket{{Key: "a", Limit: Limit{TokensPerDay: 100}}}
+
+	if err := l.AddTokens([]string{"a"}, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens are batched up but the limiter counts its own before they're written
+	if _, err := l.Acquire(buckets); err == nil {
+		t.Error("Expected the tokens per day limit to be reached before the tokens are written")
+	}
+
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new limiter, as after a restart, reads the tokens used from the file
+	restarted := New(path)
+	restarted.now = l.now
+
+	_, err := restarted.Acquire(buckets)
+
+	var exceeded *ExceededError
+
+	if !errors.As(err, &exceeded) || exceeded.Limit != "tokensPerDay" {
+		t.Fatalf("Expected the tokens per day limit to be reached, got: %v", err)
+	}
+
+	if exceeded.RetryAfter != 6*time.Hour {
+		t.Errorf("Expected to retry at midnight, got: %s", exceeded.RetryAfter)
+	}
+
+	now = now.Add(6 * time.Hour)
+
+	if _, err := restarted.Acquire(buckets); err != nil {
+		t.Errorf("Expected requests to be allowed the next day, got: %v", err)
+	}
+}
+
+func TestAcquireIsAllOrNothing(t *testing.T) {
+	l := New("")
+
+	buckets := []Bucket{
+		{Key: "a", Limit: Limit{RequestsPerMinute: 1}},
+		{Key: "b", Limit: Limit{ConcurrentStreams: 1}},
+	}
+
+	release, err := l.Acquire(buckets[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	if _, err := l.Acquire(buckets); err == nil {
+		t.Fatal("Expected the request to be refused by the stream limit")
+	}
+
+	if _, err := l.Acquire(buckets[:1]); err != nil {
+		t.Errorf("Expected the refused request not to count towards the other limit, got: %v", err)
+	}
+}
+
+func TestSharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	buckets := []Bucket{{Key: "a", Limit: Limit{RequestsPerMinute: 10}}}
+
+	var wg sync.WaitGroup
+	var acquired atomic.Int32
+
+	// Two limiters on one file, as in the app and on the command line, share the limit
+	for _, l := range []*Limiter{New(path), New(path)} {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for range 10 {
+				if release, err := l.Acquire(buckets); err == nil {
+					acquired.Add(1)
+					release()
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if n := acquired.Load(); n != 10 {
+		t.Errorf("Expected 10 requests to be allowed between both limiters, got: %d", n)
+	}
+}
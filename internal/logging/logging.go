@@ -0,0 +1,137 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJson = "json"
+
+	// RequestIDHeader is taken as the id of a request when a client sends it, and is echoed back on the response
+	RequestIDHeader = "X-Request-Id"
+
+	// RequestIDKey is the attribute the request id is logged under
+	RequestIDKey = "request_id"
+
+	maxRequestIDLength = 128
+)
+
+type Options struct {
+	// Format is text or json, text when empty
+	Format string
+
+	// Level is the minimum level logged, one of debug, info, warn or error, info when empty
+	Level string
+}
+
+// New creates a logger writing to w which logs the request id of the context of every record that has one.
+func New(w io.Writer, options Options) (*slog.Logger, error) {
+	level, err := ParseLevel(options.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	handlerOptions := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+
+	switch strings.ToLower(options.Format) {
+	case "", FormatText:
+		handler = slog.NewTextHandler(w, handlerOptions)
+	case FormatJson:
+		handler = slog.NewJSONHandler(w, handlerOptions)
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected %s or %s", options.Format, FormatText, FormatJson)
+	}
+
+	return slog.New(&contextHandler{Handler: handler}), nil
+}
+
+// Discard returns a logger which logs nothing.
+func Discard() *slog.Logger {
+	return slog.New(slog.DiscardHandler)
+}
+
+// ParseLevel parses a level name, an empty name is info.
+func ParseLevel(name string) (slog.Level, error) {
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+
+	var level slog.Level
+
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", name)
+	}
+
+	return level, nil
+}
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a context whose log records are tagged with the request id.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request id of the context, or an empty string when there isn't one.
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID generates a random request id.
+func NewRequestID() string {
+	var id [8]byte
+	rand.Read(id[:])
+
+	return hex.EncodeToString(id[:])
+}
+
+// RequestID returns the id a client sent in the header if it's usable, otherwise a new one.
+func RequestID(value string) string {
+	if value == "" || len(value) > maxRequestIDLength {
+		return NewRequestID()
+	}
+
+	// Only printable ascii is kept as the id is written to logs and response headers
+	for _, c := range value {
+		if c <= ' ' || c > '~' {
+			return NewRequestID()
+		}
+	}
+
+	return value
+}
+
+// contextHandler adds the request id of the context to the records it handles.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String(RequestIDKey, id))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
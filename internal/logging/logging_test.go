@@ -0,0 +1,103 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  Options
+		expected []string
+		excluded []string
+	}{
+		{
+			name:     "text",
+			options:  Options{},
+			expected: []string{`level=INFO msg=started`, `request_id=abc`, `level=WARN msg=retrying`},
+			excluded: []string{"debugging"},
+		},
+		{
+			name:     "json",
+			options:  Options{Format: FormatJson, Level: "debug"},
+			expected: []string{`"msg":"debugging"`, `"request_id":"abc"`, `"attempt":2`},
+		},
+		{
+			name:     "level",
+			options:  Options{Level: "warn"},
+			expected: []string{`msg=retrying`},
+			excluded: []string{"started"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+
+			logger, err := New(&out, tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := ContextWithRequestID(context.Background(), "abc")
+
+			logger.DebugContext(ctx, "debugging")
+			logger.InfoContext(ctx, "started")
+			logger.With("component", "retry").WarnContext(ctx, "retrying", "attempt", 2)
+
+			for _, expected := range tt.expected {
+				if !strings.Contains(out.String(), expected) {
+					t.Errorf("Expected the logs to contain %s, got: %s", expected, out.String())
+				}
+			}
+
+			for _, excluded := range tt.excluded {
+				if strings.Contains(out.String(), excluded) {
+					t.Errorf("Expected the logs not to contain %s, got: %s", excluded, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestNewRejectsUnknownOptions(t *testing.T) {
+	if _, err := New(nil, Options{Format: "xml"}); err == nil {
+		t.Errorf("Expected an unknown format to be rejected")
+	}
+
+	if _, err := New(nil, Options{Level: "loud"}); err == nil {
+		t.Errorf("Expected an unknown level to be rejected")
+	}
+
+	if level, err := ParseLevel("WARN"); err != nil || level != slog.LevelWarn {
+		t.Errorf("Expected levels to be case insensitive, got: %v, %v", level, err)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		value    string
+		accepted bool
+	}{
+		{value: "abc-123", accepted: true},
+		{value: "", accepted: false},
+		{value: "has space", accepted: false},
+		{value: "new\nline", accepted: false},
+		{value: strings.Repeat("a", 129), accepted: false},
+	}
+
+	for _, tt := range tests {
+		id := RequestID(tt.value)
+
+		if (id == tt.value) != tt.accepted {
+			t.Errorf("Expected %q to be accepted: %v, got id: %q", tt.value, tt.accepted, id)
+		}
+
+		if id == "" {
+			t.Errorf("Expected an id to always be returned")
+		}
+	}
+}
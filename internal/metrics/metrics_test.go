@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	registry := NewRegistry()
+
+	counter := registry.NewCounter("requests_total", "Requests handled.", "route", "status")
+	gauge := registry.NewGauge("active", "Active streams.")
+	histogram := registry.NewHistogram("duration_seconds", "Request durations.", []float64{0.1, 1}, "route")
+
+	counter.Inc("/b", "200")
+	counter.Inc("/a", "500")
+	counter.Add(2, "/a", "500")
+	counter.Inc("/quote\"d", "200")
+
+	gauge.Inc()
+	gauge.Inc()
+	gauge.Dec()
+
+	histogram.Observe(0.05, "/a")
+	histogram.Observe(0.5, "/a")
+	histogram.Observe(5, "/a")
+
+	var buf bytes.Buffer
+
+	if err := registry.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP requests_total Requests handled.
+# TYPE requests_total counter
+requests_total{route="/a",status="500"} 3
+requests_total{route="/b",status="200"} 1
+requests_total{route="/quote\"d",status="200"} 1
+# HELP active Active streams.
+# TYPE active gauge
+active 1
+# HELP duration_seconds Request durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="/a",le="0.1"} 1
+duration_seconds_bucket{route="/a",le="1"} 2
+duration_seconds_bucket{route="/a",le="+Inf"} 3
+duration_seconds_sum{route="/a"} 5.55
+duration_seconds_count{route="/a"} 3
+`
+
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
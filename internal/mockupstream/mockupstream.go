@@ -0,0 +1,471 @@
+package mockupstream
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const (
+	// DefaultCompletion is the text every completion responds with by default
+	DefaultCompletion = "Hello! This is a mock response from proximity."
+
+	// created is the creation time given to every response so that responses are the same from run to run
+	created = 1700000000
+)
+
+// Model is a model listed by the mock's model list endpoints.
+type Model struct {
+	ID     string `json:"id"`
+	Vendor string `json:"vendor"`
+	Family string `json:"family"`
+}
+
+// DefaultModels cover a model of each vendor and family the shipped config lists.
+var DefaultModels = []Model{
+	{ID: "gpt-4o", Vendor: "OPENAI", Family: "gpt-family"},
+	{ID: "anthropic.claude-sonnet-4-20250514-v1:0", Vendor: "BEDROCK", Family: "claude-family"},
+	{ID: "claude-sonnet-4@20250514", Vendor: "GOOGLE", Family: "claude-family"},
+	{ID: "gemini-2.5-pro", Vendor: "GOOGLE", Family: "gemini-family"},
+}
+
+type Options struct {
+	// Completion is the text every completion responds with, DefaultCompletion when empty
+	Completion string
+
+	// Models are listed by the model list endpoints and whitelisted for every use case, DefaultModels when empty
+	Models []Model
+
+	// Delay is how long to wait between the chunks of a streamed completion
+	Delay time.Duration
+}
+
+type mock struct {
+	Options
+}
+
+// New creates a handler emulating the AI-Gateway and model config endpoints targeted by the shipped config. Each
+// completion is the same text whatever the request, sent whole or streamed a word at a time in the format of the
+// provider. Requests aren't authenticated.
+func New(options Options) http.Handler {
+	if options.Completion == "" {
+		options.Completion = DefaultCompletion
+	}
+
+	if len(options.Models) == 0 {
+		options.Models = DefaultModels
+	}
+
+	m := &mock{Options: options}
+
+	router := chi.NewRouter()
+
+	router.Post("/v1/openai/v1/chat/completions", m.openaiChatCompletions)
+	router.Post("/v1/bedrock/model/{model}/invoke", m.bedrockInvoke)
+	router.Post("/v1/bedrock/model/{model}/invoke-with-response-stream", m.bedrockInvokeWithResponseStream)
+	router.Post("/v1/google/v1/publishers/{publisher}/models/{model}", m.google)
+
+	router.Get("/api/ai-gateway/use-case/{useCase}", m.useCase)
+	router.Get("/api/ai-gateway/model/list", m.modelList)
+
+	router.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no mock for %s %s", r.Method, r.URL.Path))
+	})
+
+	return router
+}
+
+// completionRequest holds the fields of a request the responses depend on, whatever the provider.
+type completionRequest struct {
+	Model         string `json:"model"`
+	Stream        bool   `json:"stream"`
+	StreamOptions struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+
+	// inputTokens is estimated from the size of the request
+	inputTokens int
+}
+
+func readCompletionRequest(r *http.Request) (*completionRequest, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &completionRequest{inputTokens: max(1, len(body)/4)}
+
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, req); err != nil {
+			return nil, fmt.Errorf("invalid request body: %w", err)
+		}
+	}
+
+	return req, nil
+}
+
+// words splits the completion into the chunks it's streamed in, keeping the spaces so they join back up.
+func (m *mock) words() []string {
+	return strings.SplitAfter(m.Completion, " ")
+}
+
+func (m *mock) outputTokens() int {
+	return len(m.words())
+}
+
+// pause waits between streamed chunks, it returns false when the client has gone away.
+func (m *mock) pause(r *http.Request) bool {
+	if m.Delay <= 0 {
+		return true
+	}
+
+	select {
+	case <-time.After(m.Delay):
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (m *mock) openaiChatCompletions(w http.ResponseWriter, r *http.Request) {
+	req, err := readCompletionRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	usage := map[string]any{
+		"prompt_tokens":     req.inputTokens,
+		"completion_tokens": m.outputTokens(),
+		"total_tokens":      req.inputTokens + m.outputTokens(),
+	}
+
+	if !req.Stream {
+		writeJson(w, map[string]any{
+			"id":      "chatcmpl-mock",
+			"object":  "chat.completion",
+			"created": created,
+			"model":   req.Model,
+			"choices": []any{map[string]any{
+				"index":         0,
+				"message":       map[string]any{"role": "assistant", "content": m.Completion},
+				"finish_reason": "stop",
+			}},
+			"usage": usage,
+		})
+
+		return
+	}
+
+	sse := newSseWriter(w)
+
+	chunk := func(delta map[string]any, finishReason any) map[string]any {
+		return map[string]any{
+			"id":      "chatcmpl-mock",
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   req.Model,
+			"choices": []any{map[string]any{
+				"index":         0,
+				"delta":         delta,
+				"finish_reason": finishReason,
+			}},
+		}
+	}
+
+	sse.data(chunk(map[string]any{"role": "assistant", "content": ""}, nil))
+
+	for _, word := range m.words() {
+		if !m.pause(r) {
+			return
+		}
+
+		sse.data(chunk(map[string]any{"content": word}, nil))
+	}
+
+	sse.data(chunk(map[string]any{}, "stop"))
+
+	if req.StreamOptions.IncludeUsage {
+		sse.data(map[string]any{
+			"id":      "chatcmpl-mock",
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   req.Model,
+			"choices": []any{},
+			"usage":   usage,
+		})
+	}
+
+	sse.raw("data: [DONE]\n\n")
+}
+
+// anthropicMessage is the response of the Anthropic messages api, used by both Bedrock and Vertex.
+func (m *mock) anthropicMessage(model string, inputTokens int) map[string]any {
+	return map[string]any{
+		"id":            "msg_mock",
+		"type":          "message",
+		"role":          "assistant",
+		"model":         model,
+		"content":       []any{map[string]any{"type": "text", "text": m.Completion}},
+		"stop_reason":   "end_turn",
+		"stop_sequence": nil,
+		"usage":         map[string]any{"input_tokens": inputTokens, "output_tokens": m.outputTokens()},
+	}
+}
+
+// anthropicEvents streams the events of an Anthropic message to send, stopping if send returns false.
+func (m *mock) anthropicEvents(r *http.Request, model string, inputTokens int, send func(event map[string]any) bool) {
+	start := m.anthropicMessage(model, inputTokens)
+	start["content"] = []any{}
+	start["stop_reason"] = nil
+	start["usage"] = map[string]any{"input_tokens": inputTokens, "output_tokens": 1}
+
+	if !send(map[string]any{"type": "message_start", "message": start}) {
+		return
+	}
+
+	if !send(map[string]any{
+		"type":          "content_block_start",
+		"index":         0,
+		"content_block": map[string]any{"type": "text", "text": ""},
+	}) {
+		return
+	}
+
+	for _, word := range m.words() {
+		if !m.pause(r) {
+			return
+		}
+
+		if !send(map[string]any{
+			"type":  "content_block_delta",
+			"index": 0,
+			"delta": map[string]any{"type": "text_delta", "text": word},
+		}) {
+			return
+		}
+	}
+
+	for _, event := range []map[string]any{
+		{"type": "content_block_stop", "index": 0},
+		{
+			"type":  "message_delta",
+			"delta": map[string]any{"stop_reason": "end_turn", "stop_sequence": nil},
+			"usage": map[string]any{"output_tokens": m.outputTokens()},
+		},
+		{"type": "message_stop"},
+	} {
+		if !send(event) {
+			return
+		}
+	}
+}
+
+func (m *mock) bedrockInvoke(w http.ResponseWriter, r *http.Request) {
+	req, err := readCompletionRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJson(w, m.anthropicMessage(chi.URLParam(r, "model"), req.inputTokens))
+}
+
+// bedrockInvokeWithResponseStream streams the Anthropic events as AI-Gateway re-encodes them, as server sent events
+// with only their data.
+func (m *mock) bedrockInvokeWithResponseStream(w http.ResponseWriter, r *http.Request) {
+	req, err := readCompletionRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sse := newSseWriter(w)
+
+	m.anthropicEvents(r, chi.URLParam(r, "model"), req.inputTokens, func(event map[string]any) bool {
+		return sse.data(event)
+	})
+}
+
+// google handles the Vertex AI endpoints, which are named by the model and method separated by a colon.
+func (m *mock) google(w http.ResponseWriter, r *http.Request) {
+	model, method, _ := strings.Cut(chi.URLParam(r, "model"), ":")
+
+	req, err := readCompletionRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch method {
+	case "rawPredict":
+		writeJson(w, m.anthropicMessage(model, req.inputTokens))
+	case "streamRawPredict":
+		sse := newSseWriter(w)
+
+		m.anthropicEvents(r, model, req.inputTokens, func(event map[string]any) bool {
+			return sse.event(event["type"].(string), event)
+		})
+	case "generateContent":
+		writeJson(w, m.geminiResponse(model, m.Completion, req.inputTokens, "STOP"))
+	case "streamGenerateContent":
+		m.geminiStream(w, r, model, req.inputTokens)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no mock for method %q", method))
+	}
+}
+
+func (m *mock) geminiResponse(model, text string, inputTokens int, finishReason string) map[string]any {
+	candidate := map[string]any{
+		"content": map[string]any{"role": "model", "parts": []any{map[string]any{"text": text}}},
+		"index":   0,
+	}
+
+	if finishReason != "" {
+		candidate["finishReason"] = finishReason
+	}
+
+	return map[string]any{
+		"candidates": []any{candidate},
+		"usageMetadata": map[string]any{
+			"promptTokenCount":     inputTokens,
+			"candidatesTokenCount": m.outputTokens(),
+			"totalTokenCount":      inputTokens + m.outputTokens(),
+		},
+		"modelVersion": model,
+	}
+}
+
+// geminiStream streams a response a word at a time, as server sent events when asked for with alt=sse and otherwise
+// as a JSON array written an element at a time.
+func (m *mock) geminiStream(w http.ResponseWriter, r *http.Request, model string, inputTokens int) {
+	words := m.words()
+	asSse := r.URL.Query().Get("alt") == "sse"
+
+	var sse *sseWriter
+
+	if asSse {
+		sse = newSseWriter(w)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("["))
+	}
+
+	for i, word := range words {
+		if i > 0 && !m.pause(r) {
+			return
+		}
+
+		finishReason := ""
+		if i == len(words)-1 {
+			finishReason = "STOP"
+		}
+
+		response := m.geminiResponse(model, word, inputTokens, finishReason)
+
+		if asSse {
+			sse.data(response)
+			continue
+		}
+
+		data, err := json.Marshal(response)
+		if err != nil {
+			return
+		}
+
+		if i > 0 {
+			w.Write([]byte(",\n"))
+		}
+
+		w.Write(data)
+		flush(w)
+	}
+
+	if !asSse {
+		w.Write([]byte("]"))
+	}
+}
+
+func (m *mock) useCase(w http.ResponseWriter, r *http.Request) {
+	offerings := make([]any, len(m.Models))
+
+	for i, model := range m.Models {
+		offerings[i] = map[string]any{"id": model.ID}
+	}
+
+	writeJson(w, map[string]any{
+		"id":        chi.URLParam(r, "useCase"),
+		"whitelist": map[string]any{"offerings": offerings},
+	})
+}
+
+func (m *mock) modelList(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, map[string]any{"items": m.Models})
+}
+
+func writeJson(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"message": message}})
+}
+
+func flush(w http.ResponseWriter) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// sseWriter writes server sent events, flushing each one so that it's sent straight away.
+type sseWriter struct {
+	w http.ResponseWriter
+}
+
+func newSseWriter(w http.ResponseWriter) *sseWriter {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	return &sseWriter{w: w}
+}
+
+func (s *sseWriter) data(value any) bool {
+	return s.event("", value)
+}
+
+func (s *sseWriter) event(name string, value any) bool {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+
+	var b strings.Builder
+
+	if name != "" {
+		fmt.Fprintf(&b, "event: %s\n", name)
+	}
+
+	fmt.Fprintf(&b, "data: %s\n\n", data)
+
+	return s.raw(b.String())
+}
+
+func (s *sseWriter) raw(text string) bool {
+	if _, err := io.WriteString(s.w, text); err != nil {
+		return false
+	}
+
+	flush(s.w)
+	return true
+}
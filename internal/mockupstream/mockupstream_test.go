@@ -0,0 +1,342 @@
+package mockupstream
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/proxy"
+	"bitbucket.org/atlassian-developers/proximity/internal/usage"
+)
+
+// TestShippedConfig runs requests through the shipped config with the mock answering its upstream requests.
+func TestShippedConfig(t *testing.T) {
+	cfg, err := config.Load("../../config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usageStore := usage.NewStore("")
+
+	p := proxy.New(proxy.Options{
+		Config:    cfg,
+		Usage:     usageStore,
+		Transport: Transport(New(Options{Completion: "Hi there friend"})),
+		TokenSource: func(groups []string, audience string, environment string) (string, error) {
+			return "token", nil
+		},
+		Vars: map[string]any{
+			"profiles": []any{map[string]any{"name": "default", "useCaseId": "use-case"}},
+		},
+	})
+
+	if err := p.Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		expected []string
+	}{
+		{
+			name:     "openai",
+			method:   http.MethodPost,
+			path:     "/openai/v1/chat/completions",
+			body:     `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`,
+			expected: []string{`"content":"Hi there friend"`, `"finish_reason":"stop"`},
+		},
+		{
+			name:     "openai streamed",
+			method:   http.MethodPost,
+			path:     "/openai/v1/chat/completions",
+			body:     `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`,
+			expected: []string{`"content":"Hi "`, `"content":"there "`, `"content":"friend"`, "data: [DONE]"},
+		},
+		{
+			name:     "openai in anthropic format",
+			method:   http.MethodPost,
+			path:     "/openai/anthropic/v1/messages",
+			body:     `{"model":"gpt-4o","max_tokens":10,"system":"Be nice","messages":[{"role":"user","content":"hi"}]}`,
+			expected: []string{`"type":"message"`, `"text":"Hi there friend"`, `"stop_reason":"end_turn"`},
+		},
+		{
+			name:     "openai in anthropic format streamed",
+			method:   http.MethodPost,
+			path:     "/openai/anthropic/v1/messages",
+			body:     `{"model":"gpt-4o","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`,
+			expected: []string{"event: message_start", `"text":"Hi "`, `"stop_reason":"end_turn"`, "event: message_stop"},
+		},
+		{
+			name:     "bedrock streamed",
+			method:   http.MethodPost,
+			path:     "/bedrock/claude/v1/messages",
+			body:     `{"model":"claude-sonnet-4-20250514","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`,
+			expected: []string{"event: message_start", `"model":"anthropic.claude-sonnet-4-20250514-v1:0"`, `"text":"friend"`, "event: message_stop"},
+		},
+		{
+			name:     "bedrock in openai format",
+			method:   http.MethodPost,
+			path:     "/provider/bedrock/format/openai/v1/chat/completions",
+			body:     `{"model":"claude-sonnet-4-20250514","messages":[{"role":"system","content":"Be nice"},{"role":"user","content":"hi"}]}`,
+			expected: []string{`"object":"chat.completion"`, `"content":"Hi there friend"`, `"finish_reason":"stop"`},
+		},
+		{
+			name:     "bedrock in openai format streamed",
+			method:   http.MethodPost,
+			path:     "/provider/bedrock/format/openai/v1/chat/completions",
+			body:     `{"model":"claude-sonnet-4-20250514","stream":true,"messages":[{"role":"user","content":"hi"}]}`,
+			expected: []string{`"content":"Hi "`, `"finish_reason":"stop"`},
+		},
+		{
+			name:     "vertex",
+			method:   http.MethodPost,
+			path:     "/vertex/claude/v1/messages",
+			body:     `{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`,
+			expected: []string{`"model":"claude-sonnet-4@20250514"`, `"text":"Hi there friend"`},
+		},
+		{
+			name:     "gemini streamed",
+			method:   http.MethodPost,
+			path:     "/google/gemini/v1beta/models/gemini-2.5-pro:streamGenerateContent?alt=sse",
+			body:     `{"contents":[{"role":"user","parts":[{"text":"hi"}]}]}`,
+			expected: []string{`data: {"candidates":[{"content":{"parts":[{"text":"Hi "}]`, `"finishReason":"STOP"`},
+		},
+		{
+			name:     "gemini in openai format",
+			method:   http.MethodPost,
+			path:     "/provider/vertex/format/openai/v1/chat/completions",
+			body:     `{"model":"gemini-2.5-pro","messages":[{"role":"system","content":"Be nice"},{"role":"user","content":"hi"}]}`,
+			expected: []string{`"object":"chat.completion"`, `"model":"gemini-2.5-pro"`, `"content":"Hi there friend"`, `"finish_reason":"stop"`},
+		},
+		{
+			name:     "gemini in openai format streamed",
+			method:   http.MethodPost,
+			path:     "/provider/vertex/format/openai/v1/chat/completions",
+			body:     `{"model":"gemini-2.5-pro","stream":true,"messages":[{"role":"user","content":"hi"}]}`,
+			expected: []string{`"content":"Hi "`, `"finish_reason":"stop"`, `"usage":{"prompt_tokens"`, "data: [DONE]"},
+		},
+		{
+			name:     "openai models",
+			method:   http.MethodGet,
+			path:     "/openai/v1/models",
+			expected: []string{`"id":"gpt-4o"`},
+		},
+		{
+			name:     "bedrock models",
+			method:   http.MethodGet,
+			path:     "/bedrock/claude/v1/models",
+			expected: []string{`"id":"claude-sonnet-4-20250514"`},
+		},
+		{
+			name:     "vertex models",
+			method:   http.MethodGet,
+			path:     "/vertex/claude/v1/models",
+			expected: []string{`"id":"claude-sonnet-4-20250514"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			rec := httptest.NewRecorder()
+			p.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got: %d %s", rec.Code, rec.Body.String())
+			}
+
+			for _, expected := range tt.expected {
+				if !strings.Contains(rec.Body.String(), expected) {
+					t.Errorf("Expected the response to contain %s, got: %s", expected, rec.Body.String())
+				}
+			}
+		})
+	}
+
+	report, err := usageStore.Report(usage.Filter{Profile: "default", UseCaseId: "use-case", Model: "gpt-4o"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Total.Requests == 0 || report.Total.OutputTokens == 0 {
+		t.Errorf("Expected the usage of the openai requests to be recorded, got: %+v", report.Total)
+	}
+}
+
+// TestAIGatewayConfig runs requests through the ai-gateway command's config, whose models are resolved with the
+// built-in catalog.
+func TestAIGatewayConfig(t *testing.T) {
+	cfg, err := config.Load("../../cmd/commands/ai-gateway/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := proxy.New(proxy.Options{
+		Config:    cfg,
+		Transport: Transport(New(Options{Completion: "Hi there friend"})),
+		TokenSource: func(groups []string, audience string, environment string) (string, error) {
+			return "token", nil
+		},
+		Vars: map[string]any{
+			"profiles": []any{map[string]any{"name": "default", "useCaseId": "use-case"}},
+		},
+	})
+
+	if err := p.Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		status   int
+		expected []string
+
+		// unexpected are left out of the response, such as the aliases of models the use case can't use
+		unexpected []string
+	}{
+		{
+			name:     "bedrock alias",
+			method:   http.MethodPost,
+			path:     "/bedrock/claude/v1/messages",
+			body:     `{"model":"claude-sonnet-latest","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`,
+			status:   http.StatusOK,
+			expected: []string{`"model":"anthropic.claude-sonnet-4-5-20250929-v1:0"`, `"text":"Hi there friend"`},
+		},
+		{
+			name:     "vertex dated model",
+			method:   http.MethodPost,
+			path:     "/vertex/claude/v1/messages",
+			body:     `{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`,
+			status:   http.StatusOK,
+			expected: []string{`"model":"claude-sonnet-4@20250514"`},
+		},
+		{
+			name:     "older dated model",
+			method:   http.MethodPost,
+			path:     "/bedrock/claude/v1/messages",
+			body:     `{"model":"claude-3-haiku-20240307","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`,
+			status:   http.StatusOK,
+			expected: []string{`"model":"anthropic.claude-3-haiku-20240307-v1:0"`},
+		},
+		{
+			name:     "gemini alias in the path",
+			method:   http.MethodPost,
+			path:     "/google/gemini/v1beta/models/gemini-pro-latest:generateContent",
+			body:     `{"contents":[{"role":"user","parts":[{"text":"hi"}]}]}`,
+			status:   http.StatusOK,
+			expected: []string{`"modelVersion":"gemini-2.5-pro"`},
+		},
+		{
+			name:     "unknown model",
+			method:   http.MethodPost,
+			path:     "/provider/bedrock/format/openai/v1/chat/completions",
+			body:     `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`,
+			status:   http.StatusBadRequest,
+			expected: []string{`"type":"invalid_request_error"`, `unknown model \"gpt-4o\", expected one of: claude-3-5-haiku`},
+		},
+		{
+			name:       "openai models",
+			method:     http.MethodGet,
+			path:       "/openai/v1/models",
+			status:     http.StatusOK,
+			expected:   []string{`"object":"list"`, `"id":"gpt-4o"`},
+			unexpected: []string{`"id":"gpt-latest"`, `"id":"claude-sonnet-4"`},
+		},
+		{
+			name:   "anthropic models",
+			method: http.MethodGet,
+			path:   "/vertex/claude/v1/models",
+			status: http.StatusOK,
+			expected: []string{
+				`"first_id":"claude-sonnet-4@20250514"`,
+				`"id":"claude-sonnet-4"`,
+				`"last_id":"claude-sonnet-4-20250514"`,
+			},
+			unexpected: []string{`"id":"claude-sonnet-latest"`, `"id":"anthropic.claude-sonnet-4-20250514-v1:0"`},
+		},
+		{
+			name:       "gemini models",
+			method:     http.MethodGet,
+			path:       "/google/gemini/v1beta/models",
+			status:     http.StatusOK,
+			expected:   []string{`"name":"models/gemini-2.5-pro"`, `{"baseModelId":"gemini-2.5-pro","displayName":"gemini-pro-latest"`},
+			unexpected: []string{`"name":"models/gemini-flash-latest"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			rec := httptest.NewRecorder()
+			p.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got: %d %s", tt.status, rec.Code, rec.Body.String())
+			}
+
+			for _, expected := range tt.expected {
+				if !strings.Contains(rec.Body.String(), expected) {
+					t.Errorf("Expected the response to contain %s, got: %s", expected, rec.Body.String())
+				}
+			}
+
+			for _, unexpected := range tt.unexpected {
+				if strings.Contains(rec.Body.String(), unexpected) {
+					t.Errorf("Expected the response not to contain %s, got: %s", unexpected, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestGeminiStreamWithoutSse(t *testing.T) {
+	server := httptest.NewServer(New(Options{}))
+	defer server.Close()
+
+	res, err := http.Post(server.URL+"/v1/google/v1/publishers/google/models/gemini-2.5-pro:streamGenerateContent", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chunks []map[string]any
+
+	if err := json.Unmarshal(body, &chunks); err != nil {
+		t.Fatalf("Expected a json array, got: %s", body)
+	}
+
+	if len(chunks) != len(strings.Split(DefaultCompletion, " ")) {
+		t.Errorf("Expected a chunk per word, got: %d", len(chunks))
+	}
+}
+
+func TestUnknownPath(t *testing.T) {
+	res, err := Transport(New(Options{})).RoundTrip(httptest.NewRequest(http.MethodGet, "http://upstream/unknown", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404, got: %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,100 @@
+package mockupstream
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Transport serves every request with the handler in process instead of sending it over the network, whatever its
+// host. Responses are streamed back as the handler writes them.
+func Transport(handler http.Handler) http.RoundTripper {
+	return &transport{handler: handler}
+}
+
+type transport struct {
+	handler http.Handler
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	pr, pw := io.Pipe()
+
+	w := &responseWriter{
+		header:      make(http.Header),
+		body:        pw,
+		wroteHeader: make(chan struct{}),
+	}
+
+	go func() {
+		defer pw.Close()
+		defer w.WriteHeader(http.StatusOK)
+
+		if req.Body != nil {
+			defer req.Body.Close()
+		}
+
+		t.handler.ServeHTTP(w, req.WithContext(serverContext{req.Context()}))
+	}()
+
+	select {
+	case <-w.wroteHeader:
+	case <-req.Context().Done():
+		pr.CloseWithError(req.Context().Err())
+		return nil, req.Context().Err()
+	}
+
+	return &http.Response{
+		Status:        http.StatusText(w.status),
+		StatusCode:    w.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.sent,
+		Body:          pr,
+		ContentLength: -1,
+		Request:       req,
+	}, nil
+}
+
+// serverContext keeps the cancellation of the client's request but none of its values, as they wouldn't reach a
+// server over the network.
+type serverContext struct {
+	context.Context
+}
+
+func (serverContext) Value(key any) any {
+	return nil
+}
+
+// responseWriter pipes what the handler writes into the body of the response. The response is returned as soon as
+// the header is written.
+type responseWriter struct {
+	header http.Header
+	body   *io.PipeWriter
+
+	once        sync.Once
+	wroteHeader chan struct{}
+	status      int
+	sent        http.Header
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	w.once.Do(func() {
+		w.status = status
+		w.sent = w.header.Clone()
+		close(w.wroteHeader)
+	})
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+// Flush does nothing as every write is passed straight to the reader.
+func (w *responseWriter) Flush() {}
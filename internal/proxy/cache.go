@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/cache"
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+)
+
+// cacheHeader tells clients if their response came from the cache.
+const cacheHeader = "X-Proximity-Cache"
+
+const (
+	cacheHit    = "HIT"
+	cacheMiss   = "MISS"
+	cacheBypass = "BYPASS"
+)
+
+// maxCachedBodySize is the largest response body which is cached, larger responses are passed on without being kept.
+const maxCachedBodySize = 32 << 20
+
+// cacheStore returns the store for a cache config. Routes with the same dir and max size share a store, and stores
+// are kept when the config is reloaded.
+func (s *server) cacheStore(cfg *config.Cache) cache.Store {
+	key := cfg.Dir + "\x00" + strconv.Itoa(cfg.MaxSize)
+
+	s.cachesMu.Lock()
+	defer s.cachesMu.Unlock()
+
+	if s.caches == nil {
+		s.caches = make(map[string]cache.Store)
+	}
+
+	store, ok := s.caches[key]
+	if !ok {
+		store = cache.New(cfg.Dir, cfg.MaxSize)
+		s.caches[key] = store
+	}
+
+	return store
+}
+
+// cacheKey returns the key a request's response is cached under. It's scoped to the method and route so that routes
+// sharing a store don't answer each other's requests.
+func (s *server) cacheKey(r *http.Request, cfg *config.Cache, templateInput map[string]any) (string, error) {
+	var key string
+
+	if strings.TrimSpace(cfg.Key) != "" {
+		output, err := s.renderer.EvalExpr(cfg.Key, templateInput, nil)
+		if err != nil {
+			return "", err
+		}
+
+		key = fmt.Sprint(output)
+	} else {
+		// Maps are encoded with their keys sorted so the same body always has the same hash
+		body, err := json.Marshal(templateInput["body"])
+		if err != nil {
+			return "", err
+		}
+
+		hash := sha256.Sum256(body)
+		key = r.URL.RequestURI() + " " + hex.EncodeToString(hash[:])
+	}
+
+	return r.Method + " " + routePattern(r.Context()) + " " + key, nil
+}
+
+// serveCached answers the request from the cache, or with next when it's not been cached yet. The response given by
+// next is cached if it's successful and was sent in full.
+func (s *server) serveCached(w http.ResponseWriter, r *http.Request, cfg *endpointProxyConfig, templateInput map[string]any, next func(http.ResponseWriter, *http.Request, *endpointProxyConfig, map[string]any)) {
+	cacheCfg := cfg.RequestResponse.Cache
+	route := routePattern(r.Context())
+
+	key, err := s.cacheableKey(r, cacheCfg, templateInput)
+	if err != nil {
+		s.Logger.ErrorContext(r.Context(), "failed to compute cache key", "error", err)
+	}
+
+	if key == "" {
+		s.metrics.cacheRequests.Inc(route, "bypass")
+		w.Header().Set(cacheHeader, cacheBypass)
+		next(w, r, cfg, templateInput)
+
+		return
+	}
+
+	store := s.cacheStore(cacheCfg)
+
+	if entry, ok := store.Get(key); ok {
+		s.metrics.cacheRequests.Inc(route, "hit")
+		s.Logger.DebugContext(r.Context(), "serving cached response", "created", entry.Created)
+		writeCachedResponse(w, entry)
+
+		return
+	}
+
+	s.metrics.cacheRequests.Inc(route, "miss")
+	w.Header().Set(cacheHeader, cacheMiss)
+
+	cw := &cacheWriter{ResponseWriter: w}
+	next(cw, r, cfg, templateInput)
+
+	// Streams the client stopped reading part way through aren't cached
+	if cw.status != http.StatusOK || cw.tooLarge || r.Context().Err() != nil {
+		return
+	}
+
+	// The request id is the one of the request which missed, hits echo back their own
+	header := cw.Header().Clone()
+	header.Del(cacheHeader)
+	header.Del(logging.RequestIDHeader)
+	header.Del("Date")
+
+	entry := &cache.Entry{
+		Status:  cw.status,
+		Header:  header,
+		Body:    cw.body.Bytes(),
+		Created: time.Now(),
+	}
+
+	if ttl := parseDurationOr(cacheCfg.Ttl, 0); ttl > 0 {
+		entry.Expires = entry.Created.Add(ttl)
+	}
+
+	if err := store.Put(key, entry); err != nil {
+		s.Logger.ErrorContext(r.Context(), "failed to cache response", "error", err)
+	}
+}
+
+// cacheableKey returns the cache key of a request, or an empty key when the cache's when doesn't match it.
+func (s *server) cacheableKey(r *http.Request, cfg *config.Cache, templateInput map[string]any) (string, error) {
+	matched, err := s.matches(cfg.When, templateInput, nil)
+	if err != nil || !matched {
+		return "", err
+	}
+
+	return s.cacheKey(r, cfg, templateInput)
+}
+
+// writeCachedResponse replays a cached response. Streams are written an event at a time so that clients read them the
+// same as they would from the upstream.
+func writeCachedResponse(w http.ResponseWriter, entry *cache.Entry) {
+	for name, values := range entry.Header {
+		w.Header()[name] = values
+	}
+
+	w.Header().Set(cacheHeader, cacheHit)
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(entry.Created).Seconds())))
+	w.WriteHeader(entry.Status)
+
+	if !strings.Contains(entry.Header.Get("Content-Type"), "text/event-stream") {
+		w.Write(entry.Body)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+
+	for _, event := range bytes.SplitAfter(entry.Body, []byte("\n\n")) {
+		if len(event) == 0 {
+			continue
+		}
+
+		w.Write(event)
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// cacheWriter keeps a copy of the response sent to the client so that it can be cached.
+type cacheWriter struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	tooLarge bool
+}
+
+func (w *cacheWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	if !w.tooLarge {
+		if w.body.Len()+len(b) > maxCachedBodySize {
+			w.tooLarge = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *cacheWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
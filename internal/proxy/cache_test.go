@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+)
+
+func TestCache(t *testing.T) {
+	var calls atomic.Int32
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+
+		switch r.URL.Path {
+		case "/chat":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"call":%d}`, n)
+		case "/stream":
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "data: {\"call\":%d}\n\ndata: [DONE]\n\n", n)
+		case "/models":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"models":["m%d"]}`, n)
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer upstream.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(fmt.Sprintf(`
+uriGroups:
+  - name: Test
+    supportedUris:
+      - in: /chat
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            text: /chat
+      - in: /stream
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            text: /stream
+      - in: /models
+        baseEndpoint: %[1]s
+        out:
+          - method: GET
+      - in: /fail
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            text: /fail
+overrides:
+  global:
+    cache:
+      when: (get(body, "temperature") ?? 0) == 0
+  uris:
+    /models:
+      GET:
+        cache:
+          key: get(headers, "X-Profile")?.[0] ?? ""
+        fetch:
+          requests:
+            models:
+              method: GET
+              url:
+                text: %[1]s/models
+        response:
+          body:
+            expr: requests.models.body
+`, upstream.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer()
+	s.Logger = logging.Discard()
+
+	router, err := s.buildRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		profile  string
+		cache    string
+		expected string
+	}{
+		{name: "first request", path: "/chat", body: `{"a":1,"b":2}`, cache: cacheMiss, expected: `{"call":1}`},
+		{name: "same body with its keys in another order", path: "/chat", body: `{"b":2,"a":1}`, cache: cacheHit, expected: `{"call":1}`},
+		{name: "different body", path: "/chat", body: `{"a":2}`, cache: cacheMiss, expected: `{"call":2}`},
+		{name: "when doesn't match", path: "/chat", body: `{"temperature":1}`, cache: cacheBypass, expected: `{"call":3}`},
+		{name: "first stream", path: "/stream", body: `{}`, cache: cacheMiss, expected: "data: {\"call\":4}\n\ndata: [DONE]\n\n"},
+		{name: "replayed stream", path: "/stream", body: `{}`, cache: cacheHit, expected: "data: {\"call\":4}\n\ndata: [DONE]\n\n"},
+		{name: "fetch for a headless response", method: http.MethodGet, path: "/models", cache: cacheMiss, expected: `{"models":["m5"]}`},
+		{name: "cached headless response isn't fetched again", method: http.MethodGet, path: "/models", cache: cacheHit, expected: `{"models":["m5"]}`},
+		{name: "custom key", method: http.MethodGet, path: "/models", profile: "other", cache: cacheMiss, expected: `{"models":["m6"]}`},
+		{name: "failed response", path: "/fail", body: `{}`, cache: cacheMiss},
+		{name: "failed responses aren't cached", path: "/fail", body: `{}`, cache: cacheMiss},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+
+			req := httptest.NewRequest(method, tt.path, strings.NewReader(tt.body))
+
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+
+			if tt.profile != "" {
+				req.Header.Set("X-Profile", tt.profile)
+			}
+
+			requestID := fmt.Sprintf("req-%d", i)
+			req.Header.Set(logging.RequestIDHeader, requestID)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if id := rec.Header().Get(logging.RequestIDHeader); id != requestID {
+				t.Errorf("Expected %s to be %s, got: %s", logging.RequestIDHeader, requestID, id)
+			}
+
+			if cache := rec.Header().Get(cacheHeader); cache != tt.cache {
+				t.Errorf("Expected %s to be %s, got: %s", cacheHeader, tt.cache, cache)
+			}
+
+			if body := rec.Body.String(); body != tt.expected {
+				t.Errorf("Expected body %q, got: %q", tt.expected, body)
+			}
+		})
+	}
+
+	if n := calls.Load(); n != 8 {
+		t.Errorf("Expected 8 upstream calls, got: %d", n)
+	}
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+)
+
+// errorTypes are the types each provider gives errors by their status code.
+var errorTypes = map[int]struct{ openai, anthropic, gemini string }{
+	http.StatusBadRequest:          {"invalid_request_error", "invalid_request_error", "INVALID_ARGUMENT"},
+	http.StatusTooManyRequests:     {"rate_limit_exceeded", "rate_limit_error", "RESOURCE_EXHAUSTED"},
+	http.StatusInternalServerError: {"server_error", "api_error", "INTERNAL"},
+	http.StatusBadGateway:          {"server_error", "api_error", "UNAVAILABLE"},
+	http.StatusServiceUnavailable:  {"server_error", "overloaded_error", "UNAVAILABLE"},
+}
+
+// writeError responds with an error the proxy raised itself, shaped like the provider's errors so that clients handle
+// it the same as one from the upstream. It's plain text when there's no error format.
+func writeError(w http.ResponseWriter, format config.ErrorFormat, status int, message string) {
+	types, ok := errorTypes[status]
+	if !ok {
+		types = errorTypes[http.StatusInternalServerError]
+	}
+
+	var body any
+
+	switch format {
+	case config.OpenAIErrorFormat:
+		body = map[string]any{
+			"error": map[string]any{"message": message, "type": types.openai, "param": nil, "code": types.openai},
+		}
+	case config.AnthropicErrorFormat:
+		body = map[string]any{
+			"type":  "error",
+			"error": map[string]any{"type": types.anthropic, "message": message},
+		}
+	case config.GeminiErrorFormat:
+		body = map[string]any{
+			"error": map[string]any{"code": status, "message": message, "status": types.gemini},
+		}
+	default:
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
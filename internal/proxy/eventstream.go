@@ -0,0 +1,335 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	eventStreamContentType = "application/vnd.amazon.eventstream"
+
+	// The prelude holds the total and headers lengths followed by a checksum of both
+	eventStreamPreludeLength  = 12
+	eventStreamChecksumLength = 4
+
+	// Messages are limited to 16MB by the format, anything larger is treated as a corrupt stream
+	eventStreamMaxMessageLength = 16 * 1024 * 1024
+)
+
+// Header value types of the event stream format
+const (
+	eventStreamBoolTrue byte = iota
+	eventStreamBoolFalse
+	eventStreamByte
+	eventStreamShort
+	eventStreamInt
+	eventStreamLong
+	eventStreamBytes
+	eventStreamString
+	eventStreamTimestamp
+	eventStreamUUID
+)
+
+// eventStreamFixedLengths are the value lengths of the fixed size header types, the variable size types are prefixed
+// with their length instead
+var eventStreamFixedLengths = map[byte]int{
+	eventStreamBoolTrue:  0,
+	eventStreamBoolFalse: 0,
+	eventStreamByte:      1,
+	eventStreamShort:     2,
+	eventStreamInt:       4,
+	eventStreamLong:      8,
+	eventStreamTimestamp: 8,
+	eventStreamUUID:      16,
+}
+
+var errEventStreamTruncated = errors.New("event stream headers are truncated")
+
+// eventStreamMessage is a single decoded frame of an AWS event stream.
+type eventStreamMessage struct {
+	Headers map[string]any
+	Payload []byte
+}
+
+func (m *eventStreamMessage) header(name string) string {
+	value, ok := m.Headers[name].(string)
+	if !ok {
+		return ""
+	}
+
+	return value
+}
+
+// eventStreamDecoder reads the binary framing used by AWS for streamed responses, e.g. Bedrock's
+// invoke-with-response-stream. Each frame is checked against both of its checksums.
+type eventStreamDecoder struct {
+	reader io.Reader
+}
+
+func newEventStreamDecoder(r io.Reader) *eventStreamDecoder {
+	return &eventStreamDecoder{
+		reader: r,
+	}
+}
+
+// Next returns the next message in the stream or io.EOF once the stream has ended between messages.
+func (d *eventStreamDecoder) Next() (*eventStreamMessage, error) {
+	prelude := make([]byte, eventStreamPreludeLength)
+
+	if _, err := io.ReadFull(d.reader, prelude); err != nil {
+		return nil, err
+	}
+
+	totalLength := binary.BigEndian.Uint32(prelude[0:4])
+	headersLength := binary.BigEndian.Uint32(prelude[4:8])
+
+	if crc32.ChecksumIEEE(prelude[0:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, errors.New("event stream prelude checksum mismatch")
+	}
+
+	if totalLength < eventStreamPreludeLength+eventStreamChecksumLength || totalLength > eventStreamMaxMessageLength {
+		return nil, fmt.Errorf("invalid event stream message length %d", totalLength)
+	}
+
+	if headersLength > totalLength-eventStreamPreludeLength-eventStreamChecksumLength {
+		return nil, fmt.Errorf("invalid event stream headers length %d", headersLength)
+	}
+
+	message := make([]byte, totalLength)
+	copy(message, prelude)
+
+	if _, err := io.ReadFull(d.reader, message[eventStreamPreludeLength:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	checksumStart := totalLength - eventStreamChecksumLength
+
+	if crc32.ChecksumIEEE(message[:checksumStart]) != binary.BigEndian.Uint32(message[checksumStart:]) {
+		return nil, errors.New("event stream message checksum mismatch")
+	}
+
+	headersEnd := eventStreamPreludeLength + headersLength
+
+	headers, err := decodeEventStreamHeaders(message[eventStreamPreludeLength:headersEnd])
+	if err != nil {
+		return nil, err
+	}
+
+	return &eventStreamMessage{
+		Headers: headers,
+		Payload: message[headersEnd:checksumStart],
+	}, nil
+}
+
+func decodeEventStreamHeaders(data []byte) (map[string]any, error) {
+	headers := make(map[string]any)
+
+	for len(data) > 0 {
+		nameLength := int(data[0])
+
+		if len(data) < 1+nameLength+1 {
+			return nil, errEventStreamTruncated
+		}
+
+		name := string(data[1 : 1+nameLength])
+		valueType := data[1+nameLength]
+		data = data[2+nameLength:]
+
+		valueLength, fixed := eventStreamFixedLengths[valueType]
+
+		if !fixed {
+			if valueType != eventStreamBytes && valueType != eventStreamString {
+				return nil, fmt.Errorf("unknown event stream header type %d for %s", valueType, name)
+			}
+
+			if len(data) < 2 {
+				return nil, errEventStreamTruncated
+			}
+
+			valueLength = int(binary.BigEndian.Uint16(data[0:2]))
+			data = data[2:]
+		}
+
+		if len(data) < valueLength {
+			return nil, errEventStreamTruncated
+		}
+
+		value := data[:valueLength]
+		data = data[valueLength:]
+
+		switch valueType {
+		case eventStreamBoolTrue:
+			headers[name] = true
+		case eventStreamBoolFalse:
+			headers[name] = false
+		case eventStreamByte:
+			headers[name] = int8(value[0])
+		case eventStreamShort:
+			headers[name] = int16(binary.BigEndian.Uint16(value))
+		case eventStreamInt:
+			headers[name] = int32(binary.BigEndian.Uint32(value))
+		case eventStreamLong:
+			headers[name] = int64(binary.BigEndian.Uint64(value))
+		case eventStreamTimestamp:
+			headers[name] = time.UnixMilli(int64(binary.BigEndian.Uint64(value))).UTC()
+		case eventStreamBytes, eventStreamUUID:
+			headers[name] = append([]byte(nil), value...)
+		case eventStreamString:
+			headers[name] = string(value)
+		}
+	}
+
+	return headers, nil
+}
+
+// sseEventFromEventStream converts an event stream message into the equivalent Anthropic style SSE event. Bedrock
+// wraps each Anthropic event in a chunk whose payload holds the event json base64 encoded in bytes, exceptions are
+// converted into Anthropic error events so clients handle them as they would an error from the Anthropic api.
+func sseEventFromEventStream(message *eventStreamMessage) (*sseEvent, error) {
+	switch message.header(":message-type") {
+	case "exception":
+		return eventStreamErrorEvent(message.header(":exception-type"), message.Payload), nil
+	case "error":
+		return eventStreamErrorEvent(message.header(":error-code"), []byte(toJsonMessage(message.header(":error-message")))), nil
+	}
+
+	data := message.Payload
+
+	var chunk struct {
+		Bytes *string `json:"bytes"`
+	}
+
+	if err := json.Unmarshal(message.Payload, &chunk); err == nil && chunk.Bytes != nil {
+		decoded, err := base64.StdEncoding.DecodeString(*chunk.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode event stream chunk: %w", err)
+		}
+
+		data = decoded
+	}
+
+	event := &sseEvent{
+		Name:    message.header(":event-type"),
+		Data:    string(data),
+		HasData: true,
+	}
+
+	// Anthropic events are named after their type rather than the chunk they arrived in
+	var typed struct {
+		Type string `json:"type"`
+	}
+
+	if err := json.Unmarshal(data, &typed); err == nil && typed.Type != "" {
+		event.Name = typed.Type
+	}
+
+	return event, nil
+}
+
+// eventStreamErrorTypes maps Bedrock exceptions onto the closest Anthropic error type.
+var eventStreamErrorTypes = map[string]string{
+	"throttlingException":         "rate_limit_error",
+	"validationException":         "invalid_request_error",
+	"serviceUnavailableException": "overloaded_error",
+}
+
+func eventStreamErrorEvent(exceptionType string, payload []byte) *sseEvent {
+	var exception struct {
+		Message string `json:"message"`
+	}
+
+	if err := json.Unmarshal(payload, &exception); err != nil || exception.Message == "" {
+		exception.Message = strings.TrimSpace(string(payload))
+	}
+
+	if exception.Message == "" {
+		exception.Message = exceptionType
+	}
+
+	errorType, ok := eventStreamErrorTypes[exceptionType]
+	if !ok {
+		errorType = "api_error"
+	}
+
+	data, _ := json.Marshal(map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    errorType,
+			"message": exception.Message,
+		},
+	})
+
+	return &sseEvent{
+		Name:    "error",
+		Data:    string(data),
+		HasData: true,
+	}
+}
+
+func toJsonMessage(message string) string {
+	data, _ := json.Marshal(map[string]string{"message": message})
+	return string(data)
+}
+
+// eventStreamSseReader re-encodes an AWS event stream as a text/event-stream so that it can be handled the same as
+// any other streamed response.
+type eventStreamSseReader struct {
+	source  io.ReadCloser
+	decoder *eventStreamDecoder
+	pending bytes.Buffer
+	err     error
+}
+
+func newEventStreamSseReader(source io.ReadCloser) *eventStreamSseReader {
+	return &eventStreamSseReader{
+		source:  source,
+		decoder: newEventStreamDecoder(source),
+	}
+}
+
+func (r *eventStreamSseReader) Read(p []byte) (int, error) {
+	for r.pending.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		message, err := r.decoder.Next()
+		if err != nil {
+			r.err = err
+
+			// A corrupt stream is reported to the client as an error event before the stream ends
+			if err != io.EOF {
+				r.pending.Write(encodeSseEvent(eventStreamErrorEvent("", []byte(toJsonMessage(err.Error())))))
+			}
+
+			continue
+		}
+
+		event, err := sseEventFromEventStream(message)
+		if err != nil {
+			r.err = err
+			r.pending.Write(encodeSseEvent(eventStreamErrorEvent("", []byte(toJsonMessage(err.Error())))))
+			continue
+		}
+
+		r.pending.Write(encodeSseEvent(event))
+	}
+
+	return r.pending.Read(p)
+}
+
+func (r *eventStreamSseReader) Close() error {
+	return r.source.Close()
+}
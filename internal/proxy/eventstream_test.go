@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"testing"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+)
+
+// encodeEventStreamMessage builds a frame with string headers as sent by Bedrock.
+func encodeEventStreamMessage(headers map[string]string, payload string) []byte {
+	var headerBytes bytes.Buffer
+
+	for _, name := range []string{":message-type", ":event-type", ":exception-type", ":content-type"} {
+		value, ok := headers[name]
+		if !ok {
+			continue
+		}
+
+		headerBytes.WriteByte(byte(len(name)))
+		headerBytes.WriteString(name)
+		headerBytes.WriteByte(eventStreamString)
+		binary.Write(&headerBytes, binary.BigEndian, uint16(len(value)))
+		headerBytes.WriteString(value)
+	}
+
+	totalLength := eventStreamPreludeLength + headerBytes.Len() + len(payload) + eventStreamChecksumLength
+
+	var message bytes.Buffer
+
+	binary.Write(&message, binary.BigEndian, uint32(totalLength))
+	binary.Write(&message, binary.BigEndian, uint32(headerBytes.Len()))
+	binary.Write(&message, binary.BigEndian, crc32.ChecksumIEEE(message.Bytes()))
+	message.Write(headerBytes.Bytes())
+	message.WriteString(payload)
+	binary.Write(&message, binary.BigEndian, crc32.ChecksumIEEE(message.Bytes()))
+
+	return message.Bytes()
+}
+
+func encodeChunk(event string) []byte {
+	return encodeEventStreamMessage(
+		map[string]string{":message-type": "event", ":event-type": "chunk", ":content-type": "application/json"},
+		`{"bytes":"`+base64.StdEncoding.EncodeToString([]byte(event))+`"}`,
+	)
+}
+
+func TestEventStreamSseReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		stream   []byte
+		expected string
+	}{
+		{
+			name: "chunks",
+			stream: append(
+				encodeChunk(`{"type":"message_start","message":{"id":"msg_1"}}`),
+				encodeChunk(`{"type":"message_stop"}`)...,
+			),
+			expected: "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\"}}\n\n" +
+				"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+		},
+		{
+			name: "exception",
+			stream: encodeEventStreamMessage(
+				map[string]string{":message-type": "exception", ":exception-type": "throttlingException"},
+				`{"message":"Too many requests"}`,
+			),
+			expected: "event: error\ndata: {\"error\":{\"message\":\"Too many requests\",\"type\":\"rate_limit_error\"},\"type\":\"error\"}\n\n",
+		},
+		{
+			name: "corrupt message",
+			stream: func() []byte {
+				message := encodeChunk(`{"type":"ping"}`)
+				message[len(message)-1] ^= 0xff
+				return message
+			}(),
+			expected: "event: error\ndata: {\"error\":{\"message\":\"event stream message checksum mismatch\",\"type\":\"api_error\"},\"type\":\"error\"}\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := newEventStreamSseReader(io.NopCloser(bytes.NewReader(tt.stream)))
+
+			body, _ := io.ReadAll(reader)
+
+			if string(body) != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, body)
+			}
+		})
+	}
+}
+
+func TestEventStreamDecoderTruncated(t *testing.T) {
+	message := encodeChunk(`{"type":"ping"}`)
+	decoder := newEventStreamDecoder(bytes.NewReader(message[:len(message)-2]))
+
+	if _, err := decoder.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected %v, got: %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestModifyResponseEventStream(t *testing.T) {
+	s := newTestServer()
+
+	cfg := &endpointProxyConfig{
+		RequestResponse: config.RequestResponse{
+			Response: config.OverrideConfig{
+				Body: config.Body{
+					Sse:  config.SseEvents,
+					Expr: `event.name == "message_stop" ? { name: event.name, json: { type: event.json.type } } : event`,
+				},
+			},
+		},
+	}
+
+	res := &http.Response{
+		Header: http.Header{"Content-Type": []string{eventStreamContentType}},
+		Body: io.NopCloser(bytes.NewReader(append(
+			encodeChunk(`{"type":"content_block_delta","delta":{"text":"hi"}}`),
+			encodeChunk(`{"type":"message_stop","amazon-bedrock-invocationMetrics":{"inputTokenCount":1}}`)...,
+		))),
+	}
+
+	if err := s.modifyResponse(cfg)(res); err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got: %s", contentType)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"text\":\"hi\"}}\n\n" +
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+
+	if string(body) != expected {
+		t.Errorf("Expected %q, got %q", expected, body)
+	}
+}
@@ -0,0 +1,318 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/exchange"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+	"bitbucket.org/atlassian-developers/proximity/internal/redact"
+)
+
+type exchangeKey struct{}
+
+// exchangeRecorder builds up the exchange of a request as it's handled. Fetch requests run concurrently so it's
+// guarded by a mutex.
+type exchangeRecorder struct {
+	mu       sync.Mutex
+	exchange *exchange.Exchange
+
+	// upstreamBody is the start of the body of the last upstream response, it's filled in as the body is read
+	upstreamBody limitedBuffer
+}
+
+// exchangeFromContext returns the recorder of the request, it's nil when exchanges aren't captured.
+func exchangeFromContext(ctx context.Context) *exchangeRecorder {
+	rec, _ := ctx.Value(exchangeKey{}).(*exchangeRecorder)
+	return rec
+}
+
+func (r *exchangeRecorder) setError(err error) {
+	if r == nil || err == nil {
+		return
+	}
+
+	r.mu.Lock()
+	r.exchange.Error = err.Error()
+	r.mu.Unlock()
+}
+
+func (r *exchangeRecorder) addFetch(fetch exchange.Fetch) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	r.exchange.Fetches = append(r.exchange.Fetches, fetch)
+	r.mu.Unlock()
+}
+
+// captureExchanges publishes every request handled by the router to the exchange store once its response has been
+// sent. A forwarded request is part of the exchange of the original one.
+func (s *server) captureExchanges(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exchangeFromContext(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.Logger.ErrorContext(r.Context(), "failed to read request", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		rec := &exchangeRecorder{
+			exchange: &exchange.Exchange{
+				ID:        logging.NewRequestID(),
+				RequestID: logging.RequestIDFromContext(r.Context()),
+				Time:      start,
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Request: exchange.Message{
+					Method:  r.Method,
+					Url:     requestUrl(r),
+					Headers: s.redactor.Header(r.Header),
+				},
+			},
+		}
+
+		rec.exchange.Request.SetBody(s.redactor.Body(body), false)
+
+		writer := &exchangeWriter{ResponseWriter: w}
+		next.ServeHTTP(writer, r.WithContext(context.WithValue(r.Context(), exchangeKey{}, rec)))
+
+		status := writer.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+
+		ex := rec.exchange
+		ex.Route = routePattern(r.Context())
+		ex.SseEvents = writer.events
+		ex.Timings.TotalMs = milliseconds(time.Since(start))
+		ex.Response = exchange.Message{
+			Status:  status,
+			Headers: s.redactor.Header(w.Header()),
+		}
+
+		ex.Response.SetBody(s.redactor.Body(writer.body.Bytes()), writer.body.truncated)
+
+		if ex.UpstreamResponse != nil {
+			ex.UpstreamResponse.SetBody(s.redactor.Body(rec.upstreamBody.Bytes()), rec.upstreamBody.truncated)
+		}
+
+		s.Exchanges.Add(ex)
+	})
+}
+
+// exchangeWriter keeps the start of the response body and counts the events of a stream as they're sent.
+type exchangeWriter struct {
+	http.ResponseWriter
+	status int
+	body   limitedBuffer
+
+	events      int
+	lastNewline bool
+}
+
+func (w *exchangeWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *exchangeWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	w.body.Write(b)
+
+	if strings.Contains(w.Header().Get("Content-Type"), "text/event-stream") {
+		w.countEvents(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// countEvents counts the blank lines ending each event, which may be split between writes.
+func (w *exchangeWriter) countEvents(b []byte) {
+	for _, c := range b {
+		switch c {
+		case '\r':
+			continue
+		case '\n':
+			if w.lastNewline {
+				w.events++
+			}
+
+			w.lastNewline = true
+		default:
+			w.lastNewline = false
+		}
+	}
+}
+
+func (w *exchangeWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *exchangeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// exchangeTransport records the rendered request sent to the upstream and its response status. When a request is
+// retried or fails over the last attempt is kept.
+type exchangeTransport struct {
+	transport http.RoundTripper
+	redactor  *redact.Redactor
+}
+
+func (t *exchangeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := exchangeFromContext(req.Context())
+	if rec == nil {
+		return t.transport.RoundTrip(req)
+	}
+
+	body, err := peekBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	upstreamRequest := &exchange.Message{
+		Method:  req.Method,
+		Url:     req.URL.String(),
+		Headers: t.redactor.Header(req.Header),
+	}
+
+	upstreamRequest.SetBody(t.redactor.Body(body), false)
+
+	start := time.Now()
+	res, err := t.transport.RoundTrip(req)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	ex := rec.exchange
+	ex.UpstreamRequest = upstreamRequest
+	ex.UpstreamResponse = nil
+	ex.Upstream = req.URL.Host
+	ex.UpstreamAttempts++
+	ex.Timings.UpstreamMs = milliseconds(time.Since(start))
+
+	rec.upstreamBody = limitedBuffer{}
+
+	if err != nil {
+		ex.UpstreamStatus = 0
+		ex.Error = err.Error()
+		return nil, err
+	}
+
+	ex.UpstreamStatus = res.StatusCode
+	ex.UpstreamResponse = &exchange.Message{
+		Status:  res.StatusCode,
+		Headers: t.redactor.Header(res.Header),
+	}
+	ex.Error = ""
+
+	// The body is kept as it's read so that streams reach the client as they arrive
+	res.Body = &teeReadCloser{
+		Reader: io.TeeReader(res.Body, &upstreamBodyWriter{rec: rec}),
+		Closer: res.Body,
+	}
+
+	return res, nil
+}
+
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// upstreamBodyWriter keeps what's read from the upstream response in the recorder.
+type upstreamBodyWriter struct {
+	rec *exchangeRecorder
+}
+
+func (w *upstreamBodyWriter) Write(b []byte) (int, error) {
+	w.rec.mu.Lock()
+	defer w.rec.mu.Unlock()
+
+	return w.rec.upstreamBody.Write(b)
+}
+
+// limitedBuffer keeps up to exchange.MaxBodySize of what's written to it, dropping the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := exchange.MaxBodySize - b.Len(); len(p) > remaining {
+		b.Buffer.Write(p[:remaining])
+		b.truncated = true
+	} else {
+		b.Buffer.Write(p)
+	}
+
+	return len(p), nil
+}
+
+// requestUrl returns the absolute url of a request received by the proxy.
+func requestUrl(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+// peekBody reads the body of a request without consuming it.
+func peekBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+
+		return io.ReadAll(body)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/exchange"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+	"bitbucket.org/atlassian-developers/proximity/internal/redact"
+)
+
+func TestCaptureExchanges(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/models" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`["a"]`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"n\":1}\n\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("data: {\"n\":2}\r\n"))
+		w.Write([]byte("\r\ndata: [DONE]\n\n"))
+	}))
+	defer upstream.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(fmt.Sprintf(`
+redact:
+  jsonPaths:
+    - /prompt
+uriGroups:
+  - name: Test
+    supportedUris:
+      - in: /chat
+        baseEndpoint: %s
+        out:
+          - method: POST
+            text: /upstream
+overrides:
+  uris:
+    /chat:
+      POST:
+        fetch:
+          requests:
+            models:
+              method: GET
+              url:
+                text: %s/models
+        request:
+          headers:
+            - op: add
+              name: Authorization
+              text: Bearer secret
+          body:
+            expr: 'toCompactJson(merge(filterOutKeys(body, ["model"]), {"model": "b"}))'
+`, upstream.URL, upstream.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer()
+	s.Logger = logging.Discard()
+	s.Exchanges = exchange.NewStore(10)
+	s.redactor = redact.New(redactOptions(cfg, nil, nil))
+
+	var published []*exchange.Exchange
+
+	s.Exchanges.Subscribe(func(e *exchange.Exchange) {
+		published = append(published, e)
+	})
+
+	router, err := s.buildRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"model":"a","prompt":"private"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(logging.RequestIDHeader, "req-1")
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if len(published) != 1 {
+		t.Fatalf("Expected 1 exchange to be published, got: %d", len(published))
+	}
+
+	e := published[0]
+
+	if e.RequestID != "req-1" || e.Route != "/chat" || e.Method != http.MethodPost {
+		t.Errorf("Expected the exchange to be for req-1 POST /chat, got: %s %s %s", e.RequestID, e.Method, e.Route)
+	}
+
+	if e.Request.Body != `{"model":"a","prompt":"[REDACTED]"}` {
+		t.Errorf("Expected the incoming body with the prompt redacted, got: %s", e.Request.Body)
+	}
+
+	if e.UpstreamRequest == nil {
+		t.Fatal("Expected the upstream request to be captured")
+	}
+
+	if e.UpstreamRequest.Url != upstream.URL+"/upstream" {
+		t.Errorf("Expected the upstream url %s/upstream, got: %s", upstream.URL, e.UpstreamRequest.Url)
+	}
+
+	if e.UpstreamRequest.Body != `{"model":"b","prompt":"[REDACTED]"}` {
+		t.Errorf("Expected the rendered upstream body, got: %s", e.UpstreamRequest.Body)
+	}
+
+	if auth := e.UpstreamRequest.Headers.Get("Authorization"); auth != redact.Mask {
+		t.Errorf("Expected the upstream authorization header to be redacted, got: %s", auth)
+	}
+
+	if e.UpstreamStatus != http.StatusOK || e.UpstreamAttempts != 1 {
+		t.Errorf("Expected 1 upstream attempt with status 200, got: %d attempts with status %d", e.UpstreamAttempts, e.UpstreamStatus)
+	}
+
+	if e.UpstreamResponse == nil || !strings.Contains(e.UpstreamResponse.Body, "data: [DONE]") {
+		t.Errorf("Expected the upstream response to be captured, got: %+v", e.UpstreamResponse)
+	}
+
+	if e.Response.Status != http.StatusOK || !strings.Contains(e.Response.Body, "[DONE]") {
+		t.Errorf("Expected the response to be captured, got: %d %s", e.Response.Status, e.Response.Body)
+	}
+
+	if e.SseEvents != 3 {
+		t.Errorf("Expected 3 sse events, got: %d", e.SseEvents)
+	}
+
+	if len(e.Fetches) != 1 {
+		t.Fatalf("Expected 1 fetch, got: %d", len(e.Fetches))
+	}
+
+	if fetch := e.Fetches[0]; fetch.Name != "models" || fetch.Url != upstream.URL+"/models" || fetch.Status != http.StatusOK {
+		t.Errorf("Expected the models fetch to be captured, got: %+v", fetch)
+	}
+
+	if e.Timings.TotalMs < e.Timings.UpstreamMs {
+		t.Errorf("Expected the total time to include the upstream time, got: %+v", e.Timings)
+	}
+
+	if stored, ok := s.Exchanges.Get(e.ID); !ok || stored != e {
+		t.Errorf("Expected the exchange to be stored under %s", e.ID)
+	}
+}
+
+func TestCaptureExchangesUpstreamError(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+uriGroups:
+  - name: Test
+    supportedUris:
+      - in: /chat
+        baseEndpoint: http://127.0.0.1:1
+        out:
+          - method: POST
+            text: /upstream
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer()
+	s.Logger = logging.Discard()
+	s.Exchanges = exchange.NewStore(10)
+
+	router, err := s.buildRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{}`)))
+
+	exchanges := s.Exchanges.List()
+	if len(exchanges) != 1 {
+		t.Fatalf("Expected 1 exchange, got: %d", len(exchanges))
+	}
+
+	if exchanges[0].Status != http.StatusBadGateway || exchanges[0].Error == "" {
+		t.Errorf("Expected a bad gateway with the error, got: %+v", exchanges[0])
+	}
+}
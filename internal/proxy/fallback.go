@@ -0,0 +1,313 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/template"
+
+	"github.com/go-chi/chi"
+)
+
+// providerHeader names the route which served a request that could fall back
+const providerHeader = "X-Proximity-Provider"
+
+// errFallback is returned by the transport in place of an upstream response which falls back to the next route. The
+// reverse proxy hands it to its error handler, so nothing has been written to the client when the request falls back.
+var errFallback = errors.New("upstream failed, falling back to the next route")
+
+type fallbackKey struct{}
+
+// fallbackChain is the routes a request has left to fall back to. It's taken from the route the request came in on
+// and carried to each route it falls back to in the request's context.
+type fallbackChain struct {
+	routes []fallbackRoute
+	when   string
+
+	// model is what the current route resolves the request's model from in place of the original's, it's set when
+	// the route the request fell back from has another model family
+	model string
+
+	// original is the request as the client sent it, before the model was resolved or it was rendered
+	original *http.Request
+
+	// fellBack is set once the upstream of the current route has failed
+	fellBack bool
+
+	// counted is set for the routes a request falls back to, the request has been counted against its limits on
+	// the route it came in on and its usage is recorded by that route's recorder
+	counted bool
+	usage   *usageRecorder
+
+	// release frees the limits the request holds once it's done falling back
+	release func()
+}
+
+// fallbackRoute is a route a request can fall back to and the model it's asked for there
+type fallbackRoute struct {
+	route string
+	model string
+}
+
+// fallbackFromContext returns the fallback chain of a request, the chain is nil when it can't fall back.
+func fallbackFromContext(ctx context.Context) *fallbackChain {
+	chain, _ := ctx.Value(fallbackKey{}).(*fallbackChain)
+	return chain
+}
+
+// canFallBack reports whether any of an endpoint's overrides falls back.
+func canFallBack(cfg *endpointProxyConfig) bool {
+	for _, reqResp := range append([]config.RequestResponse{cfg.RequestResponse}, cfg.variants...) {
+		if reqResp.Fallback != nil && len(reqResp.Fallback.Routes) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// copyOriginalRequest copies a request before it's changed so that it can be sent to the routes it falls back to. It's
+// nil when the request can't fall back or it's already falling back, as the chain has the original then.
+func copyOriginalRequest(r *http.Request, cfg *endpointProxyConfig) (*http.Request, error) {
+	if fallbackFromContext(r.Context()) != nil || !canFallBack(cfg) {
+		return nil, nil
+	}
+
+	if err := makeBodyReplayable(r); err != nil {
+		return nil, err
+	}
+
+	return r.Clone(r.Context()), nil
+}
+
+// withFallback adds the fallback chain of the selected config to the request. A request which is already falling back
+// keeps the chain of the route it came in on.
+func (s *server) withFallback(w http.ResponseWriter, r *http.Request, cfg *endpointProxyConfig, original *http.Request, templateInput map[string]any) (*http.Request, *fallbackChain) {
+	chain := fallbackFromContext(r.Context())
+
+	if chain == nil && original != nil && cfg.Fallback != nil {
+		if routes := s.fallbackRoutes(r.Context(), cfg, requestModel(templateInput)); len(routes) > 0 {
+			chain = &fallbackChain{routes: routes, when: cfg.Fallback.When, original: original}
+			r = r.WithContext(context.WithValue(r.Context(), fallbackKey{}, chain))
+		}
+	}
+
+	if chain != nil {
+		w.Header().Set(providerHeader, cfg.In)
+	}
+
+	return r, chain
+}
+
+// fallbackRoutes picks the model each fallback route is asked for. The model the request resolved to is only known
+// to the endpoint's family, so a route of another family is asked for the model itself if it knows it or else one of
+// its aliases. Routes which know neither are left out, the endpoint's response is better than a 400 from them.
+func (s *server) fallbackRoutes(ctx context.Context, cfg *endpointProxyConfig, model string) []fallbackRoute {
+	candidates := []string{model}
+	if cfg.ModelFamily != "" {
+		candidates = append(candidates, s.Catalog.AliasesOf(cfg.ModelFamily, model)...)
+	}
+
+	routes := []fallbackRoute{}
+
+	for _, route := range cfg.Fallback.Routes {
+		family := cfg.fallbackFamilies[route]
+		if model == "" || family == "" || family == cfg.ModelFamily {
+			routes = append(routes, fallbackRoute{route: route})
+			continue
+		}
+
+		known := false
+
+		for _, candidate := range candidates {
+			if _, ok := s.Catalog.Resolve(family, candidate); ok {
+				routes = append(routes, fallbackRoute{route: route, model: candidate})
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			s.Logger.DebugContext(ctx, "fallback route doesn't know the model, skipping it", "route", route, "model", model)
+		}
+	}
+
+	return routes
+}
+
+// serveFallback sends the original request to the next route of the chain through the router, so that it's rendered
+// with the route's own overrides the same as if the client had sent it there.
+func (s *server) serveFallback(w http.ResponseWriter, r *http.Request, chain *fallbackChain) {
+	route := chain.routes[0].route
+	next := &fallbackChain{
+		routes:   chain.routes[1:],
+		when:     chain.when,
+		model:    chain.routes[0].model,
+		original: chain.original,
+		counted:  true,
+		usage:    chain.usage,
+	}
+
+	// The route is matched afresh rather than on top of the route the request came in on
+	ctx := context.WithValue(r.Context(), chi.RouteCtxKey, chi.NewRouteContext())
+	ctx = context.WithValue(ctx, fallbackKey{}, next)
+
+	req := chain.original.Clone(ctx)
+	req.URL.Path = route
+	req.URL.RawPath = ""
+	req.RequestURI = route
+
+	if chain.original.GetBody != nil {
+		body, err := chain.original.GetBody()
+		if err != nil {
+			s.Logger.ErrorContext(r.Context(), "failed to copy request for fallback", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		req.Body = body
+	}
+
+	s.Logger.WarnContext(r.Context(), "falling back", "route", route)
+
+	s.router.Load().ServeHTTP(w, req)
+}
+
+// fallbackTransport turns an upstream response which should fall back into errFallback, it passes everything through
+// for requests without routes left to fall back to.
+type fallbackTransport struct {
+	transport http.RoundTripper
+	renderer  *template.Renderer
+	logger    *slog.Logger
+	vars      map[string]any
+}
+
+func (t *fallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.transport.RoundTrip(req)
+
+	chain := fallbackFromContext(req.Context())
+	if chain == nil || len(chain.routes) == 0 || req.Context().Err() != nil {
+		return res, err
+	}
+
+	if !t.shouldFallBack(req.Context(), chain.when, res, err) {
+		return res, err
+	}
+
+	if err != nil {
+		t.logger.WarnContext(req.Context(), "upstream failed, falling back", "route", chain.routes[0].route, "error", err)
+	} else {
+		t.logger.WarnContext(req.Context(), "upstream responded with a fallback status, falling back",
+			"route", chain.routes[0].route, "status", res.StatusCode,
+		)
+
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+
+	chain.fellBack = true
+	return nil, errFallback
+}
+
+// shouldFallBack decides if a request falls back. Connection errors, 429s and 5xxs do unless there's a when expr, in
+// which case it's whatever the expr returns.
+func (t *fallbackTransport) shouldFallBack(ctx context.Context, when string, res *http.Response, err error) bool {
+	if when == "" {
+		return err != nil || res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	}
+
+	env := map[string]any{
+		"globalVars": t.vars,
+		"status":     0,
+		"headers":    http.Header{},
+		"body":       nil,
+		"error":      "",
+	}
+
+	if err != nil {
+		env["error"] = err.Error()
+	} else {
+		env["status"] = res.StatusCode
+		env["headers"] = copyHeaders(res.Header)
+
+		// Only error bodies are read, a successful response may be a stream which has to reach the client as it comes
+		if res.StatusCode >= 400 {
+			body, readErr := readErrorBody(res)
+			if readErr != nil {
+				t.logger.WarnContext(ctx, "failed to read upstream error", "error", readErr)
+			}
+
+			env["body"] = body
+		}
+	}
+
+	output, evalErr := t.renderer.EvalExpr(when, env, nil)
+	if evalErr != nil {
+		t.logger.ErrorContext(ctx, "failed to evaluate fallback when expr", "error", evalErr)
+		return err != nil
+	}
+
+	fallBack, _ := output.(bool)
+	return fallBack
+}
+
+// readErrorBody reads the body of an error response, parsed as json when it is, and puts it back for the client.
+func readErrorBody(res *http.Response) (any, error) {
+	bodyBytes, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var body any
+
+	if json.Unmarshal(bodyBytes, &body) != nil {
+		return string(bodyBytes), nil
+	}
+
+	return body, nil
+}
+
+// checkFallbackRoutes checks the routes an endpoint falls back to are proxied with the endpoint's method, and keeps
+// their model families on the endpoint.
+func checkFallbackRoutes(configs map[string]map[string]*endpointProxyConfig, method string, cfg *endpointProxyConfig) error {
+	cfg.fallbackFamilies = map[string]string{}
+
+	for _, reqResp := range append([]config.RequestResponse{cfg.RequestResponse}, cfg.variants...) {
+		if reqResp.Fallback == nil {
+			continue
+		}
+
+		tried := map[string]bool{cfg.In: true}
+
+		for _, route := range reqResp.Fallback.Routes {
+			if tried[route] {
+				return fmt.Errorf("fallback route %s is tried more than once", route)
+			}
+
+			tried[route] = true
+
+			target, ok := configs[route][method]
+			if !ok {
+				return fmt.Errorf("fallback route %s has no %s out method", route, method)
+			}
+
+			if target.Out.IsEmpty() {
+				return fmt.Errorf("fallback route %s isn't proxied to an upstream", route)
+			}
+
+			cfg.fallbackFamilies[route] = target.ModelFamily
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,324 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/catalog"
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+)
+
+func TestFallback(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(r.Header.Get("X-Upstream-Status"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error":{"type":"rate_limit"}}`))
+	}))
+	defer primary.Close()
+
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", r.URL.Path, body)
+	}))
+	defer secondary.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(fmt.Sprintf(`
+uriGroups:
+  - name: Test
+    supportedUris:
+      - in: /primary
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            text: /primary
+      - in: /conditional
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            text: /conditional
+      - in: /chain
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            text: /chain
+      - in: /secondary
+        baseEndpoint: %[2]s
+        out:
+          - method: POST
+            text: /secondary
+overrides:
+  uris:
+    /primary:
+      POST:
+        fallback:
+          routes: [/secondary]
+        request:
+          body:
+            text: '{"rendered":true}'
+    /conditional:
+      POST:
+        fallback:
+          routes: [/secondary]
+          when: status == 400 && body.error.type == "rate_limit"
+    /chain:
+      POST:
+        fallback:
+          routes: [/primary]
+`, primary.URL, secondary.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer()
+	s.Logger = logging.Discard()
+
+	router, err := s.buildRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.router.Store(router)
+
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		expected int
+		provider string
+		body     string
+	}{
+		{
+			name:     "throttled",
+			path:     "/primary",
+			status:   http.StatusTooManyRequests,
+			expected: http.StatusOK,
+			provider: "/secondary",
+			body:     `/secondary {"model":"m"}`,
+		},
+		{
+			name:     "client error",
+			path:     "/primary",
+			status:   http.StatusBadRequest,
+			expected: http.StatusBadRequest,
+			provider: "/primary",
+			body:     `{"error":{"type":"rate_limit"}}`,
+		},
+		{
+			name:     "when",
+			path:     "/conditional",
+			status:   http.StatusBadRequest,
+			expected: http.StatusOK,
+			provider: "/secondary",
+			body:     `/secondary {"model":"m"}`,
+		},
+		{
+			name:     "last route",
+			path:     "/chain",
+			status:   http.StatusServiceUnavailable,
+			expected: http.StatusServiceUnavailable,
+			provider: "/primary",
+			body:     `{"error":{"type":"rate_limit"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{"model":"m"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Upstream-Status", strconv.Itoa(tt.status))
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expected {
+				t.Errorf("Expected status %d, got: %d", tt.expected, rec.Code)
+			}
+
+			if provider := rec.Header().Get(providerHeader); provider != tt.provider {
+				t.Errorf("Expected the provider to be %s, got: %s", tt.provider, provider)
+			}
+
+			if rec.Body.String() != tt.body {
+				t.Errorf("Expected %s, got: %s", tt.body, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestFallbackModel(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer primary.Close()
+
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", r.URL.Path, body)
+	}))
+	defer secondary.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(fmt.Sprintf(`
+uriGroups:
+  - name: Bedrock
+    modelFamily: bedrock
+    supportedUris:
+      - in: /bedrock
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            text: /bedrock
+      - in: /bedrock/gemini
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            text: /bedrock/gemini
+  - name: Vertex
+    modelFamily: vertex
+    supportedUris:
+      - in: /vertex
+        baseEndpoint: %[2]s
+        out:
+          - method: POST
+            text: /vertex
+  - name: Gemini
+    modelFamily: gemini
+    supportedUris:
+      - in: /gemini
+        baseEndpoint: %[2]s
+        out:
+          - method: POST
+            text: /gemini
+overrides:
+  uris:
+    /bedrock:
+      POST:
+        fallback:
+          routes: [/gemini, /vertex]
+    /bedrock/gemini:
+      POST:
+        fallback:
+          routes: [/gemini]
+`, primary.URL, secondary.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer()
+	s.Logger = logging.Discard()
+	s.Catalog = catalog.Builtin()
+
+	router, err := s.buildRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.router.Store(router)
+
+	tests := []struct {
+		name     string
+		path     string
+		model    string
+		expected int
+		provider string
+		body     string
+	}{
+		{
+			name:     "provider id",
+			path:     "/bedrock",
+			model:    "anthropic.claude-sonnet-4-5-20250929-v1:0",
+			expected: http.StatusOK,
+			provider: "/vertex",
+			body:     `/vertex {"model":"claude-sonnet-4-5@20250929"}`,
+		},
+		{
+			name:     "alias",
+			path:     "/bedrock",
+			model:    "claude-sonnet-latest",
+			expected: http.StatusOK,
+			provider: "/vertex",
+			body:     `/vertex {"model":"claude-sonnet-4-5@20250929"}`,
+		},
+		{
+			name:     "no route knows the model",
+			path:     "/bedrock/gemini",
+			model:    "anthropic.claude-sonnet-4-5-20250929-v1:0",
+			expected: http.StatusTooManyRequests,
+			provider: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(fmt.Sprintf(`{"model":%q}`, tt.model)))
+			req.Header.Set("Content-Type", "application/json")
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expected {
+				t.Errorf("Expected status %d, got: %d", tt.expected, rec.Code)
+			}
+
+			if provider := rec.Header().Get(providerHeader); provider != tt.provider {
+				t.Errorf("Expected the provider to be %q, got: %q", tt.provider, provider)
+			}
+
+			if rec.Body.String() != tt.body {
+				t.Errorf("Expected %q, got: %q", tt.body, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestFallbackRoutes(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback string
+		expected string
+	}{
+		{name: "unknown route", fallback: "[/b]", expected: "fallback route /b has no POST out method"},
+		{name: "itself", fallback: "[/a]", expected: "fallback route /a is tried more than once"},
+		{name: "not proxied", fallback: "[/headless]", expected: "fallback route /headless isn't proxied to an upstream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := config.LoadFromBytes([]byte(fmt.Sprintf(`
+baseEndpoint: '"http://localhost"'
+uriGroups:
+  - name: Test
+    supportedUris:
+      - in: /a
+        out:
+          - method: POST
+            text: /
+      - in: /headless
+        out:
+          - method: POST
+overrides:
+  uris:
+    /a:
+      POST:
+        fallback:
+          routes: %s
+`, tt.fallback)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := newTestServer()
+			s.Logger = logging.Discard()
+
+			if _, err := s.buildRouter(cfg); err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected an error containing %q, got: %v", tt.expected, err)
+			}
+		})
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"time"
 
 	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/exchange"
+	"bitbucket.org/atlassian-developers/proximity/internal/tracing"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -20,6 +22,9 @@ type RequestResult struct {
 	Status int    `json:"status"`
 	Body   string `json:"body"`
 	Error  string `json:"error"`
+
+	// url is the rendered url of the request, it's empty when it couldn't be rendered
+	url string
 }
 
 // executeFetch executes fetch requests and populates the requests variable in templateInput
@@ -50,7 +55,36 @@ func (s *server) executeFetchRequests(ctx context.Context, requests map[string]c
 
 	for name, req := range requests {
 		g.Go(func() error {
-			result := s.executeFetchRequest(ctx, req, templateInput)
+			fetchCtx, span := s.Tracer.Start(ctx, "fetch "+name, tracing.KindInternal)
+
+			start := time.Now()
+			result := s.executeFetchRequest(fetchCtx, req, templateInput)
+
+			if result.Status != 0 {
+				span.SetAttribute("http.response.status_code", result.Status)
+			}
+
+			if result.Error != "" {
+				span.SetError(result.Error)
+			}
+
+			span.End()
+
+			outcome := "success"
+			if result.Error != "" {
+				outcome = "error"
+			}
+
+			s.metrics.fetchRequests.Inc(routePattern(ctx), name, outcome)
+
+			exchangeFromContext(ctx).addFetch(exchange.Fetch{
+				Name:       name,
+				Method:     req.Method,
+				Url:        s.redactor.String(result.url),
+				Status:     result.Status,
+				Error:      s.redactor.String(result.Error),
+				DurationMs: milliseconds(time.Since(start)),
+			})
 
 			mu.Lock()
 			results[name] = result
@@ -61,7 +95,7 @@ func (s *server) executeFetchRequests(ctx context.Context, requests map[string]c
 	}
 
 	if err := g.Wait(); err != nil {
-		s.Logger.Println(err.Error())
+		s.Logger.ErrorContext(ctx, "fetch failed", "error", err)
 	}
 
 	return results
@@ -115,18 +149,21 @@ func (s *server) executeFetchRequest(parentCtx context.Context, req config.Fetch
 
 	httpReq, err := http.NewRequestWithContext(ctx, req.Method, url, bodyReader)
 	if err != nil {
-		return &RequestResult{Error: fmt.Sprintf("failed to create request: %v", err)}
+		return &RequestResult{Error: fmt.Sprintf("failed to create request: %v", err), url: url}
 	}
 
 	if err := s.overrideHeaders(req.Headers, &httpReq.Header, templateInput, nil); err != nil {
-		return &RequestResult{Error: fmt.Sprintf("failed to render headers: %v", err)}
+		return &RequestResult{Error: fmt.Sprintf("failed to render headers: %v", err), url: url}
 	}
 
 	// Execute request
-	client := &http.Client{}
+	client := &http.Client{
+		Transport: s.withRetry(s.upstreamTransport(), req.Retry),
+	}
+
 	httpResp, err := client.Do(httpReq)
 	if err != nil {
-		return &RequestResult{Error: fmt.Sprintf("request failed: %v", err)}
+		return &RequestResult{Error: fmt.Sprintf("request failed: %v", err), url: url}
 	}
 	defer httpResp.Body.Close()
 
@@ -136,6 +173,7 @@ func (s *server) executeFetchRequest(parentCtx context.Context, req config.Fetch
 		return &RequestResult{
 			Status: httpResp.StatusCode,
 			Error:  fmt.Sprintf("failed to read response: %v", err),
+			url:    url,
 		}
 	}
 
@@ -150,6 +188,7 @@ func (s *server) executeFetchRequest(parentCtx context.Context, req config.Fetch
 		Status: httpResp.StatusCode,
 		Body:   string(respBody),
 		Error:  errMsg,
+		url:    url,
 	}
 }
 
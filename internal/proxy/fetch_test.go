@@ -8,12 +8,15 @@ import (
 	"time"
 
 	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/redact"
 	"bitbucket.org/atlassian-developers/proximity/internal/template"
 )
 
 func newTestServer() *server {
 	return &server{
 		renderer: template.NewRenderer(nil),
+		metrics:  newProxyMetrics(),
+		redactor: redact.New(redact.Options{}),
 	}
 }
 
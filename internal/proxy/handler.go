@@ -4,14 +4,17 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"strings"
 
 	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/template"
+	"bitbucket.org/atlassian-developers/proximity/internal/tracing"
+	"bitbucket.org/atlassian-developers/proximity/internal/translate"
 
 	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/go-chi/chi"
@@ -20,17 +23,43 @@ import (
 type modifyResponseFn func(*http.Response) error
 
 type endpointProxyConfig struct {
-	baseEndpoint *url.URL
+	upstreams []*upstream
+
+	// failoverStatusCodes are the upstream status codes which fail over to the next upstream
+	failoverStatusCodes []int
 
 	config.UriMap
 	Out config.OutMethod
 	config.RequestResponse
+
+	// variants are the uri overrides merged on top of global, the first whose when matches the request replaces
+	// RequestResponse for that request
+	variants []config.RequestResponse
+
+	// usage picks out who the tokens used by requests are accounted to
+	usage config.Usage
+
+	// limits are the limit rules which cover the endpoint
+	limits []limitRule
+
+	// fallbackFamilies are the model families of the routes the endpoint falls back to
+	fallbackFamilies map[string]string
 }
 
 func (s *server) modifyResponse(cfg *endpointProxyConfig) modifyResponseFn {
 	return func(res *http.Response) error {
 		contentType := res.Header.Get("Content-Type")
 
+		// AWS event streams are converted into SSE so that they're handled the same as any other stream
+		if strings.Contains(contentType, eventStreamContentType) {
+			res.Body = newEventStreamSseReader(res.Body)
+			res.ContentLength = -1
+			res.Header.Del("Content-Length")
+			res.Header.Set("Content-Type", "text/event-stream")
+
+			contentType = res.Header.Get("Content-Type")
+		}
+
 		// If we're not getting a stream back then just log out the response
 		// and stop there.
 		if !strings.Contains(contentType, "text/event-stream") {
@@ -39,6 +68,10 @@ func (s *server) modifyResponse(cfg *endpointProxyConfig) modifyResponseFn {
 				return err
 			}
 
+			rec := usageFromContext(responseContext(res))
+			rec.add(templateInput["body"])
+			rec.record()
+
 			return s.renderResponse(res, cfg, templateInput)
 		}
 
@@ -51,6 +84,11 @@ func (s *server) modifyResponse(cfg *endpointProxyConfig) modifyResponseFn {
 			return err
 		}
 
+		// Streams are translated an event at a time so transforms always parse them into events
+		if cfg.Response.Body.Sse == config.SseEvents || cfg.Response.Body.Transform != "" {
+			return s.processSseEvents(res, cfg)
+		}
+
 		return s.processSseLines(res, cfg)
 	}
 }
@@ -59,9 +97,16 @@ func (s *server) processSseLines(res *http.Response, cfg *endpointProxyConfig) e
 	pr, pw := io.Pipe()
 	orig := res.Body
 
+	ctx := responseContext(res)
+	route := responseRoute(res)
+	rec := usageFromContext(ctx)
+	s.metrics.activeStreams.Inc(route)
+
 	go func() {
 		defer orig.Close()
 		defer pw.Close()
+		defer s.metrics.activeStreams.Dec(route)
+		defer rec.record()
 
 		reader := bufio.NewReader(orig)
 		renderStorage := make(map[string]string)
@@ -69,7 +114,10 @@ func (s *server) processSseLines(res *http.Response, cfg *endpointProxyConfig) e
 		for {
 			line, err := reader.ReadString('\n')
 			if err != nil {
-				s.Logger.Println(err)
+				if err != io.EOF {
+					s.Logger.ErrorContext(ctx, "failed to read stream", "error", err)
+				}
+
 				break
 			}
 
@@ -77,15 +125,18 @@ func (s *server) processSseLines(res *http.Response, cfg *endpointProxyConfig) e
 				continue
 			}
 
+			rec.addSseLine(line)
+
 			// Modify the line as needed here
 			modifiedLine, err := s.processSseLine(line, cfg.Response.Body, renderStorage)
 			if err != nil {
-				s.Logger.Println(err)
+				s.Logger.ErrorContext(ctx, "failed to render stream line", "error", err)
+				s.metrics.renderErrors.Inc(route, renderStageEvent)
 				break
 			}
 
 			if _, err := pw.Write([]byte(modifiedLine)); err != nil {
-				s.Logger.Println(err)
+				s.Logger.WarnContext(ctx, "failed to write stream", "error", err)
 				break
 			}
 		}
@@ -121,6 +172,145 @@ func (s *server) processSseLine(line string, bodyOverride config.Body, renderSto
 	return string(renderedEventBytes), nil
 }
 
+func (s *server) processSseEvents(res *http.Response, cfg *endpointProxyConfig) error {
+	pr, pw := io.Pipe()
+	orig := res.Body
+
+	ctx := responseContext(res)
+	route := responseRoute(res)
+	rec := usageFromContext(ctx)
+	s.metrics.activeStreams.Inc(route)
+
+	go func() {
+		defer orig.Close()
+		defer pw.Close()
+		defer s.metrics.activeStreams.Dec(route)
+		defer rec.record()
+
+		decoder := newSseDecoder(orig)
+		transformer := newSseTransformer(cfg.Response.Body)
+		renderStorage := make(map[string]string)
+
+		// writeEvents renders events and sends them to the client, it's false once the stream can't carry on
+		writeEvents := func(events []*sseEvent) bool {
+			for _, event := range events {
+				modifiedEvents, err := s.processSseEvent(event, cfg.Response.Body, renderStorage)
+				if err != nil {
+					s.Logger.ErrorContext(ctx, "failed to render stream event", "error", err)
+					s.metrics.renderErrors.Inc(route, renderStageEvent)
+					return false
+				}
+
+				if _, err := pw.Write(modifiedEvents); err != nil {
+					s.Logger.WarnContext(ctx, "failed to write stream", "error", err)
+					return false
+				}
+			}
+
+			return true
+		}
+
+		for {
+			event, err := decoder.Next()
+			if err != nil {
+				if err != io.EOF {
+					s.Logger.ErrorContext(ctx, "failed to read stream", "error", err)
+					break
+				}
+
+				events, err := transformer.end()
+				if err != nil {
+					s.Logger.ErrorContext(ctx, "failed to translate end of stream", "error", err)
+					s.metrics.renderErrors.Inc(route, renderStageEvent)
+					break
+				}
+
+				writeEvents(events)
+				break
+			}
+
+			if event.HasData {
+				rec.add([]byte(event.Data))
+			}
+
+			events, err := transformer.event(event)
+			if err != nil {
+				s.Logger.ErrorContext(ctx, "failed to translate stream event", "error", err)
+				s.metrics.renderErrors.Inc(route, renderStageEvent)
+				break
+			}
+
+			if !writeEvents(events) {
+				break
+			}
+		}
+	}()
+
+	res.Body = pr
+	return nil
+}
+
+// processSseEvent renders a single parsed SSE event into zero or more events to send to the client. Text output from
+// the render is sent as is which allows for events which can't be represented as an event object.
+func (s *server) processSseEvent(event *sseEvent, bodyOverride config.Body, renderStorage map[string]string) ([]byte, error) {
+	// No overrides defined or nothing to render, return as is
+	if (bodyOverride.Template == "" && bodyOverride.Expr == "") || event.isCommentOnly() {
+		return encodeSseEvent(event), nil
+	}
+
+	templateInput := map[string]any{
+		"body":  nil,
+		"event": event.templateInput(),
+	}
+
+	output, err := s.evalEventOutput(bodyOverride, templateInput, renderStorage)
+	if err != nil {
+		return nil, err
+	}
+
+	if text, ok := output.(string); ok {
+		return []byte(text), nil
+	}
+
+	modifiedEvents, err := sseEventsFromOutput(output)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	for _, modifiedEvent := range modifiedEvents {
+		buf.Write(encodeSseEvent(modifiedEvent))
+	}
+
+	return buf.Bytes(), nil
+}
+
+// evalEventOutput evaluates the body override for an event. Expr values are used as is whereas template output is
+// parsed as json, falling back to the text if it isn't json.
+func (s *server) evalEventOutput(bodyOverride config.Body, templateInput map[string]any, renderStorage map[string]string) (any, error) {
+	if strings.TrimSpace(bodyOverride.Expr) != "" {
+		return s.renderer.EvalExpr(bodyOverride.Expr, templateInput, renderStorage)
+	}
+
+	renderedBytes, err := s.renderer.RenderTemplate(bodyOverride.Template, templateInput, renderStorage)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bytes.TrimSpace(renderedBytes)) == 0 {
+		return nil, nil
+	}
+
+	var output any
+
+	if err := json.Unmarshal(renderedBytes, &output); err != nil {
+		return string(renderedBytes), nil
+	}
+
+	return output, nil
+}
+
 // An endpoint proxy handles proxying a single URI
 // The config will have been generated for it be combining the global and uri override configs together
 // There is a generic function for an endpoint proxy which takes in config to work
@@ -131,41 +321,161 @@ func (s *server) handleEndpoint(cfg *endpointProxyConfig) http.HandlerFunc {
 		// Build the template variable map to use the render everything
 		templateInput, err := s.buildTemplateInputFromRequest(r)
 		if err != nil {
-			s.Logger.Println(err)
+			s.Logger.ErrorContext(r.Context(), "failed to read request", "error", err)
 			return
 		}
 
-		// If there's a fetch config, execute it to populate the template input
-		// Do this before the forward so that it can be used with it, the forward
-		// can then decide which endpoint based on the results of the fetch
-		if cfg.RequestResponse.Fetch != nil {
-			s.executeFetch(r.Context(), cfg.RequestResponse.Fetch, templateInput)
+		original, err := copyOriginalRequest(r, cfg)
+		if err != nil {
+			s.Logger.ErrorContext(r.Context(), "failed to read request", "error", err)
+			return
 		}
 
-		// Check if this is a forward route
-		if cfg.RequestResponse.Forward != nil {
-			s.handleForward(w, r, cfg.RequestResponse.Forward, templateInput)
+		if !s.resolveModel(w, r, cfg, templateInput) {
 			return
 		}
 
-		if cfg.Out.IsEmpty() {
-			s.serveHeadlessResponse(w, r, cfg, templateInput)
+		cfg, err := s.selectVariant(cfg, templateInput)
+		if err != nil {
+			s.Logger.ErrorContext(r.Context(), "failed to select override", "error", err)
+			exchangeFromContext(r.Context()).setError(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		if s.TestMode {
-			s.serveRenderedRequest(w, r)
-			return
+		r, chain := s.withFallback(w, r, cfg, original, templateInput)
+		if chain != nil {
+			defer func() {
+				if chain.release != nil {
+					chain.release()
+				}
+			}()
 		}
 
-		if err := s.renderRequest(r, cfg, templateInput); err != nil {
-			s.Logger.Println(err)
-			return
+		// Cached responses are served before the fetches so that those aren't made again either
+		if cfg.RequestResponse.Cache != nil && cfg.RequestResponse.Forward == nil && !s.TestMode {
+			s.serveCached(w, r, cfg, templateInput, s.serveEndpoint)
+		} else {
+			s.serveEndpoint(w, r, cfg, templateInput)
+		}
+
+		// The upstream failed before anything was sent to the client, so the next route can answer instead
+		if chain != nil && chain.fellBack {
+			s.serveFallback(w, r, chain)
+		}
+	}
+}
+
+// serveEndpoint makes the fetches for a request and then forwards it, answers it itself or proxies it to the upstream.
+func (s *server) serveEndpoint(w http.ResponseWriter, r *http.Request, cfg *endpointProxyConfig, templateInput map[string]any) {
+	// If there's a fetch config, execute it to populate the template input
+	// Do this before the forward so that it can be used with it, the forward
+	// can then decide which endpoint based on the results of the fetch
+	if cfg.RequestResponse.Fetch != nil {
+		s.executeFetch(r.Context(), cfg.RequestResponse.Fetch, templateInput)
+	}
+
+	// Check if this is a forward route
+	if cfg.RequestResponse.Forward != nil {
+		s.handleForward(w, r, cfg.RequestResponse.Forward, templateInput)
+		return
+	}
+
+	if cfg.Out.IsEmpty() {
+		s.serveHeadlessResponse(w, r, cfg, templateInput)
+		return
+	}
+
+	if s.TestMode {
+		s.serveRenderedRequest(w, r)
+		return
+	}
+
+	r, release, ok := s.countRequest(w, r, cfg, templateInput)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if err := s.renderRequest(r, cfg, templateInput); err != nil {
+		s.Logger.ErrorContext(r.Context(), "failed to render request", "error", err)
+		exchangeFromContext(r.Context()).setError(err)
+		s.metrics.renderErrors.Inc(routePattern(r.Context()), renderStageRequest)
+
+		// Requests a transform can't translate are the client's fault so they're told why
+		var requestErr *translate.RequestError
+
+		if errors.As(err, &requestErr) {
+			writeError(w, cfg.ErrorFormat, http.StatusBadRequest, requestErr.Message)
 		}
 
-		proxyHandler := s.endpointProxy(cfg)
-		proxyHandler.ServeHTTP(w, r)
+		return
 	}
+
+	ctx, span := s.Tracer.Start(r.Context(), "proxy upstream", tracing.KindInternal)
+	defer span.End()
+
+	proxyHandler := s.endpointProxy(cfg)
+	proxyHandler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// selectVariant returns the config with the first override variant whose when matches the request. The config is
+// returned as is, with only the global overrides, if no variant matches.
+func (s *server) selectVariant(cfg *endpointProxyConfig, templateInput map[string]any) (*endpointProxyConfig, error) {
+	for _, variant := range cfg.variants {
+		matched, err := s.matches(variant.When, templateInput, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if !matched {
+			continue
+		}
+
+		selected := *cfg
+		selected.RequestResponse = variant
+
+		return &selected, nil
+	}
+
+	return cfg, nil
+}
+
+// matches evaluates a when expr, an empty one always matches.
+func (s *server) matches(when string, templateInput map[string]any, renderStorage map[string]string) (bool, error) {
+	if strings.TrimSpace(when) == "" {
+		return true, nil
+	}
+
+	output, err := s.renderer.EvalExpr(when, templateInput, renderStorage)
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate when expr: %w", err)
+	}
+
+	matched, ok := output.(bool)
+	if !ok {
+		return false, fmt.Errorf("when expr must return a bool, got %T", output)
+	}
+
+	return matched, nil
+}
+
+// matchingPatches filters out the patches whose when doesn't match.
+func (s *server) matchingPatches(patches []config.Patch, templateInput map[string]any) ([]config.Patch, error) {
+	matching := []config.Patch{}
+
+	for _, patch := range patches {
+		matched, err := s.matches(patch.When, templateInput, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if matched {
+			matching = append(matching, patch)
+		}
+	}
+
+	return matching, nil
 }
 
 func (s *server) handleForward(w http.ResponseWriter, r *http.Request, fwd *config.Forward, templateInput map[string]any) {
@@ -176,7 +486,7 @@ func (s *server) handleForward(w http.ResponseWriter, r *http.Request, fwd *conf
 	// Evaluate path expression
 	pathBytes, err := s.renderer.Render(fwd.Path.Template, fwd.Path.Expr, templateInput, tmpRenderStorage)
 	if err != nil {
-		s.Logger.Println(err)
+		s.Logger.ErrorContext(r.Context(), "failed to render forward path", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -189,34 +499,71 @@ func (s *server) handleForward(w http.ResponseWriter, r *http.Request, fwd *conf
 	newReq.RequestURI = newPath
 
 	if err := s.overrideHeaders(fwd.Headers, &newReq.Header, templateInput, tmpRenderStorage); err != nil {
-		s.Logger.Println(err)
+		s.Logger.ErrorContext(r.Context(), "failed to render forward headers", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	s.Logger.Printf("forwarding request to %s", newPath)
+	s.Logger.InfoContext(r.Context(), "forwarding request", "path", newPath)
 
 	// Re-route through router
-	s.router.ServeHTTP(w, newReq)
+	s.router.Load().ServeHTTP(w, newReq)
 }
 
 func (s *server) endpointProxy(cfg *endpointProxyConfig) *httputil.ReverseProxy {
-	proxy := httputil.NewSingleHostReverseProxy(cfg.baseEndpoint)
-	proxy.ModifyResponse = s.modifyResponse(cfg)
+	modifyResponse := s.modifyResponse(cfg)
+
+	return &httputil.ReverseProxy{
+		// The url is rewritten by the transport for each upstream it tries
+		Director: func(req *http.Request) {
+			if _, ok := req.Header["User-Agent"]; !ok {
+				// Explicitly disable the default User-Agent, the same as httputil.NewSingleHostReverseProxy
+				req.Header.Set("User-Agent", "")
+			}
+		},
+		Transport: &fallbackTransport{
+			transport: &failoverTransport{
+				transport:   s.withRetry(&exchangeTransport{transport: s.upstreamTransport(), redactor: s.redactor}, cfg.Retry),
+				upstreams:   orderUpstreams(cfg.upstreams),
+				statusCodes: cfg.failoverStatusCodes,
+				logger:      s.Logger,
+			},
+			renderer: s.renderer,
+			logger:   s.Logger,
+			vars:     s.Vars,
+		},
+		ModifyResponse: func(res *http.Response) error {
+			err := modifyResponse(res)
+			if err != nil {
+				s.metrics.renderErrors.Inc(responseRoute(res), renderStageResponse)
+			}
 
-	return proxy
+			return err
+		},
+		// The same as the default error handler but logged with the request
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			// The request is sent to the next route once the proxy returns
+			if errors.Is(err, errFallback) {
+				return
+			}
+
+			s.Logger.ErrorContext(r.Context(), "proxy error", "error", err)
+			exchangeFromContext(r.Context()).setError(err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
 }
 
 func (s *server) serveRenderedRequest(w http.ResponseWriter, r *http.Request) {
 	reqCopy, err := copyRequest(r)
 	if err != nil {
-		s.Logger.Println(err)
+		s.Logger.ErrorContext(r.Context(), "failed to copy request", "error", err)
 		return
 	}
 
 	bodyMap, err := extractJsonBody(&r.Header, &r.Body)
 	if err != nil {
-		s.Logger.Println(err)
+		s.Logger.ErrorContext(r.Context(), "failed to read request body", "error", err)
 		return
 	}
 
@@ -226,7 +573,7 @@ func (s *server) serveRenderedRequest(w http.ResponseWriter, r *http.Request) {
 
 	pretty, err := json.MarshalIndent(reqCopy, "", "  ")
 	if err != nil {
-		s.Logger.Println(err)
+		s.Logger.ErrorContext(r.Context(), "failed to encode request", "error", err)
 		return
 	}
 
@@ -325,6 +672,11 @@ func (s *server) getValueAtPath(data any, path string) (string, error) {
 }
 
 func (s *server) overrideRequestBody(req *http.Request, templateInput map[string]any, bodyOverride config.Body) error {
+	templateInput, err := s.transformRequestBody(req, templateInput, bodyOverride)
+	if err != nil {
+		return err
+	}
+
 	// Use unified render to support both Template and Expr
 	renderedBodyBytes, err := s.renderer.Render(bodyOverride.Template, bodyOverride.Expr, templateInput, nil)
 	if err != nil {
@@ -336,7 +688,12 @@ func (s *server) overrideRequestBody(req *http.Request, templateInput map[string
 		return nil
 	}
 
-	if len(bodyOverride.Patches) == 0 {
+	patches, err := s.matchingPatches(bodyOverride.Patches, templateInput)
+	if err != nil {
+		return err
+	}
+
+	if len(patches) == 0 {
 		return nil
 	}
 
@@ -345,7 +702,7 @@ func (s *server) overrideRequestBody(req *http.Request, templateInput map[string
 		return err
 	}
 
-	newBody, err := s.applyPatchToJson(bodyOverride.Patches, bodyBytes)
+	newBody, err := s.applyPatchToJson(patches, bodyBytes)
 	if err != nil {
 		return err
 	}
@@ -361,6 +718,11 @@ func (s *server) overrideResponseBody(res *http.Response, templateInput map[stri
 		return nil
 	}
 
+	templateInput, err := s.transformResponseBody(res, templateInput, bodyOverride)
+	if err != nil {
+		return err
+	}
+
 	// Use unified render to support both Template and Expr
 	renderedBodyBytes, err := s.renderer.Render(bodyOverride.Template, bodyOverride.Expr, templateInput, nil)
 	if err != nil {
@@ -372,7 +734,12 @@ func (s *server) overrideResponseBody(res *http.Response, templateInput map[stri
 		return nil
 	}
 
-	if len(bodyOverride.Patches) == 0 {
+	patches, err := s.matchingPatches(bodyOverride.Patches, templateInput)
+	if err != nil {
+		return err
+	}
+
+	if len(patches) == 0 {
 		return nil
 	}
 
@@ -381,7 +748,7 @@ func (s *server) overrideResponseBody(res *http.Response, templateInput map[stri
 		return err
 	}
 
-	newBody, err := s.applyPatchToJson(bodyOverride.Patches, bodyBytes)
+	newBody, err := s.applyPatchToJson(patches, bodyBytes)
 	if err != nil {
 		return err
 	}
@@ -407,6 +774,8 @@ func (s *server) buildTemplateInputFromRequest(req *http.Request) (map[string]an
 		"version":    s.Version,
 	}
 
+	template.SetContext(templateInput, req.Context())
+
 	body, err := extractBody(&req.Header, &req.Body)
 	if err != nil {
 		return nil, err
@@ -422,6 +791,8 @@ func (s *server) buildTemplateInputFromResponse(res *http.Response, includeBody
 		"globalVars": s.Vars,
 	}
 
+	template.SetContext(templateInput, responseContext(res))
+
 	if !includeBody {
 		return templateInput, nil
 	}
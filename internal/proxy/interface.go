@@ -2,25 +2,71 @@ package proxy
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"net/http"
 
+	"bitbucket.org/atlassian-developers/proximity/internal/catalog"
 	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/exchange"
+	"bitbucket.org/atlassian-developers/proximity/internal/limits"
+	"bitbucket.org/atlassian-developers/proximity/internal/template"
+	"bitbucket.org/atlassian-developers/proximity/internal/tracing"
+	"bitbucket.org/atlassian-developers/proximity/internal/usage"
 )
 
 type Options struct {
-	Port     int
+	Port int
+
+	// AdminPort serves the proxy's metrics on /metrics, and the usage recorded on /usage, when set
+	AdminPort int
+
 	TestMode bool
 	Version  string
 
-	Logger *log.Logger
+	Logger *slog.Logger
 
 	*config.Config
 
 	// Generic global variables provided to the config for rendering
 	Vars map[string]any
+
+	// SecretVars names the vars whose values are masked wherever they'd be logged or recorded
+	SecretVars []string
+
+	// RecordDir is where upstream exchanges are written to when recording
+	RecordDir string
+
+	// ReplayDir holds recorded exchanges which are served instead of contacting upstreams when replaying
+	ReplayDir string
+
+	// Transport is used to reach upstreams instead of the default transport when set
+	Transport http.RoundTripper
+
+	// TokenSource provides slauth tokens instead of requesting them from atlas when set
+	TokenSource template.TokenSource
+
+	// Exchanges stores every request handled along with its upstream request and response, nothing is stored when
+	// it's nil
+	Exchanges *exchange.Store
+
+	// Usage aggregates the tokens used by requests, nothing is recorded when it's nil
+	Usage *usage.Store
+
+	// Limiter keeps the state of the limits in the config, it's kept in memory when nil
+	Limiter *limits.Limiter
+
+	// Tracer traces requests through the proxy, nothing is traced when it's nil
+	Tracer *tracing.Tracer
+
+	// Catalog resolves the models of uris with a model family, the built-in catalog is used when nil
+	Catalog *catalog.Catalog
 }
 
 type Interface interface {
 	RunServer(ctx context.Context)
+	Reload(cfg *config.Config) error
 	Shutdown(ctx context.Context) error
+
+	// Handler serves requests with the routes of the current config
+	Handler() http.Handler
 }
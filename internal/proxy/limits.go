@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/limits"
+)
+
+// limitRule is a limit rule which applies to an endpoint along with the name its state is kept under.
+type limitRule struct {
+	name string
+	config.LimitRule
+}
+
+// endpointLimits returns the limit rules which cover a route.
+func endpointLimits(rules []config.LimitRule, route string) []limitRule {
+	var endpointRules []limitRule
+
+	for i, rule := range rules {
+		if len(rule.Routes) > 0 && !slices.Contains(rule.Routes, route) {
+			continue
+		}
+
+		name := rule.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+
+		endpointRules = append(endpointRules, limitRule{name: name, LimitRule: rule})
+	}
+
+	return endpointRules
+}
+
+// countRequest adds the usage recorder to a request and counts it against its limits, see acquireLimits. A request is
+// only counted on the route it came in on, the routes it falls back to use that route's recorder, and the limits it
+// holds aren't released until it's done falling back.
+func (s *server) countRequest(w http.ResponseWriter, r *http.Request, cfg *endpointProxyConfig, templateInput map[string]any) (*http.Request, func(), bool) {
+	chain := fallbackFromContext(r.Context())
+
+	if chain != nil && chain.counted {
+		if chain.usage != nil {
+			r = r.WithContext(context.WithValue(r.Context(), usageKey{}, chain.usage))
+		}
+
+		return r, func() {}, true
+	}
+
+	r = s.withUsage(r, cfg, templateInput)
+
+	release, ok := s.acquireLimits(w, r, cfg)
+	if !ok || chain == nil {
+		return r, release, ok
+	}
+
+	chain.usage = usageFromContext(r.Context())
+	chain.release = release
+
+	return r, func() {}, true
+}
+
+// acquireLimits counts the request against the limits of its profile. When a limit has been reached the request is
+// answered with a 429 and ok is false. release must be called once the response has been sent.
+func (s *server) acquireLimits(w http.ResponseWriter, r *http.Request, cfg *endpointProxyConfig) (release func(), ok bool) {
+	rec := usageFromContext(r.Context())
+	if rec == nil || len(cfg.limits) == 0 {
+		return func() {}, true
+	}
+
+	var buckets []limits.Bucket
+	var keys []string
+
+	for _, rule := range cfg.limits {
+		if len(rule.Profiles) > 0 && !slices.Contains(rule.Profiles, rec.key.Profile) {
+			continue
+		}
+
+		key := rule.name + "/" + rec.key.Profile
+
+		buckets = append(buckets, limits.Bucket{
+			Key: key,
+			Limit: limits.Limit{
+				RequestsPerMinute: rule.RequestsPerMinute,
+				ConcurrentStreams: rule.ConcurrentStreams,
+				TokensPerDay:      rule.TokensPerDay,
+			},
+		})
+		keys = append(keys, key)
+	}
+
+	release, err := s.Limiter.Acquire(buckets)
+
+	var exceeded *limits.ExceededError
+
+	if errors.As(err, &exceeded) {
+		s.Logger.WarnContext(r.Context(), "limit reached", "profile", rec.key.Profile, "limit", exceeded.Limit, "retry_after", exceeded.RetryAfter)
+		exchangeFromContext(r.Context()).setError(err)
+
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(exceeded.RetryAfter.Seconds()))))
+		writeError(w, cfg.ErrorFormat, http.StatusTooManyRequests,
+			fmt.Sprintf("Proximity %s limit reached for profile %q, retry after %s.", exceeded.Limit, rec.key.Profile, exceeded.RetryAfter.Round(time.Second)))
+
+		return nil, false
+	}
+
+	// Requests aren't held up by limits which can't be counted
+	if err != nil {
+		s.Logger.ErrorContext(r.Context(), "failed to count request against limits", "error", err)
+		return func() {}, true
+	}
+
+	rec.limitKeys = keys
+	return release, true
+}
@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/limits"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+)
+
+func TestLimits(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"usage":{"input_tokens":60,"output_tokens":40}}`))
+	}))
+	defer upstream.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(fmt.Sprintf(`
+usage:
+  profile:
+    expr: get(headers, "X-Profile")?.[0] ?? "default"
+limits:
+  - profiles: [agent]
+    requestsPerMinute: 1
+  - name: claude
+    routes: [/claude]
+    tokensPerDay: 100
+uriGroups:
+  - name: OpenAI
+    errorFormat: openai
+    supportedUris:
+      - in: /openai
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            text: /
+      - in: /gemini
+        errorFormat: gemini
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            text: /
+  - name: Claude
+    errorFormat: anthropic
+    supportedUris:
+      - in: /claude
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            text: /
+`, upstream.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer()
+	s.Logger = logging.Discard()
+	s.Limiter = limits.New("")
+
+	router, err := s.buildRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		profile  string
+		status   int
+		expected string
+	}{
+		{name: "first agent request", path: "/openai", profile: "agent", status: http.StatusOK},
+		{
+			name:     "agent over its requests per minute",
+			path:     "/openai",
+			profile:  "agent",
+			status:   http.StatusTooManyRequests,
+			expected: `{"error":{"code":"rate_limit_exceeded","message":"Proximity requestsPerMinute limit reached for profile \"agent\"`,
+		},
+		{
+			name:     "agent over its requests per minute on another route",
+			path:     "/gemini",
+			profile:  "agent",
+			status:   http.StatusTooManyRequests,
+			expected: `"status":"RESOURCE_EXHAUSTED"`,
+		},
+		{name: "other profiles aren't limited", path: "/openai", status: http.StatusOK},
+		{name: "first claude request", path: "/claude", status: http.StatusOK},
+		{
+			name:     "claude over its tokens per day",
+			path:     "/claude",
+			status:   http.StatusTooManyRequests,
+			expected: `{"error":{"message":"Proximity tokensPerDay limit reached for profile \"default\"`,
+		},
+		{name: "claude tokens are counted per profile", path: "/claude", profile: "other", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{}`))
+			req.Header.Set("Content-Type", "application/json")
+
+			if tt.profile != "" {
+				req.Header.Set("X-Profile", tt.profile)
+			}
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got: %d %s", tt.status, rec.Code, rec.Body.String())
+			}
+
+			if tt.status != http.StatusTooManyRequests {
+				return
+			}
+
+			if rec.Header().Get("Retry-After") == "" {
+				t.Error("Expected a Retry-After header")
+			}
+
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Errorf("Expected the body to contain %s, got: %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestFallbackLimits(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer primary.Close()
+
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"usage":{"input_tokens":60,"output_tokens":40}}`))
+	}))
+	defer secondary.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(fmt.Sprintf(`
+limits:
+  - requestsPerMinute: 2
+    concurrentStreams: 1
+uriGroups:
+  - name: Claude
+    errorFormat: anthropic
+    supportedUris:
+      - in: /primary
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            text: /
+      - in: /secondary
+        baseEndpoint: %[2]s
+        out:
+          - method: POST
+            text: /
+overrides:
+  uris:
+    /primary:
+      POST:
+        fallback:
+          routes: [/secondary]
+`, primary.URL, secondary.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer()
+	s.Logger = logging.Discard()
+	s.Limiter = limits.New("")
+
+	router, err := s.buildRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.router.Store(router)
+
+	// Each request is counted once however many routes it tries, and is done with its stream once it's answered
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodPost, "/primary", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != expected {
+			t.Fatalf("Expected request %d to get status %d, got: %d %s", i+1, expected, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		format   config.ErrorFormat
+		expected string
+	}{
+		{config.OpenAIErrorFormat, `{"error":{"code":"rate_limit_exceeded","message":"slow down","param":null,"type":"rate_limit_exceeded"}}`},
+		{config.AnthropicErrorFormat, `{"error":{"message":"slow down","type":"rate_limit_error"},"type":"error"}`},
+		{config.GeminiErrorFormat, `{"error":{"code":429,"message":"slow down","status":"RESOURCE_EXHAUSTED"}}`},
+		{"", "slow down"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		writeError(rec, tt.format, http.StatusTooManyRequests, "slow down")
+
+		if rec.Code != http.StatusTooManyRequests {
+			t.Errorf("Expected status 429, got: %d", rec.Code)
+		}
+
+		if body := strings.TrimSpace(rec.Body.String()); body != tt.expected {
+			t.Errorf("Expected %s for %q, got: %s", tt.expected, tt.format, body)
+		}
+	}
+}
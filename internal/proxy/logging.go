@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"net/http"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+	"bitbucket.org/atlassian-developers/proximity/internal/redact"
+)
+
+// requestID gives every request an id which is attached to its log lines. The id the client sent is used if there is
+// one, and it's echoed back on the response either way. A forwarded request keeps the id of the original one.
+func (s *server) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := logging.RequestIDFromContext(r.Context())
+
+		if id == "" {
+			id = logging.RequestID(r.Header.Get(logging.RequestIDHeader))
+			r = r.WithContext(logging.ContextWithRequestID(r.Context(), id))
+		}
+
+		w.Header().Set(logging.RequestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logRequest logs out every request coming in.
+func (s *server) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Logger.InfoContext(r.Context(), "request received",
+			"method", r.Method, "path", r.URL.Path, "user_agent", r.Header.Get("User-Agent"),
+		)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// redactOptions combines what the config masks with the values of the secret vars.
+func redactOptions(cfg *config.Config, vars map[string]any, secretVars []string) redact.Options {
+	options := redact.Options{}
+
+	if cfg != nil {
+		options.Headers = cfg.Redact.Headers
+		options.JsonPaths = cfg.Redact.JsonPaths
+	}
+
+	for _, name := range secretVars {
+		options.Values = append(options.Values, stringValues(vars[name])...)
+	}
+
+	return options
+}
+
+// stringValues returns every string within a var, walking into maps and lists.
+func stringValues(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case map[string]any:
+		values := []string{}
+
+		for _, item := range v {
+			values = append(values, stringValues(item)...)
+		}
+
+		return values
+	case []any:
+		values := []string{}
+
+		for _, item := range v {
+			values = append(values, stringValues(item)...)
+		}
+
+		return values
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/metrics"
+
+	"github.com/go-chi/chi"
+)
+
+// Stages at which rendering can fail, used to label render errors.
+const (
+	renderStageRequest  = "request"
+	renderStageResponse = "response"
+	renderStageEvent    = "event"
+)
+
+type proxyMetrics struct {
+	registry *metrics.Registry
+
+	requests         *metrics.Counter
+	requestDuration  *metrics.Histogram
+	upstreamRequests *metrics.Counter
+	upstreamDuration *metrics.Histogram
+	fetchRequests    *metrics.Counter
+	renderErrors     *metrics.Counter
+	cacheRequests    *metrics.Counter
+	activeStreams    *metrics.Gauge
+}
+
+func newProxyMetrics() *proxyMetrics {
+	registry := metrics.NewRegistry()
+
+	return &proxyMetrics{
+		registry: registry,
+
+		requests: registry.NewCounter("proximity_requests_total",
+			"Requests handled by the proxy.", "route", "method", "status"),
+		requestDuration: registry.NewHistogram("proximity_request_duration_seconds",
+			"Time taken to handle requests, including the whole of streamed responses.", metrics.DefaultBuckets, "route", "method"),
+		upstreamRequests: registry.NewCounter("proximity_upstream_requests_total",
+			"Requests sent to upstreams, including fetch requests and retries. Status is error when no response was received.", "route", "upstream", "method", "status"),
+		upstreamDuration: registry.NewHistogram("proximity_upstream_request_duration_seconds",
+			"Time taken for upstreams to respond with headers.", metrics.DefaultBuckets, "route", "upstream", "method"),
+		fetchRequests: registry.NewCounter("proximity_fetch_requests_total",
+			"Fetch requests made for routes by their outcome.", "route", "name", "outcome"),
+		renderErrors: registry.NewCounter("proximity_render_errors_total",
+			"Failures to render a request, response or stream event.", "route", "stage"),
+		cacheRequests: registry.NewCounter("proximity_cache_requests_total",
+			"Requests to routes with a cache by whether they were a hit, miss or bypassed it.", "route", "result"),
+		activeStreams: registry.NewGauge("proximity_active_streams",
+			"Streamed responses currently being sent to clients.", "route"),
+	}
+}
+
+// routePattern returns the route a request matched from its context so that metrics aren't labelled with raw paths.
+func routePattern(ctx context.Context) string {
+	if rctx := chi.RouteContext(ctx); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+
+	return "unmatched"
+}
+
+// responseRoute returns the route of the request a response is for.
+func responseRoute(res *http.Response) string {
+	return routePattern(responseContext(res))
+}
+
+// statusRecorder records the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// instrument records the outcome and duration of every request handled by the router.
+func (m *proxyMetrics) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		route := routePattern(r.Context())
+
+		m.requests.Inc(route, r.Method, strconv.Itoa(status))
+		m.requestDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
+
+// metricsTransport records every request sent to an upstream.
+type metricsTransport struct {
+	transport http.RoundTripper
+	metrics   *proxyMetrics
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	route := routePattern(req.Context())
+
+	res, err := t.transport.RoundTrip(req)
+
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(res.StatusCode)
+	}
+
+	t.metrics.upstreamRequests.Inc(route, req.URL.Host, req.Method, status)
+	t.metrics.upstreamDuration.Observe(time.Since(start).Seconds(), route, req.URL.Host, req.Method)
+
+	return res, err
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+)
+
+func TestMetrics(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"n\":1}\n\n"))
+	}))
+	defer upstream.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(fmt.Sprintf(`
+uriGroups:
+  - name: Test
+    supportedUris:
+      - in: /models/{model}/chat
+        baseEndpoint: %s
+        out:
+          - method: POST
+            text: /upstream
+overrides:
+  uris:
+    /models/{model}/chat:
+      POST:
+        response:
+          body:
+            sse: events
+            expr: 'event.json.missing.field'
+`, upstream.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer()
+	s.Logger = logging.Discard()
+
+	router, err := s.buildRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/models/a/chat", "/models/b/chat"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{}`)))
+	}
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/unknown", nil))
+
+	var buf bytes.Buffer
+
+	if err := s.metrics.registry.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	host := strings.TrimPrefix(upstream.URL, "http://")
+
+	for _, expected := range []string{
+		`proximity_requests_total{route="/models/{model}/chat",method="POST",status="200"} 2`,
+		`proximity_requests_total{route="unmatched",method="GET",status="404"} 1`,
+		`proximity_request_duration_seconds_count{route="/models/{model}/chat",method="POST"} 2`,
+		fmt.Sprintf(`proximity_upstream_requests_total{route="/models/{model}/chat",upstream="%s",method="POST",status="200"} 2`, host),
+		`proximity_render_errors_total{route="/models/{model}/chat",stage="event"} 2`,
+		`proximity_active_streams{route="/models/{model}/chat"} 0`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected metrics to contain %s, got:\n%s", expected, buf.String())
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// resolveModel replaces an alias in the request's model with the id it stands for, in the path params or the body
+// wherever the model came from, and adds the family's models to the template input as modelCatalog. A model the
+// family doesn't have is answered with a 400 listing the aliases and ok is false. Requests without a model are left
+// alone. A request falling back from another family's route resolves the model the chain picked for the route.
+func (s *server) resolveModel(w http.ResponseWriter, r *http.Request, cfg *endpointProxyConfig, templateInput map[string]any) (ok bool) {
+	if cfg.ModelFamily == "" {
+		return true
+	}
+
+	models := s.Catalog.Models(cfg.ModelFamily)
+	modelCatalog := make([]any, 0, len(models))
+
+	for _, model := range models {
+		modelCatalog = append(modelCatalog, map[string]any{"alias": model.Alias, "id": model.ID})
+	}
+
+	templateInput["modelCatalog"] = modelCatalog
+
+	pathParams, _ := templateInput["pathParams"].(map[string]string)
+	body, _ := templateInput["body"].(map[string]any)
+
+	requested, inPath := pathParams["model"]
+	if !inPath {
+		requested, _ = body["model"].(string)
+	}
+
+	model := requested
+	if chain := fallbackFromContext(r.Context()); chain != nil && chain.model != "" {
+		model = chain.model
+	}
+
+	if model == "" {
+		return true
+	}
+
+	id, ok := s.Catalog.Resolve(cfg.ModelFamily, model)
+	if !ok {
+		writeError(w, cfg.ErrorFormat, http.StatusBadRequest, fmt.Sprintf("unknown model %q, expected one of: %s",
+			model, strings.Join(s.Catalog.Aliases(cfg.ModelFamily), ", "),
+		))
+		return false
+	}
+
+	if id == requested {
+		return true
+	}
+
+	if inPath {
+		pathParams["model"] = id
+		return true
+	}
+
+	if err := s.replaceBodyModel(r, id); err != nil {
+		s.Logger.ErrorContext(r.Context(), "failed to replace model", "error", err)
+		writeError(w, cfg.ErrorFormat, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	body["model"] = id
+	return true
+}
+
+// replaceBodyModel sets the model in the request's json body, the rest of the body is passed on as it was sent.
+func (s *server) replaceBodyModel(r *http.Request, id string) error {
+	bodyBytes, err := copyBody(&r.Body)
+	if err != nil {
+		return err
+	}
+
+	var body map[string]json.RawMessage
+
+	if err := json.Unmarshal(bodyBytes, &body); err != nil {
+		return err
+	}
+
+	body["model"], err = json.Marshal(id)
+	if err != nil {
+		return err
+	}
+
+	bodyBytes, err = json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	s.applyNewBodyToRequest(r, bodyBytes)
+	return nil
+}
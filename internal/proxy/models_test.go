@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/catalog"
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+)
+
+func TestResolveModel(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", r.URL.Path, body)
+	}))
+	defer upstream.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(fmt.Sprintf(`
+uriGroups:
+  - name: Claude
+    errorFormat: anthropic
+    modelFamily: bedrock
+    supportedUris:
+      - in: /claude
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            expr: '"/model/" + body.model'
+      - in: /claude/models
+        baseEndpoint: %[1]s
+        out:
+          - method: GET
+  - name: Gemini
+    errorFormat: gemini
+    modelFamily: gemini
+    supportedUris:
+      - in: /gemini/{model}
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            expr: '"/models/" + pathParams.model'
+overrides:
+  uris:
+    /claude/models:
+      GET:
+        response:
+          body:
+            expr: |
+              toCompactJson(map(filter(modelCatalog, #.alias startsWith "claude-opus-4-1"), #.alias))
+`, upstream.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer()
+	s.Logger = logging.Discard()
+	s.Catalog = catalog.Builtin()
+
+	router, err := s.buildRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		status   int
+		expected string
+	}{
+		{
+			name:     "alias in the body",
+			method:   http.MethodPost,
+			path:     "/claude",
+			body:     `{"model":"claude-sonnet-latest","max_tokens":10}`,
+			status:   http.StatusOK,
+			expected: `/model/anthropic.claude-sonnet-4-5-20250929-v1:0 {"max_tokens":10,"model":"anthropic.claude-sonnet-4-5-20250929-v1:0"}`,
+		},
+		{
+			name:     "provider id in the body",
+			method:   http.MethodPost,
+			path:     "/claude",
+			body:     `{"model":"anthropic.claude-sonnet-4-20250514-v1:0"}`,
+			status:   http.StatusOK,
+			expected: `/model/anthropic.claude-sonnet-4-20250514-v1:0 {"model":"anthropic.claude-sonnet-4-20250514-v1:0"}`,
+		},
+		{
+			name:     "unknown model",
+			method:   http.MethodPost,
+			path:     "/claude",
+			body:     `{"model":"claude-2"}`,
+			status:   http.StatusBadRequest,
+			expected: `"message":"unknown model \"claude-2\", expected one of: claude-3-5-haiku, `,
+		},
+		{
+			name:     "alias in the path",
+			method:   http.MethodPost,
+			path:     "/gemini/gemini-flash-latest",
+			body:     `{}`,
+			status:   http.StatusOK,
+			expected: "/models/gemini-2.5-flash {}",
+		},
+		{
+			name:     "model list",
+			method:   http.MethodGet,
+			path:     "/claude/models",
+			status:   http.StatusOK,
+			expected: `["claude-opus-4-1","claude-opus-4-1-20250805"]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("Expected status %d, got: %d", tt.status, rec.Code)
+			}
+
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Errorf("Expected the response to contain %s, got: %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestUnknownModelFamily(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+uriGroups:
+  - name: Test
+    supportedUris:
+      - in: /chat
+        modelFamily: unknown
+        baseEndpoint: http://localhost
+        out:
+          - method: POST
+            text: /
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer()
+	s.Logger = logging.Discard()
+	s.Catalog = catalog.Builtin()
+
+	if _, err := s.buildRouter(cfg); err == nil || !strings.Contains(err.Error(), `unknown model family "unknown"`) {
+		t.Errorf("Expected the unknown model family to be refused, got: %v", err)
+	}
+}
@@ -3,11 +3,21 @@ package proxy
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
-	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 
+	"bitbucket.org/atlassian-developers/proximity/internal/cache"
+	"bitbucket.org/atlassian-developers/proximity/internal/catalog"
 	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/limits"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+	"bitbucket.org/atlassian-developers/proximity/internal/redact"
 	"bitbucket.org/atlassian-developers/proximity/internal/template"
+	"bitbucket.org/atlassian-developers/proximity/internal/translate"
 
 	"github.com/go-chi/chi"
 )
@@ -15,66 +25,216 @@ import (
 type server struct {
 	Options
 
-	router     *chi.Mux
+	// router is swapped out as a whole when the config is reloaded so that requests already being handled finish
+	// with the routes they started with.
+	router     atomic.Pointer[chi.Mux]
 	httpServer *http.Server
 
+	// adminServer serves the proxy's own endpoints such as metrics, it's nil when no admin port is set
+	adminServer *http.Server
+
+	// reloadMu serialises reloads so the config and the router always match.
+	reloadMu sync.Mutex
+
+	// buildErr is why the routes of the config the server was created with couldn't be built, it's cleared by a
+	// successful reload
+	buildErr error
+
 	renderer *template.Renderer
+	metrics  *proxyMetrics
+
+	// redactor masks secrets in everything logged or recorded, it's updated when the config is reloaded
+	redactor *redact.Redactor
+
+	// caches are the response cache stores by their dir and max size, they're kept across reloads
+	cachesMu sync.Mutex
+	caches   map[string]cache.Store
 }
 
 func New(options Options) Interface {
+	if options.Logger == nil {
+		options.Logger = logging.Discard()
+	}
+
+	if options.Limiter == nil {
+		options.Limiter = limits.New("")
+	}
+
+	if options.Catalog == nil {
+		options.Catalog = catalog.Builtin()
+	}
+
+	redactor := redact.New(redactOptions(options.Config, options.Vars, options.SecretVars))
+	options.Logger = slog.New(redactor.Handler(options.Logger.Handler()))
+
+	s := &server{
+		Options:  options,
+		renderer: template.NewRenderer(options.Logger),
+		metrics:  newProxyMetrics(),
+		redactor: redactor,
+	}
+
+	if options.TokenSource != nil {
+		s.renderer.SetTokenSource(options.TokenSource)
+	}
+
+	s.renderer.SetTracer(options.Tracer)
+
+	// The routes are built before the server is returned so that they're in place before anything can reload them
 	router := chi.NewRouter()
 
-	httpServer := &http.Server{
-		Addr:    fmt.Sprint(":", options.Port),
-		Handler: router,
+	if options.Config != nil {
+		if built, err := s.buildRouter(options.Config); err != nil {
+			s.buildErr = err
+		} else {
+			router = built
+		}
 	}
 
-	return &server{
-		Options:    options,
-		router:     router,
-		httpServer: httpServer,
-		renderer:   template.NewRenderer(options.Logger),
+	s.router.Store(router)
+
+	s.httpServer = &http.Server{
+		Addr: fmt.Sprint(":", options.Port),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.router.Load().ServeHTTP(w, r)
+		}),
 	}
+
+	if options.AdminPort > 0 {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/metrics", s.metrics.registry.Handler())
+
+		if options.Usage != nil {
+			adminMux.HandleFunc("/usage", s.serveUsage)
+		}
+
+		s.adminServer = &http.Server{
+			Addr:    fmt.Sprint(":", options.AdminPort),
+			Handler: adminMux,
+		}
+	}
+
+	return s
 }
 
 func (s *server) RunServer(ctx context.Context) {
-	s.Logger.Printf("starting http server on port %d", s.Options.Port)
-
-	// Log out all requests coming in
-	s.router.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			s.Logger.Println(r.Method, r.URL.Path, r.Header.Get("User-Agent"))
-			next.ServeHTTP(w, r)
-		})
-	})
+	s.Logger.Info("starting http server", "port", s.Options.Port)
 
-	combinedUriConfigs, err := s.combineCommonUriConfigs()
-	if err != nil {
-		s.Logger.Fatal(err)
+	if s.adminServer != nil {
+		go s.runAdminServer()
 	}
 
-	for uri, endpointProxyCfgMap := range combinedUriConfigs {
-		for method, cfg := range endpointProxyCfgMap {
-			s.router.Method(method, uri, s.handleEndpoint(cfg))
-		}
+	s.reloadMu.Lock()
+	buildErr := s.buildErr
+	s.reloadMu.Unlock()
+
+	if buildErr != nil {
+		s.Logger.Error("failed to build routes", "error", buildErr)
+		os.Exit(1)
 	}
 
 	if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		s.Logger.Fatal(err)
+		s.Logger.Error("http server failed", "error", err)
+		os.Exit(1)
 	}
 }
 
+// Reload builds the routes for a new config and swaps them in without restarting the listener. The current routes
+// are kept if the new config can't be built.
+func (s *server) Reload(cfg *config.Config) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	router, err := s.buildRouter(cfg)
+	if err != nil {
+		return err
+	}
+
+	s.Config = cfg
+	s.buildErr = nil
+	s.router.Store(router)
+	s.redactor.SetOptions(redactOptions(cfg, s.Vars, s.SecretVars))
+
+	return nil
+}
+
+func (s *server) runAdminServer() {
+	s.Logger.Info("starting admin server", "port", s.Options.AdminPort)
+
+	if err := s.adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		s.Logger.Error("admin server failed", "error", err)
+	}
+}
+
+// Handler serves requests with the routes of the current config without starting the listener.
+func (s *server) Handler() http.Handler {
+	return s.httpServer.Handler
+}
+
 // Shutdown the http server gracefully
 func (s *server) Shutdown(ctx context.Context) error {
+	if s.adminServer != nil {
+		if err := s.adminServer.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+
 	return s.httpServer.Shutdown(ctx)
 }
 
-func (s *server) combineCommonUriConfigs() (map[string]map[string]*endpointProxyConfig, error) {
+func (s *server) buildRouter(cfg *config.Config) (*chi.Mux, error) {
+	router := chi.NewRouter()
+
+	// Tag every request with an id and log out all requests coming in
+	router.Use(s.requestID)
+	router.Use(s.logRequest)
+
+	if s.Exchanges != nil {
+		router.Use(s.captureExchanges)
+	}
+
+	router.Use(s.metrics.instrument)
+
+	if s.Tracer != nil {
+		router.Use(s.trace)
+	}
+
+	combinedUriConfigs, err := s.combineCommonUriConfigs(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	for uri, endpointProxyCfgMap := range combinedUriConfigs {
+		for method, endpointProxyCfg := range endpointProxyCfgMap {
+			if err := s.compileEndpointProxyConfig(endpointProxyCfg); err != nil {
+				return nil, fmt.Errorf("route %s %s: %w", method, uri, err)
+			}
+
+			if err := checkFallbackRoutes(combinedUriConfigs, method, endpointProxyCfg); err != nil {
+				return nil, fmt.Errorf("route %s %s: %w", method, uri, err)
+			}
+
+			router.Method(method, uri, s.handleEndpoint(endpointProxyCfg))
+		}
+	}
+
+	return router, nil
+}
+
+func (s *server) combineCommonUriConfigs(cfg *config.Config) (map[string]map[string]*endpointProxyConfig, error) {
 	combinedUriConfigs := make(map[string]map[string]*endpointProxyConfig)
 
-	for _, uriGroup := range s.UriGroups {
+	for _, uriGroup := range cfg.UriGroups {
 		for _, supportedUri := range uriGroup.SupportedUris {
-			endpointProxyCfgMap, err := s.buildEndpointProxyConfigs(supportedUri)
+			if supportedUri.ErrorFormat == "" {
+				supportedUri.ErrorFormat = uriGroup.ErrorFormat
+			}
+
+			if supportedUri.ModelFamily == "" {
+				supportedUri.ModelFamily = uriGroup.ModelFamily
+			}
+
+			endpointProxyCfgMap, err := s.buildEndpointProxyConfigs(cfg, supportedUri)
 			if err != nil {
 				return nil, err
 			}
@@ -100,15 +260,10 @@ func (s *server) combineCommonUriConfigs() (map[string]map[string]*endpointProxy
 	return combinedUriConfigs, nil
 }
 
-func (s *server) buildEndpointProxyConfigs(uriMap config.UriMap) (map[string]*endpointProxyConfig, error) {
+func (s *server) buildEndpointProxyConfigs(cfg *config.Config, uriMap config.UriMap) (map[string]*endpointProxyConfig, error) {
 	endpointProxyConfigMap := make(map[string]*endpointProxyConfig)
 
-	baseEndpoint, err := s.getBaseEndpoint(uriMap)
-	if err != nil {
-		return nil, err
-	}
-
-	target, err := url.Parse(baseEndpoint)
+	upstreams, err := s.resolveUpstreams(cfg, uriMap)
 	if err != nil {
 		return nil, err
 	}
@@ -117,20 +272,23 @@ func (s *server) buildEndpointProxyConfigs(uriMap config.UriMap) (map[string]*en
 		httpMethod := outMethod.Method
 
 		endpointProxyCfg := &endpointProxyConfig{
-			baseEndpoint:    target,
-			UriMap:          uriMap,
-			Out:             outMethod,
-			RequestResponse: s.Overrides.Global,
+			upstreams:           upstreams,
+			failoverStatusCodes: cfg.Failover.StatusCodes,
+			UriMap:              uriMap,
+			Out:                 outMethod,
+			RequestResponse:     cfg.Overrides.Global,
+			usage:               cfg.Usage,
+			limits:              endpointLimits(cfg.Limits, uriMap.In),
 		}
 
-		uriCfgMap, ok := s.Overrides.Uris[uriMap.In]
+		uriCfgMap, ok := cfg.Overrides.Uris[uriMap.In]
 		if !ok {
 			endpointProxyConfigMap[httpMethod] = endpointProxyCfg
 			continue
 		}
 
-		if reqResp, ok := uriCfgMap[httpMethod]; ok {
-			endpointProxyCfg.RequestResponse = mergeRequestResponse(s.Overrides.Global, reqResp)
+		for _, variant := range uriCfgMap[httpMethod] {
+			endpointProxyCfg.variants = append(endpointProxyCfg.variants, mergeRequestResponse(cfg.Overrides.Global, variant))
 		}
 
 		endpointProxyConfigMap[httpMethod] = endpointProxyCfg
@@ -139,31 +297,151 @@ func (s *server) buildEndpointProxyConfigs(uriMap config.UriMap) (map[string]*en
 	return endpointProxyConfigMap, nil
 }
 
-func (s *server) getBaseEndpoint(uriMap config.UriMap) (string, error) {
-	if uriMap.BaseEndpoint != "" {
-		return uriMap.BaseEndpoint, nil
+// compileEndpointProxyConfig compiles every expr and template an endpoint uses so they are cached before the first
+// request and broken ones are found when the config is loaded rather than when a request hits them.
+func (s *server) compileEndpointProxyConfig(cfg *endpointProxyConfig) error {
+	if cfg.ModelFamily != "" && !s.Catalog.HasFamily(cfg.ModelFamily) {
+		return fmt.Errorf("unknown model family %q", cfg.ModelFamily)
 	}
 
-	env := map[string]any{
-		"globalVars": s.Vars,
+	if err := s.compile(cfg.Out.Template, cfg.Out.Expr); err != nil {
+		return err
 	}
 
-	baseEndpointBytes, err := s.renderer.RenderExpr(s.BaseEndpoint, env, nil)
+	for _, input := range []config.Input{cfg.usage.Profile, cfg.usage.UseCaseId, cfg.usage.Model} {
+		if err := s.compile(input.Template, input.Expr); err != nil {
+			return err
+		}
+	}
 
-	if err != nil {
-		return "", fmt.Errorf("failed to evaluate baseEndpoint expr: %w", err)
+	for _, reqResp := range append([]config.RequestResponse{cfg.RequestResponse}, cfg.variants...) {
+		if err := s.compileRequestResponse(reqResp); err != nil {
+			return err
+		}
 	}
 
-	return string(baseEndpointBytes), nil
+	return nil
+}
+
+func (s *server) compileRequestResponse(reqResp config.RequestResponse) error {
+	inputs := []config.Input{}
+	bodies := []config.Body{reqResp.Request.Body, reqResp.Response.Body}
+	headers := append(copyHeadersSlice(reqResp.Request.Headers), reqResp.Response.Headers...)
+	whens := []string{reqResp.When}
+
+	if reqResp.Forward != nil {
+		inputs = append(inputs, reqResp.Forward.Path)
+		headers = append(headers, reqResp.Forward.Headers...)
+	}
+
+	if reqResp.Fallback != nil {
+		whens = append(whens, reqResp.Fallback.When)
+	}
+
+	if reqResp.Cache != nil {
+		whens = append(whens, reqResp.Cache.When)
+
+		if err := s.compile("", reqResp.Cache.Key); err != nil {
+			return err
+		}
+	}
+
+	if reqResp.Fetch != nil {
+		for _, req := range reqResp.Fetch.Requests {
+			inputs = append(inputs, req.Url, req.Body)
+			headers = append(headers, req.Headers...)
+		}
+	}
+
+	for _, header := range headers {
+		inputs = append(inputs, header.Input)
+		whens = append(whens, header.When)
+	}
+
+	for _, body := range bodies {
+		for _, patch := range body.Patches {
+			whens = append(whens, patch.When)
+		}
+	}
+
+	if reqResp.Retry != nil {
+		whens = append(whens, reqResp.Retry.When)
+	}
+
+	if reqResp.Fetch != nil {
+		for _, req := range reqResp.Fetch.Requests {
+			if req.Retry != nil {
+				whens = append(whens, req.Retry.When)
+			}
+		}
+	}
+
+	for _, input := range inputs {
+		if err := s.compile(input.Template, input.Expr); err != nil {
+			return err
+		}
+	}
+
+	for _, body := range bodies {
+		if err := s.compile(body.Template, body.Expr); err != nil {
+			return err
+		}
+
+		if _, ok := translate.Get(body.Transform); body.Transform != "" && !ok {
+			return fmt.Errorf("unknown transform %q", body.Transform)
+		}
+	}
+
+	for _, when := range whens {
+		if err := s.compile("", when); err != nil {
+			return err
+		}
+	}
+
+	return s.compile("", reqResp.Response.StatusCode.Expr)
+}
+
+func (s *server) compile(templateStr, exprStr string) error {
+	if strings.TrimSpace(exprStr) != "" {
+		return s.renderer.CompileExpr(exprStr)
+	}
+
+	if strings.TrimSpace(templateStr) != "" {
+		return s.renderer.CompileTemplate(templateStr)
+	}
+
+	return nil
 }
 
 // Merge two config.RequestResponse structs, extending header lists and merging bodies.
 func mergeRequestResponse(a, b config.RequestResponse) config.RequestResponse {
 	merged := config.RequestResponse{
+		When:     b.When,
 		Request:  mergeOverrideConfig(a.Request, b.Request),
 		Response: mergeOverrideConfig(a.Response, b.Response),
 	}
 
+	// Retry from b takes precedence if set
+	if b.Retry != nil {
+		merged.Retry = b.Retry
+	} else {
+		merged.Retry = a.Retry
+	}
+
+	// Fallback from b takes precedence if set
+	if b.Fallback != nil {
+		merged.Fallback = b.Fallback
+	} else {
+		merged.Fallback = a.Fallback
+	}
+
+	// Cache from b takes precedence if set
+	if b.Cache != nil {
+		merged.Cache = b.Cache
+	} else {
+		merged.Cache = a.Cache
+	}
+
 	// Forward from b takes precedence if set
 	if b.Forward != nil {
 		merged.Forward = b.Forward
@@ -196,6 +474,7 @@ func mergeFetch(globalHeaders []config.Header, fetch *config.Fetch) *config.Fetc
 			Headers: append(copyHeadersSlice(globalHeaders), copyHeadersSlice(req.Headers)...),
 			Body:    req.Body,
 			Timeout: req.Timeout,
+			Retry:   req.Retry,
 		}
 	}
 
@@ -226,6 +505,7 @@ func copyHeader(h config.Header) config.Header {
 	return config.Header{
 		Operation: h.Operation,
 		Name:      h.Name,
+		When:      h.When,
 		Input: config.Input{
 			Text:     h.Text,
 			File:     h.File,
@@ -265,12 +545,28 @@ func mergeBody(a, b config.Body) config.Body {
 		expr = b.Expr
 	}
 
+	// Same for the sse mode
+	sse := a.Sse
+
+	if b.Sse != "" {
+		sse = b.Sse
+	}
+
+	// Same for the transform
+	transform := a.Transform
+
+	if b.Transform != "" {
+		transform = b.Transform
+	}
+
 	// Extend patches
 	return config.Body{
-		Patches:  append(copyPatchesSlice(a.Patches), copyPatchesSlice(b.Patches)...),
-		Text:     text,
-		Template: template,
-		Expr:     expr,
+		Patches:   append(copyPatchesSlice(a.Patches), copyPatchesSlice(b.Patches)...),
+		Text:      text,
+		Template:  template,
+		Expr:      expr,
+		Sse:       sse,
+		Transform: transform,
 	}
 }
 
@@ -289,5 +585,6 @@ func copyPatch(p config.Patch) config.Patch {
 		Operation: p.Operation,
 		Path:      p.Path,
 		Value:     p.Value,
+		When:      p.When,
 	}
 }
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+)
+
+func TestOverrideVariants(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"variant": r.Header.Get("X-Variant"),
+			"model":   r.Header.Get("X-Model"),
+			"body":    string(body),
+		})
+	}))
+	defer upstream.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(fmt.Sprintf(`
+uriGroups:
+  - name: Test
+    supportedUris:
+      - in: /chat
+        baseEndpoint: %s
+        out:
+          - method: POST
+            text: /upstream
+overrides:
+  global:
+    request:
+      headers:
+        - op: add
+          name: X-Model
+          when: body.model startsWith "claude"
+          expr: body.model
+  uris:
+    /chat:
+      POST:
+        - when: body.stream ?? false
+          request:
+            headers:
+              - op: add
+                name: X-Variant
+                text: stream
+        - when: body.model == "legacy"
+          request:
+            headers:
+              - op: add
+                name: X-Variant
+                text: legacy
+            body:
+              patches:
+                - op: add
+                  path: /max_tokens
+                  value: "1024"
+                  when: body.max_tokens == nil
+`, upstream.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer()
+	s.Logger = logging.Discard()
+
+	router, err := s.buildRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected map[string]any
+	}{
+		{
+			name:     "first matching variant",
+			body:     `{"model":"claude-sonnet","stream":true}`,
+			expected: map[string]any{"variant": "stream", "model": "claude-sonnet", "body": `{"model":"claude-sonnet","stream":true}`},
+		},
+		{
+			name:     "second variant with patch",
+			body:     `{"model":"legacy"}`,
+			expected: map[string]any{"variant": "legacy", "model": "", "body": `{"model":"legacy","max_tokens":"1024"}`},
+		},
+		{
+			name:     "patch not matching",
+			body:     `{"max_tokens":10,"model":"legacy"}`,
+			expected: map[string]any{"variant": "legacy", "model": "", "body": `{"max_tokens":10,"model":"legacy"}`},
+		},
+		{
+			name:     "no variant matches",
+			body:     `{"model":"claude-haiku"}`,
+			expected: map[string]any{"variant": "", "model": "claude-haiku", "body": `{"model":"claude-haiku"}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			var got map[string]any
+
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("Expected json response, got: %s", rec.Body.String())
+			}
+
+			for key, expected := range tt.expected {
+				if got[key] != expected {
+					t.Errorf("Expected %s to be %v, got: %v", key, expected, got[key])
+				}
+			}
+		})
+	}
+}
+
+func TestOutPathQuery(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s?%s", r.URL.Path, r.URL.RawQuery)
+	}))
+	defer upstream.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(fmt.Sprintf(`
+uriGroups:
+  - name: Test
+    supportedUris:
+      - in: /chat
+        baseEndpoint: %s
+        out:
+          - method: POST
+            expr: |
+              "/upstream" + ((body.stream ?? false) ? "?alt=sse" : "")
+`, upstream.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer()
+	s.Logger = logging.Discard()
+
+	router, err := s.buildRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "query replaces the client's",
+			path:     "/chat?key=value",
+			body:     `{"stream":true}`,
+			expected: "/upstream?alt=sse",
+		},
+		{
+			name:     "client's query without one",
+			path:     "/chat?key=value",
+			body:     `{}`,
+			expected: "/upstream?key=value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Body.String() != tt.expected {
+				t.Errorf("Expected %s, got: %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/redact"
+)
+
+// recording is a single upstream exchange as written to disk by record mode and served by replay mode
+type recording struct {
+	Request  recordedRequest  `json:"request"`
+	Response recordedResponse `json:"response"`
+}
+
+type recordedRequest struct {
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	BodyHash string `json:"bodyHash"`
+	Body     string `json:"body,omitempty"`
+}
+
+type recordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Headers    http.Header `json:"headers"`
+
+	// Chunks are the body as it was read from the upstream with the delay before each one, so that streamed
+	// responses are replayed with the same timing
+	Chunks []recordedChunk `json:"chunks"`
+}
+
+// recordedChunk holds its data as text, or base64 encoded if it isn't valid utf-8, e.g. AWS event streams
+type recordedChunk struct {
+	DelayMs int64  `json:"delayMs"`
+	Data    string `json:"data,omitempty"`
+	Base64  string `json:"base64,omitempty"`
+}
+
+func newRecordedChunk(delay time.Duration, data []byte) recordedChunk {
+	chunk := recordedChunk{
+		DelayMs: delay.Milliseconds(),
+	}
+
+	if utf8.Valid(data) {
+		chunk.Data = string(data)
+	} else {
+		chunk.Base64 = base64.StdEncoding.EncodeToString(data)
+	}
+
+	return chunk
+}
+
+func (c recordedChunk) bytes() ([]byte, error) {
+	if c.Base64 != "" {
+		return base64.StdEncoding.DecodeString(c.Base64)
+	}
+
+	return []byte(c.Data), nil
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// upstreamTransport returns the transport used to reach upstreams, with every request counted in the metrics and
+// traced.
+func (s *server) upstreamTransport() http.RoundTripper {
+	var transport http.RoundTripper = &metricsTransport{transport: s.baseTransport(), metrics: s.metrics}
+
+	if s.Tracer != nil {
+		transport = &tracingTransport{transport: transport, tracer: s.Tracer}
+	}
+
+	return transport
+}
+
+// baseTransport returns the transport used to reach upstreams, taking record and replay mode into account.
+func (s *server) baseTransport() http.RoundTripper {
+	transport := s.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	if s.ReplayDir != "" {
+		return &replayTransport{dir: s.ReplayDir}
+	}
+
+	if s.RecordDir != "" {
+		return &recordingTransport{
+			transport: transport,
+			dir:       s.RecordDir,
+			logger:    s.Logger,
+			redactor:  s.redactor,
+		}
+	}
+
+	return transport
+}
+
+// recordingKey identifies a request by its method, path including the query and a hash of its normalised body. Json
+// bodies are normalised by re-encoding them so that key order and whitespace don't matter.
+func recordingKey(req *http.Request) (string, string, []byte, error) {
+	if err := makeBodyReplayable(req); err != nil {
+		return "", "", nil, err
+	}
+
+	var body []byte
+
+	if req.GetBody != nil {
+		bodyReader, err := req.GetBody()
+		if err != nil {
+			return "", "", nil, err
+		}
+
+		body, err = io.ReadAll(bodyReader)
+		if err != nil {
+			return "", "", nil, err
+		}
+	}
+
+	normalisedBody := body
+
+	var jsonBody any
+
+	if err := json.Unmarshal(body, &jsonBody); err == nil {
+		normalisedBody, _ = json.Marshal(jsonBody)
+	}
+
+	bodySum := sha256.Sum256(normalisedBody)
+	bodyHash := hex.EncodeToString(bodySum[:])
+
+	keySum := sha256.Sum256([]byte(req.Method + "\n" + req.URL.RequestURI() + "\n" + bodyHash))
+	name := unsafeFileNameChars.ReplaceAllString(req.URL.Path, "_")
+
+	if len(name) > 100 {
+		name = name[:100]
+	}
+
+	key := fmt.Sprintf("%s%s_%s.json", req.Method, name, hex.EncodeToString(keySum[:])[:16])
+
+	return key, bodyHash, body, nil
+}
+
+// recordingTransport forwards requests to the upstream and writes every exchange to the record directory once the
+// response body has been read. Secrets are masked in what's written, the body hash used to match requests is taken
+// before masking.
+type recordingTransport struct {
+	transport http.RoundTripper
+	dir       string
+	logger    *slog.Logger
+	redactor  *redact.Redactor
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, bodyHash, body, err := recordingKey(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Leave compression to the transport so the body is recorded decoded
+	req = req.Clone(req.Context())
+	req.Header.Del("Accept-Encoding")
+
+	res, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &recording{
+		Request: recordedRequest{
+			Method:   req.Method,
+			Path:     req.URL.RequestURI(),
+			BodyHash: bodyHash,
+			Body:     string(t.redactor.Body(body)),
+		},
+		Response: recordedResponse{
+			StatusCode: res.StatusCode,
+			Headers:    t.redactor.Header(res.Header),
+		},
+	}
+
+	res.Body = &recordingBody{
+		source:   res.Body,
+		lastRead: time.Now(),
+		onClose: func(body []byte, reads []bodyRead) {
+			// The body is masked as a whole so that secrets split across reads are caught. Streams aren't a single
+			// json document so only their secrets are masked.
+			if strings.Contains(res.Header.Get("Content-Type"), "text/event-stream") {
+				body = []byte(t.redactor.String(string(body)))
+			} else {
+				body = t.redactor.Body(body)
+			}
+
+			rec.Response.Chunks = splitRecordedBody(body, reads)
+
+			if err := writeRecording(filepath.Join(t.dir, key), rec); err != nil {
+				t.logger.ErrorContext(req.Context(), "failed to record exchange", "method", req.Method, "path", req.URL.Path, "error", err)
+				return
+			}
+
+			t.logger.InfoContext(req.Context(), "recorded exchange", "method", req.Method, "path", req.URL.Path, "file", key)
+		},
+	}
+
+	return res, nil
+}
+
+// recordingBody captures a response body as it's read along with the size of each read and the time before it.
+type recordingBody struct {
+	source   io.ReadCloser
+	lastRead time.Time
+	body     bytes.Buffer
+	reads    []bodyRead
+	onClose  func(body []byte, reads []bodyRead)
+	once     sync.Once
+}
+
+type bodyRead struct {
+	delay time.Duration
+	size  int
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.source.Read(p)
+
+	if n > 0 {
+		now := time.Now()
+
+		b.body.Write(p[:n])
+		b.reads = append(b.reads, bodyRead{delay: now.Sub(b.lastRead), size: n})
+
+		b.lastRead = now
+	}
+
+	if err == io.EOF {
+		b.once.Do(func() { b.onClose(b.body.Bytes(), b.reads) })
+	}
+
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	b.once.Do(func() { b.onClose(b.body.Bytes(), b.reads) })
+	return b.source.Close()
+}
+
+// splitRecordedBody splits a body back into the reads it was captured in. Masking can change the body's length so
+// the last chunk takes whatever is left.
+func splitRecordedBody(body []byte, reads []bodyRead) []recordedChunk {
+	chunks := make([]recordedChunk, 0, len(reads))
+	offset := 0
+
+	for i, read := range reads {
+		end := min(offset+read.size, len(body))
+		if i == len(reads)-1 {
+			end = len(body)
+		}
+
+		chunks = append(chunks, newRecordedChunk(read.delay, body[offset:end]))
+		offset = end
+	}
+
+	return chunks
+}
+
+func writeRecording(path string, rec *recording) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+// replayTransport serves requests from the recordings in the replay directory without contacting the upstream.
+type replayTransport struct {
+	dir string
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, bodyHash, _, err := recordingKey(req)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(t.dir, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no recording for %s %s with body hash %s", req.Method, req.URL.RequestURI(), bodyHash)
+		}
+
+		return nil, err
+	}
+
+	var rec recording
+
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("failed to parse recording %s: %w", key, err)
+	}
+
+	headers := copyHeaders(rec.Response.Headers)
+
+	// The recorded body may have been chunked on the wire but it's replayed in its own chunks
+	headers.Del("Content-Length")
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.Response.StatusCode, http.StatusText(rec.Response.StatusCode)),
+		StatusCode:    rec.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        headers,
+		Body:          newReplayBody(req, rec.Response.Chunks),
+		ContentLength: -1,
+		Request:       req,
+	}, nil
+}
+
+// newReplayBody streams recorded chunks back with their original timing.
+func newReplayBody(req *http.Request, chunks []recordedChunk) io.ReadCloser {
+	if len(chunks) == 0 {
+		return http.NoBody
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		defer pw.Close()
+
+		for _, chunk := range chunks {
+			select {
+			case <-req.Context().Done():
+				pw.CloseWithError(req.Context().Err())
+				return
+			case <-time.After(time.Duration(chunk.DelayMs) * time.Millisecond):
+			}
+
+			data, err := chunk.bytes()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+
+			if _, err := pw.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	return pr
+}
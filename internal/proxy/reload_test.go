@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+)
+
+const reloadTestConfig = `
+uriGroups:
+  - name: Test
+    supportedUris:
+      - in: %[2]s
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            text: %[2]s
+`
+
+// duplicateRoute is added to the end of a reloadTestConfig to give it a route which can't be built
+const duplicateRoute = `      - in: %[2]s
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+`
+
+func loadReloadTestConfig(t *testing.T, data string) *config.Config {
+	t.Helper()
+
+	cfg, err := config.LoadFromBytes([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg
+}
+
+func newReloadTestServer(t *testing.T, cfg *config.Config) *server {
+	t.Helper()
+
+	return New(Options{Config: cfg, Logger: logging.Discard()}).(*server)
+}
+
+func postStatus(s *server, path string) int {
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{}`)))
+
+	return rec.Code
+}
+
+func TestReload(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name     string
+		config   string
+		reloaded bool
+	}{
+		{name: "valid config", config: fmt.Sprintf(reloadTestConfig, upstream.URL, "/new"), reloaded: true},
+		{
+			name:     "routes which can't be built",
+			config:   fmt.Sprintf(reloadTestConfig+duplicateRoute, upstream.URL, "/new"),
+			reloaded: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newReloadTestServer(t, loadReloadTestConfig(t, fmt.Sprintf(reloadTestConfig, upstream.URL, "/old")))
+
+			// The routes are served before the first reload
+			if status := postStatus(s, "/old"); status != http.StatusOK {
+				t.Fatalf("Expected status 200 for the old route before reloading, got: %d", status)
+			}
+
+			err := s.Reload(loadReloadTestConfig(t, tt.config))
+			if reloaded := err == nil; reloaded != tt.reloaded {
+				t.Errorf("Expected reloaded to be %t, got error: %v", tt.reloaded, err)
+			}
+
+			expectedOld, expectedNew := http.StatusOK, http.StatusNotFound
+			if tt.reloaded {
+				expectedOld, expectedNew = http.StatusNotFound, http.StatusOK
+			}
+
+			if status := postStatus(s, "/old"); status != expectedOld {
+				t.Errorf("Expected status %d for the old route, got: %d", expectedOld, status)
+			}
+
+			if status := postStatus(s, "/new"); status != expectedNew {
+				t.Errorf("Expected status %d for the new route, got: %d", expectedNew, status)
+			}
+		})
+	}
+}
+
+func TestNewWithRoutesWhichCantBeBuilt(t *testing.T) {
+	s := newReloadTestServer(t, loadReloadTestConfig(t, fmt.Sprintf(reloadTestConfig+duplicateRoute, "http://localhost", "/chat")))
+
+	if s.buildErr == nil {
+		t.Fatal("Expected the routes to fail to build")
+	}
+
+	if err := s.Reload(loadReloadTestConfig(t, fmt.Sprintf(reloadTestConfig, "http://localhost", "/chat"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.buildErr != nil {
+		t.Errorf("Expected a reload to clear the build error, got: %v", s.buildErr)
+	}
+}
+
+func TestReloadDuringStream(t *testing.T) {
+	release := make(chan struct{})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+
+		<-release
+		fmt.Fprint(w, "data: second\n\n")
+	}))
+	defer upstream.Close()
+
+	s := newReloadTestServer(t, loadReloadTestConfig(t, fmt.Sprintf(reloadTestConfig, upstream.URL, "/stream")))
+
+	srv := httptest.NewServer(s.httpServer.Handler)
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/stream", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	reader := bufio.NewReader(res.Body)
+
+	if line, err := reader.ReadString('\n'); err != nil || line != "data: first\n" {
+		t.Fatalf("Expected the first event, got: %q, %v", line, err)
+	}
+
+	if err := s.Reload(loadReloadTestConfig(t, fmt.Sprintf(reloadTestConfig, upstream.URL, "/other"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := postStatus(s, "/stream"); status != http.StatusNotFound {
+		t.Errorf("Expected the route to be gone after the reload, got: %d", status)
+	}
+
+	close(release)
+
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "\ndata: second\n\n"; string(rest) != expected {
+		t.Errorf("Expected the stream to finish with %q, got: %q", expected, rest)
+	}
+}
@@ -26,30 +26,50 @@ type HttpResponse struct {
 
 // renderRequest applies all config-driven transformations to the request and returns a new http.Request.
 func (s *server) renderRequest(req *http.Request, cfg *endpointProxyConfig, templateInput map[string]any) error {
+	ctx := req.Context()
+
 	if !cfg.Out.IsEmpty() {
 		renderedPath := cfg.Out.Text
 
 		if cfg.Out.Text == "" {
-			// Use unified render to support both Template and Expr
-			renderedPathBytes, err := s.renderer.Render(cfg.Out.Template, cfg.Out.Expr, templateInput, nil)
+			err := s.traceRender(ctx, "render request path", templateInput, func() error {
+				// Use unified render to support both Template and Expr
+				renderedPathBytes, err := s.renderer.Render(cfg.Out.Template, cfg.Out.Expr, templateInput, nil)
+				if err != nil {
+					return err
+				}
+
+				renderedPath = strings.TrimSpace(string(renderedPathBytes))
+				return nil
+			})
 			if err != nil {
 				return err
 			}
+		}
 
-			renderedPath = strings.TrimSpace(string(renderedPathBytes))
+		// A query in the rendered path replaces the client's, e.g. to ask for a stream as SSE
+		path, query, hasQuery := strings.Cut(renderedPath, "?")
+		if hasQuery {
+			req.URL.RawQuery = query
 		}
 
-		req.URL.Path = renderedPath
+		req.URL.Path = path
 		req.RequestURI = renderedPath
 	}
 
-	if err := s.overrideHeaders(cfg.Request.Headers, &req.Header, templateInput, nil); err != nil {
+	err := s.traceRender(ctx, "render request headers", templateInput, func() error {
+		return s.overrideHeaders(cfg.Request.Headers, &req.Header, templateInput, nil)
+	})
+	if err != nil {
 		return err
 	}
 
 	// Apply body patches/overrides as per config
-	if err := s.overrideRequestBody(req, templateInput, cfg.Request.Body); err != nil {
-		return fmt.Errorf("error applying body override: %v", err)
+	err = s.traceRender(ctx, "render request body", templateInput, func() error {
+		return s.overrideRequestBody(req, templateInput, cfg.Request.Body)
+	})
+	if err != nil {
+		return fmt.Errorf("error applying body override: %w", err)
 	}
 
 	return nil
@@ -57,12 +77,20 @@ func (s *server) renderRequest(req *http.Request, cfg *endpointProxyConfig, temp
 
 // renderResponse applies all config-driven transformations to the response and returns a new http.Reponse.
 func (s *server) renderResponse(res *http.Response, cfg *endpointProxyConfig, templateInput map[string]any) error {
-	if err := s.overrideHeaders(cfg.Response.Headers, &res.Header, templateInput, nil); err != nil {
+	ctx := responseContext(res)
+
+	err := s.traceRender(ctx, "render response headers", templateInput, func() error {
+		return s.overrideHeaders(cfg.Response.Headers, &res.Header, templateInput, nil)
+	})
+	if err != nil {
 		return err
 	}
 
 	// Apply body patches/overrides as per config
-	if err := s.overrideResponseBody(res, templateInput, cfg.Response.Body); err != nil {
+	err = s.traceRender(ctx, "render response body", templateInput, func() error {
+		return s.overrideResponseBody(res, templateInput, cfg.Response.Body)
+	})
+	if err != nil {
 		return fmt.Errorf("error applying body override: %v", err)
 	}
 
@@ -142,6 +170,15 @@ func (s *server) overrideHeaders(headerOperations []config.Header, originalHeade
 	headerRenderInfo := make(map[string]config.Input)
 
 	for _, headerOperation := range headerOperations {
+		matched, err := s.matches(headerOperation.When, templateInput, tmpRenderStorage)
+		if err != nil {
+			return err
+		}
+
+		if !matched {
+			continue
+		}
+
 		if err := s.overrideHeader(headerOperation, originalHeaders, headerRenderInfo); err != nil {
 			return err
 		}
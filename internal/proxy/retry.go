@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/template"
+)
+
+const (
+	defaultRetryInitialDelay = 500 * time.Millisecond
+	defaultRetryMaxDelay     = 30 * time.Second
+)
+
+// retryTransport retries requests which fail with a connection error or a retryable response. Retries happen before
+// the response is handed back to the reverse proxy, so a response which has started streaming to the client is never
+// retried.
+type retryTransport struct {
+	transport http.RoundTripper
+	retry     *config.Retry
+	renderer  *template.Renderer
+	logger    *slog.Logger
+	vars      map[string]any
+}
+
+// withRetry wraps a transport with the retry policy, the transport is returned as is if there's no policy.
+func (s *server) withRetry(transport http.RoundTripper, retry *config.Retry) http.RoundTripper {
+	if retry == nil || retry.MaxAttempts <= 1 {
+		return transport
+	}
+
+	return &retryTransport{
+		transport: transport,
+		retry:     retry,
+		renderer:  s.renderer,
+		logger:    s.Logger,
+		vars:      s.Vars,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := makeBodyReplayable(req); err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		res, err := t.transport.RoundTrip(attemptReq)
+
+		if attempt >= t.retry.MaxAttempts || req.Context().Err() != nil || !t.shouldRetry(req.Context(), res, err, attempt) {
+			return res, err
+		}
+
+		delay := t.delay(attempt, res)
+
+		if err != nil {
+			t.logger.WarnContext(req.Context(), "upstream request failed, retrying",
+				"attempt", attempt, "method", req.Method, "path", req.URL.Path, "error", err, "delay", delay,
+			)
+		} else {
+			t.logger.WarnContext(req.Context(), "upstream responded with a retryable status, retrying",
+				"attempt", attempt, "method", req.Method, "path", req.URL.Path, "status", res.StatusCode, "delay", delay,
+			)
+
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// shouldRetry decides if an attempt is retried. Connection errors and the configured status codes are retried, as
+// is any response the when expr returns true for.
+func (t *retryTransport) shouldRetry(ctx context.Context, res *http.Response, err error, attempt int) bool {
+	if err != nil || slices.Contains(t.retry.StatusCodes, res.StatusCode) {
+		return true
+	}
+
+	if t.retry.When == "" {
+		return false
+	}
+
+	env := map[string]any{
+		"attempt":    attempt,
+		"globalVars": t.vars,
+		"status":     res.StatusCode,
+		"headers":    copyHeaders(res.Header),
+	}
+
+	output, evalErr := t.renderer.EvalExpr(t.retry.When, env, nil)
+	if evalErr != nil {
+		t.logger.ErrorContext(ctx, "failed to evaluate retry when expr", "error", evalErr)
+		return false
+	}
+
+	retry, _ := output.(bool)
+	return retry
+}
+
+// delay returns how long to wait before the next attempt. The upstream's Retry-After is used when it's set,
+// otherwise the delay backs off exponentially with full jitter. Both are capped at the max delay.
+func (t *retryTransport) delay(attempt int, res *http.Response) time.Duration {
+	initialDelay := parseDurationOr(t.retry.InitialDelay, defaultRetryInitialDelay)
+	maxDelay := parseDurationOr(t.retry.MaxDelay, defaultRetryMaxDelay)
+
+	if res != nil {
+		if retryAfter, ok := parseRetryAfter(res.Header.Get("Retry-After")); ok {
+			return min(retryAfter, maxDelay)
+		}
+	}
+
+	backoff := initialDelay << (attempt - 1)
+
+	// Guard against the shift overflowing for large attempt counts
+	if backoff <= 0 || backoff > maxDelay {
+		backoff = maxDelay
+	}
+
+	return time.Duration(rand.Int64N(int64(backoff) + 1))
+}
+
+// parseRetryAfter parses a Retry-After header given in either seconds or as an http date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+
+	return 0, false
+}
+
+func parseDurationOr(value string, fallback time.Duration) time.Duration {
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fallback
+	}
+
+	return duration
+}
+
+// makeBodyReplayable buffers the request body so it can be sent more than once.
+func makeBodyReplayable(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+
+	if err != nil {
+		return err
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return nil
+}
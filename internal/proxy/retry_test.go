@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+)
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name             string
+		retry            *config.Retry
+		failures         int32
+		expectedStatus   int
+		expectedAttempts int32
+	}{
+		{
+			name:             "retries status codes",
+			retry:            &config.Retry{MaxAttempts: 3, StatusCodes: []int{529}, InitialDelay: "1ms"},
+			failures:         2,
+			expectedStatus:   http.StatusOK,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "gives up after max attempts",
+			retry:            &config.Retry{MaxAttempts: 2, StatusCodes: []int{529}, InitialDelay: "1ms"},
+			failures:         5,
+			expectedStatus:   529,
+			expectedAttempts: 2,
+		},
+		{
+			name:             "retries when expr matches",
+			retry:            &config.Retry{MaxAttempts: 3, When: `status >= 500 && attempt < 2`, InitialDelay: "1ms"},
+			failures:         5,
+			expectedStatus:   529,
+			expectedAttempts: 2,
+		},
+		{
+			name:             "other status codes aren't retried",
+			retry:            &config.Retry{MaxAttempts: 3, StatusCodes: []int{503}, InitialDelay: "1ms"},
+			failures:         5,
+			expectedStatus:   529,
+			expectedAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+
+			testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+
+				if string(body) != "hello" {
+					t.Errorf("Expected body to be replayed, got: %q", body)
+				}
+
+				if attempts.Add(1) <= tt.failures {
+					w.WriteHeader(529)
+					return
+				}
+
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer testSrv.Close()
+
+			s := newTestServer()
+			s.Logger = logging.Discard()
+
+			client := &http.Client{Transport: s.withRetry(http.DefaultTransport, tt.retry)}
+
+			req, _ := http.NewRequest(http.MethodPost, testSrv.URL, io.NopCloser(strings.NewReader("hello")))
+
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res.Body.Close()
+
+			if res.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got: %d", tt.expectedStatus, res.StatusCode)
+			}
+
+			if attempts.Load() != tt.expectedAttempts {
+				t.Errorf("Expected %d attempts, got: %d", tt.expectedAttempts, attempts.Load())
+			}
+		})
+	}
+}
+
+// countingTransport counts the attempts made through it
+type countingTransport struct {
+	transport http.RoundTripper
+	attempts  atomic.Int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.attempts.Add(1)
+	return t.transport.RoundTrip(req)
+}
+
+func TestRetryConnectionErrorWithWhen(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	s := newTestServer()
+	s.Logger = logging.Discard()
+
+	transport := &countingTransport{transport: http.DefaultTransport}
+	retry := &config.Retry{MaxAttempts: 3, When: `status == 500`, InitialDelay: "1ms"}
+
+	req, _ := http.NewRequest(http.MethodGet, closed.URL, nil)
+
+	if _, err := (&http.Client{Transport: s.withRetry(transport, retry)}).Do(req); err == nil {
+		t.Fatal("Expected the request to fail")
+	}
+
+	if attempts := transport.attempts.Load(); attempts != 3 {
+		t.Errorf("Expected the connection error to be retried 3 times, got: %d", attempts)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	transport := &retryTransport{
+		retry: &config.Retry{InitialDelay: "100ms", MaxDelay: "1s"},
+	}
+
+	retryAfter := &http.Response{Header: http.Header{"Retry-After": []string{"2"}}}
+
+	if delay := transport.delay(1, retryAfter); delay != time.Second {
+		t.Errorf("Expected Retry-After to be capped at the max delay, got: %s", delay)
+	}
+
+	retryAfter.Header.Set("Retry-After", "0")
+
+	if delay := transport.delay(1, retryAfter); delay != 0 {
+		t.Errorf("Expected Retry-After to be used, got: %s", delay)
+	}
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		limit := min(100*time.Millisecond<<(attempt-1), time.Second)
+
+		if delay := transport.delay(attempt, nil); delay < 0 || delay > limit {
+			t.Errorf("Expected attempt %d delay within %s, got: %s", attempt, limit, delay)
+		}
+	}
+}
+
+func TestExecuteFetchRequestRetry(t *testing.T) {
+	var attempts atomic.Int32
+
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte("ok"))
+	}))
+	defer testSrv.Close()
+
+	s := newTestServer()
+	s.Logger = logging.Discard()
+
+	result := s.executeFetchRequest(context.Background(), config.FetchRequest{
+		Method: http.MethodGet,
+		Url:    config.Input{Text: testSrv.URL},
+		Retry:  &config.Retry{MaxAttempts: 2, StatusCodes: []int{http.StatusServiceUnavailable}},
+	}, map[string]any{})
+
+	if result.Status != http.StatusOK || result.Body != "ok" {
+		t.Errorf("Expected the fetch to be retried, got: %+v", result)
+	}
+}
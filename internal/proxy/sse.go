@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// sseEvent is a single server-sent event as it appeared on the wire. Only the fields present in the event are set so
+// that it can be re-serialised without adding fields, e.g. the id isn't carried over from previous events.
+type sseEvent struct {
+	Name  string
+	ID    string
+	Data  string
+	Retry string
+
+	// HasData distinguishes an event with an empty data field from one without any
+	HasData bool
+
+	// Comments are kept so that keep-alive comments can be passed through to the client
+	Comments []string
+}
+
+// isCommentOnly is true for blocks which only contain comments, these aren't events and aren't rendered.
+func (e *sseEvent) isCommentOnly() bool {
+	return !e.HasData && e.ID == "" && e.Retry == "" && len(e.Comments) > 0
+}
+
+// templateInput builds the event object made available to templates and expressions.
+func (e *sseEvent) templateInput() map[string]any {
+	var jsonData any
+
+	if err := json.Unmarshal([]byte(e.Data), &jsonData); err != nil {
+		jsonData = nil
+	}
+
+	return map[string]any{
+		"name":  e.Name,
+		"id":    e.ID,
+		"data":  e.Data,
+		"retry": e.Retry,
+		"json":  jsonData,
+	}
+}
+
+// sseDecoder reads events from a text/event-stream following the parsing rules of the HTML specification: lines end
+// with CRLF, LF or CR, a blank line dispatches the event, multiple data fields are joined with newlines and an
+// unterminated event at the end of the stream is discarded.
+type sseDecoder struct {
+	reader    *bufio.Reader
+	line      bytes.Buffer
+	started   bool
+	lastWasCR bool
+}
+
+func newSseDecoder(r io.Reader) *sseDecoder {
+	return &sseDecoder{
+		reader: bufio.NewReader(r),
+	}
+}
+
+// Next returns the next event in the stream or io.EOF once the stream has ended.
+func (d *sseDecoder) Next() (*sseEvent, error) {
+	event := &sseEvent{}
+	var data strings.Builder
+
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if line == "" {
+			if !event.HasData && event.ID == "" && event.Retry == "" && len(event.Comments) == 0 {
+				// Nothing to dispatch, which also drops events which only set a name
+				event = &sseEvent{}
+				continue
+			}
+
+			event.Data = data.String()
+			return event, nil
+		}
+
+		if strings.HasPrefix(line, ":") {
+			event.Comments = append(event.Comments, strings.TrimPrefix(line, ":"))
+			continue
+		}
+
+		field, value, found := strings.Cut(line, ":")
+
+		if found {
+			value = strings.TrimPrefix(value, " ")
+		}
+
+		switch field {
+		case "event":
+			event.Name = value
+		case "data":
+			if event.HasData {
+				data.WriteByte('\n')
+			}
+
+			data.WriteString(value)
+			event.HasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				event.ID = value
+			}
+		case "retry":
+			if _, err := strconv.ParseUint(value, 10, 64); err == nil {
+				event.Retry = value
+			}
+		}
+	}
+}
+
+// readLine reads a single line without its terminator.
+func (d *sseDecoder) readLine() (string, error) {
+	d.line.Reset()
+
+	for {
+		b, err := d.reader.ReadByte()
+		if err != nil {
+			// An unterminated line at the end of the stream is incomplete and so is discarded
+			return "", err
+		}
+
+		// A LF directly after a CR is part of the same CRLF terminator
+		if d.lastWasCR && b == '\n' {
+			d.lastWasCR = false
+			continue
+		}
+
+		d.lastWasCR = b == '\r'
+
+		if b == '\r' || b == '\n' {
+			line := d.line.String()
+
+			if !d.started {
+				d.started = true
+				line = strings.TrimPrefix(line, "\ufeff")
+			}
+
+			return line, nil
+		}
+
+		d.line.WriteByte(b)
+	}
+}
+
+// encodeSseEvent serialises an event into its wire format including the blank line which dispatches it.
+func encodeSseEvent(event *sseEvent) []byte {
+	var buf bytes.Buffer
+
+	for _, comment := range event.Comments {
+		buf.WriteString(":" + comment + "\n")
+	}
+
+	if event.Name != "" {
+		buf.WriteString("event: " + event.Name + "\n")
+	}
+
+	if event.ID != "" {
+		buf.WriteString("id: " + event.ID + "\n")
+	}
+
+	if event.Retry != "" {
+		buf.WriteString("retry: " + event.Retry + "\n")
+	}
+
+	if event.HasData {
+		for _, line := range strings.Split(event.Data, "\n") {
+			buf.WriteString("data: " + line + "\n")
+		}
+	}
+
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+// sseEventsFromOutput converts the output of a rendered event expression into the events to send to the client. The
+// output can be nil to drop the event, an event object, or a list of event objects. Event objects use the same fields
+// as the event input: name, id, retry, and data or json. Raw text output is handled by the caller.
+func sseEventsFromOutput(output any) ([]*sseEvent, error) {
+	switch value := output.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		event, err := sseEventFromMap(value)
+		if err != nil {
+			return nil, err
+		}
+
+		return []*sseEvent{event}, nil
+	case []any:
+		events := make([]*sseEvent, 0, len(value))
+
+		for _, item := range value {
+			itemEvents, err := sseEventsFromOutput(item)
+			if err != nil {
+				return nil, err
+			}
+
+			events = append(events, itemEvents...)
+		}
+
+		return events, nil
+	default:
+		return nil, fmt.Errorf("event expression must return nil, an event or a list of events, got %T", output)
+	}
+}
+
+func sseEventFromMap(value map[string]any) (*sseEvent, error) {
+	event := &sseEvent{
+		Name:  stringField(value, "name"),
+		ID:    stringField(value, "id"),
+		Retry: stringField(value, "retry"),
+	}
+
+	if data, ok := value["data"]; ok && data != nil {
+		event.HasData = true
+
+		if dataStr, ok := data.(string); ok {
+			event.Data = dataStr
+		} else {
+			dataBytes, err := json.Marshal(data)
+			if err != nil {
+				return nil, err
+			}
+
+			event.Data = string(dataBytes)
+		}
+	} else if jsonData, ok := value["json"]; ok && jsonData != nil {
+		dataBytes, err := json.Marshal(jsonData)
+		if err != nil {
+			return nil, err
+		}
+
+		event.HasData = true
+		event.Data = string(dataBytes)
+	}
+
+	return event, nil
+}
+
+func stringField(value map[string]any, key string) string {
+	field, ok := value[key]
+	if !ok || field == nil {
+		return ""
+	}
+
+	return fmt.Sprint(field)
+}
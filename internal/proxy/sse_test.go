@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+)
+
+func decodeAll(t *testing.T, stream string) []*sseEvent {
+	t.Helper()
+
+	decoder := newSseDecoder(strings.NewReader(stream))
+	events := []*sseEvent{}
+
+	for {
+		event, err := decoder.Next()
+		if err == io.EOF {
+			return events
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		events = append(events, event)
+	}
+}
+
+func TestSseDecoder(t *testing.T) {
+	tests := []struct {
+		name     string
+		stream   string
+		expected []sseEvent
+	}{
+		{
+			name:   "data only",
+			stream: "data: {\"a\":1}\n\ndata: {\"b\":2}\n\n",
+			expected: []sseEvent{
+				{Data: `{"a":1}`, HasData: true},
+				{Data: `{"b":2}`, HasData: true},
+			},
+		},
+		{
+			name:   "all fields",
+			stream: "event: message_start\nid: 7\nretry: 3000\ndata: {}\n\n",
+			expected: []sseEvent{
+				{Name: "message_start", ID: "7", Retry: "3000", Data: "{}", HasData: true},
+			},
+		},
+		{
+			name:   "multi-line data",
+			stream: "data: first\ndata: second\ndata\n\n",
+			expected: []sseEvent{
+				{Data: "first\nsecond\n", HasData: true},
+			},
+		},
+		{
+			name:   "CRLF and CR line endings",
+			stream: "event: a\r\ndata: 1\r\n\r\nevent: b\rdata: 2\r\r",
+			expected: []sseEvent{
+				{Name: "a", Data: "1", HasData: true},
+				{Name: "b", Data: "2", HasData: true},
+			},
+		},
+		{
+			name:   "no space after colon and invalid retry",
+			stream: "data:tight\nretry: soon\n\n",
+			expected: []sseEvent{
+				{Data: "tight", HasData: true},
+			},
+		},
+		{
+			name:   "comments and name only events",
+			stream: ": keep-alive\n\nevent: ignored\n\ndata: x\n\n",
+			expected: []sseEvent{
+				{Comments: []string{" keep-alive"}},
+				{Data: "x", HasData: true},
+			},
+		},
+		{
+			name:     "unterminated event is discarded",
+			stream:   "data: partial\n",
+			expected: []sseEvent{},
+		},
+		{
+			name:   "byte order mark",
+			stream: "\ufeffdata: x\n\n",
+			expected: []sseEvent{
+				{Data: "x", HasData: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := decodeAll(t, tt.stream)
+
+			if len(events) != len(tt.expected) {
+				t.Fatalf("Expected %d events, got %d: %+v", len(tt.expected), len(events), events)
+			}
+
+			for i, expected := range tt.expected {
+				if string(encodeSseEvent(events[i])) != string(encodeSseEvent(&expected)) {
+					t.Errorf("Expected event %+v, got %+v", expected, *events[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeSseEvent(t *testing.T) {
+	event := &sseEvent{Name: "delta", ID: "1", Data: "line one\nline two", HasData: true}
+	expected := "event: delta\nid: 1\ndata: line one\ndata: line two\n\n"
+
+	if encoded := string(encodeSseEvent(event)); encoded != expected {
+		t.Errorf("Expected %q, got %q", expected, encoded)
+	}
+}
+
+func TestProcessSseEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		stream   string
+		expected string
+	}{
+		{
+			name:     "passthrough",
+			expr:     `event`,
+			stream:   "event: ping\ndata: {\"type\":\"ping\"}\n\n",
+			expected: "event: ping\ndata: {\"type\":\"ping\"}\n\n",
+		},
+		{
+			name:     "name from json data",
+			expr:     `{ name: event.json.type, data: event.data }`,
+			stream:   "data: {\"type\":\"message_stop\"}\n\n",
+			expected: "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+		},
+		{
+			name:     "drop events",
+			expr:     `event.data == "[DONE]" ? nil : event`,
+			stream:   "data: {}\n\ndata: [DONE]\n\n",
+			expected: "data: {}\n\n",
+		},
+		{
+			name:     "many events",
+			expr:     `[{ json: { n: 1 } }, { json: { n: 2 } }]`,
+			stream:   "data: {}\n\n",
+			expected: "data: {\"n\":1}\n\ndata: {\"n\":2}\n\n",
+		},
+		{
+			name:     "raw text",
+			expr:     `"data: " + event.data + "\n\n"`,
+			stream:   "data: raw\n\n",
+			expected: "data: raw\n\n",
+		},
+		{
+			name:     "comments pass through",
+			expr:     `nil`,
+			stream:   ": keep-alive\n\n",
+			expected: ": keep-alive\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer()
+
+			cfg := &endpointProxyConfig{
+				RequestResponse: config.RequestResponse{
+					Response: config.OverrideConfig{
+						Body: config.Body{Expr: tt.expr, Sse: config.SseEvents},
+					},
+				},
+			}
+
+			res := &http.Response{
+				Header: http.Header{"Content-Type": []string{"text/event-stream"}},
+				Body:   io.NopCloser(strings.NewReader(tt.stream)),
+			}
+
+			if err := s.processSseEvents(res, cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(body) != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/template"
+	"bitbucket.org/atlassian-developers/proximity/internal/tracing"
+)
+
+// trace starts a server span for every request handled by the router, continuing the trace of the traceparent header
+// when the client sent one.
+func (s *server) trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := s.Tracer.Start(tracing.Extract(r.Context(), r.Header), "HTTP "+r.Method, tracing.KindServer)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		route := routePattern(ctx)
+
+		span.SetName(r.Method + " " + route)
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("http.response.status_code", status)
+
+		if status >= http.StatusInternalServerError {
+			span.SetError(http.StatusText(status))
+		}
+	})
+}
+
+// traceRender runs a render step in its own span. The span is set as the context of the template input while it runs
+// so that token requests made by the render are within it.
+func (s *server) traceRender(ctx context.Context, name string, templateInput map[string]any, render func() error) error {
+	if s.Tracer == nil {
+		return render()
+	}
+
+	spanCtx, span := s.Tracer.Start(ctx, name, tracing.KindInternal)
+
+	template.SetContext(templateInput, spanCtx)
+	err := render()
+	template.SetContext(templateInput, ctx)
+
+	span.RecordError(err)
+	span.End()
+
+	return err
+}
+
+// responseContext returns the context of the request a response is for.
+func responseContext(res *http.Response) context.Context {
+	if res.Request == nil {
+		return context.Background()
+	}
+
+	return res.Request.Context()
+}
+
+// tracingTransport traces every request sent to an upstream and propagates the trace to it with the traceparent
+// header.
+type tracingTransport struct {
+	transport http.RoundTripper
+	tracer    *tracing.Tracer
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := t.tracer.Start(req.Context(), "HTTP "+req.Method, tracing.KindClient)
+	defer span.End()
+
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("server.address", req.URL.Host)
+	span.SetAttribute("url.path", req.URL.Path)
+
+	// The request mustn't be modified by a transport so the header is set on a copy
+	req = req.Clone(ctx)
+	tracing.Inject(ctx, req.Header)
+
+	res, err := t.transport.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttribute("http.response.status_code", res.StatusCode)
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		span.SetError(res.Status)
+	}
+
+	return res, nil
+}
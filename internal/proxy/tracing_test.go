@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+	"bitbucket.org/atlassian-developers/proximity/internal/tracing"
+)
+
+func TestTracing(t *testing.T) {
+	var upstreamTraceparent string
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("traceparent")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(fmt.Sprintf(`
+uriGroups:
+  - name: Test
+    supportedUris:
+      - in: /chat
+        baseEndpoint: %s
+        out:
+          - method: POST
+            text: /upstream
+overrides:
+  uris:
+    /chat:
+      POST:
+        request:
+          headers:
+            - op: add
+              name: Authorization
+              expr: '"slauth " + slauthtoken([], "audience", "staging")'
+`, upstream.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	traceFile := filepath.Join(t.TempDir(), "traces.jsonl")
+
+	tracer, err := tracing.New(tracing.Options{File: traceFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer()
+	s.Logger = logging.Discard()
+	s.Tracer = tracer
+	s.renderer.SetTracer(tracer)
+	s.renderer.SetTokenSource(func(groups []string, audience string, environment string) (string, error) {
+		return "token", nil
+	})
+
+	router, err := s.buildRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := readTraceFile(t, traceFile)
+
+	for _, name := range []string{"POST /chat", "render request headers", "slauth token", "proxy upstream", "HTTP POST", "render response body"} {
+		if _, ok := spans[name]; !ok {
+			t.Errorf("Expected a %q span, got: %v", name, spans)
+		}
+	}
+
+	for name, span := range spans {
+		if span.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("Expected span %q to continue the trace of the request, got: %s", name, span.TraceId)
+		}
+	}
+
+	parents := map[string]string{
+		"POST /chat":             "00f067aa0ba902b7",
+		"render request headers": spans["POST /chat"].SpanId,
+		"slauth token":           spans["render request headers"].SpanId,
+		"proxy upstream":         spans["POST /chat"].SpanId,
+		"HTTP POST":              spans["proxy upstream"].SpanId,
+	}
+
+	for name, parent := range parents {
+		if spans[name].ParentSpanId != parent {
+			t.Errorf("Expected span %q to have parent %s, got: %s", name, parent, spans[name].ParentSpanId)
+		}
+	}
+
+	expectedTraceparent := fmt.Sprintf("00-4bf92f3577b34da6a3ce929d0e0e4736-%s-01", spans["HTTP POST"].SpanId)
+
+	if upstreamTraceparent != expectedTraceparent {
+		t.Errorf("Expected the upstream to receive traceparent %s, got: %s", expectedTraceparent, upstreamTraceparent)
+	}
+}
+
+type tracedSpan struct {
+	TraceId      string `json:"traceId"`
+	SpanId       string `json:"spanId"`
+	ParentSpanId string `json:"parentSpanId"`
+	Name         string `json:"name"`
+}
+
+// readTraceFile returns the spans written by a file exporter by their name.
+func readTraceFile(t *testing.T, path string) map[string]tracedSpan {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	spans := make(map[string]tracedSpan)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+
+	for scanner.Scan() {
+		var batch struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []tracedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+
+		if err := json.Unmarshal(scanner.Bytes(), &batch); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, resourceSpans := range batch.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				for _, span := range scopeSpans.Spans {
+					spans[span.Name] = span
+				}
+			}
+		}
+	}
+
+	return spans
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/translate"
+)
+
+// transformRequestBody translates the request body with the body override's transform. The template input is
+// returned with the translated body so that the rest of the overrides work on it.
+func (s *server) transformRequestBody(req *http.Request, templateInput map[string]any, bodyOverride config.Body) (map[string]any, error) {
+	transformer, ok := translate.Get(bodyOverride.Transform)
+	if !ok {
+		return templateInput, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	transformed, err := transformer.Request(body)
+	if err != nil {
+		return nil, err
+	}
+
+	s.applyNewBodyToRequest(req, transformed)
+	return withBody(templateInput, transformed), nil
+}
+
+// transformResponseBody translates a buffered response body, the same as transformRequestBody.
+func (s *server) transformResponseBody(res *http.Response, templateInput map[string]any, bodyOverride config.Body) (map[string]any, error) {
+	transformer, ok := translate.Get(bodyOverride.Transform)
+	if !ok {
+		return templateInput, nil
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	transformed, err := transformer.Response(body)
+	if err != nil {
+		return nil, err
+	}
+
+	s.applyNewBodyToResponse(res, transformed)
+	return withBody(templateInput, transformed), nil
+}
+
+// withBody returns a copy of the template input with a new body, parsed as json when it can be.
+func withBody(templateInput map[string]any, body []byte) map[string]any {
+	updated := maps.Clone(templateInput)
+
+	var bodyMap map[string]any
+
+	if err := json.Unmarshal(body, &bodyMap); err != nil {
+		updated["body"] = body
+	} else {
+		updated["body"] = bodyMap
+	}
+
+	return updated
+}
+
+// sseTransformer translates the events of a streamed response. A nil transformer passes events through as they are.
+type sseTransformer struct {
+	stream translate.Stream
+}
+
+func newSseTransformer(bodyOverride config.Body) *sseTransformer {
+	transformer, ok := translate.Get(bodyOverride.Transform)
+	if !ok {
+		return nil
+	}
+
+	return &sseTransformer{stream: transformer.Stream()}
+}
+
+func (t *sseTransformer) event(event *sseEvent) ([]*sseEvent, error) {
+	// Comments and events without data are passed through, e.g. keep-alives
+	if t == nil || !event.HasData {
+		return []*sseEvent{event}, nil
+	}
+
+	translated, err := t.stream.Event(translate.Event{Name: event.Name, Data: event.Data})
+	return sseEventsFromTranslated(translated), err
+}
+
+// end returns the events to send once the upstream's stream has ended.
+func (t *sseTransformer) end() ([]*sseEvent, error) {
+	if t == nil {
+		return nil, nil
+	}
+
+	translated, err := t.stream.End()
+	return sseEventsFromTranslated(translated), err
+}
+
+func sseEventsFromTranslated(translated []translate.Event) []*sseEvent {
+	events := make([]*sseEvent, 0, len(translated))
+
+	for _, event := range translated {
+		events = append(events, &sseEvent{Name: event.Name, Data: event.Data, HasData: true})
+	}
+
+	return events
+}
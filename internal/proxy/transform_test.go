@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+)
+
+func TestTransform(t *testing.T) {
+	var upstreamBody string
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		upstreamBody = string(body)
+
+		if strings.Contains(upstreamBody, `"stream":true`) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"model\":\"claude\",\"usage\":{\"input_tokens\":1}}}\n\n"))
+			w.Write([]byte(": keep-alive\n\n"))
+			w.Write([]byte("event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}\n\n"))
+			w.Write([]byte("event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"))
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1","model":"claude","content":[{"type":"text","text":"Hi"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`))
+	}))
+	defer upstream.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(fmt.Sprintf(`
+uriGroups:
+  - name: Test
+    errorFormat: openai
+    supportedUris:
+      - in: /chat
+        baseEndpoint: %s
+        out:
+          - method: POST
+            text: /messages
+overrides:
+  uris:
+    /chat:
+      POST:
+        request:
+          body:
+            transform: openai-chat-to-anthropic
+            expr: 'toCompactJson(merge(body, { anthropic_version: "test" }))'
+        response:
+          body:
+            transform: openai-chat-to-anthropic
+`, upstream.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer()
+	s.Logger = logging.Discard()
+
+	router, err := s.buildRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		upstream string
+		expected []string
+	}{
+		{
+			name:     "buffered",
+			body:     `{"model":"claude","messages":[{"role":"system","content":"Be brief"},{"role":"user","content":"Hello"}]}`,
+			status:   http.StatusOK,
+			upstream: `{"anthropic_version":"test","max_tokens":8192,"messages":[{"content":[{"text":"Hello","type":"text"}],"role":"user"}],"model":"claude","system":[{"text":"Be brief","type":"text"}]}`,
+			expected: []string{`"object":"chat.completion"`, `"message":{"role":"assistant","content":"Hi"}`, `"finish_reason":"stop"`},
+		},
+		{
+			name:   "streamed",
+			body:   `{"model":"claude","stream":true,"messages":[{"role":"user","content":"Hello"}]}`,
+			status: http.StatusOK,
+			expected: []string{
+				`data: {"id":"msg_1","object":"chat.completion.chunk","created":`,
+				": keep-alive\n\n",
+				`"delta":{"content":"Hi"}`,
+				"data: [DONE]\n\n",
+			},
+		},
+		{
+			name:     "untranslatable request",
+			body:     `{"model":"claude","n":3,"messages":[{"role":"user","content":"Hello"}]}`,
+			status:   http.StatusBadRequest,
+			expected: []string{`{"error":{"code":"invalid_request_error","message":"n greater than 1 isn't supported by Claude"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamBody = ""
+
+			req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got: %d %s", tt.status, rec.Code, rec.Body.String())
+			}
+
+			if tt.upstream != "" && upstreamBody != tt.upstream {
+				t.Errorf("Expected the upstream to get %s, got: %s", tt.upstream, upstreamBody)
+			}
+
+			for _, expected := range tt.expected {
+				if !strings.Contains(rec.Body.String(), expected) {
+					t.Errorf("Expected the response to contain %q, got: %s", expected, rec.Body.String())
+				}
+			}
+		})
+	}
+}
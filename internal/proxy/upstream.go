@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+)
+
+// upstream is a resolved config.Upstream
+type upstream struct {
+	url      *url.URL
+	weight   int
+	priority int
+}
+
+// resolveUpstreams resolves the upstreams of a uri, falling back to the global base endpoint if the uri doesn't set
+// its own.
+func (s *server) resolveUpstreams(cfg *config.Config, uriMap config.UriMap) ([]*upstream, error) {
+	upstreamCfgs := []config.Upstream(cfg.BaseEndpoint)
+
+	if len(uriMap.BaseEndpoint) > 0 {
+		upstreamCfgs = uriMap.BaseEndpoint
+	}
+
+	if len(upstreamCfgs) == 0 {
+		return nil, fmt.Errorf("uri %s has no baseEndpoint", uriMap.In)
+	}
+
+	env := map[string]any{
+		"globalVars": s.Vars,
+	}
+
+	upstreams := make([]*upstream, 0, len(upstreamCfgs))
+
+	for _, upstreamCfg := range upstreamCfgs {
+		rawUrl := upstreamCfg.Url
+
+		if rawUrl == "" {
+			rawUrlBytes, err := s.renderer.RenderExpr(upstreamCfg.Expr, env, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to evaluate baseEndpoint expr: %w", err)
+			}
+
+			rawUrl = string(rawUrlBytes)
+		}
+
+		target, err := url.Parse(strings.TrimSpace(rawUrl))
+		if err != nil {
+			return nil, err
+		}
+
+		weight := upstreamCfg.Weight
+
+		if weight <= 0 {
+			weight = 1
+		}
+
+		upstreams = append(upstreams, &upstream{
+			url:      target,
+			weight:   weight,
+			priority: upstreamCfg.Priority,
+		})
+	}
+
+	return upstreams, nil
+}
+
+// orderUpstreams returns the order to try the upstreams in for a single request. Upstreams are tried by priority,
+// lowest first, and upstreams of the same priority are shuffled by weight so that load is spread between them.
+func orderUpstreams(upstreams []*upstream) []*upstream {
+	remaining := slices.Clone(upstreams)
+
+	sort.SliceStable(remaining, func(i, j int) bool {
+		return remaining[i].priority < remaining[j].priority
+	})
+
+	ordered := make([]*upstream, 0, len(remaining))
+
+	for len(remaining) > 0 {
+		// Upstreams of the current priority are at the start of the remaining upstreams
+		group := 1
+		totalWeight := remaining[0].weight
+
+		for group < len(remaining) && remaining[group].priority == remaining[0].priority {
+			totalWeight += remaining[group].weight
+			group++
+		}
+
+		pick := rand.IntN(totalWeight)
+		chosen := 0
+
+		for pick >= remaining[chosen].weight {
+			pick -= remaining[chosen].weight
+			chosen++
+		}
+
+		ordered = append(ordered, remaining[chosen])
+		remaining = slices.Delete(remaining, chosen, chosen+1)
+	}
+
+	return ordered
+}
+
+// failoverTransport sends a request to each upstream in turn until one of them responds without a connection error
+// or a failover status code. As the response of a failed attempt is discarded before it's returned to the reverse
+// proxy, nothing has been sent to the client when failing over.
+type failoverTransport struct {
+	transport   http.RoundTripper
+	upstreams   []*upstream
+	statusCodes []int
+	logger      *slog.Logger
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The body has to be replayable to send it to more than one upstream
+	if len(t.upstreams) > 1 {
+		if err := makeBodyReplayable(req); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, target := range t.upstreams {
+		last := i == len(t.upstreams)-1
+
+		attempt := req.Clone(req.Context())
+		rewriteRequestURL(attempt, target.url)
+
+		if i > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+
+			attempt.Body = body
+		}
+
+		res, err := t.transport.RoundTrip(attempt)
+
+		if err != nil {
+			// The client has gone away, there's nobody to fail over for
+			if last || req.Context().Err() != nil {
+				return nil, err
+			}
+
+			t.logger.WarnContext(req.Context(), "upstream failed, failing over", "upstream", target.url.Host, "error", err)
+			continue
+		}
+
+		if !last && slices.Contains(t.statusCodes, res.StatusCode) {
+			t.logger.WarnContext(req.Context(), "upstream responded with a failover status, failing over",
+				"upstream", target.url.Host, "status", res.StatusCode,
+			)
+			res.Body.Close()
+			continue
+		}
+
+		t.logger.InfoContext(req.Context(), "request served by upstream",
+			"method", req.Method, "path", attempt.URL.Path, "upstream", target.url.Host, "status", res.StatusCode,
+		)
+		return res, nil
+	}
+
+	return nil, fmt.Errorf("no upstreams for %s", req.URL.Path)
+}
+
+// rewriteRequestURL points the request at the target the same way as httputil.NewSingleHostReverseProxy. The Host
+// header is left as it is.
+func rewriteRequestURL(req *http.Request, target *url.URL) {
+	targetQuery := target.RawQuery
+
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	req.URL.Path = singleJoiningSlash(target.Path, req.URL.Path)
+	req.URL.RawPath = ""
+
+	if targetQuery == "" || req.URL.RawQuery == "" {
+		req.URL.RawQuery = targetQuery + req.URL.RawQuery
+	} else {
+		req.URL.RawQuery = targetQuery + "&" + req.URL.RawQuery
+	}
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+
+	return a + b
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+)
+
+func TestOrderUpstreams(t *testing.T) {
+	primary := &upstream{weight: 3, priority: 0}
+	secondary := &upstream{weight: 1, priority: 0}
+	backup := &upstream{weight: 1, priority: 1}
+
+	firsts := map[*upstream]int{}
+
+	for i := 0; i < 1000; i++ {
+		ordered := orderUpstreams([]*upstream{backup, secondary, primary})
+
+		if len(ordered) != 3 || ordered[2] != backup {
+			t.Fatalf("Expected the backup upstream last, got: %v", ordered)
+		}
+
+		firsts[ordered[0]]++
+	}
+
+	// Primary should be first roughly three quarters of the time
+	if firsts[primary] < 650 || firsts[primary] > 850 {
+		t.Errorf("Expected primary first about 750 times, got: %d", firsts[primary])
+	}
+}
+
+func TestFailover(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	throttled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer throttled.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", r.URL.Path, body)
+	}))
+	defer healthy.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(fmt.Sprintf(`
+failover:
+  statusCodes: [429, 503]
+uriGroups:
+  - name: Test
+    supportedUris:
+      - in: /chat
+        baseEndpoint:
+          - url: %s
+          - url: %s/region
+            priority: 1
+          - url: %s/backup
+            priority: 2
+        out:
+          - method: POST
+            text: /upstream
+`, down.URL, throttled.URL, healthy.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logs strings.Builder
+
+	s := newTestServer()
+	s.Logger, err = logging.New(&logs, logging.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := s.buildRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader("hello")))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got: %d", rec.Code)
+	}
+
+	if rec.Body.String() != "/backup/upstream hello" {
+		t.Errorf("Expected the body to be replayed to the backup upstream, got: %s", rec.Body.String())
+	}
+
+	if !strings.Contains(logs.String(), "upstream="+strings.TrimPrefix(healthy.URL, "http://")) {
+		t.Errorf("Expected the serving upstream to be logged, got: %s", logs.String())
+	}
+}
+
+func TestFailoverLastUpstreamStatusIsReturned(t *testing.T) {
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	transport := &failoverTransport{
+		transport:   http.DefaultTransport,
+		upstreams:   []*upstream{{url: mustParseURL(t, unavailable.URL)}, {url: mustParseURL(t, unavailable.URL)}},
+		statusCodes: []int{http.StatusServiceUnavailable},
+		logger:      logging.Discard(),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RequestURI = ""
+
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got: %d", res.StatusCode)
+	}
+}
+
+func mustParseURL(t *testing.T, rawUrl string) *url.URL {
+	t.Helper()
+
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return parsed
+}
@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/usage"
+)
+
+type usageKey struct{}
+
+// usageRecorder counts the tokens used by a request from its response and adds them to the store, and to the limits
+// the request was counted against, once the response has been read.
+type usageRecorder struct {
+	s   *server
+	ctx context.Context
+
+	key usage.Key
+
+	// model is the model configured for the usage, requestModel is used when neither it nor the response has one
+	model        string
+	requestModel string
+
+	// limitKeys are the limits the tokens count towards
+	limitKeys []string
+
+	mu      sync.Mutex
+	counter usage.Counter
+}
+
+// withUsage adds a recorder for the usage of the request to its context. Nothing is recorded when there's no usage
+// store and no limits.
+func (s *server) withUsage(r *http.Request, cfg *endpointProxyConfig, templateInput map[string]any) *http.Request {
+	if s.Usage == nil && len(cfg.limits) == 0 {
+		return r
+	}
+
+	rec := &usageRecorder{
+		s:            s,
+		ctx:          r.Context(),
+		requestModel: requestModel(templateInput),
+	}
+
+	fields := []struct {
+		name   string
+		input  config.Input
+		output *string
+	}{
+		{"profile", cfg.usage.Profile, &rec.key.Profile},
+		{"useCaseId", cfg.usage.UseCaseId, &rec.key.UseCaseId},
+		{"model", cfg.usage.Model, &rec.model},
+	}
+
+	for _, field := range fields {
+		rendered, err := s.renderText(field.input, templateInput)
+		if err != nil {
+			s.Logger.WarnContext(r.Context(), "failed to render usage", "field", field.name, "error", err)
+			continue
+		}
+
+		*field.output = rendered
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), usageKey{}, rec))
+}
+
+// usageFromContext returns the usage recorder of a request, the recorder is nil when usage isn't being recorded.
+func usageFromContext(ctx context.Context) *usageRecorder {
+	rec, _ := ctx.Value(usageKey{}).(*usageRecorder)
+	return rec
+}
+
+// add counts a decoded response body.
+func (rec *usageRecorder) add(body any) {
+	if rec == nil {
+		return
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if data, ok := body.([]byte); ok {
+		rec.counter.AddJson(data)
+		return
+	}
+
+	rec.counter.Add(body)
+}
+
+// addSseLine counts the data of a raw stream line.
+func (rec *usageRecorder) addSseLine(line string) {
+	if rec == nil {
+		return
+	}
+
+	data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+	if !ok {
+		return
+	}
+
+	rec.add([]byte(data))
+}
+
+// record adds the tokens counted to the store and limits, nothing is added when the response had no usage.
+func (rec *usageRecorder) record() {
+	if rec == nil {
+		return
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	tokens, ok := rec.counter.Tokens()
+	if !ok {
+		return
+	}
+
+	key := rec.key
+	key.Day = time.Now().Format(usage.DayFormat)
+	key.Model = firstNonEmpty(rec.model, rec.counter.Model(), rec.requestModel)
+
+	if rec.s.Usage != nil {
+		if err := rec.s.Usage.Add(key, tokens); err != nil {
+			rec.s.Logger.ErrorContext(rec.ctx, "failed to record usage", "error", err)
+		}
+	}
+
+	if err := rec.s.Limiter.AddTokens(rec.limitKeys, tokens.Input+tokens.Output); err != nil {
+		rec.s.Logger.ErrorContext(rec.ctx, "failed to count tokens against limits", "error", err)
+	}
+}
+
+// requestModel returns the model named in the request body or path.
+func requestModel(templateInput map[string]any) string {
+	if body, ok := templateInput["body"].(map[string]any); ok {
+		if model, ok := body["model"].(string); ok && model != "" {
+			return model
+		}
+	}
+
+	if pathParams, ok := templateInput["pathParams"].(map[string]string); ok {
+		return pathParams["model"]
+	}
+
+	return ""
+}
+
+// renderText renders an input which is text, a template or an expr. An expr which returns nil renders as empty.
+func (s *server) renderText(input config.Input, templateInput map[string]any) (string, error) {
+	if strings.TrimSpace(input.Expr) != "" {
+		output, err := s.renderer.EvalExpr(input.Expr, templateInput, nil)
+		if err != nil || output == nil {
+			return "", err
+		}
+
+		return fmt.Sprint(output), nil
+	}
+
+	if strings.TrimSpace(input.Template) != "" {
+		rendered, err := s.renderer.RenderTemplate(input.Template, templateInput, nil)
+		return string(rendered), err
+	}
+
+	return input.Text, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// serveUsage reports the usage recorded, filtered by the from, to, profile, useCaseId and model query parameters.
+func (s *server) serveUsage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	report, err := s.Usage.Report(usage.Filter{
+		From:      query.Get("from"),
+		To:        query.Get("to"),
+		Profile:   query.Get("profile"),
+		UseCaseId: query.Get("useCaseId"),
+		Model:     query.Get("model"),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+	"bitbucket.org/atlassian-developers/proximity/internal/usage"
+)
+
+func TestRecordUsage(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/openai":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"model":"gpt-4o-2024","usage":{"prompt_tokens":10,"completion_tokens":4}}`))
+		case "/anthropic":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":20,\"output_tokens\":1}}}\n\n"))
+			w.Write([]byte("event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":6}}\n\n"))
+		case "/gemini":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: {\"modelVersion\":\"gemini-2.5-pro\",\"usageMetadata\":{\"promptTokenCount\":3,\"candidatesTokenCount\":2}}\n\n"))
+		case "/none":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer upstream.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(fmt.Sprintf(`
+usage:
+  profile:
+    expr: get(headers, "X-Profile")?.[0] ?? "default"
+  useCaseId:
+    text: use-case
+uriGroups:
+  - name: Test
+    supportedUris:
+      - in: /openai
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            text: /openai
+      - in: /anthropic
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            text: /anthropic
+      - in: /gemini/{model}
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            text: /gemini
+      - in: /none
+        baseEndpoint: %[1]s
+        out:
+          - method: POST
+            text: /none
+overrides:
+  uris:
+    /gemini/{model}:
+      POST:
+        response:
+          body:
+            sse: events
+`, upstream.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer()
+	s.Logger = logging.Discard()
+	s.Usage = usage.NewStore("")
+
+	router, err := s.buildRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []struct {
+		path    string
+		profile string
+		body    string
+	}{
+		{"/openai", "a", `{"model":"gpt-4o"}`},
+		{"/anthropic", "", `{"model":"claude"}`},
+		{"/gemini/gemini-2.5-pro", "a", `{}`},
+		{"/none", "a", `{"model":"gpt-4o"}`},
+	}
+
+	for _, tt := range requests {
+		req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+
+		if tt.profile != "" {
+			req.Header.Set("X-Profile", tt.profile)
+		}
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s, got: %d", tt.path, rec.Code)
+		}
+	}
+
+	report, err := s.Usage.Report(usage.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	today := time.Now().Format(usage.DayFormat)
+
+	expected := []usage.Record{
+		{
+			Key:    usage.Key{Day: today, Profile: "a", UseCaseId: "use-case", Model: "gemini-2.5-pro"},
+			Totals: usage.Totals{Requests: 1, InputTokens: 3, OutputTokens: 2},
+		},
+		{
+			Key:    usage.Key{Day: today, Profile: "a", UseCaseId: "use-case", Model: "gpt-4o-2024"},
+			Totals: usage.Totals{Requests: 1, InputTokens: 10, OutputTokens: 4},
+		},
+		{
+			Key:    usage.Key{Day: today, Profile: "default", UseCaseId: "use-case", Model: "claude"},
+			Totals: usage.Totals{Requests: 1, InputTokens: 20, OutputTokens: 6},
+		},
+	}
+
+	if len(report.Records) != len(expected) {
+		t.Fatalf("Expected %d records, got: %+v", len(expected), report.Records)
+	}
+
+	for i, record := range report.Records {
+		if record != expected[i] {
+			t.Errorf("Expected %+v, got: %+v", expected[i], record)
+		}
+	}
+}
+
+func TestServeUsage(t *testing.T) {
+	s := newTestServer()
+	s.Usage = usage.NewStore("")
+
+	s.Usage.Add(usage.Key{Day: "2026-01-01", Profile: "a"}, usage.Tokens{Input: 1, Output: 2})
+	s.Usage.Add(usage.Key{Day: "2026-01-02", Profile: "b"}, usage.Tokens{Input: 3, Output: 4})
+
+	rec := httptest.NewRecorder()
+	s.serveUsage(rec, httptest.NewRequest(http.MethodGet, "/usage?from=2026-01-02", nil))
+
+	var report usage.Report
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Records) != 1 || report.Records[0].Profile != "b" || report.Total.OutputTokens != 4 {
+		t.Errorf("Expected only the usage from 2026-01-02, got: %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,322 @@
+package redact
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Mask replaces every redacted value
+const Mask = "[REDACTED]"
+
+// minValueLength is the shortest secret value which is masked, shorter ones would mask unrelated text
+const minValueLength = 4
+
+// DefaultHeaders carry credentials and are always masked
+var DefaultHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"Api-Key",
+	"X-Amz-Security-Token",
+	"X-Slauth-Token",
+}
+
+// jwtPattern matches JWTs, whose header and payload are base64 encoded json objects so they start with eyJ
+var jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+
+type Options struct {
+	// Headers are masked in addition to DefaultHeaders
+	Headers []string
+
+	// JsonPaths are JSON pointers to the values masked in JSON bodies, * matches any key or index
+	JsonPaths []string
+
+	// Values are secrets masked wherever they appear
+	Values []string
+}
+
+// Redactor masks secrets in headers, bodies and log records. JWTs are always masked. A Redactor is safe to use
+// concurrently and its options can be changed while it's in use.
+type Redactor struct {
+	mu        sync.RWMutex
+	headers   map[string]bool
+	jsonPaths [][]string
+	values    []string
+}
+
+func New(options Options) *Redactor {
+	r := &Redactor{}
+	r.SetOptions(options)
+
+	return r
+}
+
+// SetOptions replaces what the redactor masks.
+func (r *Redactor) SetOptions(options Options) {
+	headers := make(map[string]bool)
+
+	for _, name := range append(append([]string{}, DefaultHeaders...), options.Headers...) {
+		headers[strings.ToLower(name)] = true
+	}
+
+	jsonPaths := make([][]string, 0, len(options.JsonPaths))
+
+	for _, path := range options.JsonPaths {
+		jsonPaths = append(jsonPaths, splitJsonPointer(path))
+	}
+
+	values := []string{}
+
+	for _, value := range options.Values {
+		if len(value) >= minValueLength {
+			values = append(values, value)
+		}
+	}
+
+	// Longer values first so that a value containing another is masked as a whole
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	r.mu.Lock()
+	r.headers = headers
+	r.jsonPaths = jsonPaths
+	r.values = values
+	r.mu.Unlock()
+}
+
+// IsSensitiveHeader reports whether the values of the header are masked.
+func (r *Redactor) IsSensitiveHeader(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.headers[strings.ToLower(name)]
+}
+
+// String masks JWTs and secret values in s.
+func (r *Redactor) String(s string) string {
+	r.mu.RLock()
+	values := r.values
+	r.mu.RUnlock()
+
+	for _, value := range values {
+		s = strings.ReplaceAll(s, value, Mask)
+	}
+
+	return jwtPattern.ReplaceAllString(s, Mask)
+}
+
+// Header returns a copy of the header with the sensitive headers and any secrets in the others masked.
+func (r *Redactor) Header(header http.Header) http.Header {
+	redacted := make(http.Header, len(header))
+
+	for name, values := range header {
+		redactedValues := make([]string, len(values))
+
+		for i, value := range values {
+			if r.IsSensitiveHeader(name) {
+				redactedValues[i] = Mask
+			} else {
+				redactedValues[i] = r.String(value)
+			}
+		}
+
+		redacted[name] = redactedValues
+	}
+
+	return redacted
+}
+
+// Body masks the JSON paths and any secrets in a JSON body. A body which isn't JSON only has its secrets masked.
+func (r *Redactor) Body(body []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var doc any
+
+	if err := decoder.Decode(&doc); err != nil || decoder.More() {
+		return []byte(r.String(string(body)))
+	}
+
+	r.mu.RLock()
+	jsonPaths := r.jsonPaths
+	r.mu.RUnlock()
+
+	for _, path := range jsonPaths {
+		doc = maskPath(doc, path)
+	}
+
+	redacted, err := json.Marshal(r.Value(doc))
+	if err != nil {
+		return []byte(r.String(string(body)))
+	}
+
+	return redacted
+}
+
+// Value masks secrets in a value, walking into maps and slices. Map keys which are sensitive header names have their
+// values masked.
+func (r *Redactor) Value(value any) any {
+	switch v := value.(type) {
+	case string:
+		return r.String(v)
+	case error:
+		return r.String(v.Error())
+	case http.Header:
+		return r.Header(v)
+	case map[string]any:
+		redacted := make(map[string]any, len(v))
+
+		for key, item := range v {
+			if r.IsSensitiveHeader(key) {
+				redacted[key] = Mask
+			} else {
+				redacted[key] = r.Value(item)
+			}
+		}
+
+		return redacted
+	case map[string]string:
+		redacted := make(map[string]string, len(v))
+
+		for key, item := range v {
+			if r.IsSensitiveHeader(key) {
+				redacted[key] = Mask
+			} else {
+				redacted[key] = r.String(item)
+			}
+		}
+
+		return redacted
+	case []any:
+		redacted := make([]any, len(v))
+
+		for i, item := range v {
+			redacted[i] = r.Value(item)
+		}
+
+		return redacted
+	case []string:
+		redacted := make([]string, len(v))
+
+		for i, item := range v {
+			redacted[i] = r.String(item)
+		}
+
+		return redacted
+	}
+
+	return value
+}
+
+// splitJsonPointer splits a JSON pointer such as /messages/*/content into its unescaped segments.
+func splitJsonPointer(pointer string) []string {
+	pointer = strings.TrimPrefix(pointer, "/")
+	if pointer == "" {
+		return []string{}
+	}
+
+	segments := strings.Split(pointer, "/")
+
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(strings.ReplaceAll(segment, "~1", "/"), "~0", "~")
+	}
+
+	return segments
+}
+
+// maskPath replaces the values at the path within node with the mask.
+func maskPath(node any, path []string) any {
+	if len(path) == 0 {
+		return Mask
+	}
+
+	segment, rest := path[0], path[1:]
+
+	switch v := node.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if segment == "*" || segment == key {
+				v[key] = maskPath(item, rest)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			if segment == "*" || segment == strconv.Itoa(i) {
+				v[i] = maskPath(item, rest)
+			}
+		}
+	}
+
+	return node
+}
+
+// Handler wraps a log handler so that the messages and attributes of its records are redacted.
+func (r *Redactor) Handler(handler slog.Handler) slog.Handler {
+	return &logHandler{Handler: handler, redactor: r}
+}
+
+type logHandler struct {
+	slog.Handler
+	redactor *Redactor
+}
+
+func (h *logHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, h.redactor.String(record.Message), record.PC)
+
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(h.redactor.attr(attr))
+		return true
+	})
+
+	return h.Handler.Handle(ctx, redacted)
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+
+	for i, attr := range attrs {
+		redacted[i] = h.redactor.attr(attr)
+	}
+
+	return &logHandler{Handler: h.Handler.WithAttrs(redacted), redactor: h.redactor}
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	return &logHandler{Handler: h.Handler.WithGroup(name), redactor: h.redactor}
+}
+
+// attr redacts a log attribute, attributes named after a sensitive header are masked as a whole.
+func (r *Redactor) attr(attr slog.Attr) slog.Attr {
+	attr.Value = attr.Value.Resolve()
+
+	if r.IsSensitiveHeader(attr.Key) {
+		return slog.String(attr.Key, Mask)
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, r.String(attr.Value.String()))
+	case slog.KindGroup:
+		group := attr.Value.Group()
+		redacted := make([]any, len(group))
+
+		for i, groupAttr := range group {
+			redacted[i] = r.attr(groupAttr)
+		}
+
+		return slog.Group(attr.Key, redacted...)
+	case slog.KindAny:
+		return slog.Any(attr.Key, r.Value(attr.Value.Any()))
+	}
+
+	return attr
+}
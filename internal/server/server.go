@@ -2,32 +2,154 @@ package server
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"bitbucket.org/atlassian-developers/proximity/internal/catalog"
 	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/exchange"
+	"bitbucket.org/atlassian-developers/proximity/internal/limits"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+	"bitbucket.org/atlassian-developers/proximity/internal/mockupstream"
 	"bitbucket.org/atlassian-developers/proximity/internal/proxy"
+	"bitbucket.org/atlassian-developers/proximity/internal/template"
+	"bitbucket.org/atlassian-developers/proximity/internal/tracing"
+	"bitbucket.org/atlassian-developers/proximity/internal/usage"
 )
 
-func RunServer(cfg *config.Config, port int, vars map[string]any) error {
-	logger := log.Default()
+const (
+	configPollInterval = 2 * time.Second
+)
+
+type Options struct {
+	Port int
+
+	// AdminPort serves metrics on /metrics, and the usage recorded on /usage, when set
+	AdminPort int
+
+	// Generic global variables provided to the config for rendering
+	Vars map[string]any
+
+	// ConfigPath is the file the config was loaded from. When set the file is watched for changes and reloaded, and
+	// SIGHUP triggers a reload.
+	ConfigPath string
+
+	// RecordDir is where upstream exchanges are written to when recording
+	RecordDir string
+
+	// ReplayDir holds recorded exchanges which are served instead of contacting upstreams when replaying
+	ReplayDir string
+
+	// MockUpstream answers upstream requests with the built-in mock instead of contacting upstreams, and provides
+	// slauth tokens without requesting them
+	MockUpstream bool
+
+	// OtlpEndpoint is the OTLP/HTTP traces url spans are sent to
+	OtlpEndpoint string
+
+	// TraceFile is where spans are written to as OTLP JSON for inspecting offline
+	TraceFile string
+
+	// LogFormat is text or json
+	LogFormat string
+
+	// LogLevel is the minimum level logged
+	LogLevel string
+
+	// UsageFile is where the tokens used by requests are aggregated, nothing is recorded when it's empty
+	UsageFile string
+
+	// LimitsFile is where the state of the config's limits is kept so that it survives restarts, it's kept in memory
+	// when empty
+	LimitsFile string
+
+	// ModelsFile holds the user's model aliases, which are added to the built-in catalog
+	ModelsFile string
+
+	// HarFile is where the exchanges handled are written as a HAR file on shutdown
+	HarFile string
+
+	Version string
+}
+
+func RunServer(cfg *config.Config, options Options) error {
+	if options.RecordDir != "" && options.ReplayDir != "" {
+		return fmt.Errorf("recording and replaying can't be used together")
+	}
+
+	logger, err := logging.New(os.Stderr, logging.Options{Format: options.LogFormat, Level: options.LogLevel})
+	if err != nil {
+		return err
+	}
+
+	tracer, err := tracing.New(tracing.Options{
+		OtlpEndpoint:   options.OtlpEndpoint,
+		File:           options.TraceFile,
+		ServiceVersion: options.Version,
+		Logger:         logger,
+	})
+	if err != nil {
+		return err
+	}
+
+	models, err := catalog.Load(options.ModelsFile)
+	if err != nil {
+		return err
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go awaitStopSignal(cancel, logger)
 
-	options := proxy.Options{
-		Port:   port,
-		Logger: logger,
-		Config: cfg,
-		Vars:   vars,
+	proxyOptions := proxy.Options{
+		Port:      options.Port,
+		AdminPort: options.AdminPort,
+		Logger:    logger,
+		Config:    cfg,
+		Vars:      options.Vars,
+		Version:   options.Version,
+
+		RecordDir: options.RecordDir,
+		ReplayDir: options.ReplayDir,
+
+		Limiter: limits.New(options.LimitsFile),
+		Tracer:  tracer,
+		Catalog: models,
+	}
+
+	if options.RecordDir != "" {
+		logger.Info("recording upstream exchanges", "dir", options.RecordDir)
+	}
+
+	if options.MockUpstream {
+		proxyOptions.Transport = mockupstream.Transport(mockupstream.New(mockupstream.Options{}))
+		proxyOptions.TokenSource = func(groups []string, audience string, environment string) (string, error) {
+			return "mock-token", nil
+		}
+
+		logger.Info("answering upstream requests with the mock upstream, upstreams won't be contacted")
+	}
+
+	if options.UsageFile != "" {
+		proxyOptions.Usage = usage.NewStore(options.UsageFile)
+	}
+
+	if options.HarFile != "" {
+		proxyOptions.Exchanges = exchange.NewStore(exchange.DefaultCapacity)
+		logger.Info("capturing exchanges, they'll be written on shutdown", "file", options.HarFile)
+	}
+
+	if options.ReplayDir != "" {
+		logger.Info("replaying upstream exchanges, upstreams won't be contacted", "dir", options.ReplayDir)
 	}
 
-	p := proxy.New(options)
+	p := proxy.New(proxyOptions)
 
 	go p.RunServer(ctx)
+	go watchConfig(ctx, p, options.ConfigPath, logger)
 
 	<-ctx.Done()
 
@@ -38,16 +160,130 @@ func RunServer(cfg *config.Config, port int, vars map[string]any) error {
 		return err
 	}
 
-	logger.Println("successfully shut down the proxy")
+	if err := proxyOptions.Limiter.Flush(); err != nil {
+		return err
+	}
+
+	if proxyOptions.Usage != nil {
+		if err := proxyOptions.Usage.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if err := tracer.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+
+	if options.HarFile != "" {
+		if err := writeHar(options.HarFile, proxyOptions.Exchanges, options.Version); err != nil {
+			return err
+		}
+
+		logger.Info("wrote exchanges", "file", options.HarFile)
+	}
+
+	logger.Info("successfully shut down the proxy")
 	return nil
 }
 
-func awaitStopSignal(cancelFunc context.CancelFunc, logger *log.Logger) {
+func writeHar(path string, exchanges *exchange.Store, version string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to write exchanges: %w", err)
+	}
+	defer f.Close()
+
+	return exchange.WriteHar(f, exchanges.All(), version)
+}
+
+func awaitStopSignal(cancelFunc context.CancelFunc, logger *slog.Logger) {
 	defer cancelFunc()
 
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 	sig := <-signalChan
 
-	logger.Print("signal received: ", sig)
+	logger.Info("signal received", "signal", sig.String())
+}
+
+// watchConfig reloads the config whenever the file changes or SIGHUP is received until the context is cancelled.
+func watchConfig(ctx context.Context, p proxy.Interface, configPath string, logger *slog.Logger) {
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	defer signal.Stop(hupChan)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	lastModified := configModTime(configPath)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hupChan:
+			logger.Info("signal received", "signal", syscall.SIGHUP.String())
+
+			if configPath == "" {
+				logger.Warn("no config file to reload, the config is embedded")
+				continue
+			}
+
+			reloadConfig(p, configPath, logger)
+		case <-ticker.C:
+			if configPath == "" {
+				continue
+			}
+
+			modified := configModTime(configPath)
+
+			if modified.IsZero() || modified.Equal(lastModified) {
+				continue
+			}
+
+			lastModified = modified
+			reloadConfig(p, configPath, logger)
+		}
+	}
+}
+
+// reloadConfig validates the config file and swaps it into the proxy. An invalid config is rejected and the proxy
+// carries on with the config it already has.
+func reloadConfig(p proxy.Interface, configPath string, logger *slog.Logger) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		logger.Error("failed to reload config, keeping the current config", "error", err)
+		return
+	}
+
+	if err := config.Validate(data, template.NewRenderer(logger)); err != nil {
+		logger.Error("rejected config reload, keeping the current config", "path", configPath, "error", err)
+		return
+	}
+
+	cfg, err := config.LoadFromBytes(data)
+	if err != nil {
+		logger.Error("failed to reload config, keeping the current config", "error", err)
+		return
+	}
+
+	if err := p.Reload(cfg); err != nil {
+		logger.Error("failed to reload config, keeping the current config", "error", err)
+		return
+	}
+
+	logger.Info("reloaded config", "path", configPath)
+}
+
+func configModTime(configPath string) time.Time {
+	if configPath == "" {
+		return time.Time{}
+	}
+
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
 }
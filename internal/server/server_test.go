@@ -0,0 +1,65 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+	"bitbucket.org/atlassian-developers/proximity/internal/proxy"
+)
+
+// reloadRecorder is a proxy which keeps the config it's reloaded with
+type reloadRecorder struct {
+	proxy.Interface
+	cfg *config.Config
+}
+
+func (p *reloadRecorder) Reload(cfg *config.Config) error {
+	p.cfg = cfg
+	return nil
+}
+
+const testConfig = `
+uriGroups:
+  - name: Test
+    supportedUris:
+      - in: /chat
+        baseEndpoint: http://localhost
+        out:
+          - method: POST
+            text: /chat
+`
+
+func TestReloadConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		reloaded bool
+	}{
+		{name: "valid config", config: testConfig, reloaded: true},
+		{name: "missing file", reloaded: false},
+		{name: "invalid yaml", config: "uriGroups: [", reloaded: false},
+		{name: "unknown key", config: testConfig + "unknown: true\n", reloaded: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+
+			if tt.config != "" {
+				if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			p := &reloadRecorder{}
+			reloadConfig(p, path, logging.Discard())
+
+			if reloaded := p.cfg != nil; reloaded != tt.reloaded {
+				t.Errorf("Expected reloaded to be %t, got: %t", tt.reloaded, reloaded)
+			}
+		})
+	}
+}
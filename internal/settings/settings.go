@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 
 	"github.com/BurntSushi/toml"
 	"gopkg.in/yaml.v3"
@@ -13,7 +15,18 @@ import (
 
 type Struct struct {
 	AutoStartProxy bool           `yaml:"autoStartProxy" toml:"autoStartProxy"`
+	AdminPort      int            `yaml:"adminPort" toml:"adminPort"`
+	LogLevel       string         `yaml:"logLevel" toml:"logLevel"`
+	Tracing        Tracing        `yaml:"tracing" toml:"tracing"`
 	Vars           map[string]any `yaml:"vars" toml:"vars"`
+
+	// SecretVars are the names of the vars marked with secret: true, their values are never logged or shown
+	SecretVars []string `yaml:"-" toml:"-" json:"-"`
+}
+
+type Tracing struct {
+	OtlpEndpoint string `yaml:"otlpEndpoint" toml:"otlpEndpoint"`
+	File         string `yaml:"file" toml:"file"`
 }
 
 var defaultSettings Struct = Struct{
@@ -56,9 +69,47 @@ func Read(settingsPath string) (*Struct, error) {
 			return nil, err
 		}
 
+		settings.resolveSecretVars()
+
 		return &settings, nil
 	}
 
 	// No file found
 	return &defaultSettings, nil
 }
+
+// resolveSecretVars unwraps the vars written as a value with a secret marker, such as
+//
+//	apiKey:
+//	  value: abc123
+//	  secret: true
+//
+// into their value, and records the names of the ones which are secret.
+func (s *Struct) resolveSecretVars() {
+	for name, value := range s.Vars {
+		marked, ok := value.(map[string]any)
+		if !ok || len(marked) != 2 {
+			continue
+		}
+
+		secret, isBool := marked["secret"].(bool)
+		markedValue, hasValue := marked["value"]
+
+		if !isBool || !hasValue {
+			continue
+		}
+
+		s.Vars[name] = markedValue
+
+		if secret {
+			s.SecretVars = append(s.SecretVars, name)
+		}
+	}
+
+	sort.Strings(s.SecretVars)
+}
+
+// IsSecretVar reports whether the var was marked as secret.
+func (s *Struct) IsSecretVar(name string) bool {
+	return slices.Contains(s.SecretVars, name)
+}
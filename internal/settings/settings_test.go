@@ -0,0 +1,50 @@
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadSecretVars(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	err := os.WriteFile(filepath.Join(home, "settings.yaml"), []byte(`
+vars:
+  aiGatewayEnv: staging
+  apiKey:
+    value: sk-secret
+    secret: true
+  publicKey:
+    value: pk-public
+    secret: false
+  profile:
+    value: a
+    name: b
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := Read("settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedVars := map[string]any{
+		"aiGatewayEnv": "staging",
+		"apiKey":       "sk-secret",
+		"publicKey":    "pk-public",
+		"profile":      map[string]any{"value": "a", "name": "b"},
+	}
+
+	if !reflect.DeepEqual(settings.Vars, expectedVars) {
+		t.Errorf("Expected the marked vars to be unwrapped, got: %v", settings.Vars)
+	}
+
+	if !reflect.DeepEqual(settings.SecretVars, []string{"apiKey"}) {
+		t.Errorf("Expected only apiKey to be secret, got: %v", settings.SecretVars)
+	}
+}
@@ -2,29 +2,107 @@ package template
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"reflect"
 	"regexp"
+	"strings"
 
 	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/vm"
 )
 
+// storageEnvKey is the environment key holding the temporary storage of a single run. Calls to the storage functions
+// are rewritten at compile time to take it as their first argument so that a compiled program doesn't capture any
+// storage and can be shared between runs.
+const storageEnvKey = "_storage_"
+
+// contextEnvKey is the environment key holding the context of the request being rendered. It's passed to the token
+// and log functions the same way as the storage so that token requests are traced, and logs tagged, as part of the
+// request.
+const contextEnvKey = "_context_"
+
+// envArguments maps the functions which take a value from the environment as their first argument to its key
+var envArguments = map[string]string{
+	"setToStorage":           storageEnvKey,
+	"getFromStorage":         storageEnvKey,
+	"slauthtoken":            contextEnvKey,
+	"slauthtokenWithCommand": contextEnvKey,
+	"log":                    contextEnvKey,
+}
+
 // RenderExpr renders an Expr expression with the given environment and storage
 func (r *Renderer) RenderExpr(exprStr string, env map[string]any, temporaryStorage map[string]string) ([]byte, error) {
+	output, err := r.EvalExpr(exprStr, env, temporaryStorage)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(fmt.Sprint(output)), nil
+}
+
+// EvalExpr runs an Expr expression with the given environment and storage and returns its value as is
+func (r *Renderer) EvalExpr(exprStr string, env map[string]any, temporaryStorage map[string]string) (any, error) {
+	program, err := r.compileExpr(exprStr)
+	if err != nil {
+		return nil, err
+	}
+
 	if temporaryStorage == nil {
 		temporaryStorage = make(map[string]string)
 	}
 
-	// Create environment with custom functions
-	options := []expr.Option{
-		expr.Env(env),
+	runEnv := make(map[string]any, len(env)+1)
+
+	for key, value := range env {
+		runEnv[key] = value
+	}
+
+	runEnv[storageEnvKey] = temporaryStorage
+	runEnv[contextEnvKey] = contextFromInput(env)
+
+	output, err := expr.Run(program, runEnv)
+	if err != nil {
+		return nil, fmt.Errorf("expr run error: %w", err)
+	}
+
+	return output, nil
+}
+
+// CompileExpr compiles an Expr expression without running it and caches the program for later renders. As no
+// environment is available variables aren't checked.
+func (r *Renderer) CompileExpr(exprStr string) error {
+	_, err := r.compileExpr(exprStr)
+	return err
+}
+
+// compileExpr returns the cached program for the expression, compiling it on first use
+func (r *Renderer) compileExpr(exprStr string) (*vm.Program, error) {
+	if program, ok := r.programs.Load(exprStr); ok {
+		return program.(*vm.Program), nil
+	}
+
+	program, err := expr.Compile(exprStr, r.exprOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("expr compile error: %w", err)
+	}
+
+	r.programs.Store(exprStr, program)
+	return program, nil
+}
+
+// exprOptions creates the options with the custom functions available to every expression
+func (r *Renderer) exprOptions() []expr.Option {
+	return []expr.Option{
+		expr.Patch(envPatcher{}),
 		expr.Function("safeEncode", r.exprSafeEncode),
 		expr.Function("trimStr", r.exprTrim),
 		expr.Function("timestamp", r.exprTimestamp),
 		expr.Function("formattedTimestamp", r.exprFormattedTimestamp),
-		expr.Function("setToStorage", r.exprSetFunc(temporaryStorage)),
-		expr.Function("getFromStorage", r.exprGetFunc(temporaryStorage)),
+		expr.Function("setToStorage", r.exprSetToStorage),
+		expr.Function("getFromStorage", r.exprGetFromStorage),
 		// expr.Function("getFromMap", r.exprGetFromMap),
 		expr.Function("type", r.exprType),
 		expr.Function("has", r.exprHas),
@@ -39,18 +117,29 @@ func (r *Renderer) RenderExpr(exprStr string, env map[string]any, temporaryStora
 		expr.Function("regexReplaceAll", r.exprRegexReplaceAll),
 		expr.Function("log", r.exprLog),
 	}
+}
 
-	program, err := expr.Compile(exprStr, options...)
-	if err != nil {
-		return nil, fmt.Errorf("expr compile error: %w", err)
+// envPatcher passes values from the environment, such as the temporary storage, as the first argument of the
+// functions which need them
+type envPatcher struct{}
+
+func (envPatcher) Visit(node *ast.Node) {
+	call, ok := (*node).(*ast.CallNode)
+	if !ok {
+		return
 	}
 
-	output, err := expr.Run(program, env)
-	if err != nil {
-		return nil, fmt.Errorf("expr run error: %w", err)
+	callee, ok := call.Callee.(*ast.IdentifierNode)
+	if !ok {
+		return
 	}
 
-	return []byte(fmt.Sprint(output)), nil
+	envKey, ok := envArguments[callee.Value]
+	if !ok {
+		return
+	}
+
+	call.Arguments = append([]ast.Node{&ast.IdentifierNode{Value: envKey}}, call.Arguments...)
 }
 
 // Expr helper functions
@@ -89,29 +178,35 @@ func (r *Renderer) exprFormattedTimestamp(params ...any) (any, error) {
 	return r.formattedTimestampFn(layout), nil
 }
 
-func (r *Renderer) exprSetFunc(temporaryStorage map[string]string) func(params ...any) (any, error) {
-	return func(params ...any) (any, error) {
-		if len(params) != 2 {
-			return nil, fmt.Errorf("set expects 2 arguments (key, value)")
-		}
-
-		key := fmt.Sprint(params[0])
-		val := params[1]
+func (r *Renderer) exprSetToStorage(params ...any) (any, error) {
+	if len(params) != 3 {
+		return nil, fmt.Errorf("setToStorage expects 2 arguments (key, value)")
+	}
 
-		return r.setFn(temporaryStorage)(key, val), nil
+	temporaryStorage, ok := params[0].(map[string]string)
+	if !ok {
+		return nil, fmt.Errorf("setToStorage: no storage available")
 	}
-}
 
-func (r *Renderer) exprGetFunc(temporaryStorage map[string]string) func(params ...any) (any, error) {
-	return func(params ...any) (any, error) {
-		if len(params) != 1 {
-			return nil, fmt.Errorf("get expects 1 argument (key)")
-		}
+	key := fmt.Sprint(params[1])
+	val := params[2]
 
-		key := fmt.Sprint(params[0])
+	return r.setFn(temporaryStorage)(key, val), nil
+}
 
-		return r.getFn(temporaryStorage)(key), nil
+func (r *Renderer) exprGetFromStorage(params ...any) (any, error) {
+	if len(params) != 2 {
+		return nil, fmt.Errorf("getFromStorage expects 1 argument (key)")
 	}
+
+	temporaryStorage, ok := params[0].(map[string]string)
+	if !ok {
+		return nil, fmt.Errorf("getFromStorage: no storage available")
+	}
+
+	key := fmt.Sprint(params[1])
+
+	return r.getFn(temporaryStorage)(key), nil
 }
 
 func (r *Renderer) exprType(params ...any) (any, error) {
@@ -172,39 +267,49 @@ func (r *Renderer) exprRegexReplace(params ...any) (any, error) {
 }
 
 func (r *Renderer) exprSlauthTokenWithCommand(params ...any) (any, error) {
-	if len(params) != 3 {
+	if len(params) != 4 {
 		return nil, fmt.Errorf("slauthtoken expects 3 arguments (groups, audience, environment)")
 	}
 
-	groupsAny := params[0].([]any)
+	ctx, ok := params[0].(context.Context)
+	if !ok {
+		ctx = context.Background()
+	}
+
+	groupsAny := params[1].([]any)
 	groups := make([]string, 0, len(groupsAny))
 
 	for _, group := range groupsAny {
 		groups = append(groups, fmt.Sprint(group))
 	}
 
-	audience := fmt.Sprint(params[1])
-	environment := fmt.Sprint(params[2])
+	audience := fmt.Sprint(params[2])
+	environment := fmt.Sprint(params[3])
 
-	return r.slauthTokenWithCommandFn(groups, audience, environment)
+	return r.slauthTokenWithCommandFn(ctx)(groups, audience, environment)
 }
 
 func (r *Renderer) exprSlauthToken(params ...any) (any, error) {
-	if len(params) != 3 {
+	if len(params) != 4 {
 		return nil, fmt.Errorf("slauthtoken expects 3 arguments (groups, audience, environment)")
 	}
 
-	groupsAny := params[0].([]any)
+	ctx, ok := params[0].(context.Context)
+	if !ok {
+		ctx = context.Background()
+	}
+
+	groupsAny := params[1].([]any)
 	groups := make([]string, 0, len(groupsAny))
 
 	for _, group := range groupsAny {
 		groups = append(groups, fmt.Sprint(group))
 	}
 
-	audience := fmt.Sprint(params[1])
-	environment := fmt.Sprint(params[2])
+	audience := fmt.Sprint(params[2])
+	environment := fmt.Sprint(params[3])
 
-	return r.slauthTokenFn(groups, audience, environment)
+	return r.slauthTokenFn(ctx)(groups, audience, environment)
 }
 
 func (r *Renderer) exprFilterOutKeys(params ...any) (any, error) {
@@ -362,12 +467,17 @@ func (r *Renderer) exprRegexReplaceAll(params ...any) (any, error) {
 	return result, nil
 }
 
-// exprLog logs the given arguments to the renderer's logger
+// exprLog logs the given arguments to the renderer's logger, the first argument is the context of the request
 func (r *Renderer) exprLog(params ...any) (any, error) {
-	if len(params) == 0 {
+	if len(params) < 2 {
 		return nil, nil
 	}
 
-	r.logger.Println(params...)
+	ctx, _ := params[0].(context.Context)
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	r.logger.InfoContext(ctx, strings.TrimSuffix(fmt.Sprintln(params[1:]...), "\n"))
 	return nil, nil
 }
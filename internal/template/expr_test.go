@@ -0,0 +1,140 @@
+package template
+
+import (
+	"fmt"
+	"testing"
+)
+
+// streamEventExpr is representative of the per-event expressions used to convert streamed responses between formats
+const streamEventExpr = `
+event != nil ? (
+  let eventJson = trimPrefix(event ?? "", "data:") | trim();
+
+  len(eventJson) > 0 ? (
+    let e = fromJSON(eventJson);
+    let eventType = get(e, "type") ?? "";
+    let delta = get(e, "delta") ?? {};
+
+    eventType == "message_start" ? (
+      setToStorage("requestId", get(get(e, "message") ?? {}, "id") ?? "");
+      "data: " + toCompactJson({ id: getFromStorage("requestId"), object: "chat.completion.chunk", choices: [{ index: 0, delta: { role: "assistant" } }] }) + "\n\n"
+    ) : eventType == "content_block_delta" ? (
+      "data: " + toCompactJson({ id: getFromStorage("requestId"), object: "chat.completion.chunk", choices: [{ index: 0, delta: { content: get(delta, "text") ?? "" } }] }) + "\n\n"
+    ) : ""
+  ) : "\n"
+) : toCompactJson(body)
+`
+
+func streamEvents(n int) []string {
+	events := []string{`data: {"type":"message_start","message":{"id":"msg_1"}}` + "\n"}
+
+	for i := 0; i < n; i++ {
+		events = append(events, fmt.Sprintf(`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"token %d"}}`+"\n", i))
+	}
+
+	return events
+}
+
+func TestRenderExprStorageIsPerRun(t *testing.T) {
+	r := NewRenderer(nil)
+
+	first := make(map[string]string)
+	second := make(map[string]string)
+
+	if _, err := r.RenderExpr(`setToStorage("key", value)`, map[string]any{"value": "first"}, first); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.RenderExpr(`setToStorage("key", value)`, map[string]any{"value": "second"}, second); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := r.RenderExpr(`getFromStorage("key")`, nil, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != "first" {
+		t.Errorf("Expected first, got: %s", out)
+	}
+
+	if second["key"] != "second" {
+		t.Errorf("Expected second, got: %s", second["key"])
+	}
+}
+
+func TestRenderTemplateStorageIsPerRun(t *testing.T) {
+	r := NewRenderer(nil)
+
+	first := make(map[string]string)
+	second := make(map[string]string)
+
+	for storage, value := range map[*map[string]string]string{&first: "first", &second: "second"} {
+		out, err := r.RenderTemplate(`{{ set "key" .value }}{{ get "key" }}`, map[string]any{"value": value}, *storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(out) != value {
+			t.Errorf("Expected %s, got: %s", value, out)
+		}
+	}
+
+	if first["key"] != "first" || second["key"] != "second" {
+		t.Errorf("Storage leaked between renders: %v %v", first, second)
+	}
+}
+
+func TestRenderExprStreamedResponse(t *testing.T) {
+	r := NewRenderer(nil)
+	storage := make(map[string]string)
+
+	var out []byte
+
+	for _, event := range streamEvents(1) {
+		var err error
+
+		out, err = r.RenderExpr(streamEventExpr, map[string]any{"body": nil, "event": event}, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := `data: {"choices":[{"delta":{"content":"token 0"},"index":0}],"id":"msg_1","object":"chat.completion.chunk"}` + "\n\n"
+
+	if string(out) != expected {
+		t.Errorf("Expected %q, got: %q", expected, out)
+	}
+}
+
+// BenchmarkRenderExprStream renders a streamed response of 100 events, once with the compiled program cached by the
+// renderer and once compiling the expression for every event as a fresh renderer would.
+func BenchmarkRenderExprStream(b *testing.B) {
+	events := streamEvents(100)
+
+	b.Run("cached", func(b *testing.B) {
+		r := NewRenderer(nil)
+
+		for i := 0; i < b.N; i++ {
+			storage := make(map[string]string)
+
+			for _, event := range events {
+				if _, err := r.RenderExpr(streamEventExpr, map[string]any{"body": nil, "event": event}, storage); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			storage := make(map[string]string)
+
+			for _, event := range events {
+				if _, err := NewRenderer(nil).RenderExpr(streamEventExpr, map[string]any{"body": nil, "event": event}, storage); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
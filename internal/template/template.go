@@ -1,9 +1,10 @@
 package template
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"os/exec"
 	"reflect"
 	"regexp"
@@ -12,6 +13,9 @@ import (
 	"text/template"
 	"time"
 
+	"bitbucket.org/atlassian-developers/proximity/internal/logging"
+	"bitbucket.org/atlassian-developers/proximity/internal/tracing"
+
 	"bitbucket.org/atlassian/atlas-cli-kit/api/runtime"
 	"bitbucket.org/atlassian/atlas-cli-kit/api/runtime/client/slauth"
 	"bitbucket.org/atlassian/atlas-cli-kit/models"
@@ -19,20 +23,63 @@ import (
 )
 
 type Renderer struct {
-	logger *log.Logger
+	logger *slog.Logger
 	mu     sync.RWMutex
 
 	// Storage which lasts for the lifetime of the proxy.
 	permanentStorage map[string]string
+
+	// Compiled expr programs and parsed templates keyed by their source text. The same sources are rendered for every
+	// request and stream event so they are only compiled once.
+	programs  sync.Map
+	templates sync.Map
+
+	// tokenSource replaces requesting slauth tokens from atlas when set.
+	tokenSource TokenSource
+
+	tracer *tracing.Tracer
 }
 
-func NewRenderer(logger *log.Logger) *Renderer {
+// TokenSource provides a slauth token for the given groups, audience and environment.
+type TokenSource func(groups []string, audience string, environment string) (string, error)
+
+// NewRenderer creates a renderer logging to logger, nothing is logged when it's nil.
+func NewRenderer(logger *slog.Logger) *Renderer {
+	if logger == nil {
+		logger = logging.Discard()
+	}
+
 	return &Renderer{
 		logger:           logger,
 		permanentStorage: make(map[string]string),
 	}
 }
 
+// SetTokenSource makes the slauth token functions use source instead of requesting tokens from atlas. Tokens from
+// the source aren't cached.
+func (r *Renderer) SetTokenSource(source TokenSource) {
+	r.tokenSource = source
+}
+
+// SetTracer makes token requests traced as part of the request being rendered.
+func (r *Renderer) SetTracer(tracer *tracing.Tracer) {
+	r.tracer = tracer
+}
+
+// SetContext makes the context of the request available to the functions run when rendering input, so that they can
+// be traced as part of the request.
+func SetContext(input map[string]any, ctx context.Context) {
+	input[contextEnvKey] = ctx
+}
+
+func contextFromInput(input map[string]any) context.Context {
+	if ctx, ok := input[contextEnvKey].(context.Context); ok && ctx != nil {
+		return ctx
+	}
+
+	return context.Background()
+}
+
 // Render renders content using either Expr or Go template, based on which is provided.
 // If both are provided, Expr takes priority.
 // Returns nil if neither is provided.
@@ -53,21 +100,56 @@ func (r *Renderer) Render(templateStr, exprStr string, input map[string]any, sto
 
 // RenderTemplate renders using Go text/template
 func (r *Renderer) RenderTemplate(templateStr string, input map[string]any, storage map[string]string) ([]byte, error) {
-	tmpl, err := template.New("body").Funcs(r.FunctionsWithStorage(storage)).Parse(templateStr)
+	parsed, err := r.compileTemplate(templateStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if storage == nil {
+		storage = make(map[string]string)
+	}
+
+	// The parsed template is shared so it's cloned to bind the functions to the storage of this render
+	tmpl, err := parsed.Clone()
 	if err != nil {
 		return nil, err
 	}
 
 	var buf strings.Builder
 
-	if err := tmpl.Execute(&buf, input); err != nil {
+	if err := tmpl.Funcs(r.functions(storage, contextFromInput(input))).Execute(&buf, input); err != nil {
 		return nil, err
 	}
 
 	return []byte(buf.String()), nil
 }
 
+// CompileTemplate parses a Go template without executing it and caches it for later renders
+func (r *Renderer) CompileTemplate(templateStr string) error {
+	_, err := r.compileTemplate(templateStr)
+	return err
+}
+
+// compileTemplate returns the cached template, parsing it on first use
+func (r *Renderer) compileTemplate(templateStr string) (*template.Template, error) {
+	if tmpl, ok := r.templates.Load(templateStr); ok {
+		return tmpl.(*template.Template), nil
+	}
+
+	tmpl, err := template.New("body").Funcs(r.FunctionsWithStorage(nil)).Parse(templateStr)
+	if err != nil {
+		return nil, err
+	}
+
+	r.templates.Store(templateStr, tmpl)
+	return tmpl, nil
+}
+
 func (r *Renderer) FunctionsWithStorage(temporaryStorage map[string]string) template.FuncMap {
+	return r.functions(temporaryStorage, context.Background())
+}
+
+func (r *Renderer) functions(temporaryStorage map[string]string, ctx context.Context) template.FuncMap {
 	return template.FuncMap{
 		"toJson":                 r.toJsonFn,
 		"getType":                r.getTypeFn,
@@ -81,18 +163,18 @@ func (r *Renderer) FunctionsWithStorage(temporaryStorage map[string]string) temp
 		"subtract":               r.subtractFn,
 		"regexFind":              r.regexFindFn,
 		"regexReplace":           r.regexReplaceFn,
-		"slauthtokenWithCommand": r.slauthTokenWithCommandFn,
-		"slauthtoken":            r.slauthTokenFn,
+		"slauthtokenWithCommand": r.slauthTokenWithCommandFn(ctx),
+		"slauthtoken":            r.slauthTokenFn(ctx),
 	}
 }
 
-func (r *Renderer) tokenHasExpired(token string) bool {
+func (r *Renderer) tokenHasExpired(ctx context.Context, token string) bool {
 	// Parse without verifying signature to read claims only
 	parser := jwt.NewParser()
 
 	parsed, _, err := parser.ParseUnverified(token, jwt.MapClaims{})
 	if err != nil {
-		r.logger.Println("tokenHasExpired: jwt parse error:", err)
+		r.logger.WarnContext(ctx, "failed to parse cached slauth token, requesting a new one", "error", err)
 		return true
 	}
 
@@ -203,15 +285,33 @@ func (r *Renderer) regexReplaceFn(pattern, replacement, s string) (string, error
 	return re.ReplaceAllString(s, replacement), nil
 }
 
-func (r *Renderer) slauthTokenWithCommandFn(groups []string, audience string, environment string) (string, error) {
-	return r.getSlauthToken(groups, audience, environment, r.requestSlauthTokenWithCommand)
+func (r *Renderer) slauthTokenWithCommandFn(ctx context.Context) func(groups []string, audience string, environment string) (string, error) {
+	return func(groups []string, audience string, environment string) (string, error) {
+		return r.getSlauthToken(ctx, groups, audience, environment, r.requestSlauthTokenWithCommand)
+	}
 }
 
-func (r *Renderer) slauthTokenFn(groups []string, audience string, environment string) (string, error) {
-	return r.getSlauthToken(groups, audience, environment, r.requestSlauthToken)
+func (r *Renderer) slauthTokenFn(ctx context.Context) func(groups []string, audience string, environment string) (string, error) {
+	return func(groups []string, audience string, environment string) (string, error) {
+		return r.getSlauthToken(ctx, groups, audience, environment, r.requestSlauthToken)
+	}
 }
 
-func (r *Renderer) getSlauthToken(groups []string, audience string, environment string, slauthTokenFn func(groups []string, audience string, environment string) (string, error)) (string, error) {
+func (r *Renderer) getSlauthToken(ctx context.Context, groups []string, audience string, environment string, slauthTokenFn func(groups []string, audience string, environment string) (string, error)) (token string, err error) {
+	_, span := r.tracer.Start(ctx, "slauth token", tracing.KindInternal)
+	span.SetAttribute("slauth.groups", strings.Join(groups, ","))
+	span.SetAttribute("slauth.audience", audience)
+	span.SetAttribute("slauth.environment", environment)
+
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	if r.tokenSource != nil {
+		return r.tokenSource(groups, audience, environment)
+	}
+
 	// Build a cache key that includes all parameters
 	cacheKey := fmt.Sprintf("token:%s:%s:%s", strings.Join(groups, ","), audience, environment)
 
@@ -221,8 +321,9 @@ func (r *Renderer) getSlauthToken(groups []string, audience string, environment
 	r.mu.RUnlock()
 
 	// If there is an existing token and it is still valid then use it.
-	if exists && !r.tokenHasExpired(token) {
-		r.logger.Printf("use existing token for %s", cacheKey)
+	if exists && !r.tokenHasExpired(ctx, token) {
+		r.logger.DebugContext(ctx, "using cached slauth token", "key", cacheKey)
+		span.SetAttribute("slauth.cached", true)
 		return token, nil
 	}
 
@@ -233,14 +334,16 @@ func (r *Renderer) getSlauthToken(groups []string, audience string, environment
 	// Re-check after acquiring write lock since another goroutine may have already fetched it
 	token, exists = r.permanentStorage[cacheKey]
 
-	if exists && !r.tokenHasExpired(token) {
-		r.logger.Printf("use existing token for %s", cacheKey)
+	if exists && !r.tokenHasExpired(ctx, token) {
+		r.logger.DebugContext(ctx, "using cached slauth token", "key", cacheKey)
+		span.SetAttribute("slauth.cached", true)
 		return token, nil
 	}
 
-	r.logger.Printf("requesting slauth token for %s", cacheKey)
+	r.logger.InfoContext(ctx, "requesting slauth token", "key", cacheKey)
+	span.SetAttribute("slauth.cached", false)
 
-	token, err := slauthTokenFn(groups, audience, environment)
+	token, err = slauthTokenFn(groups, audience, environment)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,188 @@
+package testrunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// diffBody compares an expected body with the actual one. Expected strings are compared with the raw body and
+// anything else is compared as json so that key order and formatting don't matter. An empty diff means they match.
+func diffBody(expected any, actual []byte) string {
+	if s, ok := expected.(string); ok {
+		return diffLines(strings.TrimRight(s, "\n"), strings.TrimRight(string(actual), "\n"))
+	}
+
+	return diffLines(canonicalJson(expected), canonicalJsonBytes(actual))
+}
+
+// diffEvents compares expected events with the server sent events in the actual body. Each event is rendered on one
+// line with its data in the same form as the expected data.
+func diffEvents(expected []Event, actual []byte) string {
+	actualEvents := parseEvents(actual)
+
+	var expectedLines, actualLines []string
+
+	for _, event := range expected {
+		data, ok := event.Data.(string)
+		if !ok {
+			data = canonicalCompactJson(event.Data)
+		}
+
+		expectedLines = append(expectedLines, formatEvent(event.Event, data))
+	}
+
+	for i, event := range actualEvents {
+		data, _ := event.Data.(string)
+
+		// Compare the data as json when the matching expected event has json data
+		if i < len(expected) {
+			if _, ok := expected[i].Data.(string); !ok {
+				var decoded any
+				if err := json.Unmarshal([]byte(data), &decoded); err == nil {
+					data = canonicalCompactJson(decoded)
+				}
+			}
+		}
+
+		actualLines = append(actualLines, formatEvent(event.Event, data))
+	}
+
+	return diffLines(strings.Join(expectedLines, "\n"), strings.Join(actualLines, "\n"))
+}
+
+func formatEvent(name, data string) string {
+	if name == "" {
+		return "data: " + data
+	}
+
+	return fmt.Sprintf("event: %s data: %s", name, data)
+}
+
+// parseEvents splits a server sent event stream into its events. Comments and fields other than event and data are
+// ignored.
+func parseEvents(body []byte) []Event {
+	var events []Event
+
+	normalised := strings.ReplaceAll(string(body), "\r\n", "\n")
+
+	for _, block := range strings.Split(normalised, "\n\n") {
+		var event Event
+		var dataLines []string
+		hasData := false
+
+		for _, line := range strings.Split(block, "\n") {
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+
+			switch field {
+			case "event":
+				event.Event = value
+			case "data":
+				dataLines = append(dataLines, value)
+				hasData = true
+			}
+		}
+
+		if !hasData && event.Event == "" {
+			continue
+		}
+
+		event.Data = strings.Join(dataLines, "\n")
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// canonicalJson formats a value as indented json with sorted keys.
+func canonicalJson(value any) string {
+	data, err := json.MarshalIndent(roundTrip(value), "", "  ")
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(data)
+}
+
+func canonicalCompactJson(value any) string {
+	data, err := json.Marshal(roundTrip(value))
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(data)
+}
+
+// canonicalJsonBytes formats a json body the same way as canonicalJson and returns bodies which aren't json as is.
+func canonicalJsonBytes(body []byte) string {
+	var decoded any
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return strings.TrimRight(string(body), "\n")
+	}
+
+	return canonicalJson(decoded)
+}
+
+// roundTrip converts a value decoded from yaml into the types encoding/json decodes into so that yaml and json values
+// compare equal.
+func roundTrip(value any) any {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return value
+	}
+
+	return decoded
+}
+
+// diffLines returns a line diff of expected and actual with removed lines prefixed by "-" and added lines by "+", or
+// an empty string when they're the same.
+func diffLines(expected, actual string) string {
+	if expected == actual {
+		return ""
+	}
+
+	a := strings.Split(expected, "\n")
+	b := strings.Split(actual, "\n")
+
+	// lengths[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	var sb strings.Builder
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			sb.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lengths[i+1][j] >= lengths[i][j+1]):
+			sb.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+
+	return strings.TrimRight(sb.String(), "\n")
+}
@@ -0,0 +1,499 @@
+package testrunner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+	"bitbucket.org/atlassian-developers/proximity/internal/proxy"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Token is returned by the slauth token functions while running tests so that no tokens are requested from atlas.
+const Token = "test-token"
+
+// Version is provided to the config as the proxy version while running tests.
+const Version = "test"
+
+// Suite is a file of test cases which share the same vars.
+type Suite struct {
+	Path string `yaml:"-"`
+
+	// Vars are provided to the config as globalVars for every case in the suite
+	Vars  map[string]any `yaml:"vars"`
+	Cases []Case         `yaml:"cases"`
+}
+
+// Case sends a request through the proxy, answers its upstream requests with stubbed responses and checks what the
+// proxy sent upstream and what it responded with.
+type Case struct {
+	Name string `yaml:"name"`
+
+	// Vars are merged over the suite vars
+	Vars     map[string]any              `yaml:"vars"`
+	Request  Request                     `yaml:"request"`
+	Upstream oneOrMany[UpstreamResponse] `yaml:"upstream"`
+	Expect   Expectation                 `yaml:"expect"`
+}
+
+type Request struct {
+	Method  string            `yaml:"method"`
+	Path    string            `yaml:"path"`
+	Headers map[string]string `yaml:"headers"`
+
+	// Body is sent as is when it's a string and as json otherwise
+	Body any `yaml:"body"`
+}
+
+// UpstreamResponse is the stubbed response of an upstream. Events are sent as a server sent event stream instead of
+// the body when provided.
+type UpstreamResponse struct {
+	// Path limits the response to upstream requests for this path, it's used for any request when empty
+	Path string `yaml:"path"`
+
+	Status  int               `yaml:"status"`
+	Headers map[string]string `yaml:"headers"`
+	Body    any               `yaml:"body"`
+	Events  []Event           `yaml:"events"`
+}
+
+type Event struct {
+	Event string `yaml:"event"`
+
+	// Data is used as is when it's a string and as json otherwise
+	Data any `yaml:"data"`
+}
+
+type Expectation struct {
+	Upstream oneOrMany[ExpectedRequest] `yaml:"upstream"`
+	Response ExpectedResponse           `yaml:"response"`
+}
+
+// ExpectedRequest is checked against the request the upstream received. Only the fields and headers which are set
+// are checked.
+type ExpectedRequest struct {
+	Method  string            `yaml:"method"`
+	Path    string            `yaml:"path"`
+	Headers map[string]string `yaml:"headers"`
+	Body    any               `yaml:"body"`
+}
+
+// ExpectedResponse is checked against the response the client received. Only the fields and headers which are set
+// are checked.
+type ExpectedResponse struct {
+	Status  int               `yaml:"status"`
+	Headers map[string]string `yaml:"headers"`
+	Body    any               `yaml:"body"`
+	Events  []Event           `yaml:"events"`
+}
+
+// oneOrMany accepts either a single mapping or a sequence of them.
+type oneOrMany[T any] []T
+
+func (o *oneOrMany[T]) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		var item T
+		if err := node.Decode(&item); err != nil {
+			return err
+		}
+
+		*o = []T{item}
+		return nil
+	}
+
+	var items []T
+	if err := node.Decode(&items); err != nil {
+		return err
+	}
+
+	*o = items
+	return nil
+}
+
+// Load reads the suites from the given files and directories. Directories are searched for yaml files recursively.
+func Load(paths ...string) ([]*Suite, error) {
+	var files []string
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if ext := filepath.Ext(file); !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+				files = append(files, file)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var suites []*Suite
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+
+		suite := &Suite{Path: file}
+		if err := decoder.Decode(suite); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+
+		suites = append(suites, suite)
+	}
+
+	return suites, nil
+}
+
+// Runner runs test cases against a config.
+type Runner struct {
+	Config *config.Config
+
+	// Out receives the results
+	Out io.Writer
+
+	// Logger receives the proxy logs, they're discarded when nil
+	Logger *slog.Logger
+}
+
+// Run runs every case of the suites and returns the number of cases which failed and the number which were run.
+func (r *Runner) Run(suites []*Suite) (int, int) {
+	failed, total := 0, 0
+
+	for _, suite := range suites {
+		for _, c := range suite.Cases {
+			total++
+
+			failures := r.runCase(suite, c)
+			if len(failures) == 0 {
+				fmt.Fprintf(r.Out, "PASS %s: %s\n", suite.Path, c.Name)
+				continue
+			}
+
+			failed++
+			fmt.Fprintf(r.Out, "FAIL %s: %s\n", suite.Path, c.Name)
+
+			for _, failure := range failures {
+				fmt.Fprintf(r.Out, "    %s\n", strings.ReplaceAll(failure, "\n", "\n    "))
+			}
+		}
+	}
+
+	return failed, total
+}
+
+func (r *Runner) runCase(suite *Suite, c Case) []string {
+	vars := make(map[string]any)
+	maps.Copy(vars, suite.Vars)
+	maps.Copy(vars, c.Vars)
+
+	upstream := &stubTransport{responses: c.Upstream}
+
+	p := proxy.New(proxy.Options{
+		Version:   Version,
+		Logger:    r.Logger,
+		Config:    r.Config,
+		Vars:      vars,
+		Transport: upstream,
+		TokenSource: func(groups []string, audience string, environment string) (string, error) {
+			return Token, nil
+		},
+	})
+
+	if err := p.Reload(r.Config); err != nil {
+		return []string{err.Error()}
+	}
+
+	req, err := newRequest(c.Request)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	rec := httptest.NewRecorder()
+	p.Handler().ServeHTTP(rec, req)
+
+	failures := checkUpstreamRequests(c.Expect.Upstream, upstream.received())
+	return append(failures, checkResponse(c.Expect.Response, rec.Result())...)
+}
+
+func newRequest(request Request) (*http.Request, error) {
+	method := request.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	body, isJson, err := encodeBody(request.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+
+	req := httptest.NewRequest(method, request.Path, bytes.NewReader(body))
+
+	for name, value := range request.Headers {
+		req.Header.Set(name, value)
+	}
+
+	if isJson && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+// encodeBody returns a string body as is and anything else as json.
+func encodeBody(body any) ([]byte, bool, error) {
+	switch b := body.(type) {
+	case nil:
+		return nil, false, nil
+	case string:
+		return []byte(b), false, nil
+	}
+
+	data, err := json.Marshal(body)
+	return data, true, err
+}
+
+// receivedRequest is a request received by the stub upstream with its body read.
+type receivedRequest struct {
+	*http.Request
+	body []byte
+}
+
+// stubTransport answers upstream requests with the stubbed responses of a case instead of contacting the upstream.
+type stubTransport struct {
+	responses []UpstreamResponse
+
+	mu       sync.Mutex
+	requests []receivedRequest
+}
+
+func (t *stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+
+	if req.Body != nil {
+		var err error
+
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t.mu.Lock()
+	t.requests = append(t.requests, receivedRequest{Request: req, body: body})
+	t.mu.Unlock()
+
+	for _, response := range t.responses {
+		if response.Path == "" || response.Path == req.URL.Path {
+			return response.toHttpResponse(req)
+		}
+	}
+
+	return nil, fmt.Errorf("no stubbed upstream response for %s %s", req.Method, req.URL.Path)
+}
+
+func (t *stubTransport) received() []receivedRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.requests
+}
+
+func (u UpstreamResponse) toHttpResponse(req *http.Request) (*http.Response, error) {
+	header := make(http.Header)
+
+	var body []byte
+
+	if u.Events != nil {
+		header.Set("Content-Type", "text/event-stream")
+
+		var buf bytes.Buffer
+
+		for _, event := range u.Events {
+			data, _, err := encodeBody(event.Data)
+			if err != nil {
+				return nil, fmt.Errorf("invalid stubbed event data: %w", err)
+			}
+
+			if event.Event != "" {
+				fmt.Fprintf(&buf, "event: %s\n", event.Event)
+			}
+
+			for _, line := range strings.Split(string(data), "\n") {
+				fmt.Fprintf(&buf, "data: %s\n", line)
+			}
+
+			buf.WriteString("\n")
+		}
+
+		body = buf.Bytes()
+	} else {
+		data, isJson, err := encodeBody(u.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stubbed body: %w", err)
+		}
+
+		if isJson {
+			header.Set("Content-Type", "application/json")
+		}
+
+		body = data
+	}
+
+	for name, value := range u.Headers {
+		header.Set(name, value)
+	}
+
+	status := u.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func checkUpstreamRequests(expected []ExpectedRequest, received []receivedRequest) []string {
+	var failures []string
+
+	used := make(map[int]bool)
+
+	for i, exp := range expected {
+		index := -1
+
+		for j, req := range received {
+			if used[j] {
+				continue
+			}
+
+			// Requests are matched on their path when one is expected and otherwise on the order they were made
+			matches := j == i
+			if exp.Path != "" {
+				matches = req.URL.RequestURI() == exp.Path
+			}
+
+			if matches {
+				index = j
+				break
+			}
+		}
+
+		if index == -1 {
+			failures = append(failures, fmt.Sprintf("expected upstream request %d %s was not made", i+1, exp.Path))
+			continue
+		}
+
+		used[index] = true
+		req := received[index]
+
+		label := fmt.Sprintf("upstream request %s %s", req.Method, req.URL.RequestURI())
+
+		if exp.Method != "" && exp.Method != req.Method {
+			failures = append(failures, fmt.Sprintf("%s: expected method %s, got: %s", label, exp.Method, req.Method))
+		}
+
+		failures = append(failures, checkHeaders(label, exp.Headers, req.Header)...)
+
+		if exp.Body != nil {
+			if diff := diffBody(exp.Body, req.body); diff != "" {
+				failures = append(failures, fmt.Sprintf("%s body differs (- expected, + actual):\n%s", label, diff))
+			}
+		}
+	}
+
+	if len(expected) > 0 && len(received) > len(expected) {
+		failures = append(failures, fmt.Sprintf("expected %d upstream request(s), got: %d", len(expected), len(received)))
+	}
+
+	return failures
+}
+
+func checkResponse(expected ExpectedResponse, res *http.Response) []string {
+	var failures []string
+
+	if expected.Status != 0 && expected.Status != res.StatusCode {
+		failures = append(failures, fmt.Sprintf("response: expected status %d, got: %d", expected.Status, res.StatusCode))
+	}
+
+	failures = append(failures, checkHeaders("response", expected.Headers, res.Header)...)
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return append(failures, fmt.Sprintf("response: failed to read the body: %v", err))
+	}
+
+	if expected.Body != nil {
+		if diff := diffBody(expected.Body, body); diff != "" {
+			failures = append(failures, fmt.Sprintf("response body differs (- expected, + actual):\n%s", diff))
+		}
+	}
+
+	if expected.Events != nil {
+		if diff := diffEvents(expected.Events, body); diff != "" {
+			failures = append(failures, fmt.Sprintf("response events differ (- expected, + actual):\n%s", diff))
+		}
+	}
+
+	return failures
+}
+
+func checkHeaders(label string, expected map[string]string, actual http.Header) []string {
+	var failures []string
+
+	names := make([]string, 0, len(expected))
+	for name := range expected {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if value := actual.Get(name); value != expected[name] {
+			failures = append(failures, fmt.Sprintf("%s: expected header %s to be %q, got: %q", label, name, expected[name], value))
+		}
+	}
+
+	return failures
+}
@@ -0,0 +1,152 @@
+package testrunner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/config"
+)
+
+func TestShippedConfigTests(t *testing.T) {
+	cfg, err := config.Load("../../config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suites, err := Load("../../tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+
+	runner := &Runner{Config: cfg, Out: &out}
+
+	if failed, total := runner.Run(suites); failed > 0 || total == 0 {
+		t.Errorf("Expected all test cases to pass, got %d of %d failing:\n%s", failed, total, out.String())
+	}
+}
+
+func TestRunReportsFailures(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+baseEndpoint: '"https://upstream.example.com"'
+uriGroups:
+  - name: Test
+    supportedUris:
+      - in: /chat
+        out:
+          - method: POST
+            text: /v1/chat
+overrides:
+  uris:
+    /chat:
+      POST:
+        request:
+          body:
+            expr: 'toCompactJson(merge(filterOutKeys(body, ["model"]), { "model": "b" }))'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "cases.yaml")
+
+	err = os.WriteFile(path, []byte(`
+cases:
+  - name: passes
+    request: { method: POST, path: /chat, body: { model: a } }
+    upstream: { body: { ok: true } }
+    expect:
+      upstream: { path: /v1/chat, body: { model: b } }
+      response: { status: 200, body: { ok: true } }
+
+  - name: fails
+    request: { method: POST, path: /chat, body: { model: a } }
+    upstream: { status: 201, headers: { X-Test: "1" }, body: done }
+    expect:
+      upstream: { path: /v1/chat, body: { model: c } }
+      response: { status: 200, headers: { X-Test: "2" }, body: other }
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suites, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+
+	runner := &Runner{Config: cfg, Out: &out}
+
+	failed, total := runner.Run(suites)
+	if failed != 1 || total != 2 {
+		t.Fatalf("Expected 1 of 2 test cases to fail, got %d of %d:\n%s", failed, total, out.String())
+	}
+
+	for _, expected := range []string{
+		"PASS " + path + ": passes",
+		"FAIL " + path + ": fails",
+		`-   "model": "c"`,
+		`+   "model": "b"`,
+		"expected status 200, got: 201",
+		`expected header X-Test to be "2", got: "1"`,
+		"- other\n    + done",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected output to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cases.yaml")
+
+	if err := os.WriteFile(path, []byte("cases:\n  - name: typo\n    expected: {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Errorf("Expected an error for an unknown field")
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		actual   string
+		diff     string
+	}{
+		{
+			name:     "same",
+			expected: "a\nb",
+			actual:   "a\nb",
+			diff:     "",
+		},
+		{
+			name:     "changed line",
+			expected: "a\nb\nc",
+			actual:   "a\nx\nc",
+			diff:     "  a\n- b\n+ x\n  c",
+		},
+		{
+			name:     "added line",
+			expected: "a",
+			actual:   "a\nb",
+			diff:     "  a\n+ b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := diffLines(tt.expected, tt.actual); diff != tt.diff {
+				t.Errorf("Expected diff %q, got: %q", tt.diff, diff)
+			}
+		})
+	}
+}
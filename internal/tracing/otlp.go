@@ -0,0 +1,243 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const serviceName = "proximity"
+
+// otlpStatusError is the OTLP status code for a failed span.
+const otlpStatusError = 2
+
+// The OTLP/JSON encoding of spans, see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func newOtlpValue(value any) otlpValue {
+	switch v := value.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	}
+
+	s := fmt.Sprint(value)
+	return otlpValue{StringValue: &s}
+}
+
+func newOtlpAttributes(attributes map[string]any) []otlpAttribute {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	otlpAttributes := make([]otlpAttribute, 0, len(keys))
+
+	for _, key := range keys {
+		otlpAttributes = append(otlpAttributes, otlpAttribute{Key: key, Value: newOtlpValue(attributes[key])})
+	}
+
+	return otlpAttributes
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// encodeOtlp encodes spans as an OTLP/JSON export request.
+func encodeOtlp(spans []*Span, serviceVersion string) ([]byte, error) {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+
+	for _, span := range spans {
+		span.mu.Lock()
+
+		otlp := otlpSpan{
+			TraceId:           span.context.TraceID.String(),
+			SpanId:            span.context.SpanID.String(),
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: unixNano(span.start),
+			EndTimeUnixNano:   unixNano(span.end),
+			Attributes:        newOtlpAttributes(span.attributes),
+		}
+
+		if span.parent.IsValid() {
+			otlp.ParentSpanId = span.parent.String()
+		}
+
+		if span.err != "" {
+			otlp.Status = &otlpStatus{Code: otlpStatusError, Message: span.err}
+		}
+
+		span.mu.Unlock()
+
+		otlpSpans = append(otlpSpans, otlp)
+	}
+
+	resourceAttributes := map[string]any{"service.name": serviceName}
+	if serviceVersion != "" {
+		resourceAttributes["service.version"] = serviceVersion
+	}
+
+	return json.Marshal(otlpTraces{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: newOtlpAttributes(resourceAttributes)},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: serviceName},
+				Spans: otlpSpans,
+			}},
+		}},
+	})
+}
+
+// OtlpHttpExporter sends spans to an OTLP/HTTP collector using the JSON encoding.
+type OtlpHttpExporter struct {
+	url            string
+	serviceVersion string
+	client         *http.Client
+}
+
+func NewOtlpHttpExporter(url string, serviceVersion string) *OtlpHttpExporter {
+	return &OtlpHttpExporter{
+		url:            url,
+		serviceVersion: serviceVersion,
+		client:         &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (e *OtlpHttpExporter) Export(ctx context.Context, spans []*Span) error {
+	body, err := encodeOtlp(spans, e.serviceVersion)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("collector responded with %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func (e *OtlpHttpExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// FileExporter appends spans to a file as OTLP/JSON export requests, one per line, for inspecting offline.
+type FileExporter struct {
+	serviceVersion string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileExporter(path string, serviceVersion string) (*FileExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+
+	return &FileExporter{serviceVersion: serviceVersion, file: file}, nil
+}
+
+func (e *FileExporter) Export(ctx context.Context, spans []*Span) error {
+	line, err := encodeOtlp(spans, e.serviceVersion)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err = e.file.Write(append(line, '\n'))
+	return err
+}
+
+func (e *FileExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.file.Close()
+}
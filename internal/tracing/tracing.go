@@ -0,0 +1,396 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	traceparentHeader = "traceparent"
+
+	exportInterval = 2 * time.Second
+	maxBatchSize   = 256
+)
+
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value.
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+
+	// Only version 00 is known, later versions may append fields but must keep these ones
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+
+	var sc SpanContext
+
+	if n, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil || n != len(sc.TraceID) || len(parts[1]) != 32 {
+		return SpanContext{}, fmt.Errorf("invalid trace id in traceparent %q", value)
+	}
+
+	if n, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil || n != len(sc.SpanID) || len(parts[2]) != 16 {
+		return SpanContext{}, fmt.Errorf("invalid span id in traceparent %q", value)
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return SpanContext{}, fmt.Errorf("invalid flags in traceparent %q", value)
+	}
+
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a context whose spans are children of sc.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context of the current span, or a remote parent extracted from a request.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// Extract returns a context with the parent from the traceparent header when there's a valid one.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, err := ParseTraceparent(header.Get(traceparentHeader))
+	if err != nil {
+		return ctx
+	}
+
+	return ContextWithSpanContext(ctx, sc)
+}
+
+// Inject sets the traceparent header to the span of the context.
+func Inject(ctx context.Context, header http.Header) {
+	if sc, ok := SpanContextFromContext(ctx); ok {
+		header.Set(traceparentHeader, sc.Traceparent())
+	}
+}
+
+// SpanKind follows the OTLP span kinds.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// Span is a timed operation within a trace. A nil span is valid and does nothing, which is what a nil tracer starts.
+type Span struct {
+	tracer *Tracer
+
+	context SpanContext
+	parent  SpanID
+	kind    SpanKind
+	start   time.Time
+
+	mu         sync.Mutex
+	name       string
+	end        time.Time
+	attributes map[string]any
+	err        string
+	ended      bool
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.context
+}
+
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+// SetAttribute sets an attribute of the span, values should be strings, bools, ints or floats.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.attributes[key] = value
+	s.mu.Unlock()
+}
+
+// RecordError marks the span as failed, a nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.err = err.Error()
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed with a message.
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.err = message
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export. Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.context.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+// Exporter sends finished spans somewhere.
+type Exporter interface {
+	Export(ctx context.Context, spans []*Span) error
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer starts spans and exports them in batches in the background. A nil tracer is valid and doesn't trace.
+type Tracer struct {
+	exporters []Exporter
+	logger    *slog.Logger
+
+	mu      sync.Mutex
+	pending []*Span
+
+	flush    chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+type Options struct {
+	// OtlpEndpoint is the OTLP/HTTP traces url spans are sent to, such as http://localhost:4318/v1/traces
+	OtlpEndpoint string
+
+	// File is a path spans are appended to as OTLP JSON, one batch per line
+	File string
+
+	ServiceVersion string
+	Logger         *slog.Logger
+}
+
+// New creates a tracer exporting to the endpoint and file of the options. The tracer is nil when neither is set.
+func New(options Options) (*Tracer, error) {
+	var exporters []Exporter
+
+	if options.OtlpEndpoint != "" {
+		exporters = append(exporters, NewOtlpHttpExporter(options.OtlpEndpoint, options.ServiceVersion))
+	}
+
+	if options.File != "" {
+		exporter, err := NewFileExporter(options.File, options.ServiceVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		exporters = append(exporters, exporter)
+	}
+
+	if len(exporters) == 0 {
+		return nil, nil
+	}
+
+	return NewTracer(options.Logger, exporters...), nil
+}
+
+func NewTracer(logger *slog.Logger, exporters ...Exporter) *Tracer {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+
+	t := &Tracer{
+		exporters: exporters,
+		logger:    logger,
+		flush:     make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go t.run()
+
+	return t
+}
+
+// Start starts a span which is a child of the span in the context, or a new trace when there isn't one. The returned
+// context carries the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer:     t,
+		kind:       kind,
+		name:       name,
+		start:      time.Now(),
+		attributes: make(map[string]any),
+	}
+
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		span.context.TraceID = parent.TraceID
+		span.context.Sampled = parent.Sampled
+		span.parent = parent.SpanID
+	} else {
+		rand.Read(span.context.TraceID[:])
+		span.context.Sampled = true
+	}
+
+	rand.Read(span.context.SpanID[:])
+
+	return ContextWithSpanContext(ctx, span.context), span
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	t.mu.Lock()
+	t.pending = append(t.pending, span)
+	full := len(t.pending) >= maxBatchSize
+	t.mu.Unlock()
+
+	if full {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		case <-t.flush:
+		}
+
+		t.export(context.Background())
+	}
+}
+
+func (t *Tracer) export(ctx context.Context) {
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	for _, exporter := range t.exporters {
+		if err := exporter.Export(ctx, spans); err != nil {
+			t.logger.Warn("failed to export spans", "spans", len(spans), "error", err)
+		}
+	}
+}
+
+// Shutdown exports the spans which haven't been yet and shuts down the exporters.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	t.stopOnce.Do(func() { close(t.stop) })
+	<-t.done
+
+	t.export(ctx)
+
+	for _, exporter := range t.exporters {
+		if err := exporter.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		valid   bool
+		sampled bool
+	}{
+		{name: "sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", valid: true, sampled: true},
+		{name: "not sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", valid: true},
+		{name: "future version with extra fields", value: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", valid: true, sampled: true},
+		{name: "empty", value: ""},
+		{name: "invalid version", value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "extra fields in version 00", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{name: "zero trace id", value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "short span id", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa-01"},
+		{name: "not hex", value: "00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.value)
+
+			if tt.valid != (err == nil) {
+				t.Fatalf("Expected valid to be %v, got error: %v", tt.valid, err)
+			}
+
+			if !tt.valid {
+				return
+			}
+
+			if sc.Sampled != tt.sampled {
+				t.Errorf("Expected sampled to be %v, got: %v", tt.sampled, sc.Sampled)
+			}
+
+			if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+				t.Errorf("Expected the ids to be parsed, got: %s", sc.Traceparent())
+			}
+		})
+	}
+}
+
+func TestTracerExportsOtlp(t *testing.T) {
+	var received otlpTraces
+
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected json to be sent, got: %s", r.Header.Get("Content-Type"))
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer collector.Close()
+
+	tracer, err := New(Options{
+		OtlpEndpoint:   collector.URL,
+		ServiceVersion: "1.2.3",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	ctx, parent := tracer.Start(Extract(context.Background(), header), "parent", KindServer)
+	parent.SetAttribute("http.response.status_code", 200)
+
+	_, child := tracer.Start(ctx, "child", KindInternal)
+	child.SetError("failed")
+	child.End()
+	parent.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received.ResourceSpans) != 1 || len(received.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Expected one batch of spans, got: %+v", received)
+	}
+
+	spans := received.ResourceSpans[0].ScopeSpans[0].Spans
+
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got: %d", len(spans))
+	}
+
+	childSpan, parentSpan := spans[0], spans[1]
+
+	if parentSpan.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || childSpan.TraceId != parentSpan.TraceId {
+		t.Errorf("Expected the spans to continue the trace, got: %s and %s", parentSpan.TraceId, childSpan.TraceId)
+	}
+
+	if parentSpan.ParentSpanId != "00f067aa0ba902b7" || childSpan.ParentSpanId != parentSpan.SpanId {
+		t.Errorf("Expected the spans to be nested, got parents: %s and %s", parentSpan.ParentSpanId, childSpan.ParentSpanId)
+	}
+
+	if childSpan.Status == nil || childSpan.Status.Message != "failed" {
+		t.Errorf("Expected the child span to have failed, got: %+v", childSpan.Status)
+	}
+
+	if len(parentSpan.Attributes) != 1 || parentSpan.Attributes[0].Value.IntValue == nil || *parentSpan.Attributes[0].Value.IntValue != "200" {
+		t.Errorf("Expected an int status code attribute, got: %+v", parentSpan.Attributes)
+	}
+}
+
+func TestUnsampledSpansAreNotExported(t *testing.T) {
+	var exported int
+
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exported++
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer(nil, NewOtlpHttpExporter(collector.URL, ""))
+
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+
+	_, span := tracer.Start(Extract(context.Background(), header), "unsampled", KindServer)
+	span.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if exported != 0 {
+		t.Errorf("Expected no spans to be exported, got %d export(s)", exported)
+	}
+}
@@ -0,0 +1,520 @@
+package translate
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// openAIErrorTypes are the Anthropic error types of OpenAI's error types.
+var openAIErrorTypes = map[string]string{
+	"invalid_request_error": "invalid_request_error",
+	"authentication_error":  "authentication_error",
+	"permission_error":      "permission_error",
+	"not_found_error":       "not_found_error",
+	"rate_limit_exceeded":   "rate_limit_error",
+	"insufficient_quota":    "rate_limit_error",
+	"server_error":          "api_error",
+}
+
+// anthropicToOpenAIChat lets Anthropic Messages clients use any model through the OpenAI chat completions API.
+type anthropicToOpenAIChat struct{}
+
+func (anthropicToOpenAIChat) Request(body []byte) ([]byte, error) {
+	var req anthropicRequest
+
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, requestError("invalid messages request: %v", err)
+	}
+
+	out := openAIChatRequest{
+		Model:       req.Model,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stop:        req.StopSequences,
+		Stream:      req.Stream,
+	}
+
+	if req.MaxTokens > 0 {
+		out.MaxCompletionTokens = &req.MaxTokens
+	}
+
+	// OpenAI only sends the usage of a stream when it's asked for
+	if req.Stream {
+		out.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+
+	if req.Metadata != nil {
+		out.User = req.Metadata.UserID
+	}
+
+	if system := anthropicText(req.System); system != "" {
+		out.Messages = append(out.Messages, openAIMessage{Role: "system", Content: openAIContent{Text: system}})
+	}
+
+	for _, message := range req.Messages {
+		switch message.Role {
+		case "user":
+			messages, err := openAIUserMessages(message.Content)
+			if err != nil {
+				return nil, err
+			}
+
+			out.Messages = append(out.Messages, messages...)
+		case "assistant":
+			assistant := openAIMessage{Role: "assistant"}
+
+			for _, block := range message.Content {
+				if block.Type != "tool_use" {
+					continue
+				}
+
+				assistant.ToolCalls = append(assistant.ToolCalls, openAIToolCall{
+					ID:       block.ID,
+					Type:     "function",
+					Function: openAIFunctionCall{Name: block.Name, Arguments: toolArguments(block.Input)},
+				})
+			}
+
+			// Thinking is left out as it can't be given back to other models
+			assistant.Content = openAIContent{Text: anthropicText(message.Content)}
+			if assistant.Content.Text == "" && len(assistant.ToolCalls) == 0 {
+				continue
+			}
+
+			out.Messages = append(out.Messages, assistant)
+		default:
+			return nil, requestError("unsupported message role %q", message.Role)
+		}
+	}
+
+	for _, tool := range req.Tools {
+		if tool.Type != "" && tool.Type != "custom" {
+			return nil, requestError("unsupported tool type %q, only the client's own tools can be used with OpenAI", tool.Type)
+		}
+
+		out.Tools = append(out.Tools, openAITool{
+			Type:     "function",
+			Function: openAIFunction{Name: tool.Name, Description: tool.Description, Parameters: tool.InputSchema},
+		})
+	}
+
+	if req.ToolChoice != nil {
+		switch req.ToolChoice.Type {
+		case "tool":
+			out.ToolChoice = &openAIToolChoice{Function: req.ToolChoice.Name}
+		case "any":
+			out.ToolChoice = &openAIToolChoice{Mode: "required"}
+		case "none":
+			out.ToolChoice = &openAIToolChoice{Mode: "none"}
+		default:
+			out.ToolChoice = &openAIToolChoice{Mode: "auto"}
+		}
+
+		if req.ToolChoice.DisableParallelToolUse && len(out.Tools) > 0 {
+			parallelToolCalls := false
+			out.ParallelToolCalls = &parallelToolCalls
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+// anthropicText returns the text of content blocks, joining their text blocks.
+func anthropicText(content anthropicContent) string {
+	var texts []string
+
+	for _, block := range content {
+		if block.Type == "text" {
+			texts = append(texts, block.Text)
+		}
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+// openAIUserMessages converts the content of an Anthropic user message into OpenAI messages. Tool results become tool
+// messages, which are sent first as they must follow the assistant's tool calls.
+func openAIUserMessages(content anthropicContent) ([]openAIMessage, error) {
+	var messages []openAIMessage
+	var parts []openAIContentPart
+
+	for _, block := range content {
+		switch block.Type {
+		case "text":
+			parts = append(parts, openAIContentPart{Type: "text", Text: block.Text})
+		case "image":
+			url, err := openAIURLFromSource(block.Source)
+			if err != nil {
+				return nil, err
+			}
+
+			parts = append(parts, openAIContentPart{Type: "image_url", ImageURL: &openAIImageURL{URL: url}})
+		case "document":
+			if block.Source != nil && block.Source.Type == "text" {
+				parts = append(parts, openAIContentPart{Type: "text", Text: block.Source.Data})
+				continue
+			}
+
+			if block.Source == nil || block.Source.Type != "base64" {
+				return nil, requestError("documents must be base64 or text, other documents aren't supported by OpenAI")
+			}
+
+			url, err := openAIURLFromSource(block.Source)
+			if err != nil {
+				return nil, err
+			}
+
+			parts = append(parts, openAIContentPart{Type: "file", File: &openAIFile{FileData: url, Filename: "document.pdf"}})
+		case "tool_result":
+			// OpenAI's tool messages only take text
+			messages = append(messages, openAIMessage{Role: "tool", ToolCallID: block.ToolUseID, Content: openAIContent{Text: anthropicText(block.Content)}})
+		case "thinking", "redacted_thinking":
+			continue
+		default:
+			return nil, requestError("unsupported content block type %q", block.Type)
+		}
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0].Type == "text":
+		messages = append(messages, openAIMessage{Role: "user", Content: openAIContent{Text: parts[0].Text}})
+	case len(parts) > 0:
+		messages = append(messages, openAIMessage{Role: "user", Content: openAIContent{Parts: parts}})
+	}
+
+	return messages, nil
+}
+
+// openAIURLFromSource converts base64 data into a data url and passes on urls.
+func openAIURLFromSource(source *anthropicSource) (string, error) {
+	switch {
+	case source == nil:
+		return "", requestError("image and document blocks must have a source")
+	case source.Type == "base64":
+		return "data:" + source.MediaType + ";base64," + source.Data, nil
+	case source.Type == "url":
+		return source.URL, nil
+	default:
+		return "", requestError("unsupported source type %q", source.Type)
+	}
+}
+
+func (anthropicToOpenAIChat) Response(body []byte) ([]byte, error) {
+	if errorBody, ok := openAIErrorToAnthropic(body); ok {
+		return errorBody, nil
+	}
+
+	var res openAIChatResponse
+
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+
+	out := anthropicResponse{
+		ID:      res.ID,
+		Type:    "message",
+		Role:    "assistant",
+		Model:   res.Model,
+		Content: []anthropicContentBlock{},
+	}
+
+	var finishReason *string
+
+	if len(res.Choices) > 0 && res.Choices[0].Message != nil {
+		message := res.Choices[0].Message
+		finishReason = res.Choices[0].FinishReason
+
+		text := message.Content.text()
+		if text == "" {
+			text = message.Refusal
+		}
+
+		if text != "" {
+			out.Content = append(out.Content, anthropicContentBlock{Type: "text", Text: text})
+		}
+
+		for _, toolCall := range message.ToolCalls {
+			out.Content = append(out.Content, anthropicContentBlock{
+				Type:  "tool_use",
+				ID:    toolCall.ID,
+				Name:  toolCall.Function.Name,
+				Input: toolInput(toolCall.Function.Arguments),
+			})
+		}
+	}
+
+	stopReason := anthropicStopReason(finishReason)
+	out.StopReason = &stopReason
+
+	if res.Usage != nil {
+		out.Usage = anthropicUsageFromOpenAI(*res.Usage)
+	}
+
+	return json.Marshal(out)
+}
+
+// openAIErrorToAnthropic converts the body to an Anthropic error if it's an OpenAI error.
+func openAIErrorToAnthropic(body []byte) ([]byte, bool) {
+	var openAIErr struct {
+		Error *openAIErrorDetail `json:"error"`
+	}
+
+	if err := json.Unmarshal(body, &openAIErr); err != nil || openAIErr.Error == nil {
+		return nil, false
+	}
+
+	data, err := json.Marshal(anthropicErrorFromOpenAI(*openAIErr.Error))
+	if err != nil {
+		return nil, false
+	}
+
+	return data, true
+}
+
+func anthropicErrorFromOpenAI(detail openAIErrorDetail) anthropicError {
+	errorType, ok := openAIErrorTypes[detail.Type]
+	if !ok {
+		errorType = "api_error"
+	}
+
+	return anthropicError{Type: "error", Error: anthropicErrorDetail{Type: errorType, Message: detail.Message}}
+}
+
+// toolInput returns the arguments of a tool call as the input of a tool_use block, which must be an object.
+func toolInput(arguments string) json.RawMessage {
+	input := json.RawMessage(strings.TrimSpace(arguments))
+	if !json.Valid(input) || !strings.HasPrefix(string(input), "{") {
+		return json.RawMessage("{}")
+	}
+
+	return input
+}
+
+// anthropicStopReason returns the stop reason of an OpenAI finish reason.
+func anthropicStopReason(finishReason *string) string {
+	if finishReason == nil {
+		return "end_turn"
+	}
+
+	switch *finishReason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	case "content_filter":
+		return "refusal"
+	default:
+		return "end_turn"
+	}
+}
+
+func anthropicUsageFromOpenAI(usage openAIUsage) anthropicUsage {
+	// Anthropic counts cached tokens separately whereas OpenAI counts them as part of the prompt
+	cachedTokens := 0
+	if usage.PromptTokensDetails != nil {
+		cachedTokens = usage.PromptTokensDetails.CachedTokens
+	}
+
+	return anthropicUsage{
+		InputTokens:          usage.PromptTokens - cachedTokens,
+		OutputTokens:         usage.CompletionTokens,
+		CacheReadInputTokens: cachedTokens,
+	}
+}
+
+func (anthropicToOpenAIChat) Stream() Stream {
+	return &openAIChatToAnthropicStream{toolCalls: make(map[int]int), block: -1}
+}
+
+// openAIChatToAnthropicStream translates chat completion chunks into the events of a streamed message. The message is
+// only finished once the stream is done, as the usage comes in a chunk after the finish reason.
+type openAIChatToAnthropicStream struct {
+	started  bool
+	finished bool
+
+	finishReason *string
+	usage        anthropicUsage
+
+	// block is the index of the open content block, or -1 when there isn't one, and blocks is the number of blocks
+	block     int
+	blockType string
+	blocks    int
+
+	// toolCalls are the index of the content block of each tool call
+	toolCalls map[int]int
+
+	// pending are the events to send for the chunk being translated
+	pending []pendingEvent
+}
+
+type pendingEvent struct {
+	name string
+	data any
+}
+
+func (s *openAIChatToAnthropicStream) Event(event Event) ([]Event, error) {
+	data := strings.TrimSpace(event.Data)
+
+	if data == "" {
+		return nil, nil
+	}
+
+	if data == "[DONE]" {
+		return s.finish()
+	}
+
+	if errorBody, ok := openAIErrorToAnthropic([]byte(data)); ok {
+		s.finished = true
+		return []Event{{Name: "error", Data: string(errorBody)}}, nil
+	}
+
+	var chunk openAIChatResponse
+
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return nil, err
+	}
+
+	if !s.started {
+		s.started = true
+
+		s.emit("message_start", anthropicStreamEvent{Type: "message_start", Message: &anthropicResponse{
+			ID:      chunk.ID,
+			Type:    "message",
+			Role:    "assistant",
+			Model:   chunk.Model,
+			Content: []anthropicContentBlock{},
+		}})
+	}
+
+	if chunk.Usage != nil {
+		s.usage = anthropicUsageFromOpenAI(*chunk.Usage)
+	}
+
+	if len(chunk.Choices) == 0 || chunk.Choices[0].Delta == nil {
+		return s.flush()
+	}
+
+	delta := chunk.Choices[0].Delta
+
+	if delta.Content != nil && *delta.Content != "" {
+		if s.blockType != "text" {
+			s.stopBlock()
+
+			// Clients expect the text of a text block to be set, even though it's empty
+			s.startBlock("text", json.RawMessage(`{"type":"text","text":""}`))
+		}
+
+		index := s.block
+		s.emit("content_block_delta", anthropicStreamEvent{
+			Type:  "content_block_delta",
+			Index: &index,
+			Delta: &anthropicDelta{Type: "text_delta", Text: *delta.Content},
+		})
+	}
+
+	for _, toolCall := range delta.ToolCalls {
+		toolCallIndex := 0
+		if toolCall.Index != nil {
+			toolCallIndex = *toolCall.Index
+		}
+
+		block, ok := s.toolCalls[toolCallIndex]
+		if !ok {
+			s.stopBlock()
+			s.startBlock("tool_use", anthropicContentBlock{
+				Type:  "tool_use",
+				ID:    toolCall.ID,
+				Name:  toolCall.Function.Name,
+				Input: json.RawMessage("{}"),
+			})
+
+			block = s.block
+			s.toolCalls[toolCallIndex] = block
+		}
+
+		if toolCall.Function.Arguments == "" {
+			continue
+		}
+
+		s.emit("content_block_delta", anthropicStreamEvent{
+			Type:  "content_block_delta",
+			Index: &block,
+			Delta: &anthropicDelta{Type: "input_json_delta", PartialJSON: toolCall.Function.Arguments},
+		})
+	}
+
+	if chunk.Choices[0].FinishReason != nil {
+		s.finishReason = chunk.Choices[0].FinishReason
+	}
+
+	return s.flush()
+}
+
+func (s *openAIChatToAnthropicStream) End() ([]Event, error) {
+	return s.finish()
+}
+
+// finish stops the open content block and finishes the message, with its stop reason and usage.
+func (s *openAIChatToAnthropicStream) finish() ([]Event, error) {
+	if !s.started || s.finished {
+		return nil, nil
+	}
+
+	s.finished = true
+	s.stopBlock()
+
+	usage := s.usage
+	s.emit("message_delta", anthropicStreamEvent{
+		Type:  "message_delta",
+		Delta: &anthropicDelta{StopReason: anthropicStopReason(s.finishReason)},
+		Usage: &usage,
+	})
+	s.emit("message_stop", anthropicStreamEvent{Type: "message_stop"})
+
+	return s.flush()
+}
+
+func (s *openAIChatToAnthropicStream) startBlock(blockType string, block any) {
+	s.block = s.blocks
+	s.blockType = blockType
+	s.blocks++
+
+	s.emit("content_block_start", struct {
+		Type         string `json:"type"`
+		Index        int    `json:"index"`
+		ContentBlock any    `json:"content_block"`
+	}{"content_block_start", s.block, block})
+}
+
+func (s *openAIChatToAnthropicStream) stopBlock() {
+	if s.block < 0 {
+		return
+	}
+
+	index := s.block
+	s.block = -1
+	s.blockType = ""
+
+	s.emit("content_block_stop", anthropicStreamEvent{Type: "content_block_stop", Index: &index})
+}
+
+func (s *openAIChatToAnthropicStream) emit(name string, data any) {
+	s.pending = append(s.pending, pendingEvent{name: name, data: data})
+}
+
+// flush returns the pending events, which are named after their type as Anthropic's are.
+func (s *openAIChatToAnthropicStream) flush() ([]Event, error) {
+	events := make([]Event, 0, len(s.pending))
+
+	for _, pending := range s.pending {
+		data, err := json.Marshal(pending.data)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, Event{Name: pending.name, Data: string(data)})
+	}
+
+	s.pending = nil
+	return events, nil
+}
@@ -0,0 +1,220 @@
+package translate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAnthropicToOpenAIChatRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+		err      string
+	}{
+		{
+			name: "text",
+			body: `{"model":"gpt-5","max_tokens":100,"stream":true,"system":[{"type":"text","text":"Be brief."},{"type":"text","text":"Be nice."}],
+				"messages":[{"role":"user","content":"Hi"}]}`,
+			expected: `{"model":"gpt-5","max_completion_tokens":100,"stream":true,"stream_options":{"include_usage":true},"messages":[
+				{"role":"system","content":"Be brief.\nBe nice."},
+				{"role":"user","content":"Hi"}
+			]}`,
+		},
+		{
+			name: "images and documents",
+			body: `{"model":"m","max_tokens":100,"messages":[{"role":"user","content":[
+				{"type":"text","text":"What are these?"},
+				{"type":"image","source":{"type":"base64","media_type":"image/png","data":"aGk="}},
+				{"type":"image","source":{"type":"url","url":"https://example.com/cat.jpg"}},
+				{"type":"document","source":{"type":"base64","media_type":"application/pdf","data":"aGk="}}
+			]}]}`,
+			expected: `{"model":"m","max_completion_tokens":100,"messages":[{"role":"user","content":[
+				{"type":"text","text":"What are these?"},
+				{"type":"image_url","image_url":{"url":"data:image/png;base64,aGk="}},
+				{"type":"image_url","image_url":{"url":"https://example.com/cat.jpg"}},
+				{"type":"file","file":{"file_data":"data:application/pdf;base64,aGk=","filename":"document.pdf"}}
+			]}]}`,
+		},
+		{
+			name: "tools",
+			body: `{"model":"m","max_tokens":100,"tool_choice":{"type":"any","disable_parallel_tool_use":true},
+				"tools":[{"name":"weather","description":"Get the weather","input_schema":{"type":"object","properties":{"city":{"type":"string"}}}}],
+				"messages":[
+					{"role":"user","content":"Weather in Sydney?"},
+					{"role":"assistant","content":[
+						{"type":"thinking","thinking":"Let me check","signature":"sig"},
+						{"type":"text","text":"Checking"},
+						{"type":"tool_use","id":"toolu_1","name":"weather","input":{"city":"Sydney"}}
+					]},
+					{"role":"user","content":[
+						{"type":"tool_result","tool_use_id":"toolu_1","content":[{"type":"text","text":"Sunny"}]},
+						{"type":"text","text":"And tomorrow?"}
+					]}
+				]}`,
+			expected: `{"model":"m","max_completion_tokens":100,"tool_choice":"required","parallel_tool_calls":false,
+				"tools":[{"type":"function","function":{"name":"weather","description":"Get the weather","parameters":{"type":"object","properties":{"city":{"type":"string"}}}}}],
+				"messages":[
+					{"role":"user","content":"Weather in Sydney?"},
+					{"role":"assistant","content":"Checking","tool_calls":[
+						{"id":"toolu_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Sydney\"}"}}
+					]},
+					{"role":"tool","tool_call_id":"toolu_1","content":"Sunny"},
+					{"role":"user","content":"And tomorrow?"}
+				]}`,
+		},
+		{
+			name: "server tools",
+			body: `{"model":"m","max_tokens":100,"tools":[{"type":"web_search_20250305","name":"web_search"}],"messages":[{"role":"user","content":"Hi"}]}`,
+			err:  `unsupported tool type "web_search_20250305"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := anthropicToOpenAIChat{}.Request([]byte(tt.body))
+
+			if tt.err != "" {
+				var requestErr *RequestError
+
+				if !errors.As(err, &requestErr) || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Expected a request error containing %q, got: %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if expected, actual := normaliseJson(t, tt.expected), normaliseJson(t, string(body)); actual != expected {
+				t.Errorf("Expected %s, got: %s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestAnthropicToOpenAIChatResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name: "text",
+			body: `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-5",
+				"choices":[{"index":0,"message":{"role":"assistant","content":"Hello"},"finish_reason":"length"}],
+				"usage":{"prompt_tokens":15,"completion_tokens":3,"total_tokens":18,"prompt_tokens_details":{"cached_tokens":5}}}`,
+			expected: `{"id":"chatcmpl-1","type":"message","role":"assistant","model":"gpt-5","content":[{"type":"text","text":"Hello"}],
+				"stop_reason":"max_tokens","stop_sequence":null,"usage":{"input_tokens":10,"output_tokens":3,"cache_read_input_tokens":5}}`,
+		},
+		{
+			name: "tool calls",
+			body: `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-5",
+				"choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[
+					{"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Sydney\"}"}}
+				]},"finish_reason":"tool_calls"}],
+				"usage":{"prompt_tokens":1,"completion_tokens":2,"total_tokens":3}}`,
+			expected: `{"id":"chatcmpl-1","type":"message","role":"assistant","model":"gpt-5",
+				"content":[{"type":"tool_use","id":"call_1","name":"weather","input":{"city":"Sydney"}}],
+				"stop_reason":"tool_use","stop_sequence":null,"usage":{"input_tokens":1,"output_tokens":2}}`,
+		},
+		{
+			name:     "error",
+			body:     `{"error":{"message":"Slow down","type":"rate_limit_exceeded","param":null,"code":"rate_limit_exceeded"}}`,
+			expected: `{"type":"error","error":{"type":"rate_limit_error","message":"Slow down"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := anthropicToOpenAIChat{}.Response([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if expected, actual := normaliseJson(t, tt.expected), normaliseJson(t, string(body)); actual != expected {
+				t.Errorf("Expected %s, got: %s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestAnthropicToOpenAIChatStream(t *testing.T) {
+	chunk := `{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-5","choices":[{"index":0,%s}]}`
+
+	events := []string{
+		strings.Replace(chunk, "%s", `"delta":{"role":"assistant","content":""},"finish_reason":null`, 1),
+		strings.Replace(chunk, "%s", `"delta":{"content":"Checking"},"finish_reason":null`, 1),
+		strings.Replace(chunk, "%s", `"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"weather","arguments":""}}]},"finish_reason":null`, 1),
+		strings.Replace(chunk, "%s", `"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":\"Sydney\"}"}}]},"finish_reason":null`, 1),
+		strings.Replace(chunk, "%s", `"delta":{},"finish_reason":"tool_calls"`, 1),
+		`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-5","choices":[],
+			"usage":{"prompt_tokens":10,"completion_tokens":20,"total_tokens":30}}`,
+		`[DONE]`,
+	}
+
+	expected := []Event{
+		{Name: "message_start", Data: `{"type":"message_start","message":{"id":"chatcmpl-1","type":"message","role":"assistant","model":"gpt-5",
+			"content":[],"stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":0,"output_tokens":0}}}`},
+		{Name: "content_block_start", Data: `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`},
+		{Name: "content_block_delta", Data: `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Checking"}}`},
+		{Name: "content_block_stop", Data: `{"type":"content_block_stop","index":0}`},
+		{Name: "content_block_start", Data: `{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"call_1","name":"weather","input":{}}}`},
+		{Name: "content_block_delta", Data: `{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":\"Sydney\"}"}}`},
+		{Name: "content_block_stop", Data: `{"type":"content_block_stop","index":1}`},
+		{Name: "message_delta", Data: `{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"input_tokens":10,"output_tokens":20}}`},
+		{Name: "message_stop", Data: `{"type":"message_stop"}`},
+	}
+
+	stream := anthropicToOpenAIChat{}.Stream()
+
+	var actual []Event
+
+	for _, data := range events {
+		translated, err := stream.Event(Event{Data: data})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual = append(actual, translated...)
+	}
+
+	translated, err := stream.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual = append(actual, translated...)
+
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %d events, got: %d\n%v", len(expected), len(actual), actual)
+	}
+
+	for i := range expected {
+		if actual[i].Name != expected[i].Name {
+			t.Errorf("Expected event %d to be named %s, got: %s", i, expected[i].Name, actual[i].Name)
+		}
+
+		if e, a := normaliseJson(t, expected[i].Data), normaliseJson(t, actual[i].Data); e != a {
+			t.Errorf("Expected event %d to be %s, got: %s", i, e, a)
+		}
+	}
+}
+
+func TestAnthropicToOpenAIChatStreamError(t *testing.T) {
+	translated, err := anthropicToOpenAIChat{}.Stream().Event(Event{
+		Data: `{"error":{"message":"Overloaded","type":"server_error","param":null,"code":null}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"error":{"message":"Overloaded","type":"api_error"},"type":"error"}`
+
+	if len(translated) != 1 || translated[0].Name != "error" || normaliseJson(t, translated[0].Data) != expected {
+		t.Errorf("Expected %s, got: %v", expected, translated)
+	}
+}
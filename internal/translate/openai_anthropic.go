@@ -0,0 +1,529 @@
+package translate
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	// defaultMaxTokens is used when a request doesn't set a limit, which Anthropic requires
+	defaultMaxTokens = 8192
+
+	// responseFormatTool is the tool Claude is made to call to answer with a json schema
+	responseFormatTool = "json_response"
+)
+
+// anthropicErrorTypes are the OpenAI error types of Anthropic's error types.
+var anthropicErrorTypes = map[string]string{
+	"invalid_request_error": "invalid_request_error",
+	"authentication_error":  "authentication_error",
+	"permission_error":      "permission_error",
+	"not_found_error":       "not_found_error",
+	"rate_limit_error":      "rate_limit_exceeded",
+	"api_error":             "server_error",
+	"overloaded_error":      "server_error",
+}
+
+// openAIChatToAnthropic lets OpenAI chat completions clients use Claude through the Anthropic Messages API.
+type openAIChatToAnthropic struct{}
+
+func (openAIChatToAnthropic) Request(body []byte) ([]byte, error) {
+	var req openAIChatRequest
+
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, requestError("invalid chat completions request: %v", err)
+	}
+
+	if req.N != nil && *req.N > 1 {
+		return nil, requestError("n greater than 1 isn't supported by Claude")
+	}
+
+	out := anthropicRequest{
+		Model:         req.Model,
+		MaxTokens:     defaultMaxTokens,
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		StopSequences: req.Stop,
+		Stream:        req.Stream,
+	}
+
+	if req.MaxCompletionTokens != nil {
+		out.MaxTokens = *req.MaxCompletionTokens
+	} else if req.MaxTokens != nil {
+		out.MaxTokens = *req.MaxTokens
+	}
+
+	if req.User != "" {
+		out.Metadata = &anthropicMetadata{UserID: req.User}
+	}
+
+	for _, message := range req.Messages {
+		switch message.Role {
+		case "system", "developer":
+			// Claude only takes a single system prompt so every system message is added to it
+			if text := message.Content.text(); text != "" {
+				out.System = append(out.System, anthropicContentBlock{Type: "text", Text: text})
+			}
+		case "user":
+			blocks, err := anthropicBlocks(message.Content)
+			if err != nil {
+				return nil, err
+			}
+
+			out.Messages = appendAnthropicMessage(out.Messages, "user", blocks)
+		case "assistant":
+			blocks, err := anthropicBlocks(message.Content)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, toolCall := range message.ToolCalls {
+				input := json.RawMessage(toolCall.Function.Arguments)
+				if strings.TrimSpace(toolCall.Function.Arguments) == "" {
+					input = json.RawMessage("{}")
+				}
+
+				if !json.Valid(input) {
+					return nil, requestError("arguments of tool call %s aren't valid json", toolCall.ID)
+				}
+
+				blocks = append(blocks, anthropicContentBlock{Type: "tool_use", ID: toolCall.ID, Name: toolCall.Function.Name, Input: input})
+			}
+
+			out.Messages = appendAnthropicMessage(out.Messages, "assistant", blocks)
+		case "tool":
+			result := anthropicContentBlock{Type: "tool_result", ToolUseID: message.ToolCallID}
+
+			if text := message.Content.text(); text != "" {
+				result.Content = anthropicContent{{Type: "text", Text: text}}
+			}
+
+			out.Messages = appendAnthropicMessage(out.Messages, "user", []anthropicContentBlock{result})
+		default:
+			return nil, requestError("unsupported message role %q", message.Role)
+		}
+	}
+
+	for _, tool := range req.Tools {
+		if tool.Type != "function" {
+			continue
+		}
+
+		schema := tool.Function.Parameters
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object","properties":{}}`)
+		}
+
+		out.Tools = append(out.Tools, anthropicTool{Name: tool.Function.Name, Description: tool.Function.Description, InputSchema: schema})
+	}
+
+	if req.ToolChoice != nil {
+		switch {
+		case req.ToolChoice.Function != "":
+			out.ToolChoice = &anthropicToolChoice{Type: "tool", Name: req.ToolChoice.Function}
+		case req.ToolChoice.Mode == "required":
+			out.ToolChoice = &anthropicToolChoice{Type: "any"}
+		case req.ToolChoice.Mode == "none":
+			out.ToolChoice = &anthropicToolChoice{Type: "none"}
+		default:
+			out.ToolChoice = &anthropicToolChoice{Type: "auto"}
+		}
+	}
+
+	if req.ParallelToolCalls != nil && !*req.ParallelToolCalls && len(out.Tools) > 0 {
+		if out.ToolChoice == nil {
+			out.ToolChoice = &anthropicToolChoice{Type: "auto"}
+		}
+
+		if out.ToolChoice.Type != "none" {
+			out.ToolChoice.DisableParallelToolUse = true
+		}
+	}
+
+	if req.ResponseFormat != nil {
+		switch req.ResponseFormat.Type {
+		case "json_schema":
+			// Claude doesn't have a json mode so it's made to answer by calling a tool with the schema as its input
+			if req.ResponseFormat.JSONSchema == nil || len(req.ResponseFormat.JSONSchema.Schema) == 0 {
+				return nil, requestError("response_format json_schema must have a schema")
+			}
+
+			out.Tools = append(out.Tools, anthropicTool{
+				Name:        responseFormatTool,
+				Description: strings.TrimSpace("Respond with a JSON object. " + req.ResponseFormat.JSONSchema.Description),
+				InputSchema: req.ResponseFormat.JSONSchema.Schema,
+			})
+			out.ToolChoice = &anthropicToolChoice{Type: "tool", Name: responseFormatTool}
+		case "json_object":
+			out.System = append(out.System, anthropicContentBlock{Type: "text", Text: "Respond only with a JSON object."})
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+// anthropicBlocks converts the content of an OpenAI message into content blocks.
+func anthropicBlocks(content openAIContent) ([]anthropicContentBlock, error) {
+	if content.Parts == nil {
+		if content.Text == "" {
+			return nil, nil
+		}
+
+		return []anthropicContentBlock{{Type: "text", Text: content.Text}}, nil
+	}
+
+	var blocks []anthropicContentBlock
+
+	for _, part := range content.Parts {
+		switch part.Type {
+		case "text":
+			blocks = append(blocks, anthropicContentBlock{Type: "text", Text: part.Text})
+		case "image_url":
+			if part.ImageURL == nil {
+				return nil, requestError("image_url part must have an image_url")
+			}
+
+			source, err := anthropicSourceFromURL(part.ImageURL.URL)
+			if err != nil {
+				return nil, err
+			}
+
+			blocks = append(blocks, anthropicContentBlock{Type: "image", Source: source})
+		case "file":
+			if part.File == nil || part.File.FileData == "" {
+				return nil, requestError("file parts must have file_data, file ids aren't supported by Claude")
+			}
+
+			source, err := anthropicSourceFromURL(part.File.FileData)
+			if err != nil {
+				return nil, err
+			}
+
+			blocks = append(blocks, anthropicContentBlock{Type: "document", Source: source})
+		default:
+			return nil, requestError("unsupported content part type %q", part.Type)
+		}
+	}
+
+	return blocks, nil
+}
+
+// anthropicSourceFromURL converts a data url into base64 data and passes on http urls for Claude to fetch.
+func anthropicSourceFromURL(url string) (*anthropicSource, error) {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return &anthropicSource{Type: "url", URL: url}, nil
+	}
+
+	mediaType, data, ok := parseDataURL(url)
+	if !ok {
+		return nil, requestError("unsupported url %q, expected an http url or a base64 data url", truncate(url, 32))
+	}
+
+	return &anthropicSource{Type: "base64", MediaType: mediaType, Data: data}, nil
+}
+
+// parseDataURL splits a base64 data url into its media type and data.
+func parseDataURL(url string) (mediaType, data string, ok bool) {
+	header, data, found := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	if !strings.HasPrefix(url, "data:") || !found {
+		return "", "", false
+	}
+
+	mediaType, found = strings.CutSuffix(header, ";base64")
+	if !found || mediaType == "" {
+		return "", "", false
+	}
+
+	return mediaType, data, true
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+
+	return s[:length] + "..."
+}
+
+// appendAnthropicMessage adds content to the conversation. Content from the same role as the last message is added to
+// it, so that tool results are sent together and the roles alternate as Claude expects.
+func appendAnthropicMessage(messages []anthropicMessage, role string, blocks []anthropicContentBlock) []anthropicMessage {
+	if len(blocks) == 0 {
+		return messages
+	}
+
+	if len(messages) > 0 && messages[len(messages)-1].Role == role {
+		messages[len(messages)-1].Content = append(messages[len(messages)-1].Content, blocks...)
+		return messages
+	}
+
+	return append(messages, anthropicMessage{Role: role, Content: blocks})
+}
+
+func (openAIChatToAnthropic) Response(body []byte) ([]byte, error) {
+	if errorBody, ok := anthropicErrorToOpenAI(body); ok {
+		return errorBody, nil
+	}
+
+	var res anthropicResponse
+
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+
+	message := openAIMessage{Role: "assistant"}
+	var text strings.Builder
+	jsonResponse := false
+
+	for _, block := range res.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "tool_use":
+			if block.Name == responseFormatTool {
+				text.Write(block.Input)
+				jsonResponse = true
+
+				continue
+			}
+
+			message.ToolCalls = append(message.ToolCalls, openAIToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: openAIFunctionCall{Name: block.Name, Arguments: toolArguments(block.Input)},
+			})
+		}
+	}
+
+	message.Content = openAIContent{Text: text.String()}
+
+	finishReason := openAIFinishReason(res.StopReason)
+	if jsonResponse {
+		finishReason = "stop"
+	}
+
+	return json.Marshal(openAIChatResponse{
+		ID:      res.ID,
+		Object:  "chat.completion",
+		Created: now().Unix(),
+		Model:   res.Model,
+		Choices: []openAIChoice{{Message: &message, FinishReason: &finishReason}},
+		Usage:   openAIUsageFromAnthropic(res.Usage),
+	})
+}
+
+// anthropicErrorToOpenAI converts the body to an OpenAI error if it's an Anthropic error.
+func anthropicErrorToOpenAI(body []byte) ([]byte, bool) {
+	var anthropicErr anthropicError
+
+	if err := json.Unmarshal(body, &anthropicErr); err != nil || anthropicErr.Type != "error" {
+		return nil, false
+	}
+
+	data, err := json.Marshal(openAIErrorFromAnthropic(anthropicErr.Error))
+	if err != nil {
+		return nil, false
+	}
+
+	return data, true
+}
+
+func openAIErrorFromAnthropic(detail anthropicErrorDetail) openAIError {
+	errorType, ok := anthropicErrorTypes[detail.Type]
+	if !ok {
+		errorType = "server_error"
+	}
+
+	return openAIError{Error: openAIErrorDetail{Message: detail.Message, Type: errorType, Code: errorType}}
+}
+
+func toolArguments(input json.RawMessage) string {
+	if len(input) == 0 {
+		return "{}"
+	}
+
+	return string(input)
+}
+
+// openAIFinishReason returns the finish reason of an Anthropic stop reason.
+func openAIFinishReason(stopReason *string) string {
+	if stopReason == nil {
+		return "stop"
+	}
+
+	switch *stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
+
+func openAIUsageFromAnthropic(usage anthropicUsage) *openAIUsage {
+	// OpenAI counts cached tokens as part of the prompt whereas Anthropic counts them separately
+	promptTokens := usage.InputTokens + usage.CacheCreationInputTokens + usage.CacheReadInputTokens
+
+	return &openAIUsage{
+		PromptTokens:        promptTokens,
+		CompletionTokens:    usage.OutputTokens,
+		TotalTokens:         promptTokens + usage.OutputTokens,
+		PromptTokensDetails: &openAIPromptTokensDetails{CachedTokens: usage.CacheReadInputTokens},
+	}
+}
+
+func (openAIChatToAnthropic) Stream() Stream {
+	return &anthropicToOpenAIChatStream{toolCalls: make(map[int]int), jsonBlocks: make(map[int]bool)}
+}
+
+// anthropicToOpenAIChatStream translates the events of a streamed message into chat completion chunks.
+type anthropicToOpenAIChatStream struct {
+	id      string
+	model   string
+	created int64
+	usage   anthropicUsage
+
+	// toolCalls are the index of the tool call of each tool_use content block
+	toolCalls map[int]int
+
+	// jsonBlocks are the content blocks answering with the response format's json schema, their input is sent as
+	// content
+	jsonBlocks map[int]bool
+}
+
+func (s *anthropicToOpenAIChatStream) Event(event Event) ([]Event, error) {
+	if strings.TrimSpace(event.Data) == "" {
+		return nil, nil
+	}
+
+	var e anthropicStreamEvent
+
+	if err := json.Unmarshal([]byte(event.Data), &e); err != nil {
+		return nil, err
+	}
+
+	index := 0
+	if e.Index != nil {
+		index = *e.Index
+	}
+
+	switch e.Type {
+	case "message_start":
+		if e.Message != nil {
+			s.id = e.Message.ID
+			s.model = e.Message.Model
+			s.usage = e.Message.Usage
+		}
+
+		s.created = now().Unix()
+		empty := ""
+
+		return s.chunk(&openAIDelta{Role: "assistant", Content: &empty}, nil)
+	case "content_block_start":
+		if e.ContentBlock == nil || e.ContentBlock.Type != "tool_use" {
+			return nil, nil
+		}
+
+		if e.ContentBlock.Name == responseFormatTool {
+			s.jsonBlocks[index] = true
+			return nil, nil
+		}
+
+		toolCall := len(s.toolCalls)
+		s.toolCalls[index] = toolCall
+
+		return s.chunk(&openAIDelta{ToolCalls: []openAIToolCall{{
+			Index:    &toolCall,
+			ID:       e.ContentBlock.ID,
+			Type:     "function",
+			Function: openAIFunctionCall{Name: e.ContentBlock.Name},
+		}}}, nil)
+	case "content_block_delta":
+		if e.Delta == nil {
+			return nil, nil
+		}
+
+		switch {
+		case e.Delta.Type == "text_delta" && e.Delta.Text != "":
+			return s.chunk(&openAIDelta{Content: &e.Delta.Text}, nil)
+		case e.Delta.Type == "input_json_delta" && e.Delta.PartialJSON != "":
+			if s.jsonBlocks[index] {
+				return s.chunk(&openAIDelta{Content: &e.Delta.PartialJSON}, nil)
+			}
+
+			toolCall := s.toolCalls[index]
+
+			return s.chunk(&openAIDelta{ToolCalls: []openAIToolCall{{
+				Index:    &toolCall,
+				Function: openAIFunctionCall{Arguments: e.Delta.PartialJSON},
+			}}}, nil)
+		}
+
+		return nil, nil
+	case "message_delta":
+		if e.Usage != nil {
+			s.usage.OutputTokens = e.Usage.OutputTokens
+		}
+
+		if e.Delta == nil || e.Delta.StopReason == "" {
+			return nil, nil
+		}
+
+		finishReason := openAIFinishReason(&e.Delta.StopReason)
+		if len(s.jsonBlocks) > 0 {
+			finishReason = "stop"
+		}
+
+		return s.chunk(&openAIDelta{}, &finishReason)
+	case "message_stop":
+		data, err := json.Marshal(openAIChatResponse{
+			ID:      s.id,
+			Object:  "chat.completion.chunk",
+			Created: s.created,
+			Model:   s.model,
+			Choices: []openAIChoice{},
+			Usage:   openAIUsageFromAnthropic(s.usage),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return []Event{{Data: string(data)}, {Data: "[DONE]"}}, nil
+	case "error":
+		if e.Error == nil {
+			return nil, nil
+		}
+
+		data, err := json.Marshal(openAIErrorFromAnthropic(*e.Error))
+		if err != nil {
+			return nil, err
+		}
+
+		return []Event{{Data: string(data)}}, nil
+	}
+
+	return nil, nil
+}
+
+func (s *anthropicToOpenAIChatStream) End() ([]Event, error) {
+	return nil, nil
+}
+
+// chunk returns the event for a chat completion chunk.
+func (s *anthropicToOpenAIChatStream) chunk(delta *openAIDelta, finishReason *string) ([]Event, error) {
+	data, err := json.Marshal(openAIChatResponse{
+		ID:      s.id,
+		Object:  "chat.completion.chunk",
+		Created: s.created,
+		Model:   s.model,
+		Choices: []openAIChoice{{Delta: delta, FinishReason: finishReason}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []Event{{Data: string(data)}}, nil
+}
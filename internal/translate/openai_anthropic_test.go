@@ -0,0 +1,294 @@
+package translate
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	now = func() time.Time { return time.Unix(1700000000, 0) }
+	newID = func(prefix string) string { return prefix + "generated" }
+}
+
+// normaliseJson re-encodes json with its keys sorted so that it can be compared.
+func normaliseJson(t *testing.T, data string) string {
+	t.Helper()
+
+	var value any
+
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		t.Fatalf("Expected valid json, got: %s", data)
+	}
+
+	normalised, _ := json.Marshal(value)
+	return string(normalised)
+}
+
+func TestOpenAIChatToAnthropicRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+		err      string
+	}{
+		{
+			name:     "defaults max tokens",
+			body:     `{"model":"claude-sonnet-4-5","messages":[{"role":"user","content":"Hi"}]}`,
+			expected: `{"model":"claude-sonnet-4-5","max_tokens":8192,"messages":[{"role":"user","content":[{"type":"text","text":"Hi"}]}]}`,
+		},
+		{
+			name: "multiple system messages",
+			body: `{"model":"m","max_completion_tokens":100,"messages":[
+				{"role":"system","content":"Be brief."},
+				{"role":"user","content":"Hi"},
+				{"role":"developer","content":[{"type":"text","text":"Use British spelling."}]}
+			]}`,
+			expected: `{"model":"m","max_tokens":100,"system":[{"type":"text","text":"Be brief."},{"type":"text","text":"Use British spelling."}],
+				"messages":[{"role":"user","content":[{"type":"text","text":"Hi"}]}]}`,
+		},
+		{
+			name:     "stop sequence as a string",
+			body:     `{"model":"m","stop":"END","temperature":0,"messages":[{"role":"user","content":"Hi"}]}`,
+			expected: `{"model":"m","max_tokens":8192,"temperature":0,"stop_sequences":["END"],"messages":[{"role":"user","content":[{"type":"text","text":"Hi"}]}]}`,
+		},
+		{
+			name:     "stop sequences as a list",
+			body:     `{"model":"m","stop":["END","STOP"],"messages":[{"role":"user","content":"Hi"}]}`,
+			expected: `{"model":"m","max_tokens":8192,"stop_sequences":["END","STOP"],"messages":[{"role":"user","content":[{"type":"text","text":"Hi"}]}]}`,
+		},
+		{
+			name: "images",
+			body: `{"model":"m","messages":[{"role":"user","content":[
+				{"type":"text","text":"What are these?"},
+				{"type":"image_url","image_url":{"url":"data:image/png;base64,aGk="}},
+				{"type":"image_url","image_url":{"url":"https://example.com/cat.jpg","detail":"high"}}
+			]}]}`,
+			expected: `{"model":"m","max_tokens":8192,"messages":[{"role":"user","content":[
+				{"type":"text","text":"What are these?"},
+				{"type":"image","source":{"type":"base64","media_type":"image/png","data":"aGk="}},
+				{"type":"image","source":{"type":"url","url":"https://example.com/cat.jpg"}}
+			]}]}`,
+		},
+		{
+			name: "tools",
+			body: `{"model":"m","stream":true,"parallel_tool_calls":false,"tool_choice":{"type":"function","function":{"name":"weather"}},
+				"tools":[{"type":"function","function":{"name":"weather","description":"Get the weather","parameters":{"type":"object","properties":{"city":{"type":"string"}}}}}],
+				"messages":[
+					{"role":"user","content":"Weather in Sydney and Perth?"},
+					{"role":"assistant","content":null,"tool_calls":[
+						{"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Sydney\"}"}},
+						{"id":"call_2","type":"function","function":{"name":"weather","arguments":""}}
+					]},
+					{"role":"tool","tool_call_id":"call_1","content":"Sunny"},
+					{"role":"tool","tool_call_id":"call_2","content":[{"type":"text","text":"Rainy"}]},
+					{"role":"user","content":"Thanks"}
+				]}`,
+			expected: `{"model":"m","max_tokens":8192,"stream":true,
+				"tools":[{"name":"weather","description":"Get the weather","input_schema":{"type":"object","properties":{"city":{"type":"string"}}}}],
+				"tool_choice":{"type":"tool","name":"weather","disable_parallel_tool_use":true},
+				"messages":[
+					{"role":"user","content":[{"type":"text","text":"Weather in Sydney and Perth?"}]},
+					{"role":"assistant","content":[
+						{"type":"tool_use","id":"call_1","name":"weather","input":{"city":"Sydney"}},
+						{"type":"tool_use","id":"call_2","name":"weather","input":{}}
+					]},
+					{"role":"user","content":[
+						{"type":"tool_result","tool_use_id":"call_1","content":[{"type":"text","text":"Sunny"}]},
+						{"type":"tool_result","tool_use_id":"call_2","content":[{"type":"text","text":"Rainy"}]},
+						{"type":"text","text":"Thanks"}
+					]}
+				]}`,
+		},
+		{
+			name: "required tool choice",
+			body: `{"model":"m","tool_choice":"required","tools":[{"type":"function","function":{"name":"noop"}}],"messages":[{"role":"user","content":"Hi"}]}`,
+			expected: `{"model":"m","max_tokens":8192,"tools":[{"name":"noop","input_schema":{"type":"object","properties":{}}}],"tool_choice":{"type":"any"},
+				"messages":[{"role":"user","content":[{"type":"text","text":"Hi"}]}]}`,
+		},
+		{
+			name: "json schema response format",
+			body: `{"model":"m","response_format":{"type":"json_schema","json_schema":{"name":"answer","schema":{"type":"object"}}},"messages":[{"role":"user","content":"Hi"}]}`,
+			expected: `{"model":"m","max_tokens":8192,"tools":[{"name":"json_response","description":"Respond with a JSON object.","input_schema":{"type":"object"}}],
+				"tool_choice":{"type":"tool","name":"json_response"},"messages":[{"role":"user","content":[{"type":"text","text":"Hi"}]}]}`,
+		},
+		{
+			name: "n",
+			body: `{"model":"m","n":2,"messages":[{"role":"user","content":"Hi"}]}`,
+			err:  "n greater than 1 isn't supported by Claude",
+		},
+		{
+			name: "file ids",
+			body: `{"model":"m","messages":[{"role":"user","content":[{"type":"file","file":{"file_id":"file-1"}}]}]}`,
+			err:  "file parts must have file_data",
+		},
+		{
+			name: "invalid image url",
+			body: `{"model":"m","messages":[{"role":"user","content":[{"type":"image_url","image_url":{"url":"ftp://example.com/cat.jpg"}}]}]}`,
+			err:  `unsupported url "ftp://example.com/cat.jpg"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := openAIChatToAnthropic{}.Request([]byte(tt.body))
+
+			if tt.err != "" {
+				var requestErr *RequestError
+
+				if !errors.As(err, &requestErr) || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Expected a request error containing %q, got: %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if expected, actual := normaliseJson(t, tt.expected), normaliseJson(t, string(body)); actual != expected {
+				t.Errorf("Expected %s, got: %s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestOpenAIChatToAnthropicResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name: "text",
+			body: `{"id":"msg_1","type":"message","role":"assistant","model":"claude","content":[{"type":"text","text":"Hello"}],
+				"stop_reason":"max_tokens","usage":{"input_tokens":10,"cache_read_input_tokens":5,"output_tokens":3}}`,
+			expected: `{"id":"msg_1","object":"chat.completion","created":1700000000,"model":"claude",
+				"choices":[{"index":0,"message":{"role":"assistant","content":"Hello"},"finish_reason":"length"}],
+				"usage":{"prompt_tokens":15,"completion_tokens":3,"total_tokens":18,"prompt_tokens_details":{"cached_tokens":5}}}`,
+		},
+		{
+			name: "tool use",
+			body: `{"id":"msg_1","type":"message","role":"assistant","model":"claude","stop_reason":"tool_use","usage":{"input_tokens":1,"output_tokens":2},
+				"content":[{"type":"tool_use","id":"toolu_1","name":"weather","input":{"city":"Sydney"}}]}`,
+			expected: `{"id":"msg_1","object":"chat.completion","created":1700000000,"model":"claude",
+				"choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[
+					{"id":"toolu_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Sydney\"}"}}
+				]},"finish_reason":"tool_calls"}],
+				"usage":{"prompt_tokens":1,"completion_tokens":2,"total_tokens":3,"prompt_tokens_details":{"cached_tokens":0}}}`,
+		},
+		{
+			name: "json schema response format",
+			body: `{"id":"msg_1","type":"message","role":"assistant","model":"claude","stop_reason":"tool_use","usage":{"input_tokens":1,"output_tokens":2},
+				"content":[{"type":"tool_use","id":"toolu_1","name":"json_response","input":{"answer":42}}]}`,
+			expected: `{"id":"msg_1","object":"chat.completion","created":1700000000,"model":"claude",
+				"choices":[{"index":0,"message":{"role":"assistant","content":"{\"answer\":42}"},"finish_reason":"stop"}],
+				"usage":{"prompt_tokens":1,"completion_tokens":2,"total_tokens":3,"prompt_tokens_details":{"cached_tokens":0}}}`,
+		},
+		{
+			name:     "error",
+			body:     `{"type":"error","error":{"type":"rate_limit_error","message":"Slow down"}}`,
+			expected: `{"error":{"message":"Slow down","type":"rate_limit_exceeded","param":null,"code":"rate_limit_exceeded"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := openAIChatToAnthropic{}.Response([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if expected, actual := normaliseJson(t, tt.expected), normaliseJson(t, string(body)); actual != expected {
+				t.Errorf("Expected %s, got: %s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestOpenAIChatToAnthropicStream(t *testing.T) {
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude","content":[],"usage":{"input_tokens":10,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Checking"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"ping"}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"weather","input":{}}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":""}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Sydney\"}"}}`,
+		`{"type":"content_block_stop","index":1}`,
+		`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_2","name":"time","input":{}}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{}"}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":20}}`,
+		`{"type":"message_stop"}`,
+	}
+
+	chunk := `{"id":"msg_1","object":"chat.completion.chunk","created":1700000000,"model":"claude","choices":[{"index":0,%s}]}`
+
+	expected := []string{
+		strings.Replace(chunk, "%s", `"delta":{"role":"assistant","content":""},"finish_reason":null`, 1),
+		strings.Replace(chunk, "%s", `"delta":{"content":"Checking"},"finish_reason":null`, 1),
+		strings.Replace(chunk, "%s", `"delta":{"tool_calls":[{"index":0,"id":"toolu_1","type":"function","function":{"name":"weather","arguments":""}}]},"finish_reason":null`, 1),
+		strings.Replace(chunk, "%s", `"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]},"finish_reason":null`, 1),
+		strings.Replace(chunk, "%s", `"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Sydney\"}"}}]},"finish_reason":null`, 1),
+		strings.Replace(chunk, "%s", `"delta":{"tool_calls":[{"index":1,"id":"toolu_2","type":"function","function":{"name":"time","arguments":""}}]},"finish_reason":null`, 1),
+		strings.Replace(chunk, "%s", `"delta":{"tool_calls":[{"index":1,"function":{"arguments":"{}"}}]},"finish_reason":null`, 1),
+		strings.Replace(chunk, "%s", `"delta":{},"finish_reason":"tool_calls"`, 1),
+		`{"id":"msg_1","object":"chat.completion.chunk","created":1700000000,"model":"claude","choices":[],
+			"usage":{"prompt_tokens":10,"completion_tokens":20,"total_tokens":30,"prompt_tokens_details":{"cached_tokens":0}}}`,
+		`[DONE]`,
+	}
+
+	stream := openAIChatToAnthropic{}.Stream()
+
+	var actual []string
+
+	for _, data := range events {
+		translated, err := stream.Event(Event{Name: "", Data: data})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, event := range translated {
+			actual = append(actual, event.Data)
+		}
+	}
+
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %d events, got: %d\n%s", len(expected), len(actual), strings.Join(actual, "\n"))
+	}
+
+	for i := range expected {
+		if expected[i] == "[DONE]" {
+			if actual[i] != "[DONE]" {
+				t.Errorf("Expected event %d to be [DONE], got: %s", i, actual[i])
+			}
+
+			continue
+		}
+
+		if e, a := normaliseJson(t, expected[i]), normaliseJson(t, actual[i]); e != a {
+			t.Errorf("Expected event %d to be %s, got: %s", i, e, a)
+		}
+	}
+}
+
+func TestOpenAIChatToAnthropicStreamError(t *testing.T) {
+	translated, err := openAIChatToAnthropic{}.Stream().Event(Event{
+		Name: "error",
+		Data: `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"error":{"code":"server_error","message":"Overloaded","param":null,"type":"server_error"}}`
+
+	if len(translated) != 1 || normaliseJson(t, translated[0].Data) != expected {
+		t.Errorf("Expected %s, got: %v", expected, translated)
+	}
+}
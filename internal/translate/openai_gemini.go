@@ -0,0 +1,523 @@
+package translate
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// geminiErrorTypes are the OpenAI error types of Gemini's error statuses.
+var geminiErrorTypes = map[string]string{
+	"INVALID_ARGUMENT":    "invalid_request_error",
+	"FAILED_PRECONDITION": "invalid_request_error",
+	"OUT_OF_RANGE":        "invalid_request_error",
+	"UNAUTHENTICATED":     "authentication_error",
+	"PERMISSION_DENIED":   "permission_error",
+	"NOT_FOUND":           "not_found_error",
+	"RESOURCE_EXHAUSTED":  "rate_limit_exceeded",
+}
+
+// openAIChatToGemini lets OpenAI chat completions clients use Gemini through the generateContent API.
+type openAIChatToGemini struct{}
+
+func (openAIChatToGemini) Request(body []byte) ([]byte, error) {
+	var req openAIChatRequest
+
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, requestError("invalid chat completions request: %v", err)
+	}
+
+	out := geminiRequest{
+		Contents: []geminiContent{},
+		GenerationConfig: &geminiGenerationConfig{
+			Temperature:      req.Temperature,
+			TopP:             req.TopP,
+			MaxOutputTokens:  req.MaxCompletionTokens,
+			CandidateCount:   req.N,
+			StopSequences:    req.Stop,
+			PresencePenalty:  req.PresencePenalty,
+			FrequencyPenalty: req.FrequencyPenalty,
+			Seed:             req.Seed,
+		},
+	}
+
+	if out.GenerationConfig.MaxOutputTokens == nil {
+		out.GenerationConfig.MaxOutputTokens = req.MaxTokens
+	}
+
+	// Gemini answers a function call with the function's name rather than the call's id
+	functionNames := make(map[string]string)
+
+	for _, message := range req.Messages {
+		switch message.Role {
+		case "system", "developer":
+			text := message.Content.text()
+			if text == "" {
+				continue
+			}
+
+			if out.SystemInstruction == nil {
+				out.SystemInstruction = &geminiContent{}
+			}
+
+			out.SystemInstruction.Parts = append(out.SystemInstruction.Parts, geminiPart{Text: text})
+		case "user":
+			parts, err := geminiParts(message.Content)
+			if err != nil {
+				return nil, err
+			}
+
+			out.Contents = appendGeminiContent(out.Contents, "user", parts)
+		case "assistant":
+			parts, err := geminiParts(message.Content)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, toolCall := range message.ToolCalls {
+				args := json.RawMessage(toolCall.Function.Arguments)
+				if strings.TrimSpace(toolCall.Function.Arguments) == "" {
+					args = json.RawMessage("{}")
+				}
+
+				if !json.Valid(args) {
+					return nil, requestError("arguments of tool call %s aren't valid json", toolCall.ID)
+				}
+
+				functionNames[toolCall.ID] = toolCall.Function.Name
+				parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: toolCall.Function.Name, Args: args}})
+			}
+
+			out.Contents = appendGeminiContent(out.Contents, "model", parts)
+		case "tool":
+			name, ok := functionNames[message.ToolCallID]
+			if !ok {
+				return nil, requestError("tool message %s doesn't answer a tool call", message.ToolCallID)
+			}
+
+			result := geminiPart{FunctionResponse: &geminiFunctionResponse{Name: name, Response: geminiFunctionResult(message.Content.text())}}
+			out.Contents = appendGeminiContent(out.Contents, "user", []geminiPart{result})
+		default:
+			return nil, requestError("unsupported message role %q", message.Role)
+		}
+	}
+
+	var declarations []geminiFunctionDeclaration
+
+	for _, tool := range req.Tools {
+		if tool.Type != "function" {
+			continue
+		}
+
+		declarations = append(declarations, geminiFunctionDeclaration{
+			Name:                 tool.Function.Name,
+			Description:          tool.Function.Description,
+			ParametersJSONSchema: tool.Function.Parameters,
+		})
+	}
+
+	if len(declarations) > 0 {
+		out.Tools = []geminiTool{{FunctionDeclarations: declarations}}
+	}
+
+	if req.ToolChoice != nil {
+		switch {
+		case req.ToolChoice.Function != "":
+			out.ToolConfig = &geminiToolConfig{FunctionCallingConfig: geminiFunctionCallingConfig{Mode: "ANY", AllowedFunctionNames: []string{req.ToolChoice.Function}}}
+		case req.ToolChoice.Mode == "required":
+			out.ToolConfig = &geminiToolConfig{FunctionCallingConfig: geminiFunctionCallingConfig{Mode: "ANY"}}
+		case req.ToolChoice.Mode == "none":
+			out.ToolConfig = &geminiToolConfig{FunctionCallingConfig: geminiFunctionCallingConfig{Mode: "NONE"}}
+		default:
+			out.ToolConfig = &geminiToolConfig{FunctionCallingConfig: geminiFunctionCallingConfig{Mode: "AUTO"}}
+		}
+	}
+
+	if req.ResponseFormat != nil {
+		switch req.ResponseFormat.Type {
+		case "json_schema":
+			if req.ResponseFormat.JSONSchema == nil || len(req.ResponseFormat.JSONSchema.Schema) == 0 {
+				return nil, requestError("response_format json_schema must have a schema")
+			}
+
+			out.GenerationConfig.ResponseMimeType = "application/json"
+			out.GenerationConfig.ResponseJSONSchema = req.ResponseFormat.JSONSchema.Schema
+		case "json_object":
+			out.GenerationConfig.ResponseMimeType = "application/json"
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+// geminiParts converts the content of an OpenAI message into parts.
+func geminiParts(content openAIContent) ([]geminiPart, error) {
+	if content.Parts == nil {
+		if content.Text == "" {
+			return nil, nil
+		}
+
+		return []geminiPart{{Text: content.Text}}, nil
+	}
+
+	var parts []geminiPart
+
+	for _, part := range content.Parts {
+		switch part.Type {
+		case "text":
+			parts = append(parts, geminiPart{Text: part.Text})
+		case "image_url":
+			if part.ImageURL == nil {
+				return nil, requestError("image_url part must have an image_url")
+			}
+
+			filePart, err := geminiPartFromURL(part.ImageURL.URL)
+			if err != nil {
+				return nil, err
+			}
+
+			parts = append(parts, filePart)
+		case "file":
+			if part.File == nil || part.File.FileData == "" {
+				return nil, requestError("file parts must have file_data, file ids aren't supported by Gemini")
+			}
+
+			filePart, err := geminiPartFromURL(part.File.FileData)
+			if err != nil {
+				return nil, err
+			}
+
+			parts = append(parts, filePart)
+		default:
+			return nil, requestError("unsupported content part type %q", part.Type)
+		}
+	}
+
+	return parts, nil
+}
+
+// geminiPartFromURL converts a data url into inline data and passes on other urls for Gemini to fetch. Gemini needs the
+// media type of a url, which is told from its extension.
+func geminiPartFromURL(fileURL string) (geminiPart, error) {
+	if mediaType, data, ok := parseDataURL(fileURL); ok {
+		return geminiPart{InlineData: &geminiBlob{MimeType: mediaType, Data: data}}, nil
+	}
+
+	parsed, err := url.Parse(fileURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https" && parsed.Scheme != "gs") {
+		return geminiPart{}, requestError("unsupported url %q, expected an http or gs url or a base64 data url", truncate(fileURL, 32))
+	}
+
+	mediaType, _, _ := strings.Cut(mime.TypeByExtension(path.Ext(parsed.Path)), ";")
+	if mediaType == "" {
+		return geminiPart{}, requestError("can't tell the media type of %q from its extension", truncate(fileURL, 32))
+	}
+
+	return geminiPart{FileData: &geminiFileData{MimeType: mediaType, FileURI: fileURL}}, nil
+}
+
+// geminiFunctionResult returns the response of a function call, which must be an object. Results which aren't json
+// objects are wrapped in one.
+func geminiFunctionResult(text string) json.RawMessage {
+	trimmed := bytes.TrimSpace([]byte(text))
+	if bytes.HasPrefix(trimmed, []byte("{")) && json.Valid(trimmed) {
+		return trimmed
+	}
+
+	data, _ := json.Marshal(map[string]string{"content": text})
+	return data
+}
+
+// appendGeminiContent adds parts to the conversation. Parts from the same role as the last content are added to it,
+// so that the responses to parallel function calls are sent together.
+func appendGeminiContent(contents []geminiContent, role string, parts []geminiPart) []geminiContent {
+	if len(parts) == 0 {
+		return contents
+	}
+
+	if len(contents) > 0 && contents[len(contents)-1].Role == role {
+		contents[len(contents)-1].Parts = append(contents[len(contents)-1].Parts, parts...)
+		return contents
+	}
+
+	return append(contents, geminiContent{Role: role, Parts: parts})
+}
+
+func (openAIChatToGemini) Response(body []byte) ([]byte, error) {
+	if errorBody, ok := geminiErrorToOpenAI(body); ok {
+		return errorBody, nil
+	}
+
+	var res geminiResponse
+
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+
+	out := openAIChatResponse{
+		ID:      geminiResponseID(res),
+		Object:  "chat.completion",
+		Created: now().Unix(),
+		Model:   res.ModelVersion,
+		Choices: []openAIChoice{},
+	}
+
+	for _, candidate := range res.Candidates {
+		text, toolCalls := openAIMessageFromGemini(candidate.Content)
+		message := openAIMessage{Role: "assistant", Content: openAIContent{Text: text}, ToolCalls: toolCalls}
+		finishReason := openAIFinishReasonFromGemini(candidate.FinishReason, len(toolCalls) > 0)
+
+		out.Choices = append(out.Choices, openAIChoice{Index: candidate.Index, Message: &message, FinishReason: &finishReason})
+	}
+
+	// A blocked prompt doesn't have any candidates
+	if len(out.Choices) == 0 && res.PromptFeedback != nil && res.PromptFeedback.BlockReason != "" {
+		finishReason := "content_filter"
+		out.Choices = append(out.Choices, openAIChoice{Message: &openAIMessage{Role: "assistant"}, FinishReason: &finishReason})
+	}
+
+	if res.UsageMetadata != nil {
+		out.Usage = openAIUsageFromGemini(*res.UsageMetadata)
+	}
+
+	return json.Marshal(out)
+}
+
+// geminiErrorToOpenAI converts the body to an OpenAI error if it's a Gemini error.
+func geminiErrorToOpenAI(body []byte) ([]byte, bool) {
+	var res geminiResponse
+
+	if err := json.Unmarshal(body, &res); err != nil || res.Error == nil {
+		return nil, false
+	}
+
+	data, err := json.Marshal(openAIErrorFromGemini(*res.Error))
+	if err != nil {
+		return nil, false
+	}
+
+	return data, true
+}
+
+func openAIErrorFromGemini(detail geminiErrorDetail) openAIError {
+	errorType, ok := geminiErrorTypes[detail.Status]
+	if !ok {
+		errorType = "server_error"
+	}
+
+	return openAIError{Error: openAIErrorDetail{Message: detail.Message, Type: errorType, Code: errorType}}
+}
+
+func geminiResponseID(res geminiResponse) string {
+	if res.ResponseID != "" {
+		return res.ResponseID
+	}
+
+	return newID("chatcmpl-")
+}
+
+// openAIMessageFromGemini returns the text and tool calls of a candidate's content, leaving out its thoughts.
+func openAIMessageFromGemini(content *geminiContent) (string, []openAIToolCall) {
+	if content == nil {
+		return "", nil
+	}
+
+	var text strings.Builder
+	var toolCalls []openAIToolCall
+
+	for _, part := range content.Parts {
+		switch {
+		case part.Thought:
+			continue
+		case part.FunctionCall != nil:
+			id := part.FunctionCall.ID
+			if id == "" {
+				id = newID("call_")
+			}
+
+			toolCalls = append(toolCalls, openAIToolCall{
+				ID:       id,
+				Type:     "function",
+				Function: openAIFunctionCall{Name: part.FunctionCall.Name, Arguments: toolArguments(part.FunctionCall.Args)},
+			})
+		default:
+			text.WriteString(part.Text)
+		}
+	}
+
+	return text.String(), toolCalls
+}
+
+// openAIFinishReasonFromGemini returns the finish reason of a Gemini finish reason. Gemini stops the same way whether
+// or not it has called a function.
+func openAIFinishReasonFromGemini(finishReason string, toolCalls bool) string {
+	switch finishReason {
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return "content_filter"
+	}
+
+	if toolCalls {
+		return "tool_calls"
+	}
+
+	return "stop"
+}
+
+func openAIUsageFromGemini(usage geminiUsageMetadata) *openAIUsage {
+	// OpenAI counts reasoning tokens as part of the completion whereas Gemini counts thoughts separately
+	completionTokens := usage.CandidatesTokenCount + usage.ThoughtsTokenCount
+
+	return &openAIUsage{
+		PromptTokens:            usage.PromptTokenCount,
+		CompletionTokens:        completionTokens,
+		TotalTokens:             usage.PromptTokenCount + completionTokens,
+		PromptTokensDetails:     &openAIPromptTokensDetails{CachedTokens: usage.CachedContentTokenCount},
+		CompletionTokensDetails: &openAICompletionTokensDetails{ReasoningTokens: usage.ThoughtsTokenCount},
+	}
+}
+
+func (openAIChatToGemini) Stream() Stream {
+	return &geminiToOpenAIChatStream{toolCalls: make(map[int]int)}
+}
+
+// geminiToOpenAIChatStream translates the chunks of a streamed response into chat completion chunks. Gemini doesn't
+// mark the end of a stream so the usage and [DONE] are sent once it has ended.
+type geminiToOpenAIChatStream struct {
+	id      string
+	model   string
+	created int64
+	usage   *geminiUsageMetadata
+
+	// toolCalls are the number of tool calls of each candidate which has started
+	toolCalls map[int]int
+}
+
+func (s *geminiToOpenAIChatStream) Event(event Event) ([]Event, error) {
+	if strings.TrimSpace(event.Data) == "" {
+		return nil, nil
+	}
+
+	var res geminiResponse
+
+	if err := json.Unmarshal([]byte(event.Data), &res); err != nil {
+		return nil, err
+	}
+
+	if res.Error != nil {
+		data, err := json.Marshal(openAIErrorFromGemini(*res.Error))
+		if err != nil {
+			return nil, err
+		}
+
+		return []Event{{Data: string(data)}}, nil
+	}
+
+	if s.id == "" {
+		s.id = geminiResponseID(res)
+		s.model = res.ModelVersion
+		s.created = now().Unix()
+	}
+
+	if res.UsageMetadata != nil {
+		s.usage = res.UsageMetadata
+	}
+
+	var events []Event
+
+	add := func(index int, delta *openAIDelta, finishReason *string) error {
+		event, err := s.chunk(index, delta, finishReason)
+		events = append(events, event)
+
+		return err
+	}
+
+	for _, candidate := range res.Candidates {
+		if _, ok := s.toolCalls[candidate.Index]; !ok {
+			s.toolCalls[candidate.Index] = 0
+			empty := ""
+
+			if err := add(candidate.Index, &openAIDelta{Role: "assistant", Content: &empty}, nil); err != nil {
+				return nil, err
+			}
+		}
+
+		text, toolCalls := openAIMessageFromGemini(candidate.Content)
+
+		if text != "" {
+			if err := add(candidate.Index, &openAIDelta{Content: &text}, nil); err != nil {
+				return nil, err
+			}
+		}
+
+		if len(toolCalls) > 0 {
+			// Gemini sends each function call whole, so its arguments are sent in the same chunk
+			for i := range toolCalls {
+				index := s.toolCalls[candidate.Index]
+				toolCalls[i].Index = &index
+				s.toolCalls[candidate.Index]++
+			}
+
+			if err := add(candidate.Index, &openAIDelta{ToolCalls: toolCalls}, nil); err != nil {
+				return nil, err
+			}
+		}
+
+		if candidate.FinishReason != "" {
+			finishReason := openAIFinishReasonFromGemini(candidate.FinishReason, s.toolCalls[candidate.Index] > 0)
+
+			if err := add(candidate.Index, &openAIDelta{}, &finishReason); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(res.Candidates) == 0 && res.PromptFeedback != nil && res.PromptFeedback.BlockReason != "" {
+		finishReason := "content_filter"
+
+		if err := add(0, &openAIDelta{Role: "assistant"}, &finishReason); err != nil {
+			return nil, err
+		}
+	}
+
+	return events, nil
+}
+
+func (s *geminiToOpenAIChatStream) End() ([]Event, error) {
+	var events []Event
+
+	if s.usage != nil {
+		data, err := json.Marshal(openAIChatResponse{
+			ID:      s.id,
+			Object:  "chat.completion.chunk",
+			Created: s.created,
+			Model:   s.model,
+			Choices: []openAIChoice{},
+			Usage:   openAIUsageFromGemini(*s.usage),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, Event{Data: string(data)})
+	}
+
+	return append(events, Event{Data: "[DONE]"}), nil
+}
+
+// chunk returns the event for a chat completion chunk of a candidate.
+func (s *geminiToOpenAIChatStream) chunk(index int, delta *openAIDelta, finishReason *string) (Event, error) {
+	data, err := json.Marshal(openAIChatResponse{
+		ID:      s.id,
+		Object:  "chat.completion.chunk",
+		Created: s.created,
+		Model:   s.model,
+		Choices: []openAIChoice{{Index: index, Delta: delta, FinishReason: finishReason}},
+	})
+
+	return Event{Data: string(data)}, err
+}
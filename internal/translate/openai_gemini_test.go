@@ -0,0 +1,245 @@
+package translate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIChatToGeminiRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+		err      string
+	}{
+		{
+			name:     "text",
+			body:     `{"model":"gemini-2.5-pro","stream":true,"messages":[{"role":"user","content":"Hi"}]}`,
+			expected: `{"contents":[{"role":"user","parts":[{"text":"Hi"}]}],"generationConfig":{}}`,
+		},
+		{
+			name: "system instructions and generation config",
+			body: `{"model":"m","max_tokens":100,"temperature":0.5,"stop":"END","n":2,"seed":7,"messages":[
+				{"role":"system","content":"Be brief."},
+				{"role":"user","content":"Hi"},
+				{"role":"assistant","content":"Hello"},
+				{"role":"developer","content":[{"type":"text","text":"Use British spelling."}]},
+				{"role":"user","content":"Colour?"}
+			]}`,
+			expected: `{"systemInstruction":{"parts":[{"text":"Be brief."},{"text":"Use British spelling."}]},
+				"contents":[
+					{"role":"user","parts":[{"text":"Hi"}]},
+					{"role":"model","parts":[{"text":"Hello"}]},
+					{"role":"user","parts":[{"text":"Colour?"}]}
+				],
+				"generationConfig":{"temperature":0.5,"maxOutputTokens":100,"candidateCount":2,"stopSequences":["END"],"seed":7}}`,
+		},
+		{
+			name: "images and files",
+			body: `{"model":"m","messages":[{"role":"user","content":[
+				{"type":"text","text":"What are these?"},
+				{"type":"image_url","image_url":{"url":"data:image/png;base64,aGk="}},
+				{"type":"image_url","image_url":{"url":"https://example.com/cat.jpg?size=large"}},
+				{"type":"file","file":{"file_data":"data:application/pdf;base64,aGk="}}
+			]}]}`,
+			expected: `{"contents":[{"role":"user","parts":[
+				{"text":"What are these?"},
+				{"inlineData":{"mimeType":"image/png","data":"aGk="}},
+				{"fileData":{"mimeType":"image/jpeg","fileUri":"https://example.com/cat.jpg?size=large"}},
+				{"inlineData":{"mimeType":"application/pdf","data":"aGk="}}
+			]}],"generationConfig":{}}`,
+		},
+		{
+			name: "tools",
+			body: `{"model":"m","tool_choice":{"type":"function","function":{"name":"weather"}},
+				"tools":[{"type":"function","function":{"name":"weather","description":"Get the weather","parameters":{"type":"object","properties":{"city":{"type":"string"}},"additionalProperties":false}}}],
+				"messages":[
+					{"role":"user","content":"Weather in Sydney and Perth?"},
+					{"role":"assistant","content":null,"tool_calls":[
+						{"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Sydney\"}"}},
+						{"id":"call_2","type":"function","function":{"name":"weather","arguments":""}}
+					]},
+					{"role":"tool","tool_call_id":"call_1","content":"{\"temperature\":25}"},
+					{"role":"tool","tool_call_id":"call_2","content":"Sunny"}
+				]}`,
+			expected: `{"contents":[
+					{"role":"user","parts":[{"text":"Weather in Sydney and Perth?"}]},
+					{"role":"model","parts":[
+						{"functionCall":{"name":"weather","args":{"city":"Sydney"}}},
+						{"functionCall":{"name":"weather","args":{}}}
+					]},
+					{"role":"user","parts":[
+						{"functionResponse":{"name":"weather","response":{"temperature":25}}},
+						{"functionResponse":{"name":"weather","response":{"content":"Sunny"}}}
+					]}
+				],
+				"tools":[{"functionDeclarations":[{"name":"weather","description":"Get the weather",
+					"parametersJsonSchema":{"type":"object","properties":{"city":{"type":"string"}},"additionalProperties":false}}]}],
+				"toolConfig":{"functionCallingConfig":{"mode":"ANY","allowedFunctionNames":["weather"]}},
+				"generationConfig":{}}`,
+		},
+		{
+			name: "json schema response format",
+			body: `{"model":"m","response_format":{"type":"json_schema","json_schema":{"name":"answer","schema":{"type":"object"}}},"messages":[{"role":"user","content":"Hi"}]}`,
+			expected: `{"contents":[{"role":"user","parts":[{"text":"Hi"}]}],
+				"generationConfig":{"responseMimeType":"application/json","responseJsonSchema":{"type":"object"}}}`,
+		},
+		{
+			name: "tool result without a tool call",
+			body: `{"model":"m","messages":[{"role":"tool","tool_call_id":"call_1","content":"Sunny"}]}`,
+			err:  "tool message call_1 doesn't answer a tool call",
+		},
+		{
+			name: "image url without an extension",
+			body: `{"model":"m","messages":[{"role":"user","content":[{"type":"image_url","image_url":{"url":"https://example.com/cat"}}]}]}`,
+			err:  `can't tell the media type of "https://example.com/cat"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := openAIChatToGemini{}.Request([]byte(tt.body))
+
+			if tt.err != "" {
+				var requestErr *RequestError
+
+				if !errors.As(err, &requestErr) || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Expected a request error containing %q, got: %v", tt.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if expected, actual := normaliseJson(t, tt.expected), normaliseJson(t, string(body)); actual != expected {
+				t.Errorf("Expected %s, got: %s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestOpenAIChatToGeminiResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name: "text",
+			body: `{"responseId":"r1","modelVersion":"gemini-2.5-pro","candidates":[{"index":0,"finishReason":"MAX_TOKENS",
+					"content":{"role":"model","parts":[{"text":"Thinking...","thought":true},{"text":"Hello"}]}}],
+				"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":3,"thoughtsTokenCount":4,"cachedContentTokenCount":5,"totalTokenCount":17}}`,
+			expected: `{"id":"r1","object":"chat.completion","created":1700000000,"model":"gemini-2.5-pro",
+				"choices":[{"index":0,"message":{"role":"assistant","content":"Hello"},"finish_reason":"length"}],
+				"usage":{"prompt_tokens":10,"completion_tokens":7,"total_tokens":17,
+					"prompt_tokens_details":{"cached_tokens":5},"completion_tokens_details":{"reasoning_tokens":4}}}`,
+		},
+		{
+			name: "function calls",
+			body: `{"modelVersion":"gemini-2.5-pro","candidates":[{"index":0,"finishReason":"STOP",
+				"content":{"role":"model","parts":[{"functionCall":{"name":"weather","args":{"city":"Sydney"}}}]}}]}`,
+			expected: `{"id":"chatcmpl-generated","object":"chat.completion","created":1700000000,"model":"gemini-2.5-pro",
+				"choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[
+					{"id":"call_generated","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Sydney\"}"}}
+				]},"finish_reason":"tool_calls"}]}`,
+		},
+		{
+			name: "blocked prompt",
+			body: `{"responseId":"r1","modelVersion":"gemini-2.5-pro","promptFeedback":{"blockReason":"SAFETY"}}`,
+			expected: `{"id":"r1","object":"chat.completion","created":1700000000,"model":"gemini-2.5-pro",
+				"choices":[{"index":0,"message":{"role":"assistant","content":null},"finish_reason":"content_filter"}]}`,
+		},
+		{
+			name:     "error",
+			body:     `{"error":{"code":429,"message":"Quota exceeded","status":"RESOURCE_EXHAUSTED"}}`,
+			expected: `{"error":{"message":"Quota exceeded","type":"rate_limit_exceeded","param":null,"code":"rate_limit_exceeded"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := openAIChatToGemini{}.Response([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if expected, actual := normaliseJson(t, tt.expected), normaliseJson(t, string(body)); actual != expected {
+				t.Errorf("Expected %s, got: %s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestOpenAIChatToGeminiStream(t *testing.T) {
+	events := []string{
+		`{"responseId":"r1","modelVersion":"gemini-2.5-pro","candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"Checking"}]}}],
+			"usageMetadata":{"promptTokenCount":10,"totalTokenCount":10}}`,
+		`{"responseId":"r1","modelVersion":"gemini-2.5-pro","candidates":[{"index":0,"content":{"role":"model","parts":[
+			{"functionCall":{"name":"weather","args":{"city":"Sydney"}}},
+			{"functionCall":{"id":"fc_2","name":"time","args":{}}}
+		]},"finishReason":"STOP"}],
+			"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":20,"totalTokenCount":30}}`,
+	}
+
+	chunk := `{"id":"r1","object":"chat.completion.chunk","created":1700000000,"model":"gemini-2.5-pro","choices":[{"index":0,%s}]}`
+
+	expected := []string{
+		strings.Replace(chunk, "%s", `"delta":{"role":"assistant","content":""},"finish_reason":null`, 1),
+		strings.Replace(chunk, "%s", `"delta":{"content":"Checking"},"finish_reason":null`, 1),
+		strings.Replace(chunk, "%s", `"delta":{"tool_calls":[
+			{"index":0,"id":"call_generated","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Sydney\"}"}},
+			{"index":1,"id":"fc_2","type":"function","function":{"name":"time","arguments":"{}"}}
+		]},"finish_reason":null`, 1),
+		strings.Replace(chunk, "%s", `"delta":{},"finish_reason":"tool_calls"`, 1),
+		`{"id":"r1","object":"chat.completion.chunk","created":1700000000,"model":"gemini-2.5-pro","choices":[],
+			"usage":{"prompt_tokens":10,"completion_tokens":20,"total_tokens":30,
+				"prompt_tokens_details":{"cached_tokens":0},"completion_tokens_details":{"reasoning_tokens":0}}}`,
+		`[DONE]`,
+	}
+
+	stream := openAIChatToGemini{}.Stream()
+
+	var actual []string
+
+	for _, data := range events {
+		translated, err := stream.Event(Event{Data: data})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, event := range translated {
+			actual = append(actual, event.Data)
+		}
+	}
+
+	translated, err := stream.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, event := range translated {
+		actual = append(actual, event.Data)
+	}
+
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %d events, got: %d\n%s", len(expected), len(actual), strings.Join(actual, "\n"))
+	}
+
+	for i := range expected {
+		if expected[i] == "[DONE]" {
+			if actual[i] != "[DONE]" {
+				t.Errorf("Expected event %d to be [DONE], got: %s", i, actual[i])
+			}
+
+			continue
+		}
+
+		if e, a := normaliseJson(t, expected[i]), normaliseJson(t, actual[i]); e != a {
+			t.Errorf("Expected event %d to be %s, got: %s", i, e, a)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package translate
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Event is a server-sent event, only its name and data are translated.
+type Event struct {
+	Name string
+	Data string
+}
+
+// Transformer translates between the API a client speaks and the API of the upstream the request is sent to.
+type Transformer interface {
+	// Request translates a request body from the client's API to the upstream's
+	Request(body []byte) ([]byte, error)
+
+	// Response translates a whole response body from the upstream's API to the client's
+	Response(body []byte) ([]byte, error)
+
+	// Stream returns a translator for the events of a single streamed response
+	Stream() Stream
+}
+
+// Stream translates the events of a streamed response as they arrive, keeping whatever it needs from earlier events.
+type Stream interface {
+	// Event translates an event from the upstream into the events sent to the client
+	Event(event Event) ([]Event, error)
+
+	// End returns the events to send once the upstream's stream has ended
+	End() ([]Event, error)
+}
+
+// RequestError is returned for requests which can't be translated, they're answered with a 400.
+type RequestError struct {
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+func requestError(format string, args ...any) error {
+	return &RequestError{Message: fmt.Sprintf(format, args...)}
+}
+
+var transformers = map[string]Transformer{
+	"anthropic-to-openai-chat": anthropicToOpenAIChat{},
+	"openai-chat-to-anthropic": openAIChatToAnthropic{},
+	"openai-chat-to-gemini":    openAIChatToGemini{},
+}
+
+// Get returns the transformer with a name.
+func Get(name string) (Transformer, bool) {
+	transformer, ok := transformers[name]
+	return transformer, ok
+}
+
+// Names returns the names of the transformers in order.
+func Names() []string {
+	names := make([]string, 0, len(transformers))
+
+	for name := range transformers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// now is replaced in tests so that timestamps are predictable
+var now = time.Now
+
+// newID returns a random id with a prefix, for APIs which don't give ids where the client's API needs them. It's
+// replaced in tests too.
+var newID = func(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+
+	return prefix + hex.EncodeToString(b)
+}
@@ -0,0 +1,306 @@
+package usage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"bitbucket.org/atlassian-developers/proximity/internal/filelock"
+)
+
+// DayFormat is the format of the days usage is aggregated by
+const DayFormat = time.DateOnly
+
+// DefaultPath is where usage is stored unless another file is given.
+func DefaultPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".config", "proximity", "usage.json")
+}
+
+// Key is what usage is aggregated by.
+type Key struct {
+	Day       string `json:"day"`
+	Profile   string `json:"profile"`
+	UseCaseId string `json:"useCaseId"`
+	Model     string `json:"model"`
+}
+
+// Totals are the requests and tokens added up.
+type Totals struct {
+	Requests     int64 `json:"requests"`
+	InputTokens  int64 `json:"inputTokens"`
+	OutputTokens int64 `json:"outputTokens"`
+}
+
+func (t *Totals) add(other Totals) {
+	t.Requests += other.Requests
+	t.InputTokens += other.InputTokens
+	t.OutputTokens += other.OutputTokens
+}
+
+// Record is the usage of a single profile, use case and model on a day.
+type Record struct {
+	Key
+	Totals
+}
+
+// Filter picks out the records reported. Days are inclusive and empty fields match everything.
+type Filter struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Profile   string `json:"profile"`
+	UseCaseId string `json:"useCaseId"`
+	Model     string `json:"model"`
+}
+
+func (f Filter) matches(key Key) bool {
+	return (f.From == "" || key.Day >= f.From) &&
+		(f.To == "" || key.Day <= f.To) &&
+		(f.Profile == "" || key.Profile == f.Profile) &&
+		(f.UseCaseId == "" || key.UseCaseId == f.UseCaseId) &&
+		(f.Model == "" || key.Model == f.Model)
+}
+
+// Report is the records matching a filter and their total.
+type Report struct {
+	Records []Record `json:"records"`
+	Total   Totals   `json:"total"`
+}
+
+type file struct {
+	Records []Record `json:"records"`
+}
+
+// flushInterval is how long usage is batched up in memory before it's written to the file
+const flushInterval = 2 * time.Second
+
+// Store aggregates usage in a json file. Usage is batched up in memory and added to the file under a lock so that a
+// proxy in the app and one on the command line can share it. Usage is only kept in memory when no path is given.
+type Store struct {
+	path string
+
+	mu      sync.Mutex
+	records map[Key]*Record
+
+	// pending is the usage which hasn't been added to the file yet
+	pending map[Key]Totals
+	timer   *time.Timer
+
+	// flushErr is why the last flush in the background failed, it's returned by the next Add
+	flushErr error
+}
+
+func NewStore(path string) *Store {
+	return &Store{
+		path:    path,
+		records: make(map[Key]*Record),
+		pending: make(map[Key]Totals),
+	}
+}
+
+// Add counts a request with the tokens it used. It's written to the file shortly after, or when the store is
+// flushed.
+func (s *Store) Add(key Key, tokens Tokens) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totals := Totals{Requests: 1, InputTokens: tokens.Input, OutputTokens: tokens.Output}
+
+	if s.path == "" {
+		s.record(key).add(totals)
+		return nil
+	}
+
+	pending := s.pending[key]
+	pending.add(totals)
+	s.pending[key] = pending
+
+	if s.timer == nil {
+		s.timer = time.AfterFunc(flushInterval, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			s.flushErr = s.flush()
+		})
+	}
+
+	err := s.flushErr
+	s.flushErr = nil
+
+	return err
+}
+
+// Flush writes the usage which hasn't been written to the file yet.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flush()
+}
+
+// Report returns the records matching the filter, ordered by day, profile, use case and model.
+func (s *Store) Report(filter Filter) (*Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	report := &Report{Records: []Record{}}
+
+	for _, record := range s.sorted() {
+		if filter.matches(record.Key) {
+			report.Records = append(report.Records, record)
+			report.Total.add(record.Totals)
+		}
+	}
+
+	return report, nil
+}
+
+func (s *Store) record(key Key) *Record {
+	record, ok := s.records[key]
+	if !ok {
+		record = &Record{Key: key}
+		s.records[key] = record
+	}
+
+	return record
+}
+
+// flush adds the pending usage to what's in the file. The file is locked from reading it until it's replaced so that
+// usage added by other proxies isn't lost.
+func (s *Store) flush() error {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+
+	if s.path == "" || len(s.pending) == 0 {
+		return nil
+	}
+
+	unlock, err := filelock.Lock(s.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	for key, totals := range s.pending {
+		s.record(key).add(totals)
+	}
+
+	if err := s.save(); err != nil {
+		return err
+	}
+
+	s.pending = make(map[Key]Totals)
+	return nil
+}
+
+func (s *Store) sorted() []Record {
+	records := make([]Record, 0, len(s.records))
+
+	for _, record := range s.records {
+		records = append(records, *record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i].Key, records[j].Key
+
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+
+		if a.Profile != b.Profile {
+			return a.Profile < b.Profile
+		}
+
+		if a.UseCaseId != b.UseCaseId {
+			return a.UseCaseId < b.UseCaseId
+		}
+
+		return a.Model < b.Model
+	})
+
+	return records
+}
+
+func (s *Store) load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to read usage: %w", err)
+	}
+
+	var stored file
+
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("failed to parse usage %s: %w", s.path, err)
+	}
+
+	s.records = make(map[Key]*Record, len(stored.Records))
+
+	for _, record := range stored.Records {
+		s.records[record.Key] = &record
+	}
+
+	return nil
+}
+
+// save writes the records to a temporary file which replaces the usage file so that it's never left half written.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(file{Records: s.sorted()}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to write usage: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write usage: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write usage: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write usage: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write usage: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package usage
+
+import (
+	"encoding/json"
+)
+
+// Tokens are the tokens used by a single request.
+type Tokens struct {
+	Input  int64 `json:"inputTokens"`
+	Output int64 `json:"outputTokens"`
+}
+
+// Counter picks out the tokens used and the model from response bodies and stream events. OpenAI (usage), Anthropic
+// and Bedrock (usage, message_start and message_delta) and Gemini (usageMetadata) formats are understood. Streams
+// report running totals so the largest count seen is kept for each of input and output.
+type Counter struct {
+	tokens Tokens
+	model  string
+	found  bool
+}
+
+// Add counts a decoded json body or event.
+func (c *Counter) Add(body any) {
+	switch value := body.(type) {
+	case map[string]any:
+		c.addObject(value)
+	case []any:
+		// Gemini streams without sse are sent as a json array of chunks
+		for _, item := range value {
+			c.Add(item)
+		}
+	}
+}
+
+// AddJson counts a json body or event, anything which isn't json is ignored.
+func (c *Counter) AddJson(data []byte) {
+	var body any
+
+	if err := json.Unmarshal(data, &body); err != nil {
+		return
+	}
+
+	c.Add(body)
+}
+
+// Tokens returns the tokens counted, ok is false when no usage has been seen.
+func (c *Counter) Tokens() (tokens Tokens, ok bool) {
+	return c.tokens, c.found
+}
+
+// Model returns the model the response said it came from, if any.
+func (c *Counter) Model() string {
+	return c.model
+}
+
+func (c *Counter) addObject(body map[string]any) {
+	if model, ok := body["model"].(string); ok && model != "" {
+		c.model = model
+	} else if model, ok := body["modelVersion"].(string); ok && model != "" {
+		c.model = model
+	}
+
+	if usage, ok := body["usage"].(map[string]any); ok {
+		c.addUsage(usage)
+	}
+
+	if metadata, ok := body["usageMetadata"].(map[string]any); ok {
+		c.add(Tokens{
+			Input:  number(metadata, "promptTokenCount"),
+			Output: number(metadata, "candidatesTokenCount") + number(metadata, "thoughtsTokenCount"),
+		})
+	}
+
+	// Anthropic message_start events and OpenAI responses events wrap the message
+	for _, key := range []string{"message", "response"} {
+		if nested, ok := body[key].(map[string]any); ok {
+			c.addObject(nested)
+		}
+	}
+}
+
+func (c *Counter) addUsage(usage map[string]any) {
+	if _, ok := usage["prompt_tokens"]; ok {
+		c.add(Tokens{
+			Input:  number(usage, "prompt_tokens"),
+			Output: number(usage, "completion_tokens"),
+		})
+
+		return
+	}
+
+	// Anthropic counts cached input separately, OpenAI responses include it in input_tokens
+	c.add(Tokens{
+		Input:  number(usage, "input_tokens") + number(usage, "cache_creation_input_tokens") + number(usage, "cache_read_input_tokens"),
+		Output: number(usage, "output_tokens"),
+	})
+}
+
+func (c *Counter) add(tokens Tokens) {
+	c.found = true
+	c.tokens.Input = max(c.tokens.Input, tokens.Input)
+	c.tokens.Output = max(c.tokens.Output, tokens.Output)
+}
+
+func number(object map[string]any, key string) int64 {
+	if value, ok := object[key].(float64); ok {
+		return int64(value)
+	}
+
+	return 0
+}
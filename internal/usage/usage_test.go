@@ -0,0 +1,221 @@
+package usage
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	tests := []struct {
+		name     string
+		bodies   []string
+		expected Tokens
+		model    string
+	}{
+		{
+			name:     "openai chat completion",
+			bodies:   []string{`{"model":"gpt-4o","usage":{"prompt_tokens":12,"completion_tokens":5,"total_tokens":17}}`},
+			expected: Tokens{Input: 12, Output: 5},
+			model:    "gpt-4o",
+		},
+		{
+			name: "openai stream with usage in the last chunk",
+			bodies: []string{
+				`{"model":"gpt-4o","choices":[{"delta":{"content":"Hi"}}]}`,
+				`{"model":"gpt-4o","choices":[],"usage":{"prompt_tokens":12,"completion_tokens":1}}`,
+				`[DONE]`,
+			},
+			expected: Tokens{Input: 12, Output: 1},
+			model:    "gpt-4o",
+		},
+		{
+			name:     "openai responses",
+			bodies:   []string{`{"type":"response.completed","response":{"model":"gpt-4o","usage":{"input_tokens":8,"output_tokens":3}}}`},
+			expected: Tokens{Input: 8, Output: 3},
+			model:    "gpt-4o",
+		},
+		{
+			name:     "anthropic message",
+			bodies:   []string{`{"model":"claude","usage":{"input_tokens":10,"cache_read_input_tokens":4,"output_tokens":7}}`},
+			expected: Tokens{Input: 14, Output: 7},
+			model:    "claude",
+		},
+		{
+			name: "anthropic stream",
+			bodies: []string{
+				`{"type":"message_start","message":{"model":"claude","usage":{"input_tokens":10,"output_tokens":1}}}`,
+				`{"type":"content_block_delta","delta":{"text":"Hi"}}`,
+				`{"type":"message_delta","usage":{"output_tokens":9}}`,
+			},
+			expected: Tokens{Input: 10, Output: 9},
+			model:    "claude",
+		},
+		{
+			name: "gemini stream",
+			bodies: []string{
+				`{"modelVersion":"gemini-2.5-pro","usageMetadata":{"promptTokenCount":6,"candidatesTokenCount":1}}`,
+				`{"modelVersion":"gemini-2.5-pro","usageMetadata":{"promptTokenCount":6,"candidatesTokenCount":4,"thoughtsTokenCount":2}}`,
+			},
+			expected: Tokens{Input: 6, Output: 6},
+			model:    "gemini-2.5-pro",
+		},
+		{
+			name:     "gemini stream as a json array",
+			bodies:   []string{`[{"usageMetadata":{"promptTokenCount":6,"candidatesTokenCount":1}},{"usageMetadata":{"promptTokenCount":6,"candidatesTokenCount":3}}]`},
+			expected: Tokens{Input: 6, Output: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var counter Counter
+
+			for _, body := range tt.bodies {
+				counter.AddJson([]byte(body))
+			}
+
+			tokens, ok := counter.Tokens()
+			if !ok {
+				t.Fatal("Expected usage to be found")
+			}
+
+			if tokens != tt.expected {
+				t.Errorf("Expected %+v, got: %+v", tt.expected, tokens)
+			}
+
+			if counter.Model() != tt.model {
+				t.Errorf("Expected model %q, got: %q", tt.model, counter.Model())
+			}
+		})
+	}
+}
+
+func TestCounterWithoutUsage(t *testing.T) {
+	var counter Counter
+
+	counter.AddJson([]byte(`{"error":{"message":"bad request"}}`))
+	counter.AddJson([]byte(`not json`))
+
+	if _, ok := counter.Tokens(); ok {
+		t.Error("Expected no usage to be found")
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage", "usage.json")
+	store := NewStore(path)
+
+	adds := []struct {
+		key    Key
+		tokens Tokens
+	}{
+		{Key{Day: "2026-01-01", Profile: "a", UseCaseId: "u", Model: "gpt-4o"}, Tokens{Input: 10, Output: 5}},
+		{Key{Day: "2026-01-01", Profile: "a", UseCaseId: "u", Model: "gpt-4o"}, Tokens{Input: 2, Output: 1}},
+		{Key{Day: "2026-01-02", Profile: "b", UseCaseId: "u", Model: "claude"}, Tokens{Input: 7, Output: 3}},
+	}
+
+	for _, add := range adds {
+		if err := store.Add(add.key, add.tokens); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Usage is batched up until it's flushed, then a second store reads what the first one wrote
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := NewStore(path).Report(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Records) != 2 {
+		t.Fatalf("Expected 2 records, got: %d", len(report.Records))
+	}
+
+	if first := report.Records[0]; first.Requests != 2 || first.InputTokens != 12 || first.OutputTokens != 6 {
+		t.Errorf("Expected the first day to have 2 requests using 12 and 6 tokens, got: %+v", first)
+	}
+
+	if report.Total != (Totals{Requests: 3, InputTokens: 19, OutputTokens: 9}) {
+		t.Errorf("Expected the total of every record, got: %+v", report.Total)
+	}
+
+	filters := []struct {
+		filter   Filter
+		expected int
+	}{
+		{Filter{From: "2026-01-02"}, 1},
+		{Filter{To: "2026-01-01"}, 1},
+		{Filter{Profile: "b"}, 1},
+		{Filter{Model: "gpt-4o", Profile: "b"}, 0},
+		{Filter{UseCaseId: "u"}, 2},
+	}
+
+	for _, tt := range filters {
+		report, err := store.Report(tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(report.Records) != tt.expected {
+			t.Errorf("Expected %d records for %+v, got: %d", tt.expected, tt.filter, len(report.Records))
+		}
+	}
+}
+
+func TestStoreShared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	stores := []*Store{NewStore(path), NewStore(path)}
+	key := Key{Day: "2026-01-01", Profile: "a"}
+
+	var wg sync.WaitGroup
+
+	for _, store := range stores {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for range 50 {
+				if err := store.Add(key, Tokens{Input: 1}); err != nil {
+					t.Error(err)
+				}
+
+				if err := store.Flush(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	report, err := NewStore(path).Report(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Total.Requests != 100 {
+		t.Errorf("Expected the requests of both stores to be kept, got: %d", report.Total.Requests)
+	}
+}
+
+func TestStoreInMemory(t *testing.T) {
+	store := NewStore("")
+
+	if err := store.Add(Key{Day: "2026-01-01"}, Tokens{Input: 1, Output: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := store.Report(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Total.Requests != 1 {
+		t.Errorf("Expected 1 request, got: %d", report.Total.Requests)
+	}
+}
@@ -82,6 +82,7 @@ func main() {
 		},
 		BackgroundColour: &options.RGBA{R: 255, G: 255, B: 255, A: 0},
 		OnStartup:        application.Startup,
+		OnShutdown:       application.Shutdown,
 		Bind: []any{
 			application,
 		},